@@ -40,6 +40,29 @@ func initConfig(kupoURL, ogmiosURL string) (*config.Config, error) {
 	return cfg, nil
 }
 
+// It validates the --payment and --owner addresses against the configured
+// network before anything is fetched from the chain
+func validateAddressFlags(cfg *config.Config, payment, owner string) error {
+	if _, err := txbuilder.ParseAddress(
+		payment,
+		"payment address",
+		cfg.Indexer,
+	); err != nil {
+		return fmt.Errorf("--payment: %w", err)
+	}
+	if owner == "" {
+		return nil
+	}
+	if _, err := txbuilder.ParseOwnerAddress(
+		owner,
+		"owner address",
+		cfg.Indexer,
+	); err != nil {
+		return fmt.Errorf("--owner: %w", err)
+	}
+	return nil
+}
+
 // It makes sure both Kupo and Ogmios URLs exist
 func requireEndpoints(cfg *config.Config) error {
 	if strings.TrimSpace(cfg.TxBuilder.KupoUrl) == "" {
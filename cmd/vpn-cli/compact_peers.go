@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var flagCompactDryRun bool
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "compact-peers",
+		Short: "Remove stale peers and empty expired files from the S3 peer files",
+		RunE:  runCompactPeers,
+	}
+
+	cmd.Flags().
+		BoolVar(&flagCompactDryRun, "dry-run", false, "report what would be compacted without writing to S3")
+
+	rootCmd.AddCommand(cmd)
+}
+
+func runCompactPeers(cmd *cobra.Command, _ []string) error {
+	cfg, err := initConfig("", "")
+	if err != nil {
+		return err
+	}
+	s3Client, err := client.NewS3(cfg)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	// Every peer would look stale against a database that has none
+	hasPeers, err := db.HasWGPeers()
+	if err != nil {
+		return fmt.Errorf("check for WG peers: %w", err)
+	}
+	if !hasPeers {
+		return errors.New(
+			"database has no WG peers (run rebuild-peers first)",
+		)
+	}
+
+	summary, err := s3Client.CompactPeerFiles(
+		db,
+		cfg.Vpn.Region,
+		flagCompactDryRun,
+	)
+	if err != nil {
+		return fmt.Errorf("compact peers: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	prefix := ""
+	if flagCompactDryRun {
+		prefix = "would be "
+	}
+	_, _ = fmt.Fprintf(
+		out,
+		"peer files: %d\n%srewritten: %d\n%sdeleted: %d\nstale peers %sremoved: %d\nerrors: %d\n",
+		summary.PeerFiles,
+		prefix,
+		summary.Rewritten,
+		prefix,
+		summary.Deleted,
+		prefix,
+		summary.StalePeers,
+		len(summary.Errors),
+	)
+	for _, msg := range summary.Errors {
+		_, _ = fmt.Fprintf(out, "  %s\n", msg)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/blinklabs-io/gouroboros/cbor"
 )
@@ -33,36 +34,42 @@ func decodeRefDatumFlexible(datumCBOR []byte) ([]plan, []string, error) {
 	}
 
 	// conisdered first list of (int,int) as plans and the first "list of string" as regions.
-	var rawPlans any
-	var rawRegions any
+	var pairs [][]int
+	var outRegions []string
+	foundPlans, foundRegions := false, false
 	for _, e := range seq {
 		eu := unwrapAll(e)
-		if rawPlans == nil {
+		if !foundPlans {
 			if ps, ok := isListOfIntPairs(eu); ok {
-				rawPlans = ps
+				pairs, foundPlans = ps, true
 				continue
 			}
 		}
-		if rawRegions == nil {
+		if !foundRegions {
 			if rs, ok := isListOfStrings(eu); ok {
-				rawRegions = rs
+				outRegions, foundRegions = rs, true
 				continue
 			}
 		}
 	}
-	if rawPlans == nil {
-		return nil, nil, fmt.Errorf("refdatum: plans not found")
+	if !foundPlans {
+		return nil, nil, errors.New("refdatum: plans not found")
 	}
-	pairs := rawPlans.([][]int)
 	outPlans := make([]plan, 0, len(pairs))
 	for _, p := range pairs {
+		if p[0] <= 0 || p[1] < 0 {
+			return nil, nil, fmt.Errorf(
+				"refdatum: invalid plan (duration %d, price %d)",
+				p[0],
+				p[1],
+			)
+		}
 		outPlans = append(outPlans, plan{Duration: p[0], Price: p[1]})
 	}
 
-	if rawRegions == nil {
-		return nil, nil, fmt.Errorf("refdatum: regions not found")
+	if !foundRegions {
+		return nil, nil, errors.New("refdatum: regions not found")
 	}
-	outRegions := rawRegions.([]string)
 
 	return outPlans, outRegions, nil
 }
@@ -138,16 +145,26 @@ func toInt(v any) (int, bool) {
 	case int:
 		return n, true
 	case int64:
+		if n < math.MinInt || n > math.MaxInt {
+			return 0, false
+		}
 		return int(n), true
 	case uint64:
+		if n > math.MaxInt {
+			return 0, false
+		}
 		return int(n), true
 	case uint:
+		if n > math.MaxInt {
+			return 0, false
+		}
 		return int(n), true
 	case float64:
-		if n == float64(int(n)) {
-			return int(n), true
+		// Out of range conversions are implementation-defined
+		if n < math.MinInt || n >= math.MaxInt || n != math.Trunc(n) {
+			return 0, false
 		}
-		return 0, false
+		return int(n), true
 	default:
 		return 0, false
 	}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blinklabs-io/gouroboros/cbor"
+)
+
+func encodeTestDatum(t testing.TB, v any) []byte {
+	t.Helper()
+	data, err := cbor.Encode(v)
+	if err != nil {
+		t.Fatalf("encode datum: %v", err)
+	}
+	return data
+}
+
+func TestDecodeRefDatumFlexible(t *testing.T) {
+	regions := []any{[]byte("us-east-1"), []byte("eu-west-1")}
+	tests := []struct {
+		name        string
+		datum       any
+		wantPlans   []plan
+		wantRegions []string
+		wantErr     string
+	}{
+		{
+			name: "constructor",
+			datum: cbor.NewConstructorEncoder(
+				0,
+				cbor.IndefLengthList{
+					[]any{[]any{2592000000, 5000000}},
+					regions,
+				},
+			),
+			wantPlans:   []plan{{Duration: 2592000000, Price: 5000000}},
+			wantRegions: []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name: "constructor pairs",
+			datum: []any{
+				[]any{
+					cbor.NewConstructorEncoder(
+						0,
+						cbor.IndefLengthList{3600000, 1000000},
+					),
+				},
+				regions,
+			},
+			wantPlans:   []plan{{Duration: 3600000, Price: 1000000}},
+			wantRegions: []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name:    "not cbor",
+			datum:   nil,
+			wantErr: "cbor decode",
+		},
+		{
+			name:    "top level int",
+			datum:   42,
+			wantErr: "top-level is not a list/constructor",
+		},
+		{
+			name:    "top level map",
+			datum:   map[string]any{"plans": []any{}},
+			wantErr: "top-level is not a list/constructor",
+		},
+		{
+			name:    "empty",
+			datum:   []any{},
+			wantErr: "plans not found",
+		},
+		{
+			name:    "plans nested too deep",
+			datum:   []any{[]any{[]any{[]any{1, 2}}}, regions},
+			wantErr: "plans not found",
+		},
+		{
+			name:    "plans not nested",
+			datum:   []any{[]any{1, 2}, regions},
+			wantErr: "plans not found",
+		},
+		{
+			name:    "non-int pair",
+			datum:   []any{[]any{[]any{1, "two"}}, regions},
+			wantErr: "plans not found",
+		},
+		{
+			name:    "short pair",
+			datum:   []any{[]any{[]any{1}}, regions},
+			wantErr: "plans not found",
+		},
+		{
+			name:    "oversized pair value",
+			datum:   []any{[]any{[]any{uint64(math.MaxUint64), 1}}, regions},
+			wantErr: "plans not found",
+		},
+		{
+			name:    "negative duration",
+			datum:   []any{[]any{[]any{-1, 1}}, regions},
+			wantErr: "invalid plan",
+		},
+		{
+			name:    "missing regions",
+			datum:   []any{[]any{[]any{1, 2}}},
+			wantErr: "regions not found",
+		},
+		{
+			name: "mixed regions",
+			datum: []any{
+				[]any{[]any{1, 2}},
+				[]any{[]byte("us-east-1"), 7},
+			},
+			wantErr: "regions not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data []byte
+			if tt.datum != nil {
+				data = encodeTestDatum(t, tt.datum)
+			} else {
+				data = []byte{0xff}
+			}
+			plans, regions, err := decodeRefDatumFlexible(data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf(
+						"expected error containing %q, got: %v",
+						tt.wantErr,
+						err,
+					)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plans, tt.wantPlans) {
+				t.Fatalf("plans: got %v, want %v", plans, tt.wantPlans)
+			}
+			if !reflect.DeepEqual(regions, tt.wantRegions) {
+				t.Fatalf("regions: got %v, want %v", regions, tt.wantRegions)
+			}
+		})
+	}
+}
+
+func FuzzDecodeRefDatumFlexible(f *testing.F) {
+	f.Add(encodeTestDatum(f, cbor.NewConstructorEncoder(
+		0,
+		cbor.IndefLengthList{
+			[]any{[]any{2592000000, 5000000}},
+			[]any{[]byte("us-east-1")},
+		},
+	)))
+	f.Add(encodeTestDatum(f, []any{[]any{[]any{1, "two"}}, []any{7}}))
+	f.Add(encodeTestDatum(f, []any{[]any{[]any{-1.5, 2}}, []any{}}))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		plans, regions, err := decodeRefDatumFlexible(data)
+		if err != nil {
+			return
+		}
+		if plans == nil || regions == nil {
+			t.Fatalf("nil result without error")
+		}
+		for _, p := range plans {
+			if p.Duration <= 0 || p.Price < 0 {
+				t.Fatalf("invalid plan accepted: %+v", p)
+			}
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagInspectClientID string
+	flagInspectKupoURL  string
+	flagInspectJSON     bool
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "inspect-client",
+		Short: "Decode and display the on-chain datum for a client",
+		RunE:  runInspectClient,
+	}
+
+	cmd.Flags().
+		StringVar(&flagInspectClientID, "client-id", "", "client ID (required)")
+	cmd.Flags().
+		StringVar(&flagInspectKupoURL, "kupo-url", "", "Kupo endpoint")
+	cmd.Flags().
+		BoolVar(&flagInspectJSON, "json", false, "print the client as JSON instead of a table")
+
+	_ = cmd.MarkFlagRequired("client-id")
+
+	rootCmd.AddCommand(cmd)
+}
+
+// clientInfo is the decoded client datum along with the UTxO holding it
+type clientInfo struct {
+	ClientId      string `json:"clientId"`
+	Credential    string `json:"credential"`
+	Region        string `json:"region"`
+	Expiration    string `json:"expiration"`
+	TxHash        string `json:"txHash"`
+	TxOutputIndex uint   `json:"txOutputIndex"`
+}
+
+func newClientInfo(client database.Client) clientInfo {
+	return clientInfo{
+		ClientId:      hex.EncodeToString(client.AssetName),
+		Credential:    hex.EncodeToString(client.Credential),
+		Region:        client.Region,
+		Expiration:    client.Expiration.UTC().Format(time.RFC3339),
+		TxHash:        hex.EncodeToString(client.TxHash),
+		TxOutputIndex: client.TxOutputIndex,
+	}
+}
+
+func runInspectClient(cmd *cobra.Command, _ []string) error {
+	if strings.TrimSpace(flagInspectClientID) == "" {
+		return errors.New("--client-id is required")
+	}
+	if _, err := hex.DecodeString(parseHex(flagInspectClientID)); err != nil {
+		return fmt.Errorf("invalid --client-id: %w", err)
+	}
+
+	cfg, err := initConfig(flagInspectKupoURL, "")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(cfg.TxBuilder.KupoUrl) == "" {
+		return errors.New("kupo url is required (set --kupo-url)")
+	}
+
+	client, err := findClientOnChain(cmd.Context(), flagInspectClientID)
+	if err != nil {
+		return fmt.Errorf("find client (kupo): %w", err)
+	}
+	return writeClientInfo(cmd.OutOrStdout(), client, flagInspectJSON)
+}
+
+// writeClientInfo prints the client as an aligned table or indented JSON
+func writeClientInfo(w io.Writer, client database.Client, asJSON bool) error {
+	info := newClientInfo(client)
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "client id:\t%s\n", info.ClientId)
+	_, _ = fmt.Fprintf(tw, "credential:\t%s\n", info.Credential)
+	_, _ = fmt.Fprintf(tw, "region:\t%s\n", info.Region)
+	_, _ = fmt.Fprintf(tw, "expiration:\t%s\n", info.Expiration)
+	_, _ = fmt.Fprintf(
+		tw,
+		"utxo:\t%s#%d\n",
+		info.TxHash,
+		info.TxOutputIndex,
+	)
+	return tw.Flush()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+)
+
+const (
+	testClientId   = "891fa5d74b2292e00fea8eda28abd311cdc033a56297f84ce68c80f11170b051"
+	testTxHash     = "ea7e4f01fe5e9c30d70e7ded3c11c1cb7b0ba1e8e0d8b7f8c3f46e2e2ab613ba"
+	testDatumHash  = "923918e403bf43c34b4ef6b48eb2ee04babed17320d8d1b9ff9ad086e86f44ec"
+	testCredential = "00112233445566778899aabbccddeeff00112233445566778899aabb"
+)
+
+// newTestKupo serves a single client UTxO at the script address along with
+// its datum
+func newTestKupo(t *testing.T, datum []byte) *httptest.Server {
+	t.Helper()
+	cfg := config.GetConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /v1/matches/{pattern}",
+		func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.PathValue("pattern"), "."+testClientId) {
+				_, _ = w.Write([]byte("[]"))
+				return
+			}
+			_ = json.NewEncoder(w).Encode([]map[string]any{
+				{
+					"transaction_id": testTxHash,
+					"output_index":   1,
+					"address":        cfg.Indexer.ScriptAddress,
+					"datum_hash":     testDatumHash,
+				},
+			})
+		},
+	)
+	mux.HandleFunc(
+		"GET /v1/datums/"+testDatumHash,
+		func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"datum": hex.EncodeToString(datum),
+			})
+		},
+	)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	prevUrl := cfg.TxBuilder.KupoUrl
+	cfg.TxBuilder.KupoUrl = srv.URL
+	t.Cleanup(func() { cfg.TxBuilder.KupoUrl = prevUrl })
+	return srv
+}
+
+func TestInspectClient(t *testing.T) {
+	credential, _ := hex.DecodeString(testCredential)
+	expiration := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	datum, err := cbor.Encode(
+		cbor.NewConstructorEncoder(
+			1,
+			cbor.IndefLengthList{
+				credential,
+				[]byte("us-east-1"),
+				expiration.UnixMilli(),
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("encode datum: %v", err)
+	}
+	newTestKupo(t, datum)
+
+	client, err := findClientOnChain(context.Background(), testClientId)
+	if err != nil {
+		t.Fatalf("find client: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		asJSON bool
+		want   string
+	}{
+		{
+			name: "table",
+			want: fmt.Sprintf(
+				"client id:   %s\ncredential:  %s\nregion:      us-east-1\nexpiration:  2026-03-01T12:00:00Z\nutxo:        %s#1\n",
+				testClientId,
+				testCredential,
+				testTxHash,
+			),
+		},
+		{
+			name:   "json",
+			asJSON: true,
+			want: fmt.Sprintf(
+				`{
+  "clientId": "%s",
+  "credential": "%s",
+  "region": "us-east-1",
+  "expiration": "2026-03-01T12:00:00Z",
+  "txHash": "%s",
+  "txOutputIndex": 1
+}
+`,
+				testClientId,
+				testCredential,
+				testTxHash,
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeClientInfo(&buf, client, tt.asJSON); err != nil {
+				t.Fatalf("write client info: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestInspectClientNotFound(t *testing.T) {
+	newTestKupo(t, nil)
+	_, err := findClientOnChain(
+		context.Background(),
+		strings.Repeat("ab", 32),
+	)
+	if err == nil || !strings.Contains(err.Error(), "client not found") {
+		t.Fatalf("expected client not found error, got: %v", err)
+	}
+}
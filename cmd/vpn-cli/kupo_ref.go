@@ -218,7 +218,7 @@ func findClientOnChain(
 
 	// Remove CBOR wrappers (Tag/Constructors)
 	fields, ok := toSlice(unwrapAll(v))
-	if !ok || len(fields) != 3 {
+	if !ok || (len(fields) != 3 && len(fields) != 4) {
 		return database.Client{}, errors.New("unexpected client datum shape")
 	}
 	credentialRaw := unwrapAll(fields[0])
@@ -241,6 +241,16 @@ func findClientOnChain(
 	if credential == nil || region == "" {
 		return database.Client{}, errors.New("invalid fields in client datum")
 	}
+	// The device limit is an optional trailing field
+	var deviceLimit int
+	if len(fields) == 4 {
+		deviceLimit, ok = toInt(unwrapAll(fields[3]))
+		if !ok || deviceLimit < 0 {
+			return database.Client{}, errors.New(
+				"invalid device limit in client datum",
+			)
+		}
+	}
 
 	// Extract transaction hash
 	txid, err := hex.DecodeString(parseHex(picked.TransactionID))
@@ -256,5 +266,6 @@ func findClientOnChain(
 		Region:        region,
 		TxHash:        txid,
 		TxOutputIndex: uint(picked.OutputIndex),
+		DeviceLimit:   deviceLimit,
 	}, nil
 }
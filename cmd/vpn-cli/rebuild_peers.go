@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var flagRebuildDryRun bool
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "rebuild-peers",
+		Short: "Rebuild the WireGuard peer database from the S3 peer files",
+		RunE:  runRebuildPeers,
+	}
+
+	cmd.Flags().
+		BoolVar(&flagRebuildDryRun, "dry-run", false, "load and validate the peer files without writing to the database")
+
+	rootCmd.AddCommand(cmd)
+}
+
+func runRebuildPeers(cmd *cobra.Command, _ []string) error {
+	cfg, err := initConfig("", "")
+	if err != nil {
+		return err
+	}
+	s3Client, err := client.NewS3(cfg)
+	if err != nil {
+		return err
+	}
+
+	// A dry run doesn't touch the database
+	var db *database.Database
+	if !flagRebuildDryRun {
+		db, err = database.New(cfg, nil)
+		if err != nil {
+			return fmt.Errorf("open database: %w", err)
+		}
+		hasPeers, err := db.HasWGPeers()
+		if err != nil {
+			return fmt.Errorf("check for WG peers: %w", err)
+		}
+		if hasPeers {
+			return errors.New("database already has WG peers")
+		}
+	}
+
+	summary, err := s3Client.RebuildWGPeersFromS3(
+		db,
+		cfg.Vpn.Region,
+		flagRebuildDryRun,
+	)
+	if err != nil {
+		return fmt.Errorf("rebuild peers: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	verb := "imported"
+	if flagRebuildDryRun {
+		verb = "would import"
+	}
+	_, _ = fmt.Fprintf(
+		out,
+		"peer files: %d\npeers %s: %d\nerrors: %d\n",
+		summary.PeerFiles,
+		verb,
+		summary.Peers,
+		len(summary.Errors),
+	)
+	for _, msg := range summary.Errors {
+		_, _ = fmt.Fprintf(out, "  %s\n", msg)
+	}
+	return nil
+}
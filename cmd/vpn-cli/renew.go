@@ -74,6 +74,13 @@ func runRenew(cmd *cobra.Command, _ []string) error {
 	if err := requireEndpoints(cfg); err != nil {
 		return err
 	}
+	if err := validateAddressFlags(
+		cfg,
+		flagRenewPayment,
+		flagRenewOwner,
+	); err != nil {
+		return err
+	}
 	ref, err := loadRefData(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("load reference (kupo): %w", err)
@@ -96,6 +103,7 @@ func runRenew(cmd *cobra.Command, _ []string) error {
 		flagRenewClientID,
 		flagRenewPrice,
 		flagRenewDuration,
+		"",
 	)
 	if err != nil {
 		return err
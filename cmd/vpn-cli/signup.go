@@ -92,6 +92,13 @@ func runSignup(cmd *cobra.Command, _ []string) error {
 	if err := requireEndpoints(cfg); err != nil {
 		return err
 	}
+	if err := validateAddressFlags(
+		cfg,
+		flagPaymentAddr,
+		flagOwnerAddr,
+	); err != nil {
+		return err
+	}
 	ref, err := loadRefData(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("load reference (kupo): %w", err)
@@ -104,6 +111,7 @@ func runSignup(cmd *cobra.Command, _ []string) error {
 		flagOwnerAddr,
 		flagPrice,
 		flagDuration,
+		"",
 		flagRegion,
 	)
 	if err != nil {
@@ -67,6 +67,13 @@ func runTransfer(cmd *cobra.Command, _ []string) error {
 	if err := requireEndpoints(cfg); err != nil {
 		return err
 	}
+	if err := validateAddressFlags(
+		cfg,
+		flagTransferPayment,
+		flagTransferOwner,
+	); err != nil {
+		return err
+	}
 	ref, err := loadRefData(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("load reference (kupo): %w", err)
@@ -89,6 +96,7 @@ func runTransfer(cmd *cobra.Command, _ []string) error {
 		flagTransferClientID,
 		0,
 		0,
+		"",
 	)
 	if err != nil {
 		return err
@@ -15,12 +15,19 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	_ "net/http/pprof" // #nosec G108
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/blinklabs-io/vpn-indexer/internal/api"
@@ -37,6 +44,10 @@ import (
 	"go.uber.org/automaxprocs/maxprocs"
 )
 
+// activeSubscriptionsInterval is how often the active subscriptions gauge is
+// recalculated from the database
+const activeSubscriptionsInterval = time.Minute
+
 var cmdlineFlags struct {
 	configFile string
 }
@@ -45,6 +56,114 @@ func slogPrintf(format string, v ...any) {
 	slog.Info(fmt.Sprintf(format, v...))
 }
 
+// newLogger builds a logger with the configured output format. The configured
+// level is stored in level, which can be changed later to adjust the logger.
+func newLogger(
+	cfg *config.LoggingConfig,
+	level *slog.LevelVar,
+	w io.Writer,
+) *slog.Logger {
+	level.Set(cfg.SlogLevel())
+	opts := &slog.HandlerOptions{
+		Level: level,
+	}
+	var handler slog.Handler
+	if cfg.Format == config.LogFormatText {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(handler)
+}
+
+// newDebugHandler builds the handler for the debug listener. The pprof
+// endpoints are only registered when enabled, since profiles can expose
+// sensitive data. Importing pprof also registers them on
+// http.DefaultServeMux, which no listener serves.
+func newDebugHandler(cfg *config.DebugConfig) http.Handler {
+	debugMux := http.NewServeMux()
+	if cfg.EnablePprof {
+		debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+		debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return debugMux
+}
+
+// newMetricsHandler serves /metrics, requiring the configured Bearer token or
+// basic auth credentials when either is set
+func newMetricsHandler(cfg *config.MetricsConfig) http.Handler {
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	if cfg.Token == "" && cfg.Username == "" {
+		return metricsMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !metricsAuthorized(cfg, r) {
+			if cfg.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metricsMux.ServeHTTP(w, r)
+	})
+}
+
+// metricsAuthorized reports whether the request carries the configured metrics
+// Bearer token or basic auth credentials
+func metricsAuthorized(cfg *config.MetricsConfig, r *http.Request) bool {
+	if cfg.Token != "" {
+		token, ok := strings.CutPrefix(
+			r.Header.Get("Authorization"),
+			"Bearer ",
+		)
+		if ok && secretEqual(token, cfg.Token) {
+			return true
+		}
+	}
+	if cfg.Username != "" {
+		username, password, ok := r.BasicAuth()
+		// Compare both so a wrong username takes as long as a wrong password
+		userOk := secretEqual(username, cfg.Username)
+		passOk := secretEqual(password, cfg.Password)
+		if ok && userOk && passOk {
+			return true
+		}
+	}
+	return false
+}
+
+// secretEqual compares a provided secret against the configured one in
+// constant time
+func secretEqual(provided, configured string) bool {
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(configured)) == 1
+}
+
+// reloadConfig re-reads the config and applies the settings that can change
+// at runtime, warning about any other changes
+func reloadConfig(configFile string, level *slog.LevelVar) {
+	cfg, ignored, err := config.Reload(configFile)
+	if err != nil {
+		slog.Error("failed to reload config", "error", err)
+		return
+	}
+	if len(ignored) > 0 {
+		slog.Warn(
+			"ignoring config changes that require a restart",
+			"sections", ignored,
+		)
+	}
+	level.Set(cfg.Logging.SlogLevel())
+	slog.Info(
+		"reloaded config",
+		"level", level.Level(),
+		"wgMaxDevices", cfg.Vpn.WGMaxDevices,
+	)
+}
+
 func main() {
 	flag.StringVar(
 		&cmdlineFlags.configFile,
@@ -62,18 +181,19 @@ func main() {
 	}
 
 	// Configure logger
-	var level slog.Level
-	if cfg.Logging.Debug {
-		level = slog.LevelDebug
-	} else {
-		level = slog.LevelInfo
-	}
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
-	})
-	logger := slog.New(handler)
+	var logLevel slog.LevelVar
+	logger := newLogger(&cfg.Logging, &logLevel, os.Stdout)
 	slog.SetDefault(logger)
 
+	// Reload runtime-adjustable settings on SIGHUP
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			reloadConfig(cmdlineFlags.configFile, &logLevel)
+		}
+	}()
+
 	// Open database
 	db, err := database.New(cfg, logger)
 	if err != nil {
@@ -85,26 +205,23 @@ func main() {
 	_, err = maxprocs.Set(maxprocs.Logger(slogPrintf))
 	if err != nil {
 		// If we hit this, something really wrong happened
-		logger.Error(err.Error())
+		logger.Error("failed to set GOMAXPROCS", "error", err)
 		os.Exit(1)
 	}
 
 	slog.Info(
-		fmt.Sprintf(
-			"vpn-indexer %s started for region %s",
-			version.GetVersionString(),
-			cfg.Vpn.Region,
-		),
+		"vpn-indexer started",
+		"version", version.GetVersionString(),
+		"region", cfg.Vpn.Region,
 	)
 
 	// Start debug listener
 	if cfg.Debug.ListenPort > 0 {
 		slog.Info(
-			fmt.Sprintf(
-				"starting debug listener on %s:%d",
-				cfg.Debug.ListenAddress,
-				cfg.Debug.ListenPort,
-			),
+			"starting debug listener",
+			"address", cfg.Debug.ListenAddress,
+			"port", cfg.Debug.ListenPort,
+			"pprof", cfg.Debug.EnablePprof,
 		)
 		go func() {
 			debugger := &http.Server{
@@ -113,13 +230,12 @@ func main() {
 					cfg.Debug.ListenAddress,
 					cfg.Debug.ListenPort,
 				),
+				Handler:           newDebugHandler(&cfg.Debug),
 				ReadHeaderTimeout: 60 * time.Second,
 			}
 			err := debugger.ListenAndServe()
 			if err != nil {
-				slog.Error(
-					fmt.Sprintf("failed to start debug listener: %s", err),
-				)
+				slog.Error("failed to start debug listener", "error", err)
 				os.Exit(1)
 			}
 		}()
@@ -133,24 +249,38 @@ func main() {
 			cfg.Metrics.ListenPort,
 		)
 		slog.Info(
-			"starting listener for prometheus metrics connections on " + metricsListenAddr,
+			"starting listener for prometheus metrics connections",
+			"address", metricsListenAddr,
 		)
-		metricsMux := http.NewServeMux()
-		metricsMux.Handle("/metrics", promhttp.Handler())
 		metricsSrv := &http.Server{
 			Addr:         metricsListenAddr,
 			WriteTimeout: 10 * time.Second,
 			ReadTimeout:  10 * time.Second,
-			Handler:      metricsMux,
+			Handler:      newMetricsHandler(&cfg.Metrics),
 		}
 		go func() {
 			if err := metricsSrv.ListenAndServe(); err != nil {
-				slog.Error(
-					fmt.Sprintf("failed to start metrics listener: %s", err),
-				)
+				slog.Error("failed to start metrics listener", "error", err)
 				os.Exit(1)
 			}
 		}()
+
+		// Periodically update the active subscriptions gauge
+		go func() {
+			ticker := time.NewTicker(activeSubscriptionsInterval)
+			defer ticker.Stop()
+			for {
+				if err := db.UpdateActiveSubscriptionsMetric(
+					context.Background(),
+				); err != nil {
+					slog.Warn(
+						"failed to update active subscriptions metric",
+						"error", err,
+					)
+				}
+				<-ticker.C
+			}
+		}()
 	}
 
 	var caInstance *ca.Ca
@@ -165,29 +295,23 @@ func main() {
 	// file config (VPN_JWT_KEY_FILE) is required for all protocols.
 	jwtIssuer, err = jwt.NewIssuer(cfg.Vpn.JWTKeyFile)
 	if err != nil {
-		slog.Error(
-			fmt.Sprintf("failed to initialize JWT issuer: %s", err),
-		)
+		slog.Error("failed to initialize JWT issuer", "error", err)
 		os.Exit(1)
 	}
 
 	switch cfg.Vpn.Protocol {
 	case "openvpn":
 		// Configure CA
-		caInstance, err = ca.New(cfg)
+		caInstance, err = ca.New(cfg, db)
 		if err != nil {
-			slog.Error(
-				fmt.Sprintf("failed to configure CA: %s", err),
-			)
+			slog.Error("failed to configure CA", "error", err)
 			os.Exit(1)
 		}
 
 		// Configure CRL
 		crlInstance, err = crl.New(cfg, logger, db, caInstance)
 		if err != nil {
-			slog.Error(
-				fmt.Sprintf("failed to configure CRL: %s", err),
-			)
+			slog.Error("failed to configure CRL", "error", err)
 			os.Exit(1)
 		}
 	case "wireguard":
@@ -195,63 +319,88 @@ func main() {
 		slog.Info("initializing WireGuard components")
 
 		// Initialize WG container client
-		wgClient = wireguard.NewClient(cfg.Vpn.WGContainerURL, jwtIssuer)
+		wgClient = wireguard.NewClient(
+			cfg.Vpn.WGContainerURL,
+			jwtIssuer,
+			cfg.Vpn.WGContainerTimeout,
+		)
 
-		// Health check WG container (warn but don't fail if not available)
-		if err := wgClient.Health(); err != nil {
+		// Probe WG container health. Registrations made while the container
+		// is down only reach it on the next sync, so make that visible.
+		if cfg.Vpn.WGStartupProbeAttempts > 0 {
+			if err := wgClient.WaitHealthy(
+				context.Background(),
+				cfg.Vpn.WGStartupProbeAttempts,
+				cfg.Vpn.WGStartupProbeInterval,
+			); err != nil {
+				if cfg.Vpn.WGStartupProbeRequired {
+					slog.Error(
+						"WG container unreachable at startup",
+						"url", cfg.Vpn.WGContainerURL,
+						"attempts", cfg.Vpn.WGStartupProbeAttempts,
+						"error", err,
+					)
+					os.Exit(1)
+				}
+				slog.Warn(
+					"WG container unreachable at startup: new registrations will not reach it until it recovers and peers are re-synced",
+					"url", cfg.Vpn.WGContainerURL,
+					"attempts", cfg.Vpn.WGStartupProbeAttempts,
+					"error", err,
+				)
+			} else {
+				slog.Info("WG container health check passed")
+			}
+		}
+
+		// Initialize S3 client for peer registry. Without it, the WG API
+		// routes aren't registered and expired peers aren't cleaned up.
+		s3Client, err = client.NewS3(cfg)
+		if errors.Is(err, client.ErrS3NotConfigured) {
 			slog.Warn(
-				fmt.Sprintf(
-					"WG container not available at startup: %s",
-					err,
-				),
+				"S3 not configured, WireGuard registration is disabled",
+				"error", err,
 			)
-		} else {
-			slog.Info("WG container health check passed")
 		}
 
-		// Initialize S3 client for peer registry
-		s3Client = client.NewWithConfig(cfg)
-
 		// Check if DB needs rebuild from S3
 		hasData, err := db.HasWGPeers()
 		if err != nil {
-			slog.Warn(
-				fmt.Sprintf("failed to check for WG peers in DB: %s", err),
-			)
-		} else if !hasData {
+			slog.Warn("failed to check for WG peers in DB", "error", err)
+		} else if !hasData && s3Client != nil {
 			slog.Info("no WG peers in DB, rebuilding from S3...")
-			if err := s3Client.RebuildWGPeersFromS3(
+			if _, err := s3Client.RebuildWGPeersFromS3(
 				db,
 				cfg.Vpn.Region,
+				false,
 			); err != nil {
-				slog.Warn(
-					fmt.Sprintf("failed to rebuild WG peers from S3: %s", err),
-				)
+				slog.Warn("failed to rebuild WG peers from S3", "error", err)
 			}
 		}
 
 		// Create wireguard manager
 		if _, err := wireguard.NewManager(cfg, logger, db, wgClient, s3Client); err != nil {
-			slog.Error(
-				fmt.Sprintf("failed to initialize wireguard manager: %s", err),
-			)
+			slog.Error("failed to initialize wireguard manager", "error", err)
 			os.Exit(1)
 		}
 
 		// Sync active peers to WG container
 		slog.Info("syncing peers to WG container...")
-		if err := wgClient.SyncPeersToContainer(db, cfg.Vpn.Region); err != nil {
+		syncResult, err := wgClient.SyncPeersToContainer(db, cfg.Vpn.Region)
+		if err != nil {
+			slog.Warn("failed to sync peers to WG container", "error", err)
+		}
+		if syncResult != nil && len(syncResult.Failed) > 0 {
 			slog.Warn(
-				fmt.Sprintf("failed to sync peers to WG container: %s", err),
+				"some peers were not synced to WG container",
+				"failed", syncResult.Failed,
 			)
 		}
 	}
 
 	// Start indexer
 	if err := indexer.GetIndexer().Start(cfg, logger, db, caInstance, crlInstance); err != nil {
-		slog.Error(
-			fmt.Sprintf("failed to start indexer: %s", err),
-		)
+		slog.Error("failed to start indexer", "error", err)
 		os.Exit(1)
 	}
 
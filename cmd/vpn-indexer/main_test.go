@@ -0,0 +1,224 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.LoggingConfig
+		wantJSON  bool
+		wantDebug bool
+	}{
+		{
+			name:     "json",
+			cfg:      config.LoggingConfig{Format: config.LogFormatJSON},
+			wantJSON: true,
+		},
+		{
+			name: "text",
+			cfg:  config.LoggingConfig{Format: config.LogFormatText},
+		},
+		{
+			name:      "debug flag",
+			cfg:       config.LoggingConfig{Format: config.LogFormatJSON, Debug: true},
+			wantJSON:  true,
+			wantDebug: true,
+		},
+		{
+			name:      "level overrides debug flag",
+			cfg:       config.LoggingConfig{Format: config.LogFormatText, Debug: false, Level: "debug"},
+			wantDebug: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := newLogger(&tt.cfg, new(slog.LevelVar), &buf)
+			logger.Debug("debug message", "key", "value")
+			logger.Info("info message", "key", "value")
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			wantLines := 1
+			if tt.wantDebug {
+				wantLines = 2
+			}
+			if len(lines) != wantLines {
+				t.Fatalf("got %d log lines, want %d:\n%s", len(lines), wantLines, buf.String())
+			}
+
+			last := lines[len(lines)-1]
+			if tt.wantJSON {
+				var record map[string]any
+				if err := json.Unmarshal([]byte(last), &record); err != nil {
+					t.Fatalf("expected JSON output, got %q: %v", last, err)
+				}
+				if record["msg"] != "info message" || record["key"] != "value" {
+					t.Errorf("unexpected JSON record: %v", record)
+				}
+				return
+			}
+			if !strings.Contains(last, `msg="info message"`) ||
+				!strings.Contains(last, "key=value") {
+				t.Errorf("expected text output, got %q", last)
+			}
+		})
+	}
+}
+
+func TestMetricsHandlerAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.MetricsConfig
+		setAuth    func(*http.Request)
+		wantStatus int
+	}{
+		{
+			name:       "open by default",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token missing",
+			cfg:        config.MetricsConfig{Token: "secret"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "token wrong",
+			cfg:  config.MetricsConfig{Token: "secret"},
+			setAuth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer wrong")
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "token valid",
+			cfg:  config.MetricsConfig{Token: "secret"},
+			setAuth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer secret")
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "basic auth missing",
+			cfg: config.MetricsConfig{
+				Username: "prometheus",
+				Password: "secret",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "basic auth wrong password",
+			cfg: config.MetricsConfig{
+				Username: "prometheus",
+				Password: "secret",
+			},
+			setAuth: func(r *http.Request) {
+				r.SetBasicAuth("prometheus", "wrong")
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "basic auth valid",
+			cfg: config.MetricsConfig{
+				Username: "prometheus",
+				Password: "secret",
+			},
+			setAuth: func(r *http.Request) {
+				r.SetBasicAuth("prometheus", "secret")
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "token accepted with basic auth configured",
+			cfg: config.MetricsConfig{
+				Token:    "token",
+				Username: "prometheus",
+				Password: "secret",
+			},
+			setAuth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer token")
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newMetricsHandler(&tt.cfg)
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.setAuth != nil {
+				tt.setAuth(req)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusOK &&
+				!strings.Contains(rec.Body.String(), "go_goroutines") {
+				t.Error("expected metrics in response body")
+			}
+			if rec.Code == http.StatusUnauthorized &&
+				strings.Contains(rec.Body.String(), "go_goroutines") {
+				t.Error("unauthorized response contains metrics")
+			}
+		})
+	}
+}
+
+func TestDebugHandlerPprof(t *testing.T) {
+	paths := []string{
+		"/debug/pprof/",
+		"/debug/pprof/cmdline",
+		"/debug/pprof/symbol",
+		"/debug/pprof/goroutine",
+	}
+
+	for _, enabled := range []bool{false, true} {
+		handler := newDebugHandler(&config.DebugConfig{EnablePprof: enabled})
+		for _, path := range paths {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			wantStatus := http.StatusNotFound
+			if enabled {
+				wantStatus = http.StatusOK
+			}
+			if rec.Code != wantStatus {
+				t.Errorf(
+					"pprof enabled=%t: GET %s status = %d, want %d",
+					enabled,
+					path,
+					rec.Code,
+					wantStatus,
+				)
+			}
+		}
+	}
+}
@@ -23,38 +23,18 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/auth/session": {
-            "post": {
-                "description": "Exchange a wallet-signed challenge for a short-lived session token covering all of the wallet's subscriptions",
-                "consumes": [
-                    "application/json"
-                ],
+        "/api/admin/capacity": {
+            "get": {
+                "description": "Get the number of free WireGuard IPs per region",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "AuthSession",
-                "parameters": [
-                    {
-                        "description": "Session Request",
-                        "name": "SessionRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.SessionRequest"
-                        }
-                    }
-                ],
+                "summary": "AdminCapacity",
                 "responses": {
                     "200": {
-                        "description": "Session token",
-                        "schema": {
-                            "$ref": "#/definitions/api.SessionResponse"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
+                        "description": "Capacity by region",
                         "schema": {
-                            "$ref": "#/definitions/api.ErrorResponse"
+                            "$ref": "#/definitions/api.AdminCapacityResponse"
                         }
                     },
                     "401": {
@@ -63,16 +43,10 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "403": {
-                        "description": "Forbidden (no subscriptions for wallet)",
-                        "schema": {
-                            "$ref": "#/definitions/api.ErrorResponse"
-                        }
-                    },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
@@ -81,201 +55,216 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
-                }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
             }
         },
-        "/api/client/available": {
-            "post": {
-                "description": "Check if a client profile is available",
-                "consumes": [
+        "/api/admin/client/{id}": {
+            "get": {
+                "description": "Get a client's database record, WireGuard peers, S3 peer file, and whether an OpenVPN profile exists",
+                "produces": [
                     "application/json"
                 ],
-                "summary": "ClientAvailable",
+                "summary": "AdminClient",
                 "parameters": [
                     {
-                        "description": "Client Available Request",
-                        "name": "ClientAvailableRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.ClientAvailableRequest"
-                        }
+                        "type": "string",
+                        "description": "Client ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "Client state",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.AdminClientResponse"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
                         "description": "Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
-                }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
             }
         },
-        "/api/client/list": {
+        "/api/admin/client/{id}/regenerate-profile": {
             "post": {
-                "description": "Search for clients matching a given manager public key hash",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Replace a client's OpenVPN profile with one containing a newly issued cert. The client's previous certs are revoked on the next CRL update.",
                 "produces": [
                     "application/json"
                 ],
-                "summary": "ClientList",
+                "summary": "AdminRegenerateProfile",
                 "parameters": [
                     {
-                        "description": "List Request",
-                        "name": "ClientListRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.ClientListRequest"
-                        }
+                        "type": "string",
+                        "description": "Client ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "List of matching clients",
+                        "description": "Profile regenerated",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/api.Client"
-                            }
+                            "$ref": "#/definitions/api.AdminRegenerateProfileResponse"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
                         "description": "Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
-                }
-            }
-        },
-        "/api/client/profile": {
-            "post": {
+                },
                 "security": [
                     {
                         "BearerAuth": []
                     }
-                ],
-                "description": "Fetch a client VPN profile via a signed S3 link; authenticate with a session Bearer token and provide the subscription id in the body",
-                "consumes": [
+                ]
+            }
+        },
+        "/api/admin/reference-history": {
+            "get": {
+                "description": "Get the history of reference data updates, newest first",
+                "produces": [
                     "application/json"
                 ],
-                "summary": "ClientProfile",
+                "summary": "AdminReferenceHistory",
                 "parameters": [
                     {
-                        "description": "Profile Request",
-                        "name": "ClientProfileRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.ClientProfileRequest"
-                        }
+                        "type": "integer",
+                        "description": "Maximum number of updates (default 50, max 1000)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "302": {
-                        "description": "Found",
+                    "200": {
+                        "description": "Reference data updates",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.AdminReferenceHistoryResponse"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "403": {
-                        "description": "Forbidden",
-                        "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
                         "description": "Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
-                }
-            }
-        },
-        "/api/client/wg-devices": {
-            "post": {
+                },
                 "security": [
                     {
                         "BearerAuth": []
                     }
-                ],
-                "description": "List all WireGuard devices registered for a client",
+                ]
+            }
+        },
+        "/api/auth/session": {
+            "post": {
+                "description": "Exchange a wallet-signed challenge for a short-lived session token covering all of the wallet's subscriptions",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "WGDevices",
+                "summary": "AuthSession",
                 "parameters": [
                     {
-                        "description": "Devices Request",
-                        "name": "WGDevicesRequest",
+                        "description": "Session Request",
+                        "name": "SessionRequest",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.WGDevicesRequest"
+                            "$ref": "#/definitions/api.SessionRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Device list",
+                        "description": "Session token",
                         "schema": {
-                            "$ref": "#/definitions/api.WGDevicesResponse"
+                            "$ref": "#/definitions/api.SessionResponse"
                         }
                     },
                     "400": {
@@ -290,10 +279,22 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden (no subscriptions for wallet)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
@@ -305,37 +306,29 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/client/wg-peer": {
-            "delete": {
-                "security": [
-                    {
-                        "BearerAuth": []
-                    }
-                ],
-                "description": "Remove a WireGuard device registration",
+        "/api/client/available": {
+            "post": {
+                "description": "Check if a client profile is available",
                 "consumes": [
                     "application/json"
                 ],
-                "produces": [
-                    "application/json"
-                ],
-                "summary": "WGPeerDelete",
+                "summary": "ClientAvailable",
                 "parameters": [
                     {
-                        "description": "Delete Request",
-                        "name": "WGDeleteRequest",
+                        "description": "Client Available Request",
+                        "name": "ClientAvailableRequest",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.WGDeleteRequest"
+                            "$ref": "#/definitions/api.ClientAvailableRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Deletion successful",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.WGDeleteResponse"
+                            "type": "string"
                         }
                     },
                     "400": {
@@ -344,30 +337,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "403": {
-                        "description": "Forbidden",
+                    "405": {
+                        "description": "Method Not Allowed",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "415": {
+                        "description": "Unsupported Media Type",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "405": {
-                        "description": "Method Not Allowed",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
                     "500": {
                         "description": "Server Error",
                         "schema": {
@@ -377,38 +364,32 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/client/wg-profile": {
+        "/api/client/challenge": {
             "post": {
-                "security": [
-                    {
-                        "BearerAuth": []
-                    }
-                ],
-                "description": "Get a WireGuard configuration profile for a registered device",
+                "description": "Issue a short-lived, single-use nonce for a subscription. Signing it in a session challenge instead of a timestamp prevents the signature from being replayed.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "text/plain",
                     "application/json"
                 ],
-                "summary": "WGProfile",
+                "summary": "ClientChallenge",
                 "parameters": [
                     {
-                        "description": "Profile Request",
-                        "name": "WGProfileRequest",
+                        "description": "Challenge Request",
+                        "name": "ChallengeRequest",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.WGProfileRequest"
+                            "$ref": "#/definitions/api.ChallengeRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "WireGuard config file",
+                        "description": "Challenge nonce",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ChallengeResponse"
                         }
                     },
                     "400": {
@@ -417,7 +398,553 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "401": {
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/client/list": {
+            "get": {
+                "description": "Search for clients matching the payment credential of a given owner address",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ClientListGet",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Owner address",
+                        "name": "ownerAddress",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "List of matching clients",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.Client"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Search for clients matching a given manager public key hash",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ClientList",
+                "parameters": [
+                    {
+                        "description": "List Request",
+                        "name": "ClientListRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ClientListRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "List of matching clients",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.Client"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/client/profile": {
+            "post": {
+                "description": "Fetch a client VPN profile via a signed S3 link, or streamed directly when inline=true; authenticate with a session Bearer token and provide the subscription id in the body",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/x-openvpn-profile"
+                ],
+                "summary": "ClientProfile",
+                "parameters": [
+                    {
+                        "description": "Profile Request",
+                        "name": "ClientProfileRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ClientProfileRequest"
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Stream the profile instead of redirecting",
+                        "name": "inline",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OpenVPN profile (inline=true)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "302": {
+                        "description": "Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
+            }
+        },
+        "/api/client/profile-token": {
+            "post": {
+                "description": "Issue a short-lived token for downloading a client VPN profile with GET /api/client/profile/{id}; authenticate with a session Bearer token and provide the subscription id in the body",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "ClientProfileToken",
+                "parameters": [
+                    {
+                        "description": "Profile Request",
+                        "name": "ClientProfileRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ClientProfileRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Profile download token",
+                        "schema": {
+                            "$ref": "#/definitions/api.ClientProfileTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
+            }
+        },
+        "/api/client/profile/{id}": {
+            "get": {
+                "description": "Download a client VPN profile directly, without a redirect; authenticate with a token from POST /api/client/profile-token",
+                "produces": [
+                    "application/x-openvpn-profile"
+                ],
+                "summary": "ClientProfileDownload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Client ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Profile download token",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OpenVPN profile",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/client/wg-devices": {
+            "post": {
+                "description": "List all WireGuard devices registered for a client",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "WGDevices",
+                "parameters": [
+                    {
+                        "description": "Devices Request",
+                        "name": "WGDevicesRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.WGDevicesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Device list",
+                        "schema": {
+                            "$ref": "#/definitions/api.WGDevicesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
+            }
+        },
+        "/api/client/wg-peer": {
+            "delete": {
+                "description": "Remove a WireGuard device registration",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "WGPeerDelete",
+                "parameters": [
+                    {
+                        "description": "Delete Request",
+                        "name": "WGDeleteRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.WGDeleteRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deletion successful",
+                        "schema": {
+                            "$ref": "#/definitions/api.WGDeleteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
+            }
+        },
+        "/api/client/wg-profile": {
+            "post": {
+                "description": "Get a WireGuard configuration profile for a registered device. Returns the raw config as text/plain by default, or a WGProfileResponse when application/json is preferred by the Accept header.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "text/plain",
+                    "application/json"
+                ],
+                "summary": "WGProfile",
+                "parameters": [
+                    {
+                        "description": "Profile Request",
+                        "name": "WGProfileRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.WGProfileRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "text/plain (default) or application/json",
+                        "name": "Accept",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "WireGuard config file",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
                         "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
@@ -428,17 +955,291 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
-                    },
-                    "404": {
-                        "description": "Not Found",
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
+            }
+        },
+        "/api/client/wg-register": {
+            "post": {
+                "description": "Register a new WireGuard device for a client",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "WGRegister",
+                "parameters": [
+                    {
+                        "description": "Register Request",
+                        "name": "WGRegisterRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.WGRegisterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Registration successful",
+                        "schema": {
+                            "$ref": "#/definitions/api.WGRegisterResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request (includes generic error for duplicate pubkey to prevent enumeration)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden (device limit reached or subscription expired)",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
+            }
+        },
+        "/api/client/wg-rotate": {
+            "post": {
+                "description": "Replace a WireGuard device's pubkey, keeping its assigned IP",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "WGRotate",
+                "parameters": [
+                    {
+                        "description": "Rotate Request",
+                        "name": "WGRotateRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.WGRotateRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Rotation successful",
+                        "schema": {
+                            "$ref": "#/definitions/api.WGRotateResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                },
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ]
+            }
+        },
+        "/api/plans": {
+            "get": {
+                "description": "Fetch plans with durations in days and prices in ADA, and regions",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Plans",
+                "responses": {
+                    "200": {
+                        "description": "Plans and regions",
+                        "schema": {
+                            "$ref": "#/definitions/api.PlansResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/refdata": {
+            "get": {
+                "description": "Fetch prices and regions for signup or renewal",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "RefData",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag of a previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified of a previous response",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Prices and regions",
+                        "schema": {
+                            "$ref": "#/definitions/api.RefDataResponse"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/regions": {
+            "get": {
+                "description": "Fetch regions with their availability, free WireGuard IPs, and plans",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Regions",
+                "responses": {
+                    "200": {
+                        "description": "Regions",
                         "schema": {
-                            "$ref": "#/definitions/api.ErrorResponse"
+                            "$ref": "#/definitions/api.RegionsResponse"
                         }
                     },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
@@ -450,99 +1251,62 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/client/wg-register": {
+        "/api/tx/estimate": {
             "post": {
-                "security": [
-                    {
-                        "BearerAuth": []
-                    }
-                ],
-                "description": "Register a new WireGuard device for a client",
+                "description": "Estimate the cost of a VPN signup without returning the transaction",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "WGRegister",
+                "summary": "TxEstimate",
                 "parameters": [
                     {
-                        "description": "Register Request",
-                        "name": "WGRegisterRequest",
+                        "description": "Signup Request",
+                        "name": "TxSignupRequest",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/api.WGRegisterRequest"
+                            "$ref": "#/definitions/api.TxSignupRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Registration successful",
+                        "description": "Cost breakdown",
                         "schema": {
-                            "$ref": "#/definitions/api.WGRegisterResponse"
+                            "$ref": "#/definitions/api.TxEstimateResponse"
                         }
                     },
                     "400": {
-                        "description": "Bad Request (includes generic error for duplicate pubkey to prevent enumeration)",
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "405": {
+                        "description": "Method Not Allowed",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "403": {
-                        "description": "Forbidden (device limit reached or subscription expired)",
+                    "415": {
+                        "description": "Unsupported Media Type",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
-                    "405": {
-                        "description": "Method Not Allowed",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
                     "500": {
                         "description": "Server Error",
                         "schema": {
                             "$ref": "#/definitions/api.ErrorResponse"
                         }
-                    }
-                }
-            }
-        },
-        "/api/refdata": {
-            "get": {
-                "description": "Fetch prices and regions for signup or renewal",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "summary": "RefData",
-                "responses": {
-                    "200": {
-                        "description": "Prices and regions",
-                        "schema": {
-                            "$ref": "#/definitions/api.RefDataResponse"
-                        }
-                    },
-                    "405": {
-                        "description": "Method Not Allowed",
-                        "schema": {
-                            "type": "string"
-                        }
                     },
-                    "500": {
-                        "description": "Server Error",
+                    "503": {
+                        "description": "Chain Backends Unavailable",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
                 }
@@ -579,19 +1343,31 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
                         "description": "Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Chain Backends Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
                 }
@@ -628,19 +1404,31 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
                         "description": "Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Chain Backends Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
                 }
@@ -672,87 +1460,278 @@ const docTemplate = `{
                     "200": {
                         "description": "Ok",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.TxSubmitResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid or rejected transaction",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Submit API unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/tx/transfer": {
+            "post": {
+                "description": "Build a transaction for a VPN transfer",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "TxTransfer",
+                "parameters": [
+                    {
+                        "description": "Transfer Request",
+                        "name": "TxTransferRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.TxTransferRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Built transaction",
+                        "schema": {
+                            "$ref": "#/definitions/api.TxTransferResponse"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "405": {
                         "description": "Method Not Allowed",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "415": {
                         "description": "Unsupported Media Type",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     },
                     "500": {
                         "description": "Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Chain Backends Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/wg/info": {
+            "get": {
+                "description": "Get the WireGuard server public key, endpoint, and DNS settings",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "WGInfo",
+                "responses": {
+                    "200": {
+                        "description": "Server info",
+                        "schema": {
+                            "$ref": "#/definitions/api.WGInfoResponse"
+                        }
+                    },
+                    "405": {
+                        "description": "Method Not Allowed",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ErrorResponse"
                         }
                     }
                 }
             }
+        }
+    },
+    "definitions": {
+        "api.AdminCapacityResponse": {
+            "type": "object",
+            "properties": {
+                "regions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.RegionCapacity"
+                    }
+                }
+            }
+        },
+        "api.AdminClient": {
+            "type": "object",
+            "properties": {
+                "credential": {
+                    "type": "string"
+                },
+                "device_limit": {
+                    "type": "integer"
+                },
+                "expiration": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "region": {
+                    "type": "string"
+                },
+                "tx_hash": {
+                    "type": "string"
+                },
+                "tx_output_index": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.AdminClientResponse": {
+            "type": "object",
+            "properties": {
+                "client": {
+                    "$ref": "#/definitions/api.AdminClient"
+                },
+                "peers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.WGDeviceInfo"
+                    }
+                },
+                "profile_exists": {
+                    "type": "boolean"
+                },
+                "s3_peer_file": {
+                    "$ref": "#/definitions/api.AdminPeerFile"
+                }
+            }
+        },
+        "api.AdminPeerFile": {
+            "type": "object",
+            "properties": {
+                "asset_name": {
+                    "type": "string"
+                },
+                "peers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.WGDeviceInfo"
+                    }
+                },
+                "updated_at": {
+                    "type": "integer"
+                }
+            }
         },
-        "/api/tx/transfer": {
-            "post": {
-                "description": "Build a transaction for a VPN transfer",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "summary": "TxTransfer",
-                "parameters": [
-                    {
-                        "description": "Transfer Request",
-                        "name": "TxTransferRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/api.TxTransferRequest"
-                        }
+        "api.AdminReferenceHistoryResponse": {
+            "type": "object",
+            "properties": {
+                "history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.AdminReferenceUpdate"
                     }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "Built transaction",
-                        "schema": {
-                            "$ref": "#/definitions/api.TxTransferResponse"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "405": {
-                        "description": "Method Not Allowed",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "500": {
-                        "description": "Server Error",
-                        "schema": {
-                            "type": "string"
-                        }
+                }
+            }
+        },
+        "api.AdminReferenceUpdate": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "output_idx": {
+                    "type": "integer"
+                },
+                "prices": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.RefDataResponsePrice"
+                    }
+                },
+                "regions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
                     }
+                },
+                "tx_id": {
+                    "type": "string"
                 }
             }
-        }
-    },
-    "definitions": {
+        },
+        "api.AdminRegenerateProfileResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ChallengeRequest": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ChallengeResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "integer"
+                },
+                "nonce": {
+                    "type": "string"
+                }
+            }
+        },
         "api.Client": {
             "type": "object",
             "properties": {
@@ -791,9 +1770,144 @@ const docTemplate = `{
                 }
             }
         },
+        "api.ClientProfileTokenResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "integer"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ErrorCode": {
+            "type": "string",
+            "enum": [
+                "invalid_request",
+                "invalid_client_id",
+                "invalid_address",
+                "wrong_network",
+                "invalid_wg_pubkey",
+                "invalid_transaction",
+                "transaction_rejected",
+                "unsupported_media_type",
+                "method_not_allowed",
+                "request_too_large",
+                "unauthorized",
+                "authentication_failed",
+                "unsupported_algorithm",
+                "no_subscriptions",
+                "subscription_expired",
+                "profiles_disabled",
+                "client_not_found",
+                "profile_not_found",
+                "device_not_found",
+                "device_limit_reached",
+                "device_already_registered",
+                "too_many_challenges",
+                "unavailable",
+                "server_misconfigured",
+                "internal_error"
+            ],
+            "x-enum-comments": {
+                "ErrCodeInvalidRequest": "The request body or parameters are malformed or incomplete",
+                "ErrCodeInvalidClientID": "The client ID isn't valid hex",
+                "ErrCodeInvalidAddress": "The address can't be decoded or can't own a subscription",
+                "ErrCodeWrongNetwork": "The address is for a different network than this service",
+                "ErrCodeInvalidWGPubkey": "A WireGuard public key isn't a base64-encoded 32-byte key",
+                "ErrCodeInvalidTransaction": "The transaction can't be decoded or failed sanity checks",
+                "ErrCodeTransactionRejected": "The submit API rejected the transaction",
+                "ErrCodeUnsupportedMediaType": "The request has the wrong Content-Type",
+                "ErrCodeMethodNotAllowed": "The endpoint doesn't support the request method",
+                "ErrCodeRequestTooLarge": "The request body exceeds the size limit",
+                "ErrCodeUnauthorized": "The request has no token",
+                "ErrCodeAuthenticationFailed": "The token or signature isn't valid for the request",
+                "ErrCodeUnsupportedAlgorithm": "The signature uses an algorithm other than EdDSA",
+                "ErrCodeNoSubscriptions": "The wallet doesn't own any subscriptions",
+                "ErrCodeSubscriptionExpired": "The subscription has expired and needs renewing",
+                "ErrCodeProfilesDisabled": "OpenVPN profiles aren't enabled on this server",
+                "ErrCodeClientNotFound": "The subscription doesn't exist",
+                "ErrCodeProfileNotFound": "The subscription's OpenVPN profile hasn't been generated yet",
+                "ErrCodeDeviceNotFound": "The WireGuard device isn't registered to the subscription",
+                "ErrCodeDeviceLimitReached": "The subscription has registered the maximum number of devices",
+                "ErrCodeDeviceAlreadyRegistered": "The new WireGuard key is already registered",
+                "ErrCodeTooManyChallenges": "The subscription has too many unused challenge nonces, try again later",
+                "ErrCodeUnavailable": "A dependency is temporarily unavailable, try again later",
+                "ErrCodeServerMisconfigured": "The server is missing configuration needed for the request",
+                "ErrCodeInternal": "An unexpected server error"
+            },
+            "x-enum-descriptions": [
+                "The request body or parameters are malformed or incomplete",
+                "The client ID isn't valid hex",
+                "The address can't be decoded or can't own a subscription",
+                "The address is for a different network than this service",
+                "A WireGuard public key isn't a base64-encoded 32-byte key",
+                "The transaction can't be decoded or failed sanity checks",
+                "The submit API rejected the transaction",
+                "The request has the wrong Content-Type",
+                "The endpoint doesn't support the request method",
+                "The request body exceeds the size limit",
+                "The request has no token",
+                "The token or signature isn't valid for the request",
+                "The signature uses an algorithm other than EdDSA",
+                "The wallet doesn't own any subscriptions",
+                "The subscription has expired and needs renewing",
+                "OpenVPN profiles aren't enabled on this server",
+                "The subscription doesn't exist",
+                "The subscription's OpenVPN profile hasn't been generated yet",
+                "The WireGuard device isn't registered to the subscription",
+                "The subscription has registered the maximum number of devices",
+                "The new WireGuard key is already registered",
+                "The subscription has too many unused challenge nonces, try again later",
+                "A dependency is temporarily unavailable, try again later",
+                "The server is missing configuration needed for the request",
+                "An unexpected server error"
+            ],
+            "x-enum-varnames": [
+                "ErrCodeInvalidRequest",
+                "ErrCodeInvalidClientID",
+                "ErrCodeInvalidAddress",
+                "ErrCodeWrongNetwork",
+                "ErrCodeInvalidWGPubkey",
+                "ErrCodeInvalidTransaction",
+                "ErrCodeTransactionRejected",
+                "ErrCodeUnsupportedMediaType",
+                "ErrCodeMethodNotAllowed",
+                "ErrCodeRequestTooLarge",
+                "ErrCodeUnauthorized",
+                "ErrCodeAuthenticationFailed",
+                "ErrCodeUnsupportedAlgorithm",
+                "ErrCodeNoSubscriptions",
+                "ErrCodeSubscriptionExpired",
+                "ErrCodeProfilesDisabled",
+                "ErrCodeClientNotFound",
+                "ErrCodeProfileNotFound",
+                "ErrCodeDeviceNotFound",
+                "ErrCodeDeviceLimitReached",
+                "ErrCodeDeviceAlreadyRegistered",
+                "ErrCodeTooManyChallenges",
+                "ErrCodeUnavailable",
+                "ErrCodeServerMisconfigured",
+                "ErrCodeInternal"
+            ]
+        },
         "api.ErrorResponse": {
             "type": "object",
             "properties": {
+                "code": {
+                    "description": "Code identifies the failure for clients to branch on",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/api.ErrorCode"
+                        }
+                    ]
+                },
+                "details": {
+                    "description": "Details carries structured context for the error (e.g. the\nsubscription expiration) so UIs don't need to parse Reason",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
                 "error": {
                     "type": "string"
                 },
@@ -802,9 +1916,52 @@ const docTemplate = `{
                 }
             }
         },
+        "api.PlansResponse": {
+            "type": "object",
+            "properties": {
+                "plans": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.PlansResponsePlan"
+                    }
+                },
+                "regions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "api.PlansResponsePlan": {
+            "type": "object",
+            "properties": {
+                "duration": {
+                    "type": "integer"
+                },
+                "durationDays": {
+                    "type": "number"
+                },
+                "planId": {
+                    "type": "string"
+                },
+                "price": {
+                    "type": "integer"
+                },
+                "priceAda": {
+                    "type": "number"
+                }
+            }
+        },
         "api.RefDataResponse": {
             "type": "object",
             "properties": {
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "prices": {
                     "type": "array",
                     "items": {
@@ -816,6 +1973,9 @@ const docTemplate = `{
                     "items": {
                         "type": "string"
                     }
+                },
+                "version": {
+                    "type": "integer"
                 }
             }
         },
@@ -825,11 +1985,59 @@ const docTemplate = `{
                 "duration": {
                     "type": "integer"
                 },
+                "planId": {
+                    "type": "string"
+                },
                 "price": {
                     "type": "integer"
                 }
             }
         },
+        "api.RegionCapacity": {
+            "type": "object",
+            "properties": {
+                "free_ips": {
+                    "type": "integer"
+                },
+                "region": {
+                    "type": "string"
+                },
+                "total_ips": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.RegionsResponse": {
+            "type": "object",
+            "properties": {
+                "regions": {
+                    "items": {
+                        "$ref": "#/definitions/api.RegionsResponseRegion"
+                    },
+                    "type": "array"
+                }
+            }
+        },
+        "api.RegionsResponseRegion": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "boolean"
+                },
+                "freeIps": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "plans": {
+                    "items": {
+                        "$ref": "#/definitions/api.PlansResponsePlan"
+                    },
+                    "type": "array"
+                }
+            }
+        },
         "api.SessionRequest": {
             "type": "object",
             "required": [
@@ -856,6 +2064,23 @@ const docTemplate = `{
                 }
             }
         },
+        "api.TxEstimateResponse": {
+            "type": "object",
+            "properties": {
+                "fee": {
+                    "type": "integer"
+                },
+                "minAda": {
+                    "type": "integer"
+                },
+                "servicePrice": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
         "api.TxRenewRequest": {
             "type": "object",
             "properties": {
@@ -871,6 +2096,10 @@ const docTemplate = `{
                 "paymentAddress": {
                     "type": "string"
                 },
+                "planId": {
+                    "description": "PlanId selects the plan instead of price and duration when provided",
+                    "type": "string"
+                },
                 "price": {
                     "type": "integer"
                 }
@@ -896,6 +2125,10 @@ const docTemplate = `{
                 "paymentAddress": {
                     "type": "string"
                 },
+                "planId": {
+                    "description": "PlanId selects the plan instead of price and duration when provided",
+                    "type": "string"
+                },
                 "price": {
                     "type": "integer"
                 },
@@ -915,6 +2148,14 @@ const docTemplate = `{
                 }
             }
         },
+        "api.TxSubmitResponse": {
+            "type": "object",
+            "properties": {
+                "txHash": {
+                    "type": "string"
+                }
+            }
+        },
         "api.TxTransferRequest": {
             "type": "object",
             "properties": {
@@ -995,6 +2236,29 @@ const docTemplate = `{
                 }
             }
         },
+        "api.WGInfoResponse": {
+            "type": "object",
+            "properties": {
+                "dns": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "dns_search": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "endpoint": {
+                    "type": "string"
+                },
+                "server_pubkey": {
+                    "type": "string"
+                }
+            }
+        },
         "api.WGProfileRequest": {
             "type": "object",
             "properties": {
@@ -1029,6 +2293,35 @@ const docTemplate = `{
                 "device_limit": {
                     "type": "integer"
                 },
+                "success": {
+                    "type": "boolean"
+                },
+                "cache_stale": {
+                    "description": "CacheStale is set when the device was registered but couldn't be\nwritten to the indexer's database, so other endpoints may not report it\nyet. Clients should re-query the device list later.",
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.WGRotateRequest": {
+            "type": "object",
+            "properties": {
+                "client_id": {
+                    "type": "string"
+                },
+                "new_wg_pubkey": {
+                    "type": "string"
+                },
+                "old_wg_pubkey": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.WGRotateResponse": {
+            "type": "object",
+            "properties": {
+                "assigned_ip": {
+                    "type": "string"
+                },
                 "success": {
                     "type": "boolean"
                 }
@@ -0,0 +1,516 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+// RegionCapacity describes the WireGuard IP pool capacity for a region
+type RegionCapacity struct {
+	Region   string `json:"region"`
+	FreeIPs  int    `json:"free_ips"`
+	TotalIPs int    `json:"total_ips"`
+}
+
+// AdminCapacityResponse is the response for GET /api/admin/capacity
+type AdminCapacityResponse struct {
+	Regions []RegionCapacity `json:"regions"`
+}
+
+// AdminClient is the full database record for a client
+type AdminClient struct {
+	Id            string    `json:"id"`
+	Expiration    time.Time `json:"expiration"`
+	Credential    string    `json:"credential"`
+	Region        string    `json:"region"`
+	TxHash        string    `json:"tx_hash"`
+	TxOutputIndex uint      `json:"tx_output_index"`
+	DeviceLimit   int       `json:"device_limit"`
+}
+
+// AdminPeerFile is the content of a client's peer file in S3
+type AdminPeerFile struct {
+	AssetName string         `json:"asset_name"`
+	Peers     []WGDeviceInfo `json:"peers"`
+	UpdatedAt int64          `json:"updated_at"`
+}
+
+// AdminClientResponse is the response for GET /api/admin/client/{id}. The
+// S3 peer file is null when none has been written for the client.
+type AdminClientResponse struct {
+	Client        AdminClient    `json:"client"`
+	Peers         []WGDeviceInfo `json:"peers"`
+	S3PeerFile    *AdminPeerFile `json:"s3_peer_file"`
+	ProfileExists bool           `json:"profile_exists"`
+}
+
+// AdminRegenerateProfileResponse is the response for POST
+// /api/admin/client/{id}/regenerate-profile
+type AdminRegenerateProfileResponse struct {
+	Id string `json:"id"`
+}
+
+// AdminReferenceUpdate is a recorded update of the on-chain reference data
+type AdminReferenceUpdate struct {
+	TxId      string                 `json:"tx_id"`
+	OutputIdx int                    `json:"output_idx"`
+	Prices    []RefDataResponsePrice `json:"prices"`
+	Regions   []string               `json:"regions"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// AdminReferenceHistoryResponse is the response for GET
+// /api/admin/reference-history, newest update first
+type AdminReferenceHistoryResponse struct {
+	History []AdminReferenceUpdate `json:"history"`
+}
+
+const (
+	// defaultReferenceHistoryLimit is the number of reference data updates
+	// returned when no limit is requested
+	defaultReferenceHistoryLimit = 50
+	// maxReferenceHistoryLimit caps the requested number of updates
+	maxReferenceHistoryLimit = 1000
+)
+
+// adminPathPrefix is the path prefix of the admin routes
+const adminPathPrefix = "/api/admin/"
+
+// adminAuthMiddleware requires the admin token for every route under
+// /api/admin/. The admin routes are disabled, answering 404, when no token is
+// configured.
+func (a *Api) adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.cfg.Api.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !a.authorizeAdmin(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorizeAdmin checks the request carries the configured admin Bearer
+// token, writing a 401 response if it doesn't
+func (a *Api) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := bearerToken(r)
+	adminToken := a.cfg.Api.AdminToken
+	if adminToken == "" || token == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		writeErrorResponse(
+			w,
+			http.StatusUnauthorized,
+			ErrCodeUnauthorized,
+			"Unauthorized",
+			"admin token required",
+		)
+		return false
+	}
+	return true
+}
+
+// handleAdminCapacity godoc
+//
+//	@Summary		AdminCapacity
+//	@Description	Get the number of free WireGuard IPs per region
+//	@Produce		json
+//	@Success		200	{object}	AdminCapacityResponse	"Capacity by region"
+//	@Failure		401	{object}	ErrorResponse			"Unauthorized"
+//	@Failure		405	{object}	ErrorResponse			"Method Not Allowed"
+//	@Failure		500	{object}	ErrorResponse			"Server Error"
+//	@Security		BearerAuth
+//	@Router			/api/admin/capacity [get]
+func (a *Api) handleAdminCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	// Report every region with a pool, plus our own even before its first
+	// allocation
+	regions, err := a.db.GetWGIPPoolRegionsContext(r.Context())
+	if err != nil {
+		slog.Error("failed to get IP pool regions", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	if a.cfg.Vpn.Region != "" && !slices.Contains(regions, a.cfg.Vpn.Region) {
+		regions = append(regions, a.cfg.Vpn.Region)
+		slices.Sort(regions)
+	}
+
+	resp := AdminCapacityResponse{
+		Regions: make([]RegionCapacity, 0, len(regions)),
+	}
+	for _, region := range regions {
+		free, err := a.db.CountFreeIPsContext(r.Context(), region)
+		if err != nil {
+			slog.Error(
+				"failed to count free IPs",
+				"region", region,
+				"error", err,
+			)
+			writeErrorResponse(
+				w,
+				http.StatusInternalServerError,
+				ErrCodeInternal,
+				"Internal server error",
+				"",
+			)
+			return
+		}
+		resp.Regions = append(resp.Regions, RegionCapacity{
+			Region:   region,
+			FreeIPs:  free,
+			TotalIPs: database.WGUsableHosts,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBytes, _ := json.Marshal(resp)
+	_, _ = w.Write(respBytes)
+}
+
+// handleAdminClient godoc
+//
+//	@Summary		AdminClient
+//	@Description	Get a client's database record, WireGuard peers, S3 peer file, and whether an OpenVPN profile exists
+//	@Produce		json
+//	@Param			id	path		string				true	"Client ID"
+//	@Success		200	{object}	AdminClientResponse	"Client state"
+//	@Failure		400	{object}	ErrorResponse		"Bad Request"
+//	@Failure		401	{object}	ErrorResponse		"Unauthorized"
+//	@Failure		404	{object}	ErrorResponse		"Not Found"
+//	@Failure		405	{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		500	{object}	ErrorResponse		"Server Error"
+//	@Security		BearerAuth
+//	@Router			/api/admin/client/{id} [get]
+func (a *Api) handleAdminClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	assetName, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil || len(assetName) == 0 {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidClientID,
+			"Invalid request",
+			"invalid client ID",
+		)
+		return
+	}
+	logger := slog.With("asset_name", hex.EncodeToString(assetName))
+
+	tmpClient, err := a.db.ClientByAssetNameContext(r.Context(), assetName)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			writeErrorResponse(
+				w,
+				http.StatusNotFound,
+				ErrCodeClientNotFound,
+				"Not found",
+				"client not found",
+			)
+			return
+		}
+		logger.Error("failed to lookup client in database", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	peers, err := a.db.GetWGPeersByAssetContext(r.Context(), assetName)
+	if err != nil {
+		logger.Error("failed to get WireGuard peers", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	s3Client := client.New(a.cfg, a.ca, a.db, assetName)
+	peerFile, err := s3Client.LoadPeersFromS3(assetName)
+	if err != nil {
+		logger.Error("failed to load peer file from S3", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	profileExists, err := s3Client.ProfileExists()
+	if err != nil {
+		logger.Error("failed to check if profile exists", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	resp := AdminClientResponse{
+		Client: AdminClient{
+			Id:            hex.EncodeToString(tmpClient.AssetName),
+			Expiration:    tmpClient.Expiration,
+			Credential:    hex.EncodeToString(tmpClient.Credential),
+			Region:        tmpClient.Region,
+			TxHash:        hex.EncodeToString(tmpClient.TxHash),
+			TxOutputIndex: tmpClient.TxOutputIndex,
+			DeviceLimit:   tmpClient.DeviceLimit,
+		},
+		Peers:         make([]WGDeviceInfo, 0, len(peers)),
+		ProfileExists: profileExists,
+	}
+	for _, peer := range peers {
+		resp.Peers = append(resp.Peers, WGDeviceInfo{
+			Pubkey:     peer.Pubkey,
+			AssignedIP: peer.AssignedIP,
+			CreatedAt:  peer.CreatedAt.Unix(),
+		})
+	}
+	if peerFile != nil {
+		resp.S3PeerFile = &AdminPeerFile{
+			AssetName: peerFile.AssetName,
+			Peers:     make([]WGDeviceInfo, 0, len(peerFile.Peers)),
+			UpdatedAt: peerFile.UpdatedAt,
+		}
+		for _, peer := range peerFile.Peers {
+			resp.S3PeerFile.Peers = append(
+				resp.S3PeerFile.Peers,
+				WGDeviceInfo(peer),
+			)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBytes, _ := json.Marshal(resp)
+	_, _ = w.Write(respBytes)
+}
+
+// handleAdminRegenerateProfile godoc
+//
+//	@Summary		AdminRegenerateProfile
+//	@Description	Replace a client's OpenVPN profile with one containing a newly issued cert. The client's previous certs are revoked on the next CRL update.
+//	@Produce		json
+//	@Param			id	path		string							true	"Client ID"
+//	@Success		200	{object}	AdminRegenerateProfileResponse	"Profile regenerated"
+//	@Failure		400	{object}	ErrorResponse					"Bad Request"
+//	@Failure		401	{object}	ErrorResponse					"Unauthorized"
+//	@Failure		404	{object}	ErrorResponse					"Not Found"
+//	@Failure		405	{object}	ErrorResponse					"Method Not Allowed"
+//	@Failure		500	{object}	ErrorResponse					"Server Error"
+//	@Security		BearerAuth
+//	@Router			/api/admin/client/{id}/regenerate-profile [post]
+func (a *Api) handleAdminRegenerateProfile(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	assetName, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil || len(assetName) == 0 {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidClientID,
+			"Invalid request",
+			"invalid client ID",
+		)
+		return
+	}
+	if a.ca == nil {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeProfilesDisabled,
+			"Invalid request",
+			"OpenVPN profiles are not enabled",
+		)
+		return
+	}
+	logger := slog.With("asset_name", hex.EncodeToString(assetName))
+
+	tmpClient, err := a.db.ClientByAssetNameContext(r.Context(), assetName)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			writeErrorResponse(
+				w,
+				http.StatusNotFound,
+				ErrCodeClientNotFound,
+				"Not found",
+				"client not found",
+			)
+			return
+		}
+		logger.Error("failed to lookup client in database", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	clientId, err := client.New(a.cfg, a.ca, a.db, assetName).Regenerate(
+		a.cfg.Vpn.ProfileHost(tmpClient.Region),
+		a.cfg.Vpn.Port,
+		a.cfg.Vpn.DNS,
+	)
+	if err != nil {
+		logger.Error("failed to regenerate profile", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	logger.Info("regenerated client profile")
+
+	w.Header().Set("Content-Type", "application/json")
+	respBytes, _ := json.Marshal(AdminRegenerateProfileResponse{Id: clientId})
+	_, _ = w.Write(respBytes)
+}
+
+// handleAdminReferenceHistory godoc
+//
+//	@Summary		AdminReferenceHistory
+//	@Description	Get the history of reference data updates, newest first
+//	@Produce		json
+//	@Param			limit	query		int								false	"Maximum number of updates (default 50, max 1000)"
+//	@Success		200		{object}	AdminReferenceHistoryResponse	"Reference data updates"
+//	@Failure		400		{object}	ErrorResponse					"Bad Request"
+//	@Failure		401		{object}	ErrorResponse					"Unauthorized"
+//	@Failure		405		{object}	ErrorResponse					"Method Not Allowed"
+//	@Failure		500		{object}	ErrorResponse					"Server Error"
+//	@Security		BearerAuth
+//	@Router			/api/admin/reference-history [get]
+func (a *Api) handleAdminReferenceHistory(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	limit := defaultReferenceHistoryLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			writeErrorResponse(
+				w,
+				http.StatusBadRequest,
+				ErrCodeInvalidRequest,
+				"Invalid request",
+				"limit must be a positive integer",
+			)
+			return
+		}
+		limit = min(limit, maxReferenceHistoryLimit)
+	}
+
+	history, err := a.db.ReferenceHistoryContext(r.Context(), limit)
+	if err != nil {
+		slog.Error("failed to get reference history", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	resp := AdminReferenceHistoryResponse{
+		History: make([]AdminReferenceUpdate, 0, len(history)),
+	}
+	for _, entry := range history {
+		update := AdminReferenceUpdate{
+			TxId:      hex.EncodeToString(entry.TxId),
+			OutputIdx: entry.OutputIdx,
+			Prices:    make([]RefDataResponsePrice, 0, len(entry.Prices)),
+			Regions:   entry.Regions,
+			CreatedAt: entry.CreatedAt,
+		}
+		for _, price := range entry.Prices {
+			update.Prices = append(update.Prices, RefDataResponsePrice{
+				Duration: price.Duration,
+				Price:    price.Price,
+				PlanId: database.ReferencePrice{
+					Duration: price.Duration,
+					Price:    price.Price,
+				}.PlanId(),
+			})
+		}
+		resp.History = append(resp.History, update)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBytes, _ := json.Marshal(resp)
+	_, _ = w.Write(respBytes)
+}
@@ -0,0 +1,687 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/ledger/shelley"
+	"github.com/blinklabs-io/vpn-indexer/internal/ca"
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+func TestAdminCapacity(t *testing.T) {
+	a := newTestApi(t)
+	a.cfg.Api.AdminToken = "admin-secret"
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+	}{
+		{
+			name:       "valid token",
+			method:     http.MethodGet,
+			token:      "admin-secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing token",
+			method:     http.MethodGet,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong token",
+			method:     http.MethodGet,
+			token:      "not-the-secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodPost,
+			token:      "admin-secret",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	handler := a.adminAuthMiddleware(a.routes())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/admin/capacity", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp AdminCapacityResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(resp.Regions) != 1 || resp.Regions[0].Region != "test" {
+				t.Fatalf("regions = %+v, want only %q", resp.Regions, "test")
+			}
+			if resp.Regions[0].FreeIPs != database.WGUsableHosts {
+				t.Errorf(
+					"free_ips = %d, want %d",
+					resp.Regions[0].FreeIPs,
+					database.WGUsableHosts,
+				)
+			}
+		})
+	}
+}
+
+func TestAdminClient(t *testing.T) {
+	const peerPubkey = "cGVlci1wdWJrZXktcGxhY2Vob2xkZXItMDAwMDAwMDA="
+	a := newTestApi(t)
+	a.cfg.Api.AdminToken = "admin-secret"
+	newTestS3Store(t, a)
+
+	// One client has peers, an S3 peer file, and a profile; the other has
+	// only its database record
+	fullAsset := []byte("full-client")
+	bareAsset := []byte("bare-client")
+	for _, assetName := range [][]byte{fullAsset, bareAsset} {
+		if err := a.db.AddClient(
+			assetName,
+			time.Now().Add(time.Hour),
+			[]byte("credential"),
+			"test",
+			[]byte("txhash"),
+			1,
+			3,
+		); err != nil {
+			t.Fatalf("failed to add client: %v", err)
+		}
+	}
+	if err := a.db.AddWGPeer(fullAsset, peerPubkey, "10.8.0.2"); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if err := client.NewWithConfig(a.cfg).SavePeerToS3(
+		fullAsset,
+		peerPubkey,
+		"10.8.0.2",
+	); err != nil {
+		t.Fatalf("failed to save peer to S3: %v", err)
+	}
+	profileReq, err := http.NewRequest(
+		http.MethodPut,
+		a.cfg.S3.Endpoint+"/test-bucket/"+hex.EncodeToString(fullAsset)+".ovpn",
+		strings.NewReader("profile"),
+	)
+	if err != nil {
+		t.Fatalf("failed to build profile request: %v", err)
+	}
+	profileResp, err := http.DefaultClient.Do(profileReq)
+	if err != nil {
+		t.Fatalf("failed to upload profile: %v", err)
+	}
+	_ = profileResp.Body.Close()
+
+	tests := []struct {
+		name        string
+		id          string
+		token       string
+		wantStatus  int
+		wantPeers   int
+		wantS3File  bool
+		wantProfile bool
+	}{
+		{
+			name:        "full client",
+			id:          hex.EncodeToString(fullAsset),
+			token:       "admin-secret",
+			wantStatus:  http.StatusOK,
+			wantPeers:   1,
+			wantS3File:  true,
+			wantProfile: true,
+		},
+		{
+			name:       "bare client",
+			id:         hex.EncodeToString(bareAsset),
+			token:      "admin-secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing token",
+			id:         hex.EncodeToString(fullAsset),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong token",
+			id:         hex.EncodeToString(fullAsset),
+			token:      "not-the-secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid id",
+			id:         "not-hex",
+			token:      "admin-secret",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown client",
+			id:         hex.EncodeToString([]byte("unknown")),
+			token:      "admin-secret",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	handler := a.adminAuthMiddleware(a.routes())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodGet,
+				"/api/admin/client/"+tt.id,
+				nil,
+			)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp AdminClientResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Client.Id != tt.id {
+				t.Errorf("client id = %q, want %q", resp.Client.Id, tt.id)
+			}
+			wantCredential := hex.EncodeToString([]byte("credential"))
+			if resp.Client.Credential != wantCredential ||
+				resp.Client.TxOutputIndex != 1 ||
+				resp.Client.DeviceLimit != 3 {
+				t.Errorf("unexpected client record: %+v", resp.Client)
+			}
+			if len(resp.Peers) != tt.wantPeers {
+				t.Fatalf("peers = %+v, want %d", resp.Peers, tt.wantPeers)
+			}
+			if tt.wantPeers > 0 && resp.Peers[0].Pubkey != peerPubkey {
+				t.Errorf(
+					"peer pubkey = %q, want %q",
+					resp.Peers[0].Pubkey,
+					peerPubkey,
+				)
+			}
+			if (resp.S3PeerFile != nil) != tt.wantS3File {
+				t.Fatalf(
+					"s3_peer_file = %+v, want present=%t",
+					resp.S3PeerFile,
+					tt.wantS3File,
+				)
+			}
+			if tt.wantS3File &&
+				(len(resp.S3PeerFile.Peers) != 1 ||
+					resp.S3PeerFile.Peers[0].Pubkey != peerPubkey) {
+				t.Errorf("unexpected S3 peers: %+v", resp.S3PeerFile.Peers)
+			}
+			if resp.ProfileExists != tt.wantProfile {
+				t.Errorf(
+					"profile_exists = %t, want %t",
+					resp.ProfileExists,
+					tt.wantProfile,
+				)
+			}
+		})
+	}
+}
+
+// newTestCa generates a self-signed CA and sets it on the Api
+func newTestCa(t *testing.T, a *Api) {
+	t.Helper()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	certBytes, err := x509.CreateCertificate(
+		rand.Reader,
+		tmpl,
+		tmpl,
+		pubKey,
+		privKey,
+	)
+	if err != nil {
+		t.Fatalf("failed to create CA cert: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatalf("failed to marshal CA key: %v", err)
+	}
+	a.cfg.Ca.Cert = string(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}),
+	)
+	a.cfg.Ca.Key = string(
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}),
+	)
+	a.ca, err = ca.New(a.cfg, a.db)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+}
+
+func TestAdminRegenerateProfile(t *testing.T) {
+	a := newTestApi(t)
+	a.cfg.Api.AdminToken = "admin-secret"
+	a.cfg.Vpn.Domain = "test.domain"
+	a.cfg.Vpn.Port = 443
+	newTestS3Store(t, a)
+	newTestCa(t, a)
+
+	assetName := []byte("regen-client")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		[]byte("credential"),
+		"test",
+		[]byte("txhash"),
+		1,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	profileClient := client.New(a.cfg, a.ca, a.db, assetName)
+	if _, err := profileClient.Generate(
+		"test.test.domain",
+		443,
+		"",
+	); err != nil {
+		t.Fatalf("failed to generate profile: %v", err)
+	}
+	readProfile := func() string {
+		t.Helper()
+		body, err := profileClient.OpenProfile(t.Context())
+		if err != nil {
+			t.Fatalf("failed to open profile: %v", err)
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("failed to read profile: %v", err)
+		}
+		return string(data)
+	}
+	oldProfile := readProfile()
+
+	handler := a.adminAuthMiddleware(a.routes())
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/api/admin/client/"+hex.EncodeToString(assetName)+
+			"/regenerate-profile",
+		nil,
+	)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf(
+			"status = %d, want %d (body: %s)",
+			w.Code,
+			http.StatusOK,
+			w.Body.String(),
+		)
+	}
+	var resp AdminRegenerateProfileResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Id != hex.EncodeToString(assetName) {
+		t.Errorf("id = %q, want %q", resp.Id, hex.EncodeToString(assetName))
+	}
+
+	newProfile := readProfile()
+	if newProfile == oldProfile {
+		t.Fatal("profile was not regenerated")
+	}
+	if !strings.Contains(newProfile, "remote test.test.domain 443") {
+		t.Errorf("unexpected regenerated profile:\n%s", newProfile)
+	}
+	// The old cert is revoked and the new one has a different serial
+	certs, err := a.db.IssuedCertsByAsset(assetName)
+	if err != nil {
+		t.Fatalf("failed to get issued certs: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("got %d issued certs, want 2", len(certs))
+	}
+	if certs[0].RevokedAt == nil || certs[1].RevokedAt != nil {
+		t.Errorf(
+			"revoked at = %v, %v, want only the first cert revoked",
+			certs[0].RevokedAt,
+			certs[1].RevokedAt,
+		)
+	}
+	if slices.Equal(certs[0].Serial, certs[1].Serial) {
+		t.Error("regenerated cert reused the revoked serial")
+	}
+
+	// When the new profile can't be uploaded, the current cert isn't revoked
+	failingS3 := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}),
+	)
+	t.Cleanup(failingS3.Close)
+	s3Endpoint := a.cfg.S3.Endpoint
+	a.cfg.S3.Endpoint = failingS3.URL
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	a.cfg.S3.Endpoint = s3Endpoint
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf(
+			"failed upload status = %d, want %d",
+			w.Code,
+			http.StatusInternalServerError,
+		)
+	}
+	certs, err = a.db.IssuedCertsByAsset(assetName)
+	if err != nil {
+		t.Fatalf("failed to get issued certs: %v", err)
+	}
+	if certs[1].RevokedAt != nil {
+		t.Error("current cert was revoked without a replacement profile")
+	}
+	if readProfile() != newProfile {
+		t.Error("profile changed by a failed regeneration")
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		id         string
+		noCa       bool
+		wantStatus int
+	}{
+		{
+			name:       "unknown client",
+			method:     http.MethodPost,
+			id:         hex.EncodeToString([]byte("unknown")),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid id",
+			method:     http.MethodPost,
+			id:         "not-hex",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no CA",
+			method:     http.MethodPost,
+			id:         hex.EncodeToString(assetName),
+			noCa:       true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			id:         hex.EncodeToString(assetName),
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.noCa {
+				origCa := a.ca
+				a.ca = nil
+				t.Cleanup(func() { a.ca = origCa })
+			}
+			req := httptest.NewRequest(
+				tt.method,
+				"/api/admin/client/"+tt.id+"/regenerate-profile",
+				nil,
+			)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+		})
+	}
+}
+
+func TestAdminReferenceHistory(t *testing.T) {
+	a := newTestApi(t)
+	a.cfg.Api.AdminToken = "admin-secret"
+
+	// Each price change is kept in the history
+	for i, price := range []int{5_000_000, 6_000_000, 7_000_000} {
+		if err := a.db.UpdateReferenceData(
+			shelley.NewShelleyTransactionInput(strings.Repeat("ab", 32), i),
+			[]database.ReferencePrice{{Duration: 30, Price: price}},
+			[]string{"test"},
+			0,
+			nil,
+		); err != nil {
+			t.Fatalf("failed to update reference data: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantPrices []int
+	}{
+		{
+			name:       "all updates",
+			wantStatus: http.StatusOK,
+			wantPrices: []int{7_000_000, 6_000_000, 5_000_000},
+		},
+		{
+			name:       "limit",
+			query:      "?limit=2",
+			wantStatus: http.StatusOK,
+			wantPrices: []int{7_000_000, 6_000_000},
+		},
+		{
+			name:       "invalid limit",
+			query:      "?limit=0",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	handler := a.adminAuthMiddleware(a.routes())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodGet,
+				"/api/admin/reference-history"+tt.query,
+				nil,
+			)
+			req.Header.Set("Authorization", "Bearer admin-secret")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp AdminReferenceHistoryResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(resp.History) != len(tt.wantPrices) {
+				t.Fatalf(
+					"history has %d entries, want %d",
+					len(resp.History),
+					len(tt.wantPrices),
+				)
+			}
+			for i, update := range resp.History {
+				if len(update.Prices) != 1 ||
+					update.Prices[0].Price != tt.wantPrices[i] {
+					t.Errorf(
+						"history[%d] prices = %+v, want price %d",
+						i,
+						update.Prices,
+						tt.wantPrices[i],
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		adminToken string
+		path       string
+		header     string
+		wantStatus int
+	}{
+		{
+			name:       "valid token",
+			adminToken: "admin-secret",
+			path:       "/api/admin/capacity",
+			header:     "Bearer admin-secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "lowercase scheme",
+			adminToken: "admin-secret",
+			path:       "/api/admin/capacity",
+			header:     "bearer admin-secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing token",
+			adminToken: "admin-secret",
+			path:       "/api/admin/capacity",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong token",
+			adminToken: "admin-secret",
+			path:       "/api/admin/capacity",
+			header:     "Bearer not-the-secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token prefix",
+			adminToken: "admin-secret",
+			path:       "/api/admin/capacity",
+			header:     "Bearer admin",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "non-bearer scheme",
+			adminToken: "admin-secret",
+			path:       "/api/admin/capacity",
+			header:     "Basic admin-secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "admin disabled",
+			path:       "/api/admin/capacity",
+			header:     "Bearer admin-secret",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "non-admin route",
+			adminToken: "admin-secret",
+			path:       "/api/client/list",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.Api.AdminToken = tt.adminToken
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			a.adminAuthMiddleware(next).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+		})
+	}
+}
@@ -15,23 +15,36 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
-	_ "github.com/blinklabs-io/vpn-indexer/docs" // docs is generated by Swag CLI
 	"github.com/blinklabs-io/vpn-indexer/internal/ca"
 	"github.com/blinklabs-io/vpn-indexer/internal/client"
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
 	"github.com/blinklabs-io/vpn-indexer/internal/database"
 	"github.com/blinklabs-io/vpn-indexer/internal/jwt"
+	"github.com/blinklabs-io/vpn-indexer/internal/requestid"
+	"github.com/blinklabs-io/vpn-indexer/internal/version"
 	"github.com/blinklabs-io/vpn-indexer/internal/wireguard"
-	httpSwagger "github.com/swaggo/http-swagger"
 )
 
 const (
 	healthcheckPath = "/healthcheck"
+	readyzPath      = "/readyz"
+	versionPath     = "/version"
+
+	// readyzTimeout bounds the dependency checks made by /readyz
+	readyzTimeout = 5 * time.Second
+	// readyzS3CacheTTL is how long the result of the S3 check made by
+	// /readyz is reused, so frequent probes don't each reach S3
+	readyzS3CacheTTL = 10 * time.Second
 )
 
 // Api holds the dependencies for the API server.
@@ -40,8 +53,56 @@ type Api struct {
 	db        *database.Database
 	ca        *ca.Ca
 	wgClient  *wireguard.Client
-	s3Client  *client.Client
 	jwtIssuer *jwt.Issuer
+
+	// Dependencies of the WireGuard device handlers, which are replaced with
+	// in-memory fakes in tests
+	peerManager PeerManager
+	peerStore   PeerStore
+
+	// Cached response for GET /api/wg/info
+	wgInfoMutex   sync.Mutex
+	wgInfo        *WGInfoResponse
+	wgInfoExpires time.Time
+
+	// Background AddPeer retries started by wgRegisterImpl
+	peerRetries sync.WaitGroup
+	// peerRetryInterval overrides AddPeerRetryInterval when non-zero
+	peerRetryInterval time.Duration
+	// cacheRetryInterval overrides CacheWriteRetryInterval when non-zero
+	cacheRetryInterval time.Duration
+
+	// Client for the S3 check made by GET /readyz, built once in Start. nil
+	// when no S3 client bucket is configured.
+	s3Checker S3Checker
+	// Cached result of the S3 check made by GET /readyz
+	s3HealthMutex   sync.Mutex
+	s3Health        error
+	s3HealthExpires time.Time
+
+	// Verifiers for session challenge signatures, reused across sign-ins
+	coseVerifiers coseVerifierCache
+	// Nonces issued by POST /api/client/challenge
+	challengeNonces challengeNonceStore
+	// Transactions recently submitted with POST /api/tx/submit
+	submittedTxs submittedTxCache
+
+	// Serializes lazy profile generation, so concurrent requests for a
+	// missing profile only generate it once
+	profileGenMutex sync.Mutex
+
+	// liveConfig returns the current config for settings that can be
+	// reloaded at runtime. cfg is used when it's nil.
+	liveConfig func() *config.Config
+}
+
+// wgMaxDevices returns the current WireGuard device limit for a region, which
+// can change when the config is reloaded
+func (a *Api) wgMaxDevices(region string) int {
+	if a.liveConfig != nil {
+		return a.liveConfig().Vpn.WGMaxDevicesFor(region)
+	}
+	return a.cfg.Vpn.WGMaxDevicesFor(region)
 }
 
 // @title						vpn-indexer
@@ -78,53 +139,30 @@ func Start(
 	}
 
 	api := &Api{
-		cfg:       cfg,
-		db:        db,
-		ca:        ca,
-		wgClient:  wgClient,
-		s3Client:  s3Client,
-		jwtIssuer: jwtIssuer,
+		cfg:        cfg,
+		db:         db,
+		ca:         ca,
+		wgClient:   wgClient,
+		jwtIssuer:  jwtIssuer,
+		liveConfig: config.GetConfig,
+	}
+	// Leave the interfaces nil rather than holding nil pointers
+	if wgClient != nil {
+		api.peerManager = wgClient
+	}
+	if s3Client != nil {
+		api.peerStore = s3Client
+		api.s3Checker = s3Client
+	} else if tmpClient, err := client.NewS3(api.liveConfig()); err == nil {
+		// The S3 client bucket is checked by /readyz even when it isn't
+		// used for the WireGuard peer registry
+		api.s3Checker = tmpClient
 	}
 
 	//
 	// Main HTTP server for API endpoints
 	//
-	mainMux := http.NewServeMux()
-
-	// Healthcheck
-	mainMux.HandleFunc(healthcheckPath, api.handleHealthcheck)
-
-	// Swagger
-	mainMux.HandleFunc("/swagger/", httpSwagger.WrapHandler)
-
-	// API routes
-	mainMux.HandleFunc("/api/client/list", api.handleClientList)
-	mainMux.HandleFunc("/api/client/profile", api.handleClientProfile)
-	mainMux.HandleFunc("/api/client/available", api.handleClientAvailable)
-	mainMux.HandleFunc("/api/refdata", api.handleRefData)
-	mainMux.HandleFunc("/api/tx/signup", api.handleTxSignup)
-	mainMux.HandleFunc("/api/tx/renew", api.handleTxRenew)
-	mainMux.HandleFunc("/api/tx/transfer", api.handleTxTransfer)
-	mainMux.HandleFunc("/api/tx/submit", api.handleTxSubmit)
-
-	// Session auth route. The JWT issuer is required for all protocols, so this
-	// is always available.
-	mainMux.HandleFunc("/api/auth/session", api.handleAuthSession)
-
-	// WireGuard API routes (only register when both wgClient and s3Client are available)
-	if api.wgClient != nil && api.s3Client != nil {
-		mainMux.HandleFunc("/api/client/wg-register", api.handleWGRegister)
-		mainMux.HandleFunc("/api/client/wg-profile", api.handleWGProfile)
-		mainMux.HandleFunc("/api/client/wg-peer", api.handleWGPeer)
-		mainMux.HandleFunc("/api/client/wg-devices", api.handleWGDevices)
-	} else {
-		logger.Warn(
-			"WireGuard API routes not registered: wgClient or s3Client is nil",
-		)
-	}
-
-	// Wrap the mainMux with a CORS middleware
-	mainHandler := api.corsMiddleware(mainMux)
+	mainHandler := api.handler()
 
 	// Start API server
 	logger.Info("starting API listener",
@@ -144,6 +182,168 @@ func Start(
 	return err
 }
 
+// handler returns the routes wrapped with the request ID, CORS, admin auth,
+// compression, and body size limit middlewares
+func (a *Api) handler() http.Handler {
+	mainMux := a.routes()
+	return a.requestIDMiddleware(
+		a.corsMiddleware(
+			a.adminAuthMiddleware(
+				a.gzipMiddleware(a.bodyLimitMiddleware(mainMux.ServeMux)),
+			),
+		),
+	)
+}
+
+// routeMux is an http.ServeMux that records the patterns registered on it, so
+// the set of served routes can be enumerated
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouteMux() *routeMux {
+	return &routeMux{ServeMux: http.NewServeMux()}
+}
+
+// HandleFunc registers handler for pattern and records the pattern
+func (m *routeMux) HandleFunc(
+	pattern string,
+	handler func(http.ResponseWriter, *http.Request),
+) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, handler)
+}
+
+// Handle registers handler for pattern and records the pattern
+func (m *routeMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+// Patterns returns the registered patterns in registration order
+func (m *routeMux) Patterns() []string {
+	return slices.Clone(m.patterns)
+}
+
+// routes builds the mux for all API endpoints enabled by the config and the
+// available dependencies
+func (a *Api) routes() *routeMux {
+	mainMux := newRouteMux()
+
+	// Healthcheck
+	mainMux.HandleFunc(healthcheckPath, a.handleHealthcheck)
+	mainMux.HandleFunc(readyzPath, a.handleReadyz)
+	mainMux.HandleFunc(versionPath, a.handleVersion)
+
+	// Swagger spec and UI
+	if a.cfg.Api.Swagger {
+		registerSwagger(mainMux)
+	}
+
+	// API routes
+	mainMux.HandleFunc("/api/client/list", a.handleClientList)
+	mainMux.HandleFunc("/api/client/profile", a.handleClientProfile)
+	mainMux.HandleFunc(
+		"/api/client/profile/{id}",
+		a.handleClientProfileDownload,
+	)
+	mainMux.HandleFunc(
+		"/api/client/profile-token",
+		a.handleClientProfileToken,
+	)
+	mainMux.HandleFunc("/api/client/available", a.handleClientAvailable)
+	mainMux.HandleFunc("/api/refdata", a.handleRefData)
+	mainMux.HandleFunc("/api/plans", a.handlePlans)
+	mainMux.HandleFunc("/api/regions", a.handleRegions)
+	mainMux.HandleFunc("/api/tx/signup", a.handleTxSignup)
+	mainMux.HandleFunc("/api/tx/estimate", a.handleTxEstimate)
+	mainMux.HandleFunc("/api/tx/renew", a.handleTxRenew)
+	mainMux.HandleFunc("/api/tx/transfer", a.handleTxTransfer)
+	mainMux.HandleFunc(txSubmitPath, a.handleTxSubmit)
+
+	// Session auth routes. The JWT issuer is required for all protocols, so
+	// these are always available.
+	mainMux.HandleFunc("/api/auth/session", a.handleAuthSession)
+	mainMux.HandleFunc("/api/client/challenge", a.handleClientChallenge)
+
+	// WireGuard API routes (only register when both peerManager and peerStore are available)
+	if a.peerManager != nil && a.peerStore != nil {
+		mainMux.HandleFunc("/api/client/wg-register", a.handleWGRegister)
+		mainMux.HandleFunc("/api/client/wg-profile", a.handleWGProfile)
+		mainMux.HandleFunc("/api/client/wg-peer", a.handleWGPeer)
+		mainMux.HandleFunc("/api/client/wg-devices", a.handleWGDevices)
+		mainMux.HandleFunc("/api/client/wg-rotate", a.handleWGRotate)
+		mainMux.HandleFunc("/api/wg/info", a.handleWGInfo)
+	} else {
+		slog.Warn(
+			"WireGuard API routes not registered: peerManager or peerStore is nil",
+		)
+	}
+
+	// Admin routes (only register when an admin token is configured). The
+	// token itself is checked by adminAuthMiddleware.
+	if a.cfg.Api.AdminToken != "" {
+		mainMux.HandleFunc(adminPathPrefix+"capacity", a.handleAdminCapacity)
+		mainMux.HandleFunc(adminPathPrefix+"client/{id}", a.handleAdminClient)
+		mainMux.HandleFunc(
+			adminPathPrefix+"client/{id}/regenerate-profile",
+			a.handleAdminRegenerateProfile,
+		)
+		mainMux.HandleFunc(
+			adminPathPrefix+"reference-history",
+			a.handleAdminReferenceHistory,
+		)
+	} else {
+		slog.Info("admin API routes not registered: no admin token configured")
+	}
+	return mainMux
+}
+
+// requestIDMiddleware tags each request with a correlation ID, reusing a
+// well-formed one supplied by the caller, and echoes it in the response
+func (a *Api) requestIDMiddleware(
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// requireJSON rejects requests whose Content-Type is not application/json
+// with 415, returning false when the handler should stop
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeErrorResponse(
+			w,
+			http.StatusUnsupportedMediaType,
+			ErrCodeUnsupportedMediaType,
+			"Unsupported Media Type",
+			"Content-Type must be application/json",
+		)
+		return false
+	}
+	return true
+}
+
+// writeMethodNotAllowed rejects a request whose method the endpoint doesn't
+// support with 405
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeErrorResponse(
+		w,
+		http.StatusMethodNotAllowed,
+		ErrCodeMethodNotAllowed,
+		"Method not allowed",
+		"",
+	)
+}
+
 // corsMiddleware adds CORS-related headers to every response
 func (a *Api) corsMiddleware(
 	next http.Handler,
@@ -153,6 +353,7 @@ func (a *Api) corsMiddleware(
 		w.Header().
 			Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
+		w.Header().Set("Access-Control-Expose-Headers", requestid.Header)
 
 		// Handle CORS preflight requests
 		if r.Method == http.MethodOptions {
@@ -167,17 +368,116 @@ func (a *Api) corsMiddleware(
 // handleHealthcheck responds to GET /healthcheck
 func (*Api) handleHealthcheck(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write([]byte(`{"healthy": true}`))
 }
 
+// VersionResponse describes the running build
+type VersionResponse struct {
+	Version    string `json:"version"`
+	CommitHash string `json:"commit"`
+	BuildDate  string `json:"build_date"`
+}
+
+// handleVersion responds to GET /version
+func (*Api) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	resp := VersionResponse{
+		Version:    version.Version,
+		CommitHash: version.CommitHash,
+		BuildDate:  version.BuildDate,
+	}
+	// Match GetVersionString for builds without a release tag
+	if resp.Version == "" {
+		resp.Version = "devel"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ReadyzResponse reports whether the API and its dependencies are ready
+type ReadyzResponse struct {
+	Ready bool `json:"ready"`
+	// WGContainer is "ok" or the health check error, only set for WireGuard
+	WGContainer string `json:"wg_container,omitempty"`
+	// S3 is "ok" or the connectivity check error, only set when an S3 bucket
+	// is configured
+	S3 string `json:"s3,omitempty"`
+}
+
+// handleReadyz responds to GET /readyz, returning 503 when the WG container
+// or S3 is configured but unreachable
+func (a *Api) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	resp := ReadyzResponse{Ready: true}
+	if a.wgClient != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyzTimeout)
+		defer cancel()
+		if err := a.wgClient.HealthWithContext(ctx); err != nil {
+			requestid.Logger(r.Context()).Warn(
+				"WG container not ready",
+				"error", err,
+			)
+			resp.Ready = false
+			resp.WGContainer = err.Error()
+		} else {
+			resp.WGContainer = "ok"
+		}
+	}
+	if a.s3Checker != nil {
+		if err := a.checkS3(r.Context()); err != nil {
+			requestid.Logger(r.Context()).Warn(
+				"S3 not ready",
+				"error", err,
+			)
+			resp.Ready = false
+			resp.S3 = err.Error()
+		} else {
+			resp.S3 = "ok"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// S3Checker checks that the S3 client bucket is reachable. It's implemented by
+// *client.Client.
+type S3Checker interface {
+	CheckS3(ctx context.Context) error
+}
+
+// checkS3 checks that the S3 client bucket is reachable, reusing the last
+// result for readyzS3CacheTTL. Concurrent callers wait for a single check.
+func (a *Api) checkS3(ctx context.Context) error {
+	a.s3HealthMutex.Lock()
+	defer a.s3HealthMutex.Unlock()
+
+	if time.Now().Before(a.s3HealthExpires) {
+		return a.s3Health
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyzTimeout)
+	defer cancel()
+	a.s3Health = a.s3Checker.CheckS3(ctx)
+	a.s3HealthExpires = time.Now().Add(readyzS3CacheTTL)
+	return a.s3Health
+}
+
 // handleWGRegister handles POST /api/client/wg-register
 // Registers a new WireGuard device for a client
 func (a *Api) handleWGRegister(w http.ResponseWriter, r *http.Request) {
-	a.wgRegisterImpl(w, r, a.wgClient, a.s3Client)
+	a.wgRegisterImpl(w, r, a.peerManager, a.peerStore)
 }
 
 // handleWGProfile handles POST /api/client/wg-profile
@@ -189,7 +489,13 @@ func (a *Api) handleWGProfile(w http.ResponseWriter, r *http.Request) {
 // handleWGPeer handles DELETE /api/client/wg-peer
 // Removes a WireGuard device registration
 func (a *Api) handleWGPeer(w http.ResponseWriter, r *http.Request) {
-	a.wgPeerDeleteImpl(w, r, a.wgClient, a.s3Client)
+	a.wgPeerDeleteImpl(w, r, a.peerManager, a.peerStore)
+}
+
+// handleWGRotate handles POST /api/client/wg-rotate
+// Replaces the pubkey of a registered WireGuard device
+func (a *Api) handleWGRotate(w http.ResponseWriter, r *http.Request) {
+	a.wgRotateImpl(w, r, a.peerManager, a.peerStore)
 }
 
 // handleWGDevices handles POST /api/client/wg-devices
@@ -197,3 +503,9 @@ func (a *Api) handleWGPeer(w http.ResponseWriter, r *http.Request) {
 func (a *Api) handleWGDevices(w http.ResponseWriter, r *http.Request) {
 	a.wgDevicesImpl(w, r)
 }
+
+// handleWGInfo handles GET /api/wg/info
+// Returns the WireGuard server details needed to build a config locally
+func (a *Api) handleWGInfo(w http.ResponseWriter, r *http.Request) {
+	a.wgInfoImpl(w, r, a.wgClient)
+}
@@ -0,0 +1,392 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blinklabs-io/vpn-indexer/docs"
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/version"
+	"github.com/blinklabs-io/vpn-indexer/internal/wireguard"
+)
+
+func TestReadyz(t *testing.T) {
+	a := newTestApi(t)
+
+	// The container starts down and comes up later
+	var healthy atomic.Bool
+	container := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health" || !healthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+	defer container.Close()
+
+	tests := []struct {
+		name            string
+		wgClient        *wireguard.Client
+		healthy         bool
+		wantStatus      int
+		wantWGContainer string
+	}{
+		{
+			name:       "no WG container",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "WG container down",
+			wgClient:   wireguard.NewClient(container.URL, a.jwtIssuer, 0),
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:            "WG container healthy",
+			wgClient:        wireguard.NewClient(container.URL, a.jwtIssuer, 0),
+			healthy:         true,
+			wantStatus:      http.StatusOK,
+			wantWGContainer: "ok",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a.wgClient = tc.wgClient
+			healthy.Store(tc.healthy)
+
+			req := httptest.NewRequest(http.MethodGet, readyzPath, nil)
+			w := httptest.NewRecorder()
+			a.handleReadyz(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			var resp ReadyzResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			wantReady := tc.wantStatus == http.StatusOK
+			if resp.Ready != wantReady {
+				t.Errorf("ready = %v, want %v", resp.Ready, wantReady)
+			}
+			if tc.wantWGContainer != "" &&
+				resp.WGContainer != tc.wantWGContainer {
+				t.Errorf(
+					"wg_container = %q, want %q",
+					resp.WGContainer,
+					tc.wantWGContainer,
+				)
+			}
+			if tc.wgClient != nil && !resp.Ready && resp.WGContainer == "" {
+				t.Error("expected wg_container error for unhealthy container")
+			}
+		})
+	}
+}
+
+func TestReadyzS3(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantStatus int
+		wantS3     string
+	}{
+		{
+			name:       "S3 reachable",
+			status:     http.StatusOK,
+			wantStatus: http.StatusOK,
+			wantS3:     "ok",
+		},
+		{
+			name:       "S3 unreachable",
+			status:     http.StatusForbidden,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestApi(t)
+
+			// Static credentials keep the AWS SDK from searching the
+			// environment
+			t.Setenv("AWS_ACCESS_KEY_ID", "test")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+			t.Setenv("AWS_REGION", "us-east-1")
+
+			var checks atomic.Int32
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method != http.MethodHead ||
+						r.URL.Path != "/test-bucket" {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					checks.Add(1)
+					w.WriteHeader(tc.status)
+				}),
+			)
+			defer server.Close()
+			a.cfg.S3.ClientBucket = "test-bucket"
+			a.cfg.S3.Endpoint = server.URL
+			s3Client, err := client.NewS3(a.cfg)
+			if err != nil {
+				t.Fatalf("failed to create S3 client: %v", err)
+			}
+			a.s3Checker = s3Client
+
+			// The second probe reuses the cached result
+			for range 2 {
+				req := httptest.NewRequest(http.MethodGet, readyzPath, nil)
+				w := httptest.NewRecorder()
+				a.handleReadyz(w, req)
+
+				if w.Code != tc.wantStatus {
+					t.Fatalf("status = %d, want %d", w.Code, tc.wantStatus)
+				}
+				var resp ReadyzResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if tc.wantS3 != "" && resp.S3 != tc.wantS3 {
+					t.Errorf("s3 = %q, want %q", resp.S3, tc.wantS3)
+				}
+				if !resp.Ready && resp.S3 == "" {
+					t.Error("expected s3 error for unreachable S3")
+				}
+			}
+			if got := checks.Load(); got != 1 {
+				t.Errorf("S3 checks = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	a := newTestApi(t)
+
+	origVersion := version.Version
+	origCommit := version.CommitHash
+	origDate := version.BuildDate
+	t.Cleanup(func() {
+		version.Version = origVersion
+		version.CommitHash = origCommit
+		version.BuildDate = origDate
+	})
+	version.Version = "v1.2.3"
+	version.CommitHash = "abc1234"
+	version.BuildDate = "2026-01-02T03:04:05Z"
+
+	req := httptest.NewRequest(http.MethodGet, versionPath, nil)
+	w := httptest.NewRecorder()
+	a.routes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := map[string]string{
+		"version":    "v1.2.3",
+		"commit":     "abc1234",
+		"build_date": "2026-01-02T03:04:05Z",
+	}
+	for key, wantValue := range want {
+		if got, ok := resp[key]; !ok || got != wantValue {
+			t.Errorf("%s = %q (present: %t), want %q", key, got, ok, wantValue)
+		}
+	}
+
+	// Untagged builds report devel, like GetVersionString
+	version.Version = ""
+	w = httptest.NewRecorder()
+	a.routes().ServeHTTP(w, req)
+	var devResp VersionResponse
+	if err := json.NewDecoder(w.Body).Decode(&devResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if devResp.Version != "devel" {
+		t.Errorf("version = %q, want %q", devResp.Version, "devel")
+	}
+}
+
+// TestSpecRoutesRegistered fails when a handler is documented with @Router but
+// never wired into the mux. Regenerate the docs after adding annotations.
+func TestSpecRoutesRegistered(t *testing.T) {
+	a := newTestApi(t)
+	a.cfg.Api.Swagger = true
+	a.cfg.Api.AdminToken = "admin-secret"
+	a.peerManager = &fakePeerManager{}
+	a.peerStore = newMemPeerStore()
+	mux := a.routes()
+	patterns := mux.Patterns()
+
+	var spec struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(docs.SwaggerJSON, &spec); err != nil {
+		t.Fatalf("failed to parse generated spec: %v", err)
+	}
+	if len(spec.Paths) == 0 {
+		t.Fatal("generated spec has no paths")
+	}
+
+	for path, methods := range spec.Paths {
+		t.Run(path, func(t *testing.T) {
+			if !slices.Contains(patterns, path) {
+				t.Fatalf("documented route %s is not registered", path)
+			}
+			// Requests for each documented method reach that route
+			reqPath := strings.ReplaceAll(path, "{id}", "test")
+			for method := range methods {
+				req := httptest.NewRequest(
+					strings.ToUpper(method),
+					reqPath,
+					nil,
+				)
+				if _, pattern := mux.Handler(req); pattern != path {
+					t.Errorf(
+						"%s %s routed to %q, want %q",
+						strings.ToUpper(method),
+						reqPath,
+						pattern,
+						path,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	a := newTestApi(t)
+	a.cfg.Api.Swagger = true
+	a.cfg.Api.AdminToken = "admin-secret"
+	a.peerManager = &fakePeerManager{}
+	a.peerStore = newMemPeerStore()
+	mux := a.routes()
+
+	for _, path := range mux.Patterns() {
+		// The swagger UI serves its own files
+		if path == "/swagger/" {
+			continue
+		}
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodPatch,
+				strings.ReplaceAll(path, "{id}", "test"),
+				nil,
+			)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("status = %d, want 405", rec.Code)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if resp.Code != ErrCodeMethodNotAllowed {
+				t.Errorf(
+					"code = %q, want %q",
+					resp.Code,
+					ErrCodeMethodNotAllowed,
+				)
+			}
+		})
+	}
+}
+
+func TestRoutesOptional(t *testing.T) {
+	a := newTestApi(t)
+	patterns := a.routes().Patterns()
+
+	// Without WG dependencies, an admin token, or swagger these are absent
+	for _, path := range []string{
+		"/api/client/wg-register",
+		"/api/admin/capacity",
+		swaggerJSONPath,
+	} {
+		if slices.Contains(patterns, path) {
+			t.Errorf("route %s registered without its dependencies", path)
+		}
+	}
+	if !slices.Contains(patterns, "/api/auth/session") {
+		t.Error("route /api/auth/session not registered")
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	a := newTestApi(t)
+	mux := a.routes()
+
+	tests := []struct {
+		name        string
+		contentType string
+		wantReject  bool
+	}{
+		{"missing", "", true},
+		{"form", "application/x-www-form-urlencoded", true},
+		{"text", "text/plain", true},
+		{"malformed", "application/json;;", true},
+		{"json", "application/json", false},
+		{"json with charset", "application/json; charset=utf-8", false},
+		{"json mixed case", "Application/JSON", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/available",
+				strings.NewReader(`{}`),
+			)
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			rejected := rec.Code == http.StatusUnsupportedMediaType
+			if rejected != tc.wantReject {
+				t.Fatalf(
+					"expected reject=%t, got status %d: %s",
+					tc.wantReject,
+					rec.Code,
+					rec.Body.String(),
+				)
+			}
+			if !rejected {
+				return
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode error response: %s", err)
+			}
+			if resp.Reason != "Content-Type must be application/json" {
+				t.Errorf("unexpected reason: %q", resp.Reason)
+			}
+		})
+	}
+}
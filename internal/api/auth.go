@@ -16,6 +16,9 @@ package api
 
 import (
 	"bytes"
+	"container/list"
+	"context"
+	"crypto"
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
@@ -23,8 +26,10 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
@@ -95,24 +100,34 @@ func parseCOSESignature(
 	return innerSignature, innerKey, nil
 }
 
-// sessionChallengePrefix identifies the session challenge payload, separating
-// it from any other signed material a wallet might produce.
+// sessionChallengePrefix identifies the legacy session challenge payload,
+// separating it from any other signed material a wallet might produce.
 const sessionChallengePrefix = "vpn-session:"
 
-// validateChallengeTimestamp checks that a unix-timestamp string falls within
-// the accepted freshness window.
+// sessionChallengeType identifies the structured session challenge payload
+const sessionChallengeType = "vpn-session"
+
+// sessionChallenge is the structured session challenge payload, a JSON object
+// carrying either a timestamp, such as
+// {"type":"vpn-session","timestamp":1700000000}, or a nonce issued by POST
+// /api/client/challenge, such as {"type":"vpn-session","nonce":"<nonce>"}.
+// Unknown fields are rejected so a payload has a single meaning.
+type sessionChallenge struct {
+	Type      string `json:"type"`
+	Timestamp *int64 `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+}
+
+// validateChallengeTimestamp checks that a unix timestamp falls within the
+// accepted freshness window.
 //
-// Because the service stores no nonces, this window is the sole replay
-// defence: a captured signed challenge is replayable only until its timestamp
-// ages out. The small future-skew allowance tolerates clock drift.
-func validateChallengeTimestamp(ts string) error {
-	tmpTimestamp, err := strconv.ParseInt(ts, 10, 64)
-	if err != nil {
-		return errors.New(
-			"could not extract timestamp from challenge string",
-		)
-	}
-	age := time.Since(time.Unix(tmpTimestamp, 0))
+// Timestamp challenges aren't tracked, so this window is their only replay
+// defence: a captured signed challenge is replayable until its timestamp ages
+// out. Clients that need single-use challenges sign a nonce from POST
+// /api/client/challenge instead. The small future-skew allowance tolerates
+// clock drift.
+func validateChallengeTimestamp(ts int64) error {
+	age := time.Since(time.Unix(ts, 0))
 	// Reject timestamps too far in the future (negative age beyond the skew
 	// window) to prevent replay attacks while allowing small clock differences.
 	if age < -TimestampFutureSkewWindow {
@@ -126,33 +141,98 @@ func validateChallengeTimestamp(ts string) error {
 	return nil
 }
 
-// validateSessionChallenge verifies that a COSE payload of the form
-// "vpn-session:<unixTimestamp>" carries a fresh timestamp.
-func validateSessionChallenge(payload []byte) error {
+// parseSessionChallenge parses a session challenge payload. The payload is
+// either a JSON sessionChallenge or, during the transition to it, the legacy
+// "vpn-session:<unixTimestamp>".
+func parseSessionChallenge(payload []byte) (sessionChallenge, error) {
+	var challenge sessionChallenge
+	if len(payload) > 0 && payload[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&challenge); err != nil {
+			return challenge, fmt.Errorf("invalid session challenge: %w", err)
+		}
+		if dec.More() {
+			return challenge, errors.New(
+				"invalid session challenge: trailing data",
+			)
+		}
+		if challenge.Type != sessionChallengeType {
+			return challenge, fmt.Errorf(
+				"invalid session challenge type %q",
+				challenge.Type,
+			)
+		}
+		if (challenge.Timestamp == nil) == (challenge.Nonce == "") {
+			return challenge, errors.New(
+				"session challenge must have either a timestamp or a nonce",
+			)
+		}
+		return challenge, nil
+	}
+
 	s := string(payload)
 	if !strings.HasPrefix(s, sessionChallengePrefix) {
-		return errors.New("invalid session challenge")
+		return challenge, errors.New("invalid session challenge")
 	}
-	return validateChallengeTimestamp(s[len(sessionChallengePrefix):])
+	ts, err := strconv.ParseInt(s[len(sessionChallengePrefix):], 10, 64)
+	if err != nil {
+		return challenge, errors.New(
+			"could not extract timestamp from challenge string",
+		)
+	}
+	challenge.Type = sessionChallengeType
+	challenge.Timestamp = &ts
+	return challenge, nil
 }
 
 // verifySessionChallenge verifies a wallet-signed session challenge and returns
 // the credential (Blake2b-224 hash of the signing key, i.e. the payment key
 // hash) it resolves to. This credential is the identity a session token is
 // bound to; it covers every subscription owned by that credential.
+//
+// A challenge carrying a nonce is only accepted once, and only from the wallet
+// owning the subscription the nonce was issued for.
 func (a *Api) verifySessionChallenge(
+	ctx context.Context,
 	innerSignature *cose.UntaggedSign1Message,
 	innerKey *cose.Key,
 ) ([]byte, error) {
-	if err := validateSessionChallenge(innerSignature.Payload); err != nil {
+	challenge, err := parseSessionChallenge(innerSignature.Payload)
+	if err != nil {
 		return nil, err
 	}
+	if challenge.Timestamp != nil {
+		if err := validateChallengeTimestamp(*challenge.Timestamp); err != nil {
+			return nil, err
+		}
+	}
 
+	// The signature's protected header names its algorithm. A Cardano
+	// payment credential is the hash of an Ed25519 key, so no other key can
+	// resolve to a credential owning a subscription.
+	alg, err := innerSignature.Headers.Protected.Algorithm()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signature algorithm: %w", err)
+	}
+	if alg != cose.AlgorithmEdDSA {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedAlgorithm, alg)
+	}
+	// Only allowed algorithms are verified, so a signature can't pick a
+	// weaker one than the operator accepts
+	if !slices.Contains(a.cfg.Api.COSEAlgorithms, alg.String()) {
+		return nil, fmt.Errorf("signature algorithm %s is not allowed", alg)
+	}
 	vkey, err := innerKey.PublicKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get public key: %w", err)
 	}
-	verifier, err := cose.NewVerifier(cose.AlgorithmEdDSA, vkey)
+	ed25519Key, ok := vkey.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not Ed25519")
+	}
+	keyBytes := []byte(ed25519Key)
+	verifier, err := a.coseVerifiers.get(alg, vkey, keyBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create verifier: %w", err)
 	}
@@ -160,11 +240,84 @@ func (a *Api) verifySessionChallenge(
 		return nil, errors.New("failed to validate signature")
 	}
 
-	ed25519Key, ok := vkey.(ed25519.PublicKey)
-	if !ok {
-		return nil, errors.New("public key is not Ed25519")
+	credential := lcommon.Blake2b224Hash(keyBytes).Bytes()
+
+	// The nonce is consumed only once the signature is valid, so a request
+	// that fails verification can't use up another client's nonce
+	if challenge.Nonce != "" {
+		clientID, ok := a.challengeNonces.consume(challenge.Nonce, time.Now())
+		if !ok {
+			return nil, errors.New("unknown or expired challenge nonce")
+		}
+		if _, err := a.authorizeClient(ctx, credential, clientID); err != nil {
+			return nil, err
+		}
 	}
-	return lcommon.Blake2b224Hash([]byte(ed25519Key)).Bytes(), nil
+	return credential, nil
+}
+
+// coseVerifierCacheSize is the number of COSE verifiers kept for reuse by
+// clients that sign in repeatedly
+const coseVerifierCacheSize = 1024
+
+// coseVerifierCache keeps COSE verifiers by algorithm and public key,
+// evicting the least recently used once it holds coseVerifierCacheSize. The
+// zero value is ready to use and it's safe for concurrent use.
+type coseVerifierCache struct {
+	mu sync.Mutex
+	// entries maps each algorithm and public key to its element in order.
+	// It's keyed by algorithm first so lookups don't allocate.
+	entries map[cose.Algorithm]map[string]*list.Element
+	// order holds *coseVerifierEntry values, most recently used first
+	order *list.List
+}
+
+type coseVerifierEntry struct {
+	alg cose.Algorithm
+	// pubKey is the raw public key
+	pubKey   string
+	verifier cose.Verifier
+}
+
+// get returns the verifier for an algorithm and public key, creating it if it
+// isn't cached. keyBytes is the raw public key.
+func (c *coseVerifierCache) get(
+	alg cose.Algorithm,
+	pubKey crypto.PublicKey,
+	keyBytes []byte,
+) (cose.Verifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[alg][string(keyBytes)]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*coseVerifierEntry).verifier, nil
+	}
+	verifier, err := cose.NewVerifier(alg, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if c.entries == nil {
+		c.entries = make(map[cose.Algorithm]map[string]*list.Element)
+		c.order = list.New()
+	}
+	if c.order.Len() >= coseVerifierCacheSize {
+		oldest := c.order.Remove(c.order.Back()).(*coseVerifierEntry)
+		delete(c.entries[oldest.alg], oldest.pubKey)
+		if len(c.entries[oldest.alg]) == 0 {
+			delete(c.entries, oldest.alg)
+		}
+	}
+	if c.entries[alg] == nil {
+		c.entries[alg] = make(map[string]*list.Element)
+	}
+	entry := &coseVerifierEntry{
+		alg:      alg,
+		pubKey:   string(keyBytes),
+		verifier: verifier,
+	}
+	c.entries[alg][entry.pubKey] = c.order.PushFront(entry)
+	return verifier, nil
 }
 
 // SessionResponse is the response from POST /api/auth/session.
@@ -184,12 +337,17 @@ type SessionResponse struct {
 //	@Failure		400				{object}	ErrorResponse	"Bad Request"
 //	@Failure		401				{object}	ErrorResponse	"Unauthorized"
 //	@Failure		403				{object}	ErrorResponse	"Forbidden (no subscriptions for wallet)"
-//	@Failure		405				{object}	string			"Method Not Allowed"
+//	@Failure		405				{object}	ErrorResponse	"Method Not Allowed"
+//	@Failure		415				{object}	ErrorResponse	"Unsupported Media Type"
 //	@Failure		500				{object}	ErrorResponse	"Server Error"
 //	@Router			/api/auth/session [post]
 func (a *Api) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
 		return
 	}
 
@@ -199,6 +357,7 @@ func (a *Api) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"malformed request body",
 		)
@@ -211,6 +370,7 @@ func (a *Api) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"signature and key are required",
 		)
@@ -219,14 +379,37 @@ func (a *Api) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 
 	// Verify the signed challenge and derive the wallet credential.
 	credential, err := a.verifySessionChallenge(
+		r.Context(),
 		&req.innerSignature,
 		&req.innerKey,
 	)
+	if errors.Is(err, errAuthInternal) {
+		slog.Error("session challenge verification failed", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	if errors.Is(err, errUnsupportedAlgorithm) {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeUnsupportedAlgorithm,
+			"Invalid request",
+			"signature algorithm must be EdDSA",
+		)
+		return
+	}
 	if err != nil {
 		slog.Error("session challenge verification failed", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusUnauthorized,
+			ErrCodeAuthenticationFailed,
 			"Unauthorized",
 			"signature verification failed",
 		)
@@ -235,12 +418,13 @@ func (a *Api) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 
 	// The credential must own at least one subscription: this rejects keys
 	// unrelated to any subscription and confirms the wallet is known.
-	clients, err := a.db.ClientsByCredential(credential)
+	clients, err := a.db.ClientsByCredentialContext(r.Context(), credential)
 	if err != nil {
 		slog.Error("failed to lookup clients by credential", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -250,6 +434,7 @@ func (a *Api) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 		writeErrorResponse(
 			w,
 			http.StatusForbidden,
+			ErrCodeNoSubscriptions,
 			"Forbidden",
 			"no subscriptions for this wallet",
 		)
@@ -264,6 +449,7 @@ func (a *Api) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -321,13 +507,14 @@ func (a *Api) sessionCredential(
 // the body only names which subscription to act on, so the ownership check is
 // what prevents a token from acting on another wallet's subscription.
 func (a *Api) authorizeClient(
+	ctx context.Context,
 	credential []byte,
 	innerClientID []byte,
 ) (*database.Client, error) {
 	if len(innerClientID) == 0 {
 		return nil, errors.New("client_id is required")
 	}
-	tmpClient, err := a.db.ClientByAssetName(innerClientID)
+	tmpClient, err := a.db.ClientByAssetNameContext(ctx, innerClientID)
 	if err != nil {
 		// A missing record is an auth/ownership failure; any other error is an
 		// infrastructure problem that must surface as 500, not 401.
@@ -342,25 +529,51 @@ func (a *Api) authorizeClient(
 	return &tmpClient, nil
 }
 
+// errTokenRequired is returned by authenticate when a request has no Bearer
+// session token
+var errTokenRequired = errors.New("session token required")
+
+// errUnsupportedAlgorithm is returned by verifySessionChallenge for a
+// signature using an algorithm other than EdDSA
+var errUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+
 // errAuthInternal wraps an internal failure (e.g. a database error) that occurs
 // while authenticating a request, so handlers can return 500 rather than
 // masking infrastructure problems as 401 Unauthorized.
 var errAuthInternal = errors.New("internal authentication error")
 
 // writeAuthError maps an authenticate() error to an HTTP response: internal
-// failures return 500, all other (invalid token / ownership) failures return
-// 401.
+// failures return 500, and a missing token or any other (invalid token /
+// ownership) failure returns 401 with its own error code.
 func (a *Api) writeAuthError(w http.ResponseWriter, err error) {
 	if errors.Is(err, errAuthInternal) {
 		slog.Error("authentication error", "error", err)
 		writeErrorResponse(
-			w, http.StatusInternalServerError, "Internal server error", "",
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	if errors.Is(err, errTokenRequired) {
+		writeErrorResponse(
+			w,
+			http.StatusUnauthorized,
+			ErrCodeUnauthorized,
+			"Unauthorized",
+			"token required",
 		)
 		return
 	}
 	slog.Warn("authentication failed", "error", err)
 	writeErrorResponse(
-		w, http.StatusUnauthorized, "Unauthorized", "authentication failed",
+		w,
+		http.StatusUnauthorized,
+		ErrCodeAuthenticationFailed,
+		"Unauthorized",
+		"authentication failed",
 	)
 }
 
@@ -373,10 +586,10 @@ func (a *Api) authenticate(
 ) (*database.Client, error) {
 	credential, present, err := a.sessionCredential(r)
 	if !present {
-		return nil, errors.New("session token required")
+		return nil, errTokenRequired
 	}
 	if err != nil {
 		return nil, err
 	}
-	return a.authorizeClient(credential, innerClientID)
+	return a.authorizeClient(r.Context(), credential, innerClientID)
 }
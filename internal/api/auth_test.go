@@ -0,0 +1,361 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+	"github.com/veraison/go-cose"
+)
+
+func TestAuthorizeClient(t *testing.T) {
+	a := newTestApi(t)
+	assetName := []byte("test-client")
+	credential := []byte("credential")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+
+	t.Run("owned client", func(t *testing.T) {
+		client, err := a.authorizeClient(t.Context(), credential, assetName)
+		if err != nil {
+			t.Fatalf("authorizeClient: %v", err)
+		}
+		if string(client.AssetName) != string(assetName) {
+			t.Errorf("asset name = %q, want %q", client.AssetName, assetName)
+		}
+	})
+
+	t.Run("other wallet", func(t *testing.T) {
+		_, err := a.authorizeClient(t.Context(), []byte("other"), assetName)
+		if err == nil || errors.Is(err, errAuthInternal) {
+			t.Errorf("error = %v, want ownership failure", err)
+		}
+	})
+
+	t.Run("client not found", func(t *testing.T) {
+		_, err := a.authorizeClient(t.Context(), credential, []byte("missing"))
+		if err == nil || errors.Is(err, errAuthInternal) {
+			t.Errorf("error = %v, want ownership failure", err)
+		}
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		b := newTestApi(t)
+		if err := b.db.Close(); err != nil {
+			t.Fatalf("failed to close database: %v", err)
+		}
+		_, err := b.authorizeClient(t.Context(), credential, assetName)
+		if !errors.Is(err, errAuthInternal) {
+			t.Errorf("error = %v, want %v", err, errAuthInternal)
+		}
+	})
+}
+
+func TestCOSEVerifierCache(t *testing.T) {
+	var cache coseVerifierCache
+	pubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	verifier, err := cache.get(cose.AlgorithmEdDSA, pubKey, pubKey)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	// Repeated verifications for the same client reuse the verifier without
+	// allocating
+	var key crypto.PublicKey = pubKey
+	allocs := testing.AllocsPerRun(100, func() {
+		cached, err := cache.get(cose.AlgorithmEdDSA, key, pubKey)
+		if err != nil || cached != verifier {
+			t.Fatalf("get returned %v, %v, want cached verifier", cached, err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("cached get allocated %v times, want 0", allocs)
+	}
+
+	// Filling the cache evicts the least recently used key
+	for range coseVerifierCacheSize {
+		otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		_, err = cache.get(cose.AlgorithmEdDSA, otherKey, otherKey)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	}
+	if got := cache.order.Len(); got != coseVerifierCacheSize {
+		t.Errorf(
+			"cache holds %d verifiers, want %d",
+			got,
+			coseVerifierCacheSize,
+		)
+	}
+	if _, ok := cache.entries[cose.AlgorithmEdDSA][string(pubKey)]; ok {
+		t.Error("least recently used verifier was not evicted")
+	}
+}
+
+func TestVerifySessionChallenge(t *testing.T) {
+	a := newTestApi(t)
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	p384Priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+
+	// sign returns a session challenge signed with the given algorithm and
+	// the COSE key of pubKey
+	sign := func(
+		alg cose.Algorithm,
+		priv crypto.Signer,
+		pubKey crypto.PublicKey,
+	) (*cose.UntaggedSign1Message, *cose.Key) {
+		t.Helper()
+		signer, err := cose.NewSigner(alg, priv)
+		if err != nil {
+			t.Fatalf("failed to create signer: %v", err)
+		}
+		msg := &cose.UntaggedSign1Message{
+			Headers: cose.Headers{
+				Protected: cose.ProtectedHeader{
+					cose.HeaderLabelAlgorithm: alg,
+				},
+			},
+			Payload: []byte(
+				sessionChallengePrefix +
+					strconv.FormatInt(time.Now().Unix(), 10),
+			),
+		}
+		if err := msg.Sign(rand.Reader, nil, signer); err != nil {
+			t.Fatalf("failed to sign challenge: %v", err)
+		}
+		key, err := cose.NewKeyFromPublic(pubKey)
+		if err != nil {
+			t.Fatalf("failed to create COSE key: %v", err)
+		}
+		return msg, key
+	}
+
+	tests := []struct {
+		name            string
+		alg             cose.Algorithm
+		priv            crypto.Signer
+		pubKey          crypto.PublicKey
+		want            []byte
+		wantErr         bool
+		wantUnsupported bool
+	}{
+		{
+			name:   "EdDSA",
+			alg:    cose.AlgorithmEdDSA,
+			priv:   edPriv,
+			pubKey: edPub,
+			want:   lcommon.Blake2b224Hash(edPub).Bytes(),
+		},
+		{
+			name:    "key mismatch",
+			alg:     cose.AlgorithmEdDSA,
+			priv:    edPriv,
+			pubKey:  otherPub,
+			wantErr: true,
+		},
+		{
+			// A valid ECDSA signature can't resolve to a Cardano credential
+			name:            "ES256",
+			alg:             cose.AlgorithmES256,
+			priv:            ecPriv,
+			pubKey:          &ecPriv.PublicKey,
+			wantErr:         true,
+			wantUnsupported: true,
+		},
+		{
+			name:            "ES384",
+			alg:             cose.AlgorithmES384,
+			priv:            p384Priv,
+			pubKey:          &p384Priv.PublicKey,
+			wantErr:         true,
+			wantUnsupported: true,
+		},
+		{
+			name:    "algorithm mismatch",
+			alg:     cose.AlgorithmEdDSA,
+			priv:    edPriv,
+			pubKey:  &ecPriv.PublicKey,
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, key := sign(tc.alg, tc.priv, tc.pubKey)
+			credential, err := a.verifySessionChallenge(t.Context(), msg, key)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("verifySessionChallenge succeeded, want error")
+				}
+				got := errors.Is(err, errUnsupportedAlgorithm)
+				if got != tc.wantUnsupported {
+					t.Errorf(
+						"unsupported algorithm error = %v, want %v: %v",
+						got,
+						tc.wantUnsupported,
+						err,
+					)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifySessionChallenge: %v", err)
+			}
+			if !slices.Equal(credential, tc.want) {
+				t.Errorf("credential = %x, want %x", credential, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSessionChallenge(t *testing.T) {
+	tests := []struct {
+		name          string
+		payload       string
+		wantTimestamp int64
+		wantNonce     string
+		wantErr       bool
+	}{
+		{
+			name:          "structured",
+			payload:       `{"type":"vpn-session","timestamp":1700000000}`,
+			wantTimestamp: 1700000000,
+		},
+		{
+			name:          "structured with field order swapped",
+			payload:       `{"timestamp":1700000000,"type":"vpn-session"}`,
+			wantTimestamp: 1700000000,
+		},
+		{
+			name:      "structured nonce",
+			payload:   `{"type":"vpn-session","nonce":"abcd"}`,
+			wantNonce: "abcd",
+		},
+		{
+			name:          "legacy",
+			payload:       "vpn-session:1700000000",
+			wantTimestamp: 1700000000,
+		},
+		{
+			name:    "structured wrong type",
+			payload: `{"type":"other","timestamp":1700000000}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured missing timestamp",
+			payload: `{"type":"vpn-session"}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured timestamp and nonce",
+			payload: `{"type":"vpn-session","timestamp":1700000000,"nonce":"abcd"}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured string timestamp",
+			payload: `{"type":"vpn-session","timestamp":"1700000000"}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured unknown field",
+			payload: `{"type":"vpn-session","timestamp":1700000000,"id":"x"}`,
+			wantErr: true,
+		},
+		{
+			name:    "structured trailing data",
+			payload: `{"type":"vpn-session","timestamp":1700000000}{}`,
+			wantErr: true,
+		},
+		{
+			name:    "legacy wrong prefix",
+			payload: "vpn-login:1700000000",
+			wantErr: true,
+		},
+		{
+			name:    "legacy trailing data",
+			payload: "vpn-session:1700000000x",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseSessionChallenge([]byte(tc.payload))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("parseSessionChallenge = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSessionChallenge: %v", err)
+			}
+			var gotTimestamp int64
+			if got.Timestamp != nil {
+				gotTimestamp = *got.Timestamp
+			}
+			if gotTimestamp != tc.wantTimestamp || got.Nonce != tc.wantNonce {
+				t.Errorf(
+					"timestamp, nonce = %d, %q, want %d, %q",
+					gotTimestamp,
+					got.Nonce,
+					tc.wantTimestamp,
+					tc.wantNonce,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// DefaultMaxBodyBytes is the request body limit when none is configured
+	DefaultMaxBodyBytes = 64 << 10
+
+	// DefaultMaxTxBodyBytes is the /api/tx/submit body limit when none is
+	// configured. Signed transactions are larger than the JSON requests.
+	DefaultMaxTxBodyBytes = 256 << 10
+
+	txSubmitPath = "/api/tx/submit"
+)
+
+// maxBodyBytes returns the body size limit for a request path
+func (a *Api) maxBodyBytes(path string) int64 {
+	if path == txSubmitPath {
+		if a.cfg.Api.MaxTxBodyBytes > 0 {
+			return a.cfg.Api.MaxTxBodyBytes
+		}
+		return DefaultMaxTxBodyBytes
+	}
+	if a.cfg.Api.MaxBodyBytes > 0 {
+		return a.cfg.Api.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// bodyLimitMiddleware caps request body size. Requests declaring a larger
+// Content-Length are rejected up front; otherwise the body is wrapped so that
+// reading past the limit turns the handler's response into a 413.
+func (a *Api) bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := a.maxBodyBytes(r.URL.Path)
+		if r.ContentLength > limit {
+			writeBodyTooLarge(w, limit)
+			return
+		}
+		body := &limitedBody{
+			ReadCloser: http.MaxBytesReader(w, r.Body, limit),
+		}
+		r.Body = body
+		next.ServeHTTP(&bodyLimitWriter{ResponseWriter: w, body: body}, r)
+	})
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeErrorResponse(
+		w,
+		http.StatusRequestEntityTooLarge,
+		ErrCodeRequestTooLarge,
+		"Request body too large",
+		fmt.Sprintf("request body must not exceed %d bytes", limit),
+	)
+}
+
+// limitedBody records whether a request body read hit its size limit
+type limitedBody struct {
+	io.ReadCloser
+	exceeded *http.MaxBytesError
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		b.exceeded = maxErr
+	}
+	return n, err
+}
+
+// bodyLimitWriter replaces the handler's response with a 413 once its
+// request body has exceeded the limit
+type bodyLimitWriter struct {
+	http.ResponseWriter
+	body     *limitedBody
+	rejected bool
+}
+
+// reject writes the 413 response once and reports whether the handler's
+// output should be discarded
+func (w *bodyLimitWriter) reject() bool {
+	if w.body.exceeded == nil {
+		return false
+	}
+	if !w.rejected {
+		w.rejected = true
+		writeBodyTooLarge(w.ResponseWriter, w.body.exceeded.Limit)
+	}
+	return true
+}
+
+func (w *bodyLimitWriter) WriteHeader(status int) {
+	if w.reject() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyLimitWriter) Write(p []byte) (int, error) {
+	if w.reject() {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
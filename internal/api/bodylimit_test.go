@@ -0,0 +1,160 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	a := newTestApi(t)
+	a.cfg.Api.MaxBodyBytes = 100
+	a.cfg.Api.MaxTxBodyBytes = 1000
+	handler := a.bodyLimitMiddleware(a.routes())
+
+	// A JSON body padded past the JSON limit but within the tx limit
+	oversizedJSON := `{"client_id":"` + strings.Repeat("a", 200) + `"}`
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		chunked     bool
+		wantStatus  int
+	}{
+		{
+			name:        "JSON within limit",
+			path:        "/api/client/available",
+			contentType: "application/json",
+			body:        `{"id":"zz"}`,
+			wantStatus:  http.StatusBadRequest, // reaches the handler
+		},
+		{
+			name:        "JSON over limit",
+			path:        "/api/client/available",
+			contentType: "application/json",
+			body:        oversizedJSON,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "chunked JSON over limit",
+			path:        "/api/auth/session",
+			contentType: "application/json",
+			body:        oversizedJSON,
+			chunked:     true,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "CBOR over limit",
+			path:        txSubmitPath,
+			contentType: "application/cbor",
+			body:        strings.Repeat("\x00", 2000),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "chunked CBOR over limit",
+			path:        txSubmitPath,
+			contentType: "application/cbor",
+			body:        strings.Repeat("\x00", 2000),
+			chunked:     true,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(tc.body)
+			if tc.chunked {
+				// Hide the length so only the reader enforces the limit
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest(http.MethodPost, tc.path, body)
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			req.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tc.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tc.wantStatus != http.StatusRequestEntityTooLarge {
+				return
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("413 body is not a single JSON error: %v", err)
+			}
+			if resp.Error != "Request body too large" {
+				t.Errorf("error = %q", resp.Error)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	a := newTestApi(t)
+
+	if got := a.maxBodyBytes("/api/client/list"); got != DefaultMaxBodyBytes {
+		t.Errorf("default JSON limit = %d, want %d", got, DefaultMaxBodyBytes)
+	}
+	if got := a.maxBodyBytes(txSubmitPath); got != DefaultMaxTxBodyBytes {
+		t.Errorf("default tx limit = %d, want %d", got, DefaultMaxTxBodyBytes)
+	}
+
+	a.cfg.Api.MaxBodyBytes = 10
+	a.cfg.Api.MaxTxBodyBytes = 20
+	if got := a.maxBodyBytes("/api/client/list"); got != 10 {
+		t.Errorf("configured JSON limit = %d, want 10", got)
+	}
+	if got := a.maxBodyBytes(txSubmitPath); got != 20 {
+		t.Errorf("configured tx limit = %d, want 20", got)
+	}
+
+	// Bodies at the limit are still read in full
+	handler := a.bodyLimitMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write(data)
+		}),
+	)
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/api/client/list",
+		bytes.NewReader(bytes.Repeat([]byte("x"), 10)),
+	)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.Len() != 10 {
+		t.Errorf("status = %d, body length = %d", w.Code, w.Body.Len())
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+const (
+	// challengeNonceTTL is how long an issued challenge nonce can be signed
+	// and submitted to POST /api/auth/session
+	challengeNonceTTL = 5 * time.Minute
+	// maxChallengeNoncesPerClient bounds the outstanding challenge nonces for
+	// a subscription, so unconsumed nonces can't grow the store without limit
+	// or lock out other subscriptions. Further nonces are refused rather than
+	// evicting outstanding ones, which would let anyone invalidate the nonces
+	// a subscription's owner is about to sign.
+	maxChallengeNoncesPerClient = 5
+	// challengeNonceBytes is the length of a challenge nonce before encoding
+	challengeNonceBytes = 32
+)
+
+// ChallengeRequest names the subscription a challenge nonce is issued for
+type ChallengeRequest struct {
+	Id string `json:"id"`
+	// Inner representation (not serialized)
+	innerId []byte
+}
+
+func (r *ChallengeRequest) UnmarshalJSON(data []byte) error {
+	type tmpChallengeRequest ChallengeRequest
+	var tmpData tmpChallengeRequest
+	if err := json.Unmarshal(data, &tmpData); err != nil {
+		return err
+	}
+	r.Id = tmpData.Id
+	id, err := hex.DecodeString(r.Id)
+	if err != nil {
+		return errors.New("decode client ID hex")
+	}
+	r.innerId = id
+	return nil
+}
+
+// ChallengeResponse is the response body for POST /api/client/challenge. The
+// nonce is signed in a session challenge payload of the form
+// {"type":"vpn-session","nonce":"<nonce>"}.
+type ChallengeResponse struct {
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// challengeNonce is an issued nonce and the subscription it's bound to
+type challengeNonce struct {
+	clientID []byte
+	expires  time.Time
+}
+
+// challengeNonceStore holds the issued challenge nonces until they're
+// consumed or expire. The zero value is ready to use and it's safe for
+// concurrent use.
+type challengeNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]challengeNonce
+	// byClient lists the outstanding nonces for each subscription, oldest
+	// first
+	byClient map[string][]string
+}
+
+// issue returns a new nonce bound to a subscription and when it expires. The
+// subscription's expired nonces are pruned first. ok is false when it already
+// has maxChallengeNoncesPerClient outstanding.
+func (s *challengeNonceStore) issue(
+	clientID []byte,
+	now time.Time,
+) (nonce string, expires time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nonces == nil {
+		s.nonces = make(map[string]challengeNonce)
+		s.byClient = make(map[string][]string)
+	}
+	key := string(clientID)
+	outstanding := slices.DeleteFunc(s.byClient[key], func(nonce string) bool {
+		if now.Before(s.nonces[nonce].expires) {
+			return false
+		}
+		delete(s.nonces, nonce)
+		return true
+	})
+	if len(outstanding) == 0 {
+		delete(s.byClient, key)
+	} else {
+		s.byClient[key] = outstanding
+	}
+	if len(outstanding) >= maxChallengeNoncesPerClient {
+		return "", time.Time{}, false
+	}
+	buf := make([]byte, challengeNonceBytes)
+	_, _ = rand.Read(buf)
+	nonce = hex.EncodeToString(buf)
+	expires = now.Add(challengeNonceTTL)
+	s.nonces[nonce] = challengeNonce{clientID: clientID, expires: expires}
+	s.byClient[key] = append(outstanding, nonce)
+	return nonce, expires, true
+}
+
+// consume removes a nonce and returns the subscription it's bound to. ok is
+// false when the nonce wasn't issued, was already consumed, or has expired.
+func (s *challengeNonceStore) consume(
+	nonce string,
+	now time.Time,
+) (clientID []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nonces[nonce]
+	if !ok {
+		return nil, false
+	}
+	delete(s.nonces, nonce)
+	key := string(n.clientID)
+	s.byClient[key] = slices.DeleteFunc(
+		s.byClient[key],
+		func(other string) bool { return other == nonce },
+	)
+	if len(s.byClient[key]) == 0 {
+		delete(s.byClient, key)
+	}
+	if !now.Before(n.expires) {
+		return nil, false
+	}
+	return n.clientID, true
+}
+
+// handleClientChallenge godoc
+//
+//	@Summary		ClientChallenge
+//	@Description	Issue a short-lived, single-use nonce for a subscription. Signing it in a session challenge instead of a timestamp prevents the signature from being replayed.
+//	@Accept			json
+//	@Produce		json
+//	@Param			ChallengeRequest	body		ChallengeRequest	true	"Challenge Request"
+//	@Success		200					{object}	ChallengeResponse	"Challenge nonce"
+//	@Failure		400					{object}	ErrorResponse		"Bad Request"
+//	@Failure		404					{object}	ErrorResponse		"Not Found"
+//	@Failure		405					{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415					{object}	ErrorResponse		"Unsupported Media Type"
+//	@Failure		429					{object}	ErrorResponse		"Too Many Requests"
+//	@Failure		500					{object}	ErrorResponse		"Internal Server Error"
+//	@Router			/api/client/challenge [post]
+func (a *Api) handleClientChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req ChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Debug("failed to decode challenge request", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
+		return
+	}
+	if len(req.innerId) == 0 {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"id is required",
+		)
+		return
+	}
+
+	// Nonces are only issued for known subscriptions, so they can't be
+	// requested for arbitrary IDs to fill the store
+	if _, err := a.db.ClientByAssetNameContext(
+		r.Context(),
+		req.innerId,
+	); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			writeErrorResponse(
+				w,
+				http.StatusNotFound,
+				ErrCodeClientNotFound,
+				"Not found",
+				"client not found",
+			)
+			return
+		}
+		slog.Error("failed to lookup client in database", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	nonce, expiresAt, ok := a.challengeNonces.issue(req.innerId, time.Now())
+	if !ok {
+		writeErrorResponse(
+			w,
+			http.StatusTooManyRequests,
+			ErrCodeTooManyChallenges,
+			"Too many requests",
+			"too many outstanding challenge nonces for client",
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	resp := ChallengeResponse{
+		Nonce:     nonce,
+		ExpiresAt: expiresAt.Unix(),
+	}
+	respBytes, _ := json.Marshal(resp)
+	_, _ = w.Write(respBytes)
+}
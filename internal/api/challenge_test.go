@@ -0,0 +1,263 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+	"github.com/veraison/go-cose"
+)
+
+// newTestNonceChallenge returns a session challenge for a nonce signed by an
+// Ed25519 key
+func newTestNonceChallenge(
+	t *testing.T,
+	privKey ed25519.PrivateKey,
+	nonce string,
+) (*cose.UntaggedSign1Message, *cose.Key) {
+	t.Helper()
+	signer, err := cose.NewSigner(cose.AlgorithmEdDSA, privKey)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	payload, err := json.Marshal(sessionChallenge{
+		Type:  sessionChallengeType,
+		Nonce: nonce,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal challenge: %v", err)
+	}
+	msg := &cose.UntaggedSign1Message{
+		Headers: cose.Headers{
+			Protected: cose.ProtectedHeader{
+				cose.HeaderLabelAlgorithm: cose.AlgorithmEdDSA,
+			},
+		},
+		Payload: payload,
+	}
+	if err := msg.Sign(rand.Reader, nil, signer); err != nil {
+		t.Fatalf("failed to sign challenge: %v", err)
+	}
+	key, err := cose.NewKeyFromPublic(privKey.Public())
+	if err != nil {
+		t.Fatalf("failed to create COSE key: %v", err)
+	}
+	return msg, key
+}
+
+func TestClientChallenge(t *testing.T) {
+	a := newTestApi(t)
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	_, otherPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	credential := lcommon.Blake2b224Hash(pubKey).Bytes()
+	assetName := []byte("test-client")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+
+	// issue requests a nonce for the client from the endpoint
+	issue := func(t *testing.T) string {
+		t.Helper()
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/api/client/challenge",
+			strings.NewReader(
+				`{"id":"`+hex.EncodeToString(assetName)+`"}`,
+			),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		a.handleClientChallenge(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		var resp ChallengeResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.Nonce == "" || resp.ExpiresAt <= time.Now().Unix() {
+			t.Fatalf("response = %+v, want a nonce expiring later", resp)
+		}
+		return resp.Nonce
+	}
+
+	t.Run("consume", func(t *testing.T) {
+		msg, key := newTestNonceChallenge(t, privKey, issue(t))
+		got, err := a.verifySessionChallenge(t.Context(), msg, key)
+		if err != nil {
+			t.Fatalf("verifySessionChallenge: %v", err)
+		}
+		if !slices.Equal(got, credential) {
+			t.Errorf("credential = %x, want %x", got, credential)
+		}
+
+		// The same signed challenge can't be replayed
+		_, err = a.verifySessionChallenge(t.Context(), msg, key)
+		if err == nil {
+			t.Error("reused nonce was accepted")
+		}
+	})
+
+	t.Run("other wallet", func(t *testing.T) {
+		msg, key := newTestNonceChallenge(t, otherPrivKey, issue(t))
+		_, err := a.verifySessionChallenge(t.Context(), msg, key)
+		if err == nil {
+			t.Error("nonce was accepted from a wallet not owning the client")
+		}
+	})
+
+	t.Run("unknown nonce", func(t *testing.T) {
+		msg, key := newTestNonceChallenge(t, privKey, "unknown")
+		_, err := a.verifySessionChallenge(t.Context(), msg, key)
+		if err == nil {
+			t.Error("unknown nonce was accepted")
+		}
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/api/client/challenge",
+			strings.NewReader(
+				`{"id":"`+hex.EncodeToString([]byte("unknown"))+`"}`,
+			),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		a.handleClientChallenge(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", rec.Code)
+		}
+	})
+
+	t.Run("too many", func(t *testing.T) {
+		for range maxChallengeNoncesPerClient {
+			a.challengeNonces.issue(assetName, time.Now())
+		}
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/api/client/challenge",
+			strings.NewReader(
+				`{"id":"`+hex.EncodeToString(assetName)+`"}`,
+			),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		a.handleClientChallenge(rec, req)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want 429", rec.Code)
+		}
+	})
+
+	t.Run("missing id", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/api/client/challenge",
+			strings.NewReader(`{}`),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		a.handleClientChallenge(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestChallengeNonceStore(t *testing.T) {
+	var store challengeNonceStore
+	now := time.Now()
+	clientID := []byte("client")
+	otherClientID := []byte("other-client")
+
+	t.Run("expired", func(t *testing.T) {
+		nonce, _, _ := store.issue(clientID, now)
+		if _, ok := store.consume(nonce, now.Add(challengeNonceTTL)); ok {
+			t.Error("expired nonce was consumed")
+		}
+	})
+
+	t.Run("per client cap", func(t *testing.T) {
+		otherNonce, _, _ := store.issue(otherClientID, now)
+		nonces := make([]string, 0, maxChallengeNoncesPerClient)
+		for range maxChallengeNoncesPerClient {
+			nonce, _, ok := store.issue(clientID, now)
+			if !ok {
+				t.Fatal("nonce under the cap wasn't issued")
+			}
+			nonces = append(nonces, nonce)
+		}
+
+		// Further nonces are refused without evicting outstanding ones
+		if _, _, ok := store.issue(clientID, now); ok {
+			t.Error("nonce over the cap was issued")
+		}
+		for _, nonce := range nonces {
+			if got, ok := store.consume(nonce, now); !ok ||
+				!slices.Equal(got, clientID) {
+				t.Errorf("consume = %q, %v, want %q", got, ok, clientID)
+			}
+		}
+
+		// Other clients can still be issued nonces
+		if _, _, ok := store.issue(otherClientID, now); !ok {
+			t.Error("nonce for other client wasn't issued")
+		}
+		if got, ok := store.consume(otherNonce, now); !ok ||
+			!slices.Equal(got, otherClientID) {
+			t.Errorf("consume = %q, %v, want %q", got, ok, otherClientID)
+		}
+
+		// Once nonces expire, the client can be issued more
+		later := now.Add(challengeNonceTTL)
+		if _, _, ok := store.issue(clientID, later); !ok {
+			t.Error("nonce wasn't issued after outstanding nonces expired")
+		}
+	})
+
+	t.Run("expired pruned", func(t *testing.T) {
+		past := now.Add(-2 * challengeNonceTTL)
+		store = challengeNonceStore{}
+		store.issue(clientID, past)
+		store.issue(clientID, now)
+		if got := len(store.nonces); got != 1 {
+			t.Errorf("nonces = %d, want 1", got)
+		}
+	})
+}
@@ -18,13 +18,17 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
-	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
 	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
 	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/blinklabs-io/vpn-indexer/internal/txbuilder"
 )
 
 // ClientListRequest provides the payment credential hash to search
@@ -43,7 +47,34 @@ type Client struct {
 // ClientListResponse returns a list of Clients matching the search criteria
 type ClientListResponse []Client
 
-// handleClientList godoc
+// handleClientList serves both forms of /api/client/list
+func (a *Api) handleClientList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.handleClientListGet(w, r)
+	case http.MethodPost:
+		a.handleClientListPost(w, r)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// handleClientListGet godoc
+//
+//	@Summary		ClientListGet
+//	@Description	Search for clients matching the payment credential of a given owner address
+//	@Produce		json
+//	@Param			ownerAddress	query		string				true	"Owner address"
+//	@Success		200				{object}	ClientListResponse	"List of matching clients"
+//	@Failure		400				{object}	ErrorResponse		"Bad Request"
+//	@Failure		405				{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		500				{object}	ErrorResponse		"Server Error"
+//	@Router			/api/client/list [get]
+func (a *Api) handleClientListGet(w http.ResponseWriter, r *http.Request) {
+	a.writeClientList(w, r, r.URL.Query().Get("ownerAddress"))
+}
+
+// handleClientListPost godoc
 //
 //	@Summary		ClientList
 //	@Description	Search for clients matching a given manager public key hash
@@ -51,43 +82,70 @@ type ClientListResponse []Client
 //	@Accept			json
 //	@Param			ClientListRequest	body		ClientListRequest	true	"List Request"
 //	@Success		200					{object}	ClientListResponse	"List of matching clients"
-//	@Failure		400					{object}	string				"Bad Request"
-//	@Failure		405					{object}	string				"Method Not Allowed"
-//	@Failure		500					{object}	string				"Server Error"
+//	@Failure		400					{object}	ErrorResponse		"Bad Request"
+//	@Failure		405					{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415					{object}	ErrorResponse		"Unsupported Media Type"
+//	@Failure		500					{object}	ErrorResponse		"Server Error"
 //	@Router			/api/client/list [post]
-func (a *Api) handleClientList(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (a *Api) handleClientListPost(w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
 		return
 	}
 
 	var req ClientListRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(`{"error":"Invalid request"}`))
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
 		return
 	}
 
-	ownerAddr, err := lcommon.NewAddress(req.OwnerAddress)
+	a.writeClientList(w, r, req.OwnerAddress)
+}
+
+// writeClientList writes the clients owned by the payment credential of
+// ownerAddress
+func (a *Api) writeClientList(
+	w http.ResponseWriter,
+	r *http.Request,
+	ownerAddress string,
+) {
+	paymentKeyHash, err := txbuilder.ParseOwnerAddress(
+		ownerAddress,
+		"owner address",
+		a.cfg.Indexer,
+	)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write(
-			[]byte(
-				`{"error":"Invalid request","reason":"invalid owner address"}`,
-			),
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			addressErrorCode(err),
+			"Invalid request",
+			err.Error(),
 		)
 		return
 	}
-	paymentKeyHash := ownerAddr.PaymentKeyHash().Bytes()
-	clients, err := a.db.ClientsByCredential(paymentKeyHash)
+	clients, err := a.db.ClientsByCredentialContext(
+		r.Context(),
+		paymentKeyHash,
+	)
 	if err != nil {
 		slog.Error(
 			"failed to lookup client in database",
 			"error",
 			err,
 		)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
 		return
 	}
 	tmpResp := make(ClientListResponse, 0, len(clients))
@@ -132,28 +190,39 @@ func (r *ClientProfileRequest) UnmarshalJSON(data []byte) error {
 // handleClientProfile godoc
 //
 //	@Summary		ClientProfile
-//	@Description	Fetch a client VPN profile via a signed S3 link; authenticate with a session Bearer token and provide the subscription id in the body
+//	@Description	Fetch a client VPN profile via a signed S3 link, or streamed directly when inline=true; authenticate with a session Bearer token and provide the subscription id in the body
 //	@Accept			json
+//	@Produce		application/x-openvpn-profile
 //	@Param			ClientProfileRequest	body		ClientProfileRequest	true	"Profile Request"
+//	@Param			inline					query		bool					false	"Stream the profile instead of redirecting"
+//	@Success		200						{string}	string					"OpenVPN profile (inline=true)"
 //	@Success		302						{string}	string					"Found"
-//	@Failure		400						{object}	string					"Bad Request"
-//	@Failure		401						{object}	string					"Unauthorized"
-//	@Failure		403						{object}	string					"Forbidden"
-//	@Failure		405						{object}	string					"Method Not Allowed"
-//	@Failure		500						{object}	string					"Server Error"
+//	@Failure		400						{object}	ErrorResponse			"Bad Request"
+//	@Failure		401						{object}	ErrorResponse			"Unauthorized"
+//	@Failure		403						{object}	ErrorResponse			"Forbidden"
+//	@Failure		405						{object}	ErrorResponse			"Method Not Allowed"
+//	@Failure		415						{object}	ErrorResponse			"Unsupported Media Type"
+//	@Failure		500						{object}	ErrorResponse			"Server Error"
 //	@Security		BearerAuth
 //	@Router			/api/client/profile [post]
 func (a *Api) handleClientProfile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
 		return
 	}
 
 	var req ClientProfileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write(
-			[]byte(`{"error":"Invalid request","error":"` + err.Error() + `"}`),
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			err.Error(),
 		)
 		return
 	}
@@ -168,27 +237,58 @@ func (a *Api) handleClientProfile(w http.ResponseWriter, r *http.Request) {
 
 	// Reject expired subscriptions, as the WireGuard handlers do.
 	if time.Now().After(tmpClient.Expiration) {
-		w.WriteHeader(http.StatusForbidden)
-		_, _ = w.Write(
-			[]byte(`{"error":"Forbidden","reason":"subscription has expired"}`),
-		)
+		writeExpiredResponse(w, tmpClient.Expiration)
 		return
 	}
 
 	// Check that profile is available
-	client := client.New(a.cfg, a.ca, req.innerId)
-	if ok, err := client.ProfileExists(); err != nil {
+	client := client.New(a.cfg, a.ca, a.db, req.innerId)
+	if ok, err := a.profileAvailable(client, *tmpClient); err != nil {
 		slog.Error(
 			"failed to check if profile exists",
 			"error",
 			err,
 		)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
 		return
 	} else if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		_, _ = w.Write([]byte(`{"error":"Not found","reason":"client profile doesn't exist"}`))
+		writeErrorResponse(
+			w,
+			http.StatusNotFound,
+			ErrCodeProfileNotFound,
+			"Not found",
+			"client profile doesn't exist",
+		)
+		return
+	}
+
+	// Stream the profile from S3 for clients that can't follow a
+	// cross-origin redirect
+	if inline, _ := strconv.ParseBool(r.URL.Query().Get("inline")); inline {
+		profile, err := client.OpenProfile(r.Context())
+		if err != nil {
+			slog.Error(
+				"failed to fetch profile",
+				"error",
+				err,
+			)
+			writeErrorResponse(
+				w,
+				http.StatusInternalServerError,
+				ErrCodeInternal,
+				"Internal server error",
+				"",
+			)
+			return
+		}
+		defer func() { _ = profile.Close() }()
+		writeProfile(w, req.innerId, profile)
 		return
 	}
 
@@ -200,13 +300,283 @@ func (a *Api) handleClientProfile(w http.ResponseWriter, r *http.Request) {
 			"error",
 			err,
 		)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
 		return
 	}
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+// ClientProfileTokenResponse returns a short-lived token for downloading a
+// client profile with GET /api/client/profile/{id}
+type ClientProfileTokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// handleClientProfileToken godoc
+//
+//	@Summary		ClientProfileToken
+//	@Description	Issue a short-lived token for downloading a client VPN profile with GET /api/client/profile/{id}; authenticate with a session Bearer token and provide the subscription id in the body
+//	@Accept			json
+//	@Produce		json
+//	@Param			ClientProfileRequest	body		ClientProfileRequest		true	"Profile Request"
+//	@Success		200						{object}	ClientProfileTokenResponse	"Profile download token"
+//	@Failure		400						{object}	ErrorResponse				"Bad Request"
+//	@Failure		401						{object}	ErrorResponse				"Unauthorized"
+//	@Failure		403						{object}	ErrorResponse				"Forbidden"
+//	@Failure		405						{object}	ErrorResponse				"Method Not Allowed"
+//	@Failure		415						{object}	ErrorResponse				"Unsupported Media Type"
+//	@Failure		500						{object}	ErrorResponse				"Server Error"
+//	@Security		BearerAuth
+//	@Router			/api/client/profile-token [post]
+func (a *Api) handleClientProfileToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req ClientProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.Debug("failed to decode profile token request", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
+		return
+	}
+
+	// Authenticate via the Bearer session token and authorise the requested
+	// subscription against the token's wallet credential.
+	tmpClient, err := a.authenticate(r, req.innerId)
+	if err != nil {
+		a.writeAuthError(w, err)
+		return
+	}
+
+	if time.Now().After(tmpClient.Expiration) {
+		writeExpiredResponse(w, tmpClient.Expiration)
+		return
+	}
+
+	token, expiresAt, err := a.jwtIssuer.IssueProfileJWT(
+		hex.EncodeToString(req.innerId),
+	)
+	if err != nil {
+		slog.Error("failed to issue profile token", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// The response carries a bearer credential; never let it be cached.
+	w.Header().Set("Cache-Control", "no-store")
+	resp := ClientProfileTokenResponse{
+		Token:     token,
+		ExpiresAt: expiresAt.Unix(),
+	}
+	respBytes, _ := json.Marshal(resp)
+	_, _ = w.Write(respBytes)
+}
+
+// handleClientProfileDownload godoc
+//
+//	@Summary		ClientProfileDownload
+//	@Description	Download a client VPN profile directly, without a redirect; authenticate with a token from POST /api/client/profile-token
+//	@Produce		application/x-openvpn-profile
+//	@Param			id		path		string			true	"Client ID"
+//	@Param			token	query		string			true	"Profile download token"
+//	@Success		200		{string}	string			"OpenVPN profile"
+//	@Failure		400		{object}	ErrorResponse	"Bad Request"
+//	@Failure		401		{object}	ErrorResponse	"Unauthorized"
+//	@Failure		403		{object}	ErrorResponse	"Forbidden"
+//	@Failure		404		{object}	ErrorResponse	"Not Found"
+//	@Failure		405		{object}	ErrorResponse	"Method Not Allowed"
+//	@Failure		500		{object}	ErrorResponse	"Server Error"
+//	@Router			/api/client/profile/{id} [get]
+func (a *Api) handleClientProfileDownload(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	clientId, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil || len(clientId) == 0 {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidClientID,
+			"Invalid request",
+			"invalid client ID",
+		)
+		return
+	}
+
+	// The token is bound to a single client ID, so it can't be used to fetch
+	// any other profile.
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeErrorResponse(
+			w,
+			http.StatusUnauthorized,
+			ErrCodeUnauthorized,
+			"Unauthorized",
+			"token required",
+		)
+		return
+	}
+	tokenClientId, err := a.jwtIssuer.VerifyProfileJWT(token)
+	if err != nil || tokenClientId != hex.EncodeToString(clientId) {
+		slog.Warn("profile token verification failed", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusUnauthorized,
+			ErrCodeAuthenticationFailed,
+			"Unauthorized",
+			"authentication failed",
+		)
+		return
+	}
+
+	tmpClient, err := a.db.ClientByAssetNameContext(r.Context(), clientId)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			writeErrorResponse(
+				w,
+				http.StatusNotFound,
+				ErrCodeClientNotFound,
+				"Not found",
+				"client not found",
+			)
+			return
+		}
+		slog.Error("failed to lookup client in database", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	if time.Now().After(tmpClient.Expiration) {
+		writeExpiredResponse(w, tmpClient.Expiration)
+		return
+	}
+
+	client := client.New(a.cfg, a.ca, a.db, clientId)
+	if ok, err := a.profileAvailable(client, tmpClient); err != nil {
+		slog.Error("failed to check if profile exists", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	} else if !ok {
+		writeErrorResponse(
+			w,
+			http.StatusNotFound,
+			ErrCodeProfileNotFound,
+			"Not found",
+			"client profile doesn't exist",
+		)
+		return
+	}
+
+	profile, err := client.DownloadProfile(r.Context())
+	if err != nil {
+		slog.Error("failed to download profile", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	defer func() { _ = profile.Close() }()
+
+	writeProfile(w, clientId, profile)
+}
+
+// profileAvailable reports whether a client's OpenVPN profile exists in S3.
+// With lazy profile generation, a missing profile is generated first for
+// active clients in our region. It's only used by the authenticated profile
+// endpoints, since generation is expensive and serialized.
+func (a *Api) profileAvailable(
+	profileClient *client.Client,
+	tmpClient database.Client,
+) (bool, error) {
+	ok, err := profileClient.ProfileExists()
+	if err != nil || ok {
+		return ok, err
+	}
+	if !a.profileGeneratable(tmpClient) {
+		return false, nil
+	}
+	// Generate checks for the profile again, so requests that waited for the
+	// lock don't generate it a second time
+	a.profileGenMutex.Lock()
+	defer a.profileGenMutex.Unlock()
+	if _, err := profileClient.Generate(
+		a.cfg.Vpn.ProfileHost(tmpClient.Region),
+		a.cfg.Vpn.Port,
+		a.cfg.Vpn.DNS,
+	); err != nil {
+		return false, fmt.Errorf("generate profile: %w", err)
+	}
+	return true, nil
+}
+
+// profileGeneratable reports whether a missing profile for a client is
+// generated when it's requested, which is for active clients in our region
+// with lazy profile generation
+func (a *Api) profileGeneratable(tmpClient database.Client) bool {
+	return a.cfg.Vpn.LazyProfiles && a.ca != nil &&
+		!time.Now().After(tmpClient.Expiration) &&
+		config.NormalizeRegion(a.cfg.Vpn.Region) == tmpClient.Region
+}
+
+// writeProfile streams a client profile to the response as a file download.
+// The body is copied in chunks so the profile is never held in memory.
+func writeProfile(w http.ResponseWriter, clientId []byte, profile io.Reader) {
+	w.Header().Set("Content-Type", "application/x-openvpn-profile")
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%x.ovpn"`, clientId),
+	)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := io.Copy(w, profile); err != nil {
+		slog.Error("failed to write profile response", "error", err)
+	}
+}
+
 // ClientAvailableRequest provides the client ID to check for availability
 type ClientAvailableRequest struct {
 	Id string `json:"id"`
@@ -219,33 +589,56 @@ type ClientAvailableRequest struct {
 //	@Accept			json
 //	@Param			ClientAvailableRequest	body		ClientAvailableRequest	true	"Client Available Request"
 //	@Success		200						{string}	string					"OK"
-//	@Failure		400						{object}	string					"Bad Request"
-//	@Failure		405						{object}	string					"Method Not Allowed"
-//	@Failure		500						{object}	string					"Server Error"
+//	@Failure		400						{object}	ErrorResponse			"Bad Request"
+//	@Failure		404						{object}	ErrorResponse			"Not Found"
+//	@Failure		405						{object}	ErrorResponse			"Method Not Allowed"
+//	@Failure		415						{object}	ErrorResponse			"Unsupported Media Type"
+//	@Failure		500						{object}	ErrorResponse			"Server Error"
 //	@Router			/api/client/available [post]
 func (a *Api) handleClientAvailable(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
 		return
 	}
 
 	var req ClientAvailableRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(`{"error":"Invalid request"}`))
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
 		return
 	}
 
 	// Lookup client in database
 	assetName, err := hex.DecodeString(req.Id)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(`{"error":"Invalid request"}`))
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidClientID,
+			"Invalid request",
+			"invalid client ID",
+		)
 		return
 	}
-	if _, err = a.db.ClientByAssetName(assetName); err != nil {
+	tmpClient, err := a.db.ClientByAssetNameContext(r.Context(), assetName)
+	if err != nil {
 		if errors.Is(err, database.ErrRecordNotFound) {
-			w.WriteHeader(http.StatusNotFound)
+			writeErrorResponse(
+				w,
+				http.StatusNotFound,
+				ErrCodeClientNotFound,
+				"Not found",
+				"client not found",
+			)
 			return
 		}
 		slog.Error(
@@ -253,29 +646,48 @@ func (a *Api) handleClientAvailable(w http.ResponseWriter, r *http.Request) {
 			"error",
 			err,
 		)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
 		return
 	}
 
-	// Check that profile is available
-	client := client.New(a.cfg, a.ca, assetName)
-	ok, err := client.ProfileExists()
+	// Check that profile is available. This endpoint is unauthenticated, so
+	// it only reads stored state: a profile that's generated lazily is
+	// reported as available, but only generated when it's downloaded.
+	ok, err := client.New(a.cfg, a.ca, a.db, assetName).ProfileExists()
+	if err == nil && !ok {
+		ok = a.profileGeneratable(tmpClient)
+	}
 	if err != nil {
 		slog.Error(
 			"failed to check if profile exists",
 			"error",
 			err,
 		)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
 		return
 	}
-	if ok {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"msg":"Profile is available"}`))
-	} else {
-		w.WriteHeader(http.StatusNotFound)
-		_, _ = w.Write([]byte(`{"msg":"Profile is not available"}`))
+	if !ok {
+		writeErrorResponse(
+			w,
+			http.StatusNotFound,
+			ErrCodeProfileNotFound,
+			"Not found",
+			"profile is not available",
+		)
+		return
 	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"msg":"Profile is available"}`))
 }
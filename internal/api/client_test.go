@@ -0,0 +1,711 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/blinklabs-io/vpn-indexer/internal/jwt"
+)
+
+const testProfile = "client\ndev tun\nremote test.domain 443\n"
+
+// newTestApi creates an Api backed by a temporary database and a freshly
+// generated JWT signing key
+func newTestApi(t *testing.T) *Api {
+	t.Helper()
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	privKeyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "ed25519.key")
+	keyPem := pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: privKeyBytes},
+	)
+	if err := os.WriteFile(keyPath, keyPem, 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	jwtIssuer, err := jwt.NewIssuer(keyPath)
+	if err != nil {
+		t.Fatalf("failed to create JWT issuer: %v", err)
+	}
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Directory: t.TempDir(),
+		},
+		Vpn: config.VpnConfig{
+			Region: "test",
+		},
+		Api: config.ApiConfig{
+			COSEAlgorithms: []string{"EdDSA"},
+		},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	return &Api{
+		cfg:       cfg,
+		db:        db,
+		jwtIssuer: jwtIssuer,
+	}
+}
+
+// newTestS3 starts a fake S3 endpoint serving a single object and points the
+// Api config at it
+func newTestS3(t *testing.T, a *Api, key string, body string) {
+	t.Helper()
+
+	// Static credentials keep the AWS SDK from searching the environment
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	objectPath := "/test-bucket/" + key
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != objectPath {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			if r.Method == http.MethodHead {
+				return
+			}
+			_, _ = w.Write([]byte(body))
+		}),
+	)
+	t.Cleanup(server.Close)
+
+	a.cfg.S3.ClientBucket = "test-bucket"
+	a.cfg.S3.Endpoint = server.URL
+}
+
+// newTestS3Store starts a fake S3 endpoint that stores objects written to it
+// in memory and points the Api config at it
+func newTestS3Store(t *testing.T, a *Api) {
+	t.Helper()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch r.Method {
+			case http.MethodPut:
+				body, _ := io.ReadAll(r.Body)
+				objects[r.URL.Path] = body
+				w.Header().Set("ETag", fmt.Sprintf(`"%d"`, len(body)))
+			case http.MethodGet, http.MethodHead:
+				body, ok := objects[r.URL.Path]
+				if !ok {
+					w.Header().Set("Content-Type", "application/xml")
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write(
+						[]byte(`<Error><Code>NoSuchKey</Code></Error>`),
+					)
+					return
+				}
+				w.Header().Set("ETag", fmt.Sprintf(`"%d"`, len(body)))
+				if r.Method == http.MethodGet {
+					_, _ = w.Write(body)
+				}
+			case http.MethodDelete:
+				delete(objects, r.URL.Path)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}),
+	)
+	t.Cleanup(server.Close)
+
+	a.cfg.S3.ClientBucket = "test-bucket"
+	a.cfg.S3.Endpoint = server.URL
+}
+
+func TestClientList(t *testing.T) {
+	const (
+		ownerAddress  = "addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5"
+		rewardAddress = "stake_test1upjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeq5xlqvh"
+		// Enterprise address with a script payment credential
+		scriptAddress  = "addr_test1wpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeqaxacva"
+		mainnetAddress = "addr1q9jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jqpnzwh0"
+	)
+	a := newTestApi(t)
+	a.cfg.Indexer.Network = "preprod"
+	ownerAddr, err := lcommon.NewAddress(ownerAddress)
+	if err != nil {
+		t.Fatalf("failed to decode address: %v", err)
+	}
+	assetName := []byte("listed-client")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		ownerAddr.PaymentKeyHash().Bytes(),
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		query       string
+		body        string
+		wantStatus  int
+		wantReason  string
+		wantClients int
+	}{
+		{
+			name:        "get",
+			method:      http.MethodGet,
+			query:       "?ownerAddress=" + ownerAddress,
+			wantStatus:  http.StatusOK,
+			wantClients: 1,
+		},
+		{
+			name:       "get invalid address",
+			method:     http.MethodGet,
+			query:      "?ownerAddress=not-an-address",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "get reward address",
+			method:     http.MethodGet,
+			query:      "?ownerAddress=" + rewardAddress,
+			wantStatus: http.StatusBadRequest,
+			wantReason: "owner address has no payment credential",
+		},
+		{
+			name:       "get mainnet address",
+			method:     http.MethodGet,
+			query:      "?ownerAddress=" + mainnetAddress,
+			wantStatus: http.StatusBadRequest,
+			wantReason: "owner address is a mainnet address, but the " +
+				"configured network is preprod",
+		},
+		{
+			name:       "post script address",
+			method:     http.MethodPost,
+			body:       `{"ownerAddress":"` + scriptAddress + `"}`,
+			wantStatus: http.StatusBadRequest,
+			wantReason: "owner address has a script payment credential, " +
+				"which can't own a subscription",
+		},
+		{
+			name:       "get missing address",
+			method:     http.MethodGet,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "post",
+			method:      http.MethodPost,
+			body:        `{"ownerAddress":"` + ownerAddress + `"}`,
+			wantStatus:  http.StatusOK,
+			wantClients: 1,
+		},
+		{
+			name:       "put",
+			method:     http.MethodPut,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				tt.method,
+				"/api/client/list"+tt.query,
+				strings.NewReader(tt.body),
+			)
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			a.handleClientList(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantReason != "" {
+				var errResp ErrorResponse
+				if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if errResp.Reason != tt.wantReason {
+					t.Errorf("reason = %q, want %q", errResp.Reason, tt.wantReason)
+				}
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp ClientListResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(resp) != tt.wantClients {
+				t.Fatalf("got %d clients, want %d", len(resp), tt.wantClients)
+			}
+			if resp[0].Id != hex.EncodeToString(assetName) {
+				t.Errorf(
+					"id = %q, want %q",
+					resp[0].Id,
+					hex.EncodeToString(assetName),
+				)
+			}
+		})
+	}
+}
+
+func TestClientProfileDownload(t *testing.T) {
+	a := newTestApi(t)
+	assetName := []byte("test-client")
+	clientId := hex.EncodeToString(assetName)
+	newTestS3(t, a, clientId+".ovpn", testProfile)
+
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		[]byte("credential"),
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	token, _, err := a.jwtIssuer.IssueProfileJWT(clientId)
+	if err != nil {
+		t.Fatalf("failed to issue profile token: %v", err)
+	}
+	otherToken, _, err := a.jwtIssuer.IssueProfileJWT("00")
+	if err != nil {
+		t.Fatalf("failed to issue profile token: %v", err)
+	}
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(clientId)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		id         string
+		token      string
+		wantStatus int
+	}{
+		{
+			name:       "valid token",
+			method:     http.MethodGet,
+			id:         clientId,
+			token:      token,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing token",
+			method:     http.MethodGet,
+			id:         clientId,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token for another client",
+			method:     http.MethodGet,
+			id:         clientId,
+			token:      otherToken,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "session token",
+			method:     http.MethodGet,
+			id:         clientId,
+			token:      sessionToken,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid client ID",
+			method:     http.MethodGet,
+			id:         "not-hex",
+			token:      token,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodPost,
+			id:         clientId,
+			token:      token,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				tt.method,
+				"/api/client/profile/"+tt.id+"?token="+tt.token,
+				nil,
+			)
+			req.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			a.handleClientProfileDownload(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if got := w.Body.String(); got != testProfile {
+				t.Errorf("body = %q, want %q", got, testProfile)
+			}
+			disposition := w.Header().Get("Content-Disposition")
+			if !strings.Contains(disposition, clientId+".ovpn") {
+				t.Errorf(
+					"Content-Disposition = %q, want filename %s.ovpn",
+					disposition,
+					clientId,
+				)
+			}
+		})
+	}
+}
+
+func TestClientProfileInline(t *testing.T) {
+	a := newTestApi(t)
+	assetName := []byte("test-client")
+	clientId := hex.EncodeToString(assetName)
+	credential := []byte("credential")
+	newTestS3(t, a, clientId+".ovpn", testProfile)
+
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+		hex.EncodeToString(credential),
+	)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "inline streams profile",
+			query:      "?inline=true",
+			wantStatus: http.StatusOK,
+			wantBody:   testProfile,
+		},
+		{
+			name:       "default redirects",
+			query:      "",
+			wantStatus: http.StatusFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/profile"+tt.query,
+				strings.NewReader(`{"id":"`+clientId+`"}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			a.handleClientProfile(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			disposition := w.Header().Get("Content-Disposition")
+			if disposition != `attachment; filename="`+clientId+`.ovpn"` {
+				t.Errorf("Content-Disposition = %q", disposition)
+			}
+		})
+	}
+}
+
+func TestClientProfileLookupError(t *testing.T) {
+	credential := []byte("credential")
+	clientId := hex.EncodeToString([]byte("test-client"))
+
+	tests := []struct {
+		name       string
+		closeDB    bool
+		wantStatus int
+	}{
+		{
+			// A missing client is an ownership failure
+			name:       "client not found",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			// A database outage must not be masked as an auth failure
+			name:       "database error",
+			closeDB:    true,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+				hex.EncodeToString(credential),
+			)
+			if err != nil {
+				t.Fatalf("failed to issue session token: %v", err)
+			}
+			if tt.closeDB {
+				if err := a.db.Close(); err != nil {
+					t.Fatalf("failed to close database: %v", err)
+				}
+			}
+
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/profile",
+				strings.NewReader(`{"id":"`+clientId+`"}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			a.handleClientProfile(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+		})
+	}
+}
+
+func TestClientAvailableLazyProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		lazy       bool
+		region     string
+		expiration time.Time
+		wantStatus int
+		// wantProfileStatus is the status of the authenticated profile
+		// request, which generates a lazy profile and redirects to it
+		wantProfileStatus int
+	}{
+		{
+			name:              "eager",
+			region:            "test",
+			expiration:        time.Now().Add(time.Hour),
+			wantStatus:        http.StatusNotFound,
+			wantProfileStatus: http.StatusNotFound,
+		},
+		{
+			name:              "lazy",
+			lazy:              true,
+			region:            "test",
+			expiration:        time.Now().Add(time.Hour),
+			wantStatus:        http.StatusOK,
+			wantProfileStatus: http.StatusFound,
+		},
+		{
+			name:              "lazy other region",
+			lazy:              true,
+			region:            "other",
+			expiration:        time.Now().Add(time.Hour),
+			wantStatus:        http.StatusNotFound,
+			wantProfileStatus: http.StatusNotFound,
+		},
+		{
+			name:              "lazy expired",
+			lazy:              true,
+			region:            "test",
+			expiration:        time.Now().Add(-time.Hour),
+			wantStatus:        http.StatusNotFound,
+			wantProfileStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.Vpn.LazyProfiles = tt.lazy
+			a.cfg.Vpn.Domain = "test.domain"
+			a.cfg.Vpn.Port = 443
+			newTestS3Store(t, a)
+			newTestCa(t, a)
+
+			assetName := []byte("lazy-client")
+			credential := []byte("credential")
+			if err := a.db.AddClient(
+				assetName,
+				tt.expiration,
+				credential,
+				tt.region,
+				[]byte("txhash"),
+				0,
+				0,
+			); err != nil {
+				t.Fatalf("failed to add client: %v", err)
+			}
+			profileExists := func(t *testing.T) bool {
+				t.Helper()
+				exists, err := client.New(a.cfg, a.ca, a.db, assetName).
+					ProfileExists()
+				if err != nil {
+					t.Fatalf("failed to check profile: %v", err)
+				}
+				return exists
+			}
+			body := `{"id":"` + hex.EncodeToString(assetName) + `"}`
+
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/available",
+				strings.NewReader(body),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			a.routes().ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantStatus == http.StatusNotFound {
+				var errResp ErrorResponse
+				if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if errResp.Code != ErrCodeProfileNotFound {
+					t.Errorf(
+						"code = %q, want %q",
+						errResp.Code,
+						ErrCodeProfileNotFound,
+					)
+				}
+			}
+			// The unauthenticated availability check never generates the
+			// profile
+			if profileExists(t) {
+				t.Fatal("profile was generated by the availability check")
+			}
+
+			sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+				hex.EncodeToString(credential),
+			)
+			if err != nil {
+				t.Fatalf("failed to issue session token: %v", err)
+			}
+			req = httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/profile",
+				strings.NewReader(body),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w = httptest.NewRecorder()
+			a.handleClientProfile(w, req)
+			if w.Code != tt.wantProfileStatus {
+				t.Fatalf(
+					"profile status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantProfileStatus,
+					w.Body.String(),
+				)
+			}
+			wantGenerated := tt.wantProfileStatus == http.StatusFound
+			if exists := profileExists(t); exists != wantGenerated {
+				t.Errorf(
+					"profile exists = %t, want %t",
+					exists,
+					wantGenerated,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// gzipMinSize is the smallest response body worth compressing
+	gzipMinSize = 1024
+
+	// profileDownloadPrefix is excluded from compression: it redirects to S3
+	// or streams the profile file as-is
+	profileDownloadPrefix = "/api/client/profile/"
+)
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for part := range strings.SplitSeq(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		// A q value of 0 explicitly refuses the coding
+		key, value, _ := strings.Cut(params, "=")
+		if strings.TrimSpace(key) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// isCompressible reports whether a Content-Type is text-like. Images and
+// other binary types are already compressed or not worth compressing.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/yaml",
+		mediaType == "application/javascript":
+		return true
+	}
+	return false
+}
+
+// gzipMiddleware compresses text responses larger than gzipMinSize for
+// clients that accept gzip
+func (a *Api) gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, profileDownloadPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// the body is large enough to compress
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status != 0 {
+		return
+	}
+	g.status = status
+	// Bodiless and redirect responses are passed through untouched
+	if status < http.StatusOK || status >= http.StatusMultipleChoices ||
+		status == http.StatusNoContent {
+		g.passthrough()
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.decided {
+		if g.gz != nil {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+	g.buf.Write(p)
+	if g.buf.Len() >= gzipMinSize {
+		if err := g.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide starts compressing the buffered body if its type is compressible
+func (g *gzipResponseWriter) decide() error {
+	header := g.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(g.buf.Bytes()))
+	}
+	if header.Get("Content-Encoding") != "" ||
+		!isCompressible(header.Get("Content-Type")) {
+		return g.flushBuffer()
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	g.decided = true
+	g.ResponseWriter.WriteHeader(g.status)
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf.Bytes())
+	g.buf.Reset()
+	return err
+}
+
+// passthrough writes the status line and stops buffering
+func (g *gzipResponseWriter) passthrough() {
+	g.decided = true
+	g.ResponseWriter.WriteHeader(g.status)
+}
+
+// flushBuffer sends the buffered body uncompressed
+func (g *gzipResponseWriter) flushBuffer() error {
+	g.passthrough()
+	_, err := g.ResponseWriter.Write(g.buf.Bytes())
+	g.buf.Reset()
+	return err
+}
+
+// finish completes the response once the handler returns
+func (g *gzipResponseWriter) finish() {
+	if g.gz != nil {
+		_ = g.gz.Close()
+		return
+	}
+	if g.decided {
+		return
+	}
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	_ = g.flushBuffer()
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: "gzip", want: true},
+		{header: "deflate, gzip;q=0.8", want: true},
+		{header: "GZIP", want: true},
+		{header: "*", want: true},
+		{header: "gzip;q=0", want: false},
+		{header: "gzip; q=0.0", want: false},
+		{header: "br, deflate", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := acceptsGzip(tt.header); got != tt.want {
+				t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	// A JSON list comfortably above the compression threshold
+	items := make([]string, 200)
+	for i := range items {
+		items[i] = strings.Repeat("client", 4)
+	}
+	largeJSON, err := json.Marshal(items)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	pngBody := append(
+		[]byte("\x89PNG\r\n\x1a\n"),
+		bytes.Repeat([]byte{0}, 2*gzipMinSize)...,
+	)
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		contentType    string
+		status         int
+		body           []byte
+		wantGzip       bool
+	}{
+		{
+			name:           "large JSON",
+			path:           "/api/client/list",
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			body:           largeJSON,
+			wantGzip:       true,
+		},
+		{
+			name:        "client without gzip",
+			path:        "/api/client/list",
+			contentType: "application/json",
+			body:        largeJSON,
+		},
+		{
+			name:           "small JSON",
+			path:           "/api/refdata",
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			body:           []byte(`{"ok":true}`),
+		},
+		{
+			name:           "image",
+			path:           "/api/client/qr",
+			acceptEncoding: "gzip",
+			contentType:    "image/png",
+			body:           pngBody,
+		},
+		{
+			name:           "profile download",
+			path:           "/api/client/profile/abcd",
+			acceptEncoding: "gzip",
+			contentType:    "text/plain",
+			body:           largeJSON,
+		},
+		{
+			name:           "redirect",
+			path:           "/api/client/other",
+			acceptEncoding: "gzip",
+			contentType:    "text/html",
+			status:         http.StatusFound,
+			body:           largeJSON,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &Api{}
+			handler := a.gzipMiddleware(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", tc.contentType)
+					if tc.status != 0 {
+						w.WriteHeader(tc.status)
+					}
+					// Write in chunks to cross the threshold mid-body
+					for chunk := range slices.Chunk(tc.body, 100) {
+						_, _ = w.Write(chunk)
+					}
+				}),
+			)
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			wantStatus := tc.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if w.Code != wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, wantStatus)
+			}
+			gotGzip := w.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tc.wantGzip {
+				t.Fatalf("gzip = %v, want %v", gotGzip, tc.wantGzip)
+			}
+
+			body := w.Body.Bytes()
+			if gotGzip {
+				if len(body) >= len(tc.body) {
+					t.Errorf(
+						"compressed size %d not smaller than %d",
+						len(body),
+						len(tc.body),
+					)
+				}
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("failed to open gzip body: %v", err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("failed to decompress body: %v", err)
+				}
+			}
+			if !bytes.Equal(body, tc.body) {
+				t.Errorf("body does not match original (%d bytes, want %d)", len(body), len(tc.body))
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/txbuilder"
+)
+
+// ErrorCode is a stable, machine-readable identifier for the failure in an
+// ErrorResponse. Clients should branch on it rather than on Reason, which is
+// meant for people and may change. Codes are only ever added, never renamed.
+type ErrorCode string
+
+// Error codes returned by the API
+const (
+	ErrCodeInvalidRequest          ErrorCode = "invalid_request"           // The request body or parameters are malformed or incomplete
+	ErrCodeInvalidClientID         ErrorCode = "invalid_client_id"         // The client ID isn't valid hex
+	ErrCodeInvalidAddress          ErrorCode = "invalid_address"           // The address can't be decoded or can't own a subscription
+	ErrCodeWrongNetwork            ErrorCode = "wrong_network"             // The address is for a different network than this service
+	ErrCodeInvalidWGPubkey         ErrorCode = "invalid_wg_pubkey"         // A WireGuard public key isn't a base64-encoded 32-byte key
+	ErrCodeInvalidTransaction      ErrorCode = "invalid_transaction"       // The transaction can't be decoded or failed sanity checks
+	ErrCodeTransactionRejected     ErrorCode = "transaction_rejected"      // The submit API rejected the transaction
+	ErrCodeUnsupportedMediaType    ErrorCode = "unsupported_media_type"    // The request has the wrong Content-Type
+	ErrCodeMethodNotAllowed        ErrorCode = "method_not_allowed"        // The endpoint doesn't support the request method
+	ErrCodeRequestTooLarge         ErrorCode = "request_too_large"         // The request body exceeds the size limit
+	ErrCodeUnauthorized            ErrorCode = "unauthorized"              // The request has no token
+	ErrCodeAuthenticationFailed    ErrorCode = "authentication_failed"     // The token or signature isn't valid for the request
+	ErrCodeUnsupportedAlgorithm    ErrorCode = "unsupported_algorithm"     // The signature uses an algorithm other than EdDSA
+	ErrCodeNoSubscriptions         ErrorCode = "no_subscriptions"          // The wallet doesn't own any subscriptions
+	ErrCodeSubscriptionExpired     ErrorCode = "subscription_expired"      // The subscription has expired and needs renewing
+	ErrCodeProfilesDisabled        ErrorCode = "profiles_disabled"         // OpenVPN profiles aren't enabled on this server
+	ErrCodeClientNotFound          ErrorCode = "client_not_found"          // The subscription doesn't exist
+	ErrCodeProfileNotFound         ErrorCode = "profile_not_found"         // The subscription's OpenVPN profile hasn't been generated yet
+	ErrCodeDeviceNotFound          ErrorCode = "device_not_found"          // The WireGuard device isn't registered to the subscription
+	ErrCodeDeviceLimitReached      ErrorCode = "device_limit_reached"      // The subscription has registered the maximum number of devices
+	ErrCodeDeviceAlreadyRegistered ErrorCode = "device_already_registered" // The new WireGuard key is already registered
+	ErrCodeTooManyChallenges       ErrorCode = "too_many_challenges"       // The subscription has too many unused challenge nonces, try again later
+	ErrCodeUnavailable             ErrorCode = "unavailable"               // A dependency is temporarily unavailable, try again later
+	ErrCodeServerMisconfigured     ErrorCode = "server_misconfigured"      // The server is missing configuration needed for the request
+	ErrCodeInternal                ErrorCode = "internal_error"            // An unexpected server error
+)
+
+// ErrorResponse is a JSON error response structure
+type ErrorResponse struct {
+	// Code identifies the failure for clients to branch on
+	Code   ErrorCode `json:"code"`
+	Error  string    `json:"error"`
+	Reason string    `json:"reason,omitempty"`
+	// Details carries structured context for the error (e.g. the
+	// subscription expiration) so UIs don't need to parse Reason
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// writeErrorResponse writes a properly escaped JSON error response
+func writeErrorResponse(
+	w http.ResponseWriter,
+	status int,
+	code ErrorCode,
+	err, reason string,
+) {
+	writeErrorResponseWithDetails(w, status, code, err, reason, nil)
+}
+
+// writeErrorResponseWithDetails writes a JSON error response including
+// structured details
+func writeErrorResponseWithDetails(
+	w http.ResponseWriter,
+	status int,
+	code ErrorCode,
+	err, reason string,
+	details map[string]any,
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	resp := ErrorResponse{
+		Code:    code,
+		Error:   err,
+		Reason:  reason,
+		Details: details,
+	}
+	data, _ := json.Marshal(resp)
+	_, _ = w.Write(data)
+}
+
+// addressErrorCode returns the code for an input rejected by txbuilder. An
+// address rejected for its network or format has its own code, and any other
+// invalid input is an invalid request.
+func addressErrorCode(err error) ErrorCode {
+	switch {
+	case errors.Is(err, txbuilder.ErrAddressWrongNetwork):
+		return ErrCodeWrongNetwork
+	case errors.Is(err, txbuilder.ErrAddressInvalid),
+		errors.Is(err, txbuilder.ErrAddressNoPaymentPart),
+		errors.Is(err, txbuilder.ErrAddressScriptPayment):
+		return ErrCodeInvalidAddress
+	default:
+		return ErrCodeInvalidRequest
+	}
+}
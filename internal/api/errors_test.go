@@ -0,0 +1,307 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+)
+
+// TestErrorCodes checks that specific failures return their error codes.
+// Clients branch on the codes, so they're compared against literal strings
+// to catch a rename.
+func TestErrorCodes(t *testing.T) {
+	const (
+		laptopPubkey   = "bGlmZWN5Y2xlLWxhcHRvcC1wdWJrZXktcGxhY2Vob2w="
+		phonePubkey    = "bGlmZWN5Y2xlLXBob25lLXB1YmtleS1wbGFjZWhvbGQ="
+		tabletPubkey   = "bGlmZWN5Y2xlLXRhYmxldC1wdWJrZXktcGxhY2Vob2w="
+		rewardAddress  = "stake_test1upjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeq5xlqvh"
+		mainnetAddress = "addr1q9jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jqpnzwh0"
+	)
+	a, _, _ := newTestWGApi(t)
+	a.cfg.Indexer.Network = "preprod"
+	h := a.handler()
+
+	// One subscription is active with a full device list, and the other has
+	// expired
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	credential := lcommon.Blake2b224Hash(pub).Bytes()
+	activeID := hex.EncodeToString([]byte("active-client"))
+	expiredID := hex.EncodeToString([]byte("expired-client"))
+	for assetName, expiration := range map[string]time.Time{
+		"active-client":  time.Now().Add(time.Hour),
+		"expired-client": time.Now().Add(-time.Hour),
+	} {
+		if err := a.db.AddClient(
+			[]byte(assetName),
+			expiration,
+			credential,
+			"test",
+			[]byte("txhash-"+assetName),
+			0,
+			0,
+		); err != nil {
+			t.Fatalf("failed to add client: %v", err)
+		}
+	}
+	token := newTestSession(t, h, priv)
+	for _, pubkey := range []string{laptopPubkey, phonePubkey} {
+		w := serveTestJSON(
+			t,
+			h,
+			http.MethodPost,
+			"/api/client/wg-register",
+			token,
+			map[string]string{"client_id": activeID, "wg_pubkey": pubkey},
+		)
+		if w.Code != http.StatusOK {
+			t.Fatalf("register status = %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	tests := []struct {
+		name       string
+		serve      func() *httptest.ResponseRecorder
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name: "malformed body",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/wg-register",
+					token,
+					"not an object",
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "invalid_request",
+		},
+		{
+			name: "wrong content type",
+			serve: func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(
+					http.MethodPost,
+					"/api/client/wg-register",
+					strings.NewReader("{}"),
+				)
+				req.Header.Set("Content-Type", "text/plain")
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				return w
+			},
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantCode:   "unsupported_media_type",
+		},
+		{
+			name: "reward owner address",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/list",
+					"",
+					map[string]string{"ownerAddress": rewardAddress},
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "invalid_address",
+		},
+		{
+			name: "mainnet owner address",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/list",
+					"",
+					map[string]string{"ownerAddress": mainnetAddress},
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "wrong_network",
+		},
+		{
+			name: "mainnet payment address",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/tx/signup",
+					"",
+					map[string]any{
+						"paymentAddress": mainnetAddress,
+						"price":          1,
+						"duration":       1,
+						"region":         "us-east-1",
+					},
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "wrong_network",
+		},
+		{
+			name: "signup without region",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/tx/signup",
+					"",
+					map[string]any{
+						"paymentAddress": mainnetAddress,
+						"price":          1,
+						"duration":       1,
+					},
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "invalid_request",
+		},
+		{
+			name: "invalid wg pubkey",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/wg-register",
+					token,
+					map[string]string{
+						"client_id": activeID,
+						"wg_pubkey": "not-a-key",
+					},
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "invalid_wg_pubkey",
+		},
+		{
+			name: "missing token",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/wg-register",
+					"",
+					map[string]string{
+						"client_id": activeID,
+						"wg_pubkey": tabletPubkey,
+					},
+				)
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "unauthorized",
+		},
+		{
+			name: "device limit reached",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/wg-register",
+					token,
+					map[string]string{
+						"client_id": activeID,
+						"wg_pubkey": tabletPubkey,
+					},
+				)
+			},
+			wantStatus: http.StatusForbidden,
+			wantCode:   "device_limit_reached",
+		},
+		{
+			name: "subscription expired",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/wg-register",
+					token,
+					map[string]string{
+						"client_id": expiredID,
+						"wg_pubkey": tabletPubkey,
+					},
+				)
+			},
+			wantStatus: http.StatusForbidden,
+			wantCode:   "subscription_expired",
+		},
+		{
+			name: "device not registered",
+			serve: func() *httptest.ResponseRecorder {
+				return serveTestJSON(
+					t,
+					h,
+					http.MethodPost,
+					"/api/client/wg-profile",
+					token,
+					map[string]string{
+						"client_id": activeID,
+						"wg_pubkey": tabletPubkey,
+					},
+				)
+			},
+			wantStatus: http.StatusNotFound,
+			wantCode:   "device_not_found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.serve()
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d: %s",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if string(resp.Code) != tt.wantCode {
+				t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+			}
+			if resp.Error == "" {
+				t.Error("error is empty")
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/veraison/go-cose"
+)
+
+// newTestWGApi creates an Api with the WireGuard routes enabled, backed by an
+// in-memory peer store and a fake WG container
+func newTestWGApi(t *testing.T) (*Api, *memPeerStore, *fakePeerManager) {
+	t.Helper()
+	a := newTestApi(t)
+	a.cfg.Vpn.WGMaxDevices = 2
+	a.cfg.Vpn.WGServerPubkey = "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA="
+	a.cfg.Vpn.WGEndpoint = "vpn.example.com:51820"
+	a.cfg.Vpn.WGAllocationStrategy = config.WGAllocationLowest
+	store := newMemPeerStore()
+	manager := &fakePeerManager{}
+	a.peerStore = store
+	a.peerManager = manager
+	return a, store, manager
+}
+
+// newTestSession signs a session challenge with priv and exchanges it for a
+// session token through POST /api/auth/session
+func newTestSession(
+	t *testing.T,
+	h http.Handler,
+	priv ed25519.PrivateKey,
+) string {
+	t.Helper()
+	signer, err := cose.NewSigner(cose.AlgorithmEdDSA, priv)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	msg := &cose.UntaggedSign1Message{
+		Headers: cose.Headers{
+			Protected: cose.ProtectedHeader{
+				cose.HeaderLabelAlgorithm: cose.AlgorithmEdDSA,
+			},
+		},
+		Payload: []byte(
+			`{"type":"vpn-session","timestamp":` +
+				strconv.FormatInt(time.Now().Unix(), 10) + `}`,
+		),
+	}
+	if err := msg.Sign(rand.Reader, nil, signer); err != nil {
+		t.Fatalf("failed to sign challenge: %v", err)
+	}
+	sigBytes, err := msg.MarshalCBOR()
+	if err != nil {
+		t.Fatalf("failed to encode signature: %v", err)
+	}
+	key, err := cose.NewKeyFromPublic(priv.Public())
+	if err != nil {
+		t.Fatalf("failed to create COSE key: %v", err)
+	}
+	keyBytes, err := key.MarshalCBOR()
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+
+	w := serveTestJSON(
+		t,
+		h,
+		http.MethodPost,
+		"/api/auth/session",
+		"",
+		map[string]string{
+			"signature": hex.EncodeToString(sigBytes),
+			"key":       hex.EncodeToString(keyBytes),
+		},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("session status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp SessionResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode session response: %v", err)
+	}
+	return resp.Token
+}
+
+// serveTestJSON sends a JSON request through h, authenticated with token when
+// it's set
+func serveTestJSON(
+	t *testing.T,
+	h http.Handler,
+	method, path, token string,
+	body any,
+) *httptest.ResponseRecorder {
+	t.Helper()
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// TestWGDeviceLifecycle registers, inspects, lists, and deletes devices
+// through the full API handler, checking the IP pool, device counts, S3
+// registry, and WG container along the way
+func TestWGDeviceLifecycle(t *testing.T) {
+	const (
+		laptopPubkey = "bGlmZWN5Y2xlLWxhcHRvcC1wdWJrZXktcGxhY2Vob2w="
+		phonePubkey  = "bGlmZWN5Y2xlLXBob25lLXB1YmtleS1wbGFjZWhvbGQ="
+		tabletPubkey = "bGlmZWN5Y2xlLXRhYmxldC1wdWJrZXktcGxhY2Vob2w="
+	)
+	a, store, manager := newTestWGApi(t)
+	h := a.handler()
+
+	// The subscription is owned by the wallet key that signs in
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	assetName := []byte("lifecycle-client")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		lcommon.Blake2b224Hash(pub).Bytes(),
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	token := newTestSession(t, h, priv)
+	clientID := hex.EncodeToString(assetName)
+
+	register := func(pubkey string, wantStatus int) WGRegisterResponse {
+		t.Helper()
+		w := serveTestJSON(
+			t,
+			h,
+			http.MethodPost,
+			"/api/client/wg-register",
+			token,
+			map[string]string{"client_id": clientID, "wg_pubkey": pubkey},
+		)
+		if w.Code != wantStatus {
+			t.Fatalf(
+				"register %s status = %d, want %d: %s",
+				pubkey,
+				w.Code,
+				wantStatus,
+				w.Body.String(),
+			)
+		}
+		var resp WGRegisterResponse
+		if wantStatus == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode register response: %v", err)
+			}
+		}
+		return resp
+	}
+	profile := func(pubkey string, wantStatus int) string {
+		t.Helper()
+		w := serveTestJSON(
+			t,
+			h,
+			http.MethodPost,
+			"/api/client/wg-profile",
+			token,
+			map[string]string{"client_id": clientID, "wg_pubkey": pubkey},
+		)
+		if w.Code != wantStatus {
+			t.Fatalf(
+				"profile %s status = %d, want %d: %s",
+				pubkey,
+				w.Code,
+				wantStatus,
+				w.Body.String(),
+			)
+		}
+		var resp WGProfileResponse
+		if wantStatus == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode profile response: %v", err)
+			}
+		}
+		return resp.Config
+	}
+	devices := func() WGDevicesResponse {
+		t.Helper()
+		w := serveTestJSON(
+			t,
+			h,
+			http.MethodPost,
+			"/api/client/wg-devices",
+			token,
+			map[string]string{"client_id": clientID},
+		)
+		if w.Code != http.StatusOK {
+			t.Fatalf("devices status = %d: %s", w.Code, w.Body.String())
+		}
+		var resp WGDevicesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode devices response: %v", err)
+		}
+		return resp
+	}
+	storedPeers := func() []string {
+		t.Helper()
+		peerFile, err := store.LoadPeersFromS3(assetName)
+		if err != nil {
+			t.Fatalf("failed to load peer file: %v", err)
+		}
+		var ret []string
+		for _, peer := range peerFile.Peers {
+			ret = append(ret, peer.Pubkey+"="+peer.AssignedIP)
+		}
+		return ret
+	}
+
+	// Register two devices, which fills the device limit
+	laptop := register(laptopPubkey, http.StatusOK)
+	if laptop.AssignedIP != "10.8.0.2" || laptop.DeviceCount != 1 ||
+		laptop.DeviceLimit != 2 {
+		t.Fatalf("unexpected laptop registration: %+v", laptop)
+	}
+	phone := register(phonePubkey, http.StatusOK)
+	if phone.AssignedIP != "10.8.0.3" || phone.DeviceCount != 2 {
+		t.Fatalf("unexpected phone registration: %+v", phone)
+	}
+	register(tabletPubkey, http.StatusForbidden)
+	if got, want := storedPeers(), []string{
+		laptopPubkey + "=10.8.0.2",
+		phonePubkey + "=10.8.0.3",
+	}; !slices.Equal(got, want) {
+		t.Fatalf("S3 peers = %v, want %v", got, want)
+	}
+	want := []string{laptopPubkey, phonePubkey}
+	if !slices.Equal(manager.added, want) {
+		t.Fatalf("container adds = %v, want %v", manager.added, want)
+	}
+
+	// Each device gets a profile for its own address
+	if cfg := profile(laptopPubkey, http.StatusOK); !strings.Contains(
+		cfg,
+		"Address = 10.8.0.2/24",
+	) {
+		t.Fatalf("laptop profile missing its address:\n%s", cfg)
+	}
+	if cfg := profile(phonePubkey, http.StatusOK); !strings.Contains(
+		cfg,
+		"Address = 10.8.0.3/24",
+	) {
+		t.Fatalf("phone profile missing its address:\n%s", cfg)
+	}
+	profile(tabletPubkey, http.StatusNotFound)
+
+	list := devices()
+	if len(list.Devices) != 2 || list.Limit != 2 {
+		t.Fatalf("unexpected device list: %+v", list)
+	}
+
+	// Deleting the laptop frees its address and a device slot
+	w := serveTestJSON(
+		t,
+		h,
+		http.MethodDelete,
+		"/api/client/wg-peer",
+		token,
+		map[string]string{"client_id": clientID, "wg_pubkey": laptopPubkey},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body.String())
+	}
+	var deleted WGDeleteResponse
+	if err := json.NewDecoder(w.Body).Decode(&deleted); err != nil {
+		t.Fatalf("failed to decode delete response: %v", err)
+	}
+	if !deleted.Success || deleted.RemainingDevices != 1 {
+		t.Fatalf("unexpected delete response: %+v", deleted)
+	}
+	if want := []string{laptopPubkey}; !slices.Equal(manager.removed, want) {
+		t.Fatalf("container removes = %v, want %v", manager.removed, want)
+	}
+	if got, want := storedPeers(), []string{
+		phonePubkey + "=10.8.0.3",
+	}; !slices.Equal(got, want) {
+		t.Fatalf("S3 peers after delete = %v, want %v", got, want)
+	}
+	if _, err := a.db.GetWGPeerByPubkey(laptopPubkey); !errors.Is(
+		err,
+		database.ErrRecordNotFound,
+	) {
+		t.Fatalf("deleted peer lookup error = %v, want not found", err)
+	}
+	profile(laptopPubkey, http.StatusNotFound)
+	list = devices()
+	if len(list.Devices) != 1 || list.Devices[0].Pubkey != phonePubkey {
+		t.Fatalf("unexpected device list after delete: %+v", list)
+	}
+
+	// The freed address is reused by the next registration
+	tablet := register(tabletPubkey, http.StatusOK)
+	if tablet.AssignedIP != "10.8.0.2" || tablet.DeviceCount != 2 {
+		t.Fatalf("unexpected tablet registration: %+v", tablet)
+	}
+	if list := devices(); len(list.Devices) != 2 {
+		t.Fatalf("unexpected device list after re-register: %+v", list)
+	}
+}
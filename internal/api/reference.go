@@ -18,45 +18,105 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
 )
 
-// RefDataResponse provides the list of prices and the VPN regions available
+// RefDataResponse provides the list of prices and the VPN regions available,
+// along with the reference datum version and provider metadata when the
+// datum has them
 type RefDataResponse struct {
-	Prices  []RefDataResponsePrice `json:"prices"`
-	Regions []string               `json:"regions"`
+	Prices   []RefDataResponsePrice `json:"prices"`
+	Regions  []string               `json:"regions"`
+	Version  uint                   `json:"version,omitempty"`
+	Metadata map[string]string      `json:"metadata,omitempty"`
 }
 
-// RefDataResponsePrice provides the price for a given duration
+// RefDataResponsePrice provides the price for a given duration, along with a
+// plan ID that stays valid if the plan list is reordered
 type RefDataResponsePrice struct {
-	Duration int `json:"duration"`
-	Price    int `json:"price"`
+	Duration int    `json:"duration"`
+	Price    int    `json:"price"`
+	PlanId   string `json:"planId"`
+}
+
+// PlansResponse provides the available plans in human-readable units and the
+// VPN regions available
+type PlansResponse struct {
+	Plans   []PlansResponsePlan `json:"plans"`
+	Regions []string            `json:"regions"`
+}
+
+// PlansResponsePlan provides a plan's duration in days and price in ADA,
+// alongside the raw values and plan ID used to build transactions
+type PlansResponsePlan struct {
+	PlanId       string  `json:"planId"`
+	DurationDays float64 `json:"durationDays"`
+	PriceAda     float64 `json:"priceAda"`
+	Duration     int     `json:"duration"`
+	Price        int     `json:"price"`
 }
 
+// RegionsResponse provides the VPN regions with their capacity and plans
+type RegionsResponse struct {
+	Regions []RegionsResponseRegion `json:"regions"`
+}
+
+// RegionsResponseRegion provides whether a region is accepting signups and
+// the plans available in it. FreeIps is only set for WireGuard, where a
+// region's IP pool limits its capacity.
+type RegionsResponseRegion struct {
+	Name      string              `json:"name"`
+	Available bool                `json:"available"`
+	FreeIps   *int                `json:"freeIps,omitempty"`
+	Plans     []PlansResponsePlan `json:"plans"`
+}
+
+const (
+	millisecondsPerDay = 24 * 60 * 60 * 1000
+	lovelacePerAda     = 1_000_000
+)
+
 // handleRefData godoc
 //
 //	@Summary		RefData
 //	@Description	Fetch prices and regions for signup or renewal
 //	@Produce		json
 //	@Accept			json
-//	@Success		200	{object}	RefDataResponse	"Prices and regions"
-//	@Failure		405	{object}	string			"Method Not Allowed"
-//	@Failure		500	{object}	string			"Server Error"
+//	@Param			If-None-Match		header		string			false	"ETag of a previous response"
+//	@Param			If-Modified-Since	header		string			false	"Last-Modified of a previous response"
+//	@Success		200					{object}	RefDataResponse	"Prices and regions"
+//	@Success		304					"Not Modified"
+//	@Failure		405					{object}	ErrorResponse	"Method Not Allowed"
+//	@Failure		500					{object}	ErrorResponse	"Server Error"
 //	@Router			/api/refdata [get]
 func (a *Api) handleRefData(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
 		return
 	}
 
-	refData, err := a.db.ReferenceData()
+	refData, err := a.db.ReferenceDataContext(r.Context())
 	if err != nil {
 		slog.Error(
 			"failed to lookup reference data in database",
 			"error",
 			err,
 		)
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	if notModified(w, r, refData.UpdatedAt) {
 		return
 	}
 
@@ -68,6 +128,7 @@ func (a *Api) handleRefData(w http.ResponseWriter, r *http.Request) {
 			RefDataResponsePrice{
 				Duration: price.Duration,
 				Price:    price.Price,
+				PlanId:   price.PlanId(),
 			},
 		)
 	}
@@ -78,7 +139,188 @@ func (a *Api) handleRefData(w http.ResponseWriter, r *http.Request) {
 			region.Name,
 		)
 	}
+	tmpResp.Version = refData.Version
+	tmpResp.Metadata = refData.Metadata
+	w.Header().Set("Content-Type", "application/json")
+	resp, _ := json.Marshal(tmpResp)
+	_, _ = w.Write(resp)
+}
+
+// notModified sets the ETag and Last-Modified headers for a resource last
+// updated at updatedAt and, when the request's conditional headers match
+// them, responds with 304 Not Modified and returns true. If-None-Match takes
+// precedence over If-Modified-Since. A zero updatedAt, such as for reference
+// data written before update times were stored, sets no headers.
+func notModified(
+	w http.ResponseWriter,
+	r *http.Request,
+	updatedAt time.Time,
+) bool {
+	if updatedAt.IsZero() {
+		return false
+	}
+	etag := `"` + strconv.FormatInt(updatedAt.UnixNano(), 16) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+
+	match := false
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				match = true
+				break
+			}
+		}
+	} else if ifModifiedSince, err := http.ParseTime(
+		r.Header.Get("If-Modified-Since"),
+	); err == nil {
+		// HTTP dates have a resolution of one second
+		match = !updatedAt.Truncate(time.Second).After(ifModifiedSince)
+	}
+	if match {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return match
+}
+
+// handlePlans godoc
+//
+//	@Summary		Plans
+//	@Description	Fetch plans with durations in days and prices in ADA, and regions
+//	@Produce		json
+//	@Success		200	{object}	PlansResponse	"Plans and regions"
+//	@Failure		405	{object}	ErrorResponse	"Method Not Allowed"
+//	@Failure		500	{object}	ErrorResponse	"Server Error"
+//	@Router			/api/plans [get]
+func (a *Api) handlePlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	refData, err := a.db.ReferenceDataContext(r.Context())
+	if err != nil {
+		slog.Error(
+			"failed to lookup reference data in database",
+			"error",
+			err,
+		)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	var tmpResp PlansResponse
+	tmpResp.Plans = newPlansResponsePlans(refData.Prices)
+	tmpResp.Regions = make([]string, 0, len(refData.Regions))
+	for _, region := range refData.Regions {
+		tmpResp.Regions = append(
+			tmpResp.Regions,
+			region.Name,
+		)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	resp, _ := json.Marshal(tmpResp)
 	_, _ = w.Write(resp)
 }
+
+// handleRegions godoc
+//
+//	@Summary		Regions
+//	@Description	Fetch regions with their availability, free WireGuard IPs, and plans
+//	@Produce		json
+//	@Success		200	{object}	RegionsResponse	"Regions"
+//	@Failure		405	{object}	ErrorResponse	"Method Not Allowed"
+//	@Failure		500	{object}	ErrorResponse	"Server Error"
+//	@Router			/api/regions [get]
+func (a *Api) handleRegions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	refData, err := a.db.ReferenceDataContext(r.Context())
+	if err != nil {
+		slog.Error(
+			"failed to lookup reference data in database",
+			"error",
+			err,
+		)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	// Plans apply to every region
+	plans := newPlansResponsePlans(refData.Prices)
+	resp := RegionsResponse{
+		Regions: make([]RegionsResponseRegion, 0, len(refData.Regions)),
+	}
+	for _, region := range refData.Regions {
+		tmpRegion := RegionsResponseRegion{
+			Name:      region.Name,
+			Available: true,
+			Plans:     plans,
+		}
+		if a.cfg.Vpn.Protocol == "wireguard" {
+			// IP pools are keyed by the normalized region stored on clients
+			free, err := a.db.CountFreeIPsContext(
+				r.Context(),
+				config.NormalizeRegion(region.Name),
+			)
+			if err != nil {
+				slog.Error(
+					"failed to count free IPs",
+					"region", region.Name,
+					"error", err,
+				)
+				writeErrorResponse(
+					w,
+					http.StatusInternalServerError,
+					ErrCodeInternal,
+					"Internal server error",
+					"",
+				)
+				return
+			}
+			tmpRegion.FreeIps = &free
+			tmpRegion.Available = free > 0
+		}
+		resp.Regions = append(resp.Regions, tmpRegion)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBytes, _ := json.Marshal(resp)
+	_, _ = w.Write(respBytes)
+}
+
+// newPlansResponsePlans converts reference data prices to plans
+func newPlansResponsePlans(
+	prices []database.ReferencePrice,
+) []PlansResponsePlan {
+	ret := make([]PlansResponsePlan, 0, len(prices))
+	for _, price := range prices {
+		ret = append(
+			ret,
+			PlansResponsePlan{
+				PlanId:       price.PlanId(),
+				DurationDays: float64(price.Duration) / millisecondsPerDay,
+				PriceAda:     float64(price.Price) / lovelacePerAda,
+				Duration:     price.Duration,
+				Price:        price.Price,
+			},
+		)
+	}
+	return ret
+}
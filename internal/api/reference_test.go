@@ -0,0 +1,378 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/ledger/shelley"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+func TestPlans(t *testing.T) {
+	a := newTestApi(t)
+	prices := []database.ReferencePrice{
+		{Duration: 2_592_000_000, Price: 5_000_000},
+		{Duration: 43_200_000, Price: 1_500_000},
+	}
+	if err := a.db.UpdateReferenceData(
+		shelley.NewShelleyTransactionInput(strings.Repeat("ab", 32), 0),
+		prices,
+		[]string{"us-east-1"},
+		0,
+		nil,
+	); err != nil {
+		t.Fatalf("failed to update reference data: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/plans", nil)
+	w := httptest.NewRecorder()
+	a.handlePlans(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf(
+			"status = %d, want %d (body: %s)",
+			w.Code,
+			http.StatusOK,
+			w.Body.String(),
+		)
+	}
+	var resp PlansResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := []PlansResponsePlan{
+		{
+			PlanId:       prices[0].PlanId(),
+			DurationDays: 30,
+			PriceAda:     5,
+			Duration:     2_592_000_000,
+			Price:        5_000_000,
+		},
+		{
+			PlanId:       prices[1].PlanId(),
+			DurationDays: 0.5,
+			PriceAda:     1.5,
+			Duration:     43_200_000,
+			Price:        1_500_000,
+		},
+	}
+	if len(resp.Plans) != len(want) {
+		t.Fatalf("got %d plans, want %d", len(resp.Plans), len(want))
+	}
+	for i, plan := range resp.Plans {
+		if plan != want[i] {
+			t.Errorf("plans[%d] = %+v, want %+v", i, plan, want[i])
+		}
+	}
+	if len(resp.Regions) != 1 || resp.Regions[0] != "us-east-1" {
+		t.Errorf("regions = %v, want [us-east-1]", resp.Regions)
+	}
+}
+
+func TestRefDataVersionAndMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      uint
+		metadata     map[string]string
+		wantBodyKeys []string
+	}{
+		{
+			name:         "without extra fields",
+			wantBodyKeys: []string{"prices", "regions"},
+		},
+		{
+			name:     "with version and metadata",
+			version:  2,
+			metadata: map[string]string{"provider": "Blink Labs"},
+			wantBodyKeys: []string{
+				"metadata",
+				"prices",
+				"regions",
+				"version",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			if err := a.db.UpdateReferenceData(
+				shelley.NewShelleyTransactionInput(strings.Repeat("ab", 32), 0),
+				[]database.ReferencePrice{{Duration: 30, Price: 5_000_000}},
+				[]string{"us-east-1"},
+				tt.version,
+				tt.metadata,
+			); err != nil {
+				t.Fatalf("failed to update reference data: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/refdata", nil)
+			w := httptest.NewRecorder()
+			a.handleRefData(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					http.StatusOK,
+					w.Body.String(),
+				)
+			}
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if keys := slices.Sorted(maps.Keys(body)); !slices.Equal(
+				keys,
+				tt.wantBodyKeys,
+			) {
+				t.Errorf("response keys = %v, want %v", keys, tt.wantBodyKeys)
+			}
+			var resp RefDataResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Version != tt.version {
+				t.Errorf("version = %d, want %d", resp.Version, tt.version)
+			}
+			if !maps.Equal(resp.Metadata, tt.metadata) {
+				t.Errorf("metadata = %v, want %v", resp.Metadata, tt.metadata)
+			}
+		})
+	}
+}
+
+func TestRefDataConditionalGet(t *testing.T) {
+	a := newTestApi(t)
+	if err := a.db.UpdateReferenceData(
+		shelley.NewShelleyTransactionInput(strings.Repeat("ab", 32), 0),
+		[]database.ReferencePrice{{Duration: 30, Price: 5_000_000}},
+		[]string{"us-east-1"},
+		0,
+		nil,
+	); err != nil {
+		t.Fatalf("failed to update reference data: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/refdata", nil)
+	w := httptest.NewRecorder()
+	a.handleRefData(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf(
+			"ETag = %q, Last-Modified = %q, want both set",
+			etag,
+			lastModified,
+		)
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		t.Fatalf("failed to parse Last-Modified: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{
+			name:       "matching etag",
+			header:     "If-None-Match",
+			value:      etag,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "matching weak etag in list",
+			header:     "If-None-Match",
+			value:      `"stale", W/` + etag,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "stale etag",
+			header:     "If-None-Match",
+			value:      `"stale"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "not modified since",
+			header:     "If-Modified-Since",
+			value:      lastModified,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "modified since",
+			header:     "If-Modified-Since",
+			value:      modified.Add(-time.Second).Format(http.TimeFormat),
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/refdata", nil)
+			req.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			a.handleRefData(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Header().Get("ETag") != etag {
+				t.Errorf("ETag = %q, want %q", w.Header().Get("ETag"), etag)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 response has a body: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRegions(t *testing.T) {
+	a := newTestApi(t)
+	prices := []database.ReferencePrice{
+		{Duration: 2_592_000_000, Price: 5_000_000},
+	}
+	if err := a.db.UpdateReferenceData(
+		shelley.NewShelleyTransactionInput(strings.Repeat("ab", 32), 0),
+		prices,
+		[]string{"US-East-1", "eu-west-1"},
+		0,
+		nil,
+	); err != nil {
+		t.Fatalf("failed to update reference data: %v", err)
+	}
+	// Use up an IP in one region's pool
+	if err := a.db.AddClient(
+		[]byte("client"),
+		time.Now().Add(time.Hour),
+		[]byte("credential"),
+		"us-east-1",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	if err := a.db.AddWGPeer(
+		[]byte("client"),
+		"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		"10.8.0.2",
+	); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	wantPlans := []PlansResponsePlan{
+		{
+			PlanId:       prices[0].PlanId(),
+			DurationDays: 30,
+			PriceAda:     5,
+			Duration:     2_592_000_000,
+			Price:        5_000_000,
+		},
+	}
+	tests := []struct {
+		name     string
+		protocol string
+		wantFree []int
+	}{
+		{name: "openvpn", protocol: "openvpn"},
+		{
+			name:     "wireguard",
+			protocol: "wireguard",
+			wantFree: []int{
+				database.WGUsableHosts - 1,
+				database.WGUsableHosts,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.cfg.Vpn.Protocol = tt.protocol
+			req := httptest.NewRequest(http.MethodGet, "/api/regions", nil)
+			w := httptest.NewRecorder()
+			a.handleRegions(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					http.StatusOK,
+					w.Body.String(),
+				)
+			}
+			var resp RegionsResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			wantNames := []string{"US-East-1", "eu-west-1"}
+			if len(resp.Regions) != len(wantNames) {
+				t.Fatalf(
+					"got %d regions, want %d",
+					len(resp.Regions),
+					len(wantNames),
+				)
+			}
+			for i, region := range resp.Regions {
+				if region.Name != wantNames[i] {
+					t.Errorf(
+						"regions[%d].name = %q, want %q",
+						i,
+						region.Name,
+						wantNames[i],
+					)
+				}
+				if !region.Available {
+					t.Errorf("regions[%d] is unavailable", i)
+				}
+				if !slices.Equal(region.Plans, wantPlans) {
+					t.Errorf(
+						"regions[%d].plans = %+v, want %+v",
+						i,
+						region.Plans,
+						wantPlans,
+					)
+				}
+				switch {
+				case tt.wantFree == nil && region.FreeIps != nil:
+					t.Errorf(
+						"regions[%d].freeIps = %d, want unset",
+						i,
+						*region.FreeIps,
+					)
+				case tt.wantFree != nil && region.FreeIps == nil:
+					t.Errorf("regions[%d].freeIps is unset", i)
+				case tt.wantFree != nil && *region.FreeIps != tt.wantFree[i]:
+					t.Errorf(
+						"regions[%d].freeIps = %d, want %d",
+						i,
+						*region.FreeIps,
+						tt.wantFree[i],
+					)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/blinklabs-io/vpn-indexer/docs"
+	httpSwagger "github.com/swaggo/http-swagger"
+)
+
+const (
+	swaggerJSONPath = "/swagger/swagger.json"
+	swaggerYAMLPath = "/swagger/swagger.yaml"
+)
+
+// registerSwagger serves the embedded OpenAPI spec and a Swagger UI that
+// loads it under /swagger/
+func registerSwagger(mux *routeMux) {
+	mux.HandleFunc(
+		swaggerJSONPath,
+		serveSpec("application/json", docs.SwaggerJSON),
+	)
+	mux.HandleFunc(
+		swaggerYAMLPath,
+		serveSpec("application/yaml", docs.SwaggerYAML),
+	)
+	mux.Handle(
+		"/swagger/",
+		httpSwagger.Handler(httpSwagger.URL(swaggerJSONPath)),
+	)
+}
+
+// serveSpec returns a handler writing a static spec document
+func serveSpec(contentType string, spec []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeMethodNotAllowed(w)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(spec)
+	}
+}
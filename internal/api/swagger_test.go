@@ -0,0 +1,76 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterSwagger(t *testing.T) {
+	mux := newRouteMux()
+	registerSwagger(mux)
+
+	tests := []struct {
+		name            string
+		path            string
+		wantContentType string
+	}{
+		{name: "JSON spec", path: swaggerJSONPath, wantContentType: "application/json"},
+		{name: "YAML spec", path: swaggerYAMLPath, wantContentType: "application/yaml"},
+		{name: "UI", path: "/swagger/index.html", wantContentType: "text/html"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.wantContentType) {
+				t.Errorf("Content-Type = %q, want %q", ct, tc.wantContentType)
+			}
+		})
+	}
+}
+
+func TestSwaggerSpecJSON(t *testing.T) {
+	mux := newRouteMux()
+	registerSwagger(mux)
+
+	req := httptest.NewRequest(http.MethodGet, swaggerJSONPath, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var spec struct {
+		Swagger string                    `json:"swagger"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	if spec.Swagger != "2.0" {
+		t.Errorf("swagger = %q, want %q", spec.Swagger, "2.0")
+	}
+	if _, ok := spec.Paths["/api/client/wg-register"]["post"]; !ok {
+		t.Error("spec is missing POST /api/client/wg-register")
+	}
+}
@@ -18,21 +18,207 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/blinklabs-io/vpn-indexer/internal/txbuilder"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Transaction types for the tx build metrics
+const (
+	txTypeSignup         = "signup"
+	txTypeSignupEstimate = "signup_estimate"
+	txTypeRenew          = "renew"
+	txTypeTransfer       = "transfer"
+)
+
+// estimateSignupTx builds a signup transaction and returns its cost
+// breakdown. It's replaced in tests.
+var estimateSignupTx = txbuilder.EstimateSignupTx
+
+// submitTx submits a signed transaction. It's replaced in tests.
+var submitTx = txbuilder.SubmitTx
+
+// checkTxSanity checks a transaction's fee and outputs before submission.
+// It's replaced in tests.
+var checkTxSanity = txbuilder.CheckTxSanity
+
+const (
+	// submittedTxTTL is how long a submitted transaction is remembered, so
+	// a repeated submission returns the earlier result instead of an error
+	// from the submit API
+	submittedTxTTL = 10 * time.Minute
+	// maxSubmittedTxs bounds the remembered transactions
+	maxSubmittedTxs = 10000
+)
+
+// submittedTx is a transaction submission that's in flight or succeeded
+type submittedTx struct {
+	// done is closed when the submission completes
+	done chan struct{}
+	// ok is whether the submission succeeded. It's set before done is
+	// closed.
+	ok bool
+	// expires is when a successful submission is forgotten. It's zero
+	// while the submission is in flight.
+	expires time.Time
+}
+
+// submittedTxCache remembers in-flight and recently successful transaction
+// submissions by hash. The zero value is ready to use and it's safe for
+// concurrent use.
+type submittedTxCache struct {
+	mu  sync.Mutex
+	txs map[string]*submittedTx
+}
+
+// claim records a transaction that's about to be submitted. When the
+// transaction is already being submitted, or was submitted successfully
+// within submittedTxTTL, that submission is returned with first false.
+// Otherwise first is true and the caller submits the transaction and passes
+// the result to complete. The check and the insert are made under one lock,
+// so concurrent submissions of a transaction only submit it once. When the
+// cache is full, the submission isn't recorded and is made anyway.
+func (c *submittedTxCache) claim(
+	txHash string,
+	now time.Time,
+) (sub *submittedTx, first bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sub, ok := c.txs[txHash]; ok &&
+		(sub.expires.IsZero() || now.Before(sub.expires)) {
+		return sub, false
+	}
+	sub = &submittedTx{done: make(chan struct{})}
+	if c.txs == nil {
+		c.txs = make(map[string]*submittedTx)
+	}
+	if len(c.txs) >= maxSubmittedTxs {
+		maps.DeleteFunc(c.txs, func(_ string, sub *submittedTx) bool {
+			return !sub.expires.IsZero() && !now.Before(sub.expires)
+		})
+		if len(c.txs) >= maxSubmittedTxs {
+			return sub, true
+		}
+	}
+	c.txs[txHash] = sub
+	return sub, true
+}
+
+// complete records the result of a claimed submission and wakes any
+// duplicates waiting on it. A failed submission is forgotten, so the
+// transaction can be submitted again.
+func (c *submittedTxCache) complete(
+	txHash string,
+	sub *submittedTx,
+	ok bool,
+	now time.Time,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub.ok = ok
+	if ok {
+		sub.expires = now.Add(submittedTxTTL)
+	} else if c.txs[txHash] == sub {
+		delete(c.txs, txHash)
+	}
+	close(sub.done)
+}
+
+var (
+	metricTxBuild = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tx_build_total",
+			Help: "Number of transaction builds by type and result",
+		},
+		[]string{"type", "result"},
+	)
+	metricTxBuildDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "tx_build_duration_seconds",
+			Help:    "Time taken to build a transaction by type",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"type"},
+	)
+)
+
+// observeTxBuild records the result and duration of a transaction build.
+// Input validation failures and unavailable chain backends are counted
+// separately from other errors.
+func observeTxBuild(txType string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+		var validationErr txbuilder.InputValidationError
+		if errors.As(err, &validationErr) {
+			result = "validation_error"
+		} else if errors.Is(err, txbuilder.ErrKupoUnavailable) ||
+			errors.Is(err, txbuilder.ErrBackendUnavailable) {
+			result = "unavailable"
+		}
+	}
+	metricTxBuildDuration.WithLabelValues(txType).
+		Observe(time.Since(start).Seconds())
+	metricTxBuild.WithLabelValues(txType, result).Inc()
+}
+
+// writeTxBuildError writes the response for a failed transaction build.
+// Invalid input is a bad request, and unavailable chain backends are
+// reported as temporary so clients can retry.
+func writeTxBuildError(w http.ResponseWriter, err error) {
+	var validationErr txbuilder.InputValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			addressErrorCode(validationErr),
+			"Invalid request",
+			validationErr.Error(),
+		)
+	case errors.Is(err, txbuilder.ErrKupoUnavailable):
+		writeErrorResponse(
+			w,
+			http.StatusServiceUnavailable,
+			ErrCodeUnavailable,
+			"Service unavailable",
+			"wallet UTxOs can't be looked up right now, try again later",
+		)
+	case errors.Is(err, txbuilder.ErrBackendUnavailable):
+		writeErrorResponse(
+			w,
+			http.StatusServiceUnavailable,
+			ErrCodeUnavailable,
+			"Service unavailable",
+			"chain backends are unavailable, try again later",
+		)
+	default:
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+	}
+}
+
 // TxSignupRequest provides the client address, plan price and duration, and region for the VPN signup
 type TxSignupRequest struct {
 	PaymentAddress string `json:"paymentAddress"`
 	OwnerAddress   string `json:"ownerAddress"`
 	Price          int    `json:"price"`
 	Duration       int    `json:"duration"`
-	Region         string `json:"region"`
+	// PlanId selects the plan instead of price and duration when provided
+	PlanId string `json:"planId,omitempty"`
+	Region string `json:"region"`
 }
 
 // TxSignupResponse returns an unsigned transaction for a VPN signup
@@ -49,49 +235,52 @@ type TxSignupResponse struct {
 //	@Accept			json
 //	@Param			TxSignupRequest	body		TxSignupRequest		true	"Signup Request"
 //	@Success		200				{object}	TxSignupResponse	"Built transaction"
-//	@Failure		400				{object}	string				"Bad Request"
-//	@Failure		405				{object}	string				"Method Not Allowed"
-//	@Failure		500				{object}	string				"Server Error"
+//	@Failure		400				{object}	ErrorResponse		"Bad Request"
+//	@Failure		405				{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415				{object}	ErrorResponse		"Unsupported Media Type"
+//	@Failure		500				{object}	ErrorResponse		"Server Error"
+//	@Failure		503				{object}	ErrorResponse		"Chain Backends Unavailable"
 //	@Router			/api/tx/signup [post]
 func (a *Api) handleTxSignup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
 		return
 	}
 
 	var req TxSignupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(`{"error":"Invalid request"}`))
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
 		return
 	}
 
+	start := time.Now()
 	txCbor, clientId, err := txbuilder.BuildSignupTx(
 		txbuilder.SignupDeps{DB: a.db},
 		req.PaymentAddress,
 		req.OwnerAddress,
 		req.Price,
 		req.Duration,
+		req.PlanId,
 		req.Region,
 	)
+	observeTxBuild(txTypeSignup, start, err)
 	if err != nil {
 		slog.Error(
 			"failed to build signup TX",
 			"error",
 			err,
 		)
-		var validationErr txbuilder.InputValidationError
-		if errors.As(err, &validationErr) {
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = fmt.Fprintf(
-				w,
-				`{"error":"Invalid request: %s"}`,
-				validationErr,
-			)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
-		}
+		writeTxBuildError(w, err)
 		return
 	}
 
@@ -104,6 +293,82 @@ func (a *Api) handleTxSignup(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(resp)
 }
 
+// TxEstimateResponse returns the cost breakdown of a VPN signup in lovelace
+type TxEstimateResponse struct {
+	ServicePrice int64 `json:"servicePrice"`
+	Fee          int64 `json:"fee"`
+	MinAda       int64 `json:"minAda"`
+	Total        int64 `json:"total"`
+}
+
+// handleTxEstimate godoc
+//
+//	@Summary		TxEstimate
+//	@Description	Estimate the cost of a VPN signup without returning the transaction
+//	@Produce		json
+//	@Accept			json
+//	@Param			TxSignupRequest	body		TxSignupRequest		true	"Signup Request"
+//	@Success		200				{object}	TxEstimateResponse	"Cost breakdown"
+//	@Failure		400				{object}	ErrorResponse		"Bad Request"
+//	@Failure		405				{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415				{object}	ErrorResponse		"Unsupported Media Type"
+//	@Failure		500				{object}	ErrorResponse		"Server Error"
+//	@Failure		503				{object}	ErrorResponse		"Chain Backends Unavailable"
+//	@Router			/api/tx/estimate [post]
+func (a *Api) handleTxEstimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req TxSignupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
+		return
+	}
+
+	start := time.Now()
+	estimate, err := estimateSignupTx(
+		txbuilder.SignupDeps{DB: a.db},
+		req.PaymentAddress,
+		req.OwnerAddress,
+		req.Price,
+		req.Duration,
+		req.PlanId,
+		req.Region,
+	)
+	observeTxBuild(txTypeSignupEstimate, start, err)
+	if err != nil {
+		slog.Error(
+			"failed to estimate signup TX",
+			"error",
+			err,
+		)
+		writeTxBuildError(w, err)
+		return
+	}
+
+	tmpResp := TxEstimateResponse{
+		ServicePrice: estimate.ServicePrice,
+		Fee:          estimate.Fee,
+		MinAda:       estimate.MinAda,
+		Total:        estimate.Total,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	resp, _ := json.Marshal(tmpResp)
+	_, _ = w.Write(resp)
+}
+
 // TxRenewRequest provides the existing client ID, plan price and duration, and region for the VPN renewal
 type TxRenewRequest struct {
 	PaymentAddress string `json:"paymentAddress"`
@@ -111,6 +376,8 @@ type TxRenewRequest struct {
 	ClientId       string `json:"clientId"`
 	Price          int    `json:"price"`
 	Duration       int    `json:"duration"`
+	// PlanId selects the plan instead of price and duration when provided
+	PlanId string `json:"planId,omitempty"`
 }
 
 // TxRenewResponse returns an unsigned transaction for a VPN renewal
@@ -126,23 +393,35 @@ type TxRenewResponse struct {
 //	@Accept			json
 //	@Param			TxRenewRequest	body		TxRenewRequest	true	"Renewal Request"
 //	@Success		200				{object}	TxRenewResponse	"Built transaction"
-//	@Failure		400				{object}	string			"Bad Request"
-//	@Failure		405				{object}	string			"Method Not Allowed"
-//	@Failure		500				{object}	string			"Server Error"
+//	@Failure		400				{object}	ErrorResponse	"Bad Request"
+//	@Failure		405				{object}	ErrorResponse	"Method Not Allowed"
+//	@Failure		415				{object}	ErrorResponse	"Unsupported Media Type"
+//	@Failure		500				{object}	ErrorResponse	"Server Error"
+//	@Failure		503				{object}	ErrorResponse	"Chain Backends Unavailable"
 //	@Router			/api/tx/renew [post]
 func (a *Api) handleTxRenew(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
 		return
 	}
 
 	var req TxRenewRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(`{"error":"Invalid request"}`))
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
 		return
 	}
 
+	start := time.Now()
 	txCbor, err := txbuilder.BuildRenewTransferTx(
 		txbuilder.RenewDeps{DB: a.db},
 		req.PaymentAddress,
@@ -150,25 +429,16 @@ func (a *Api) handleTxRenew(w http.ResponseWriter, r *http.Request) {
 		req.ClientId,
 		req.Price,
 		req.Duration,
+		req.PlanId,
 	)
+	observeTxBuild(txTypeRenew, start, err)
 	if err != nil {
 		slog.Error(
 			"failed to build renewal TX",
 			"error",
 			err,
 		)
-		var validationErr txbuilder.InputValidationError
-		if errors.As(err, &validationErr) {
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = fmt.Fprintf(
-				w,
-				`{"error":"Invalid request: %s"}`,
-				validationErr,
-			)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
-		}
+		writeTxBuildError(w, err)
 		return
 	}
 
@@ -200,23 +470,35 @@ type TxTransferResponse struct {
 //	@Accept			json
 //	@Param			TxTransferRequest	body		TxTransferRequest	true	"Transfer Request"
 //	@Success		200					{object}	TxTransferResponse	"Built transaction"
-//	@Failure		400					{object}	string				"Bad Request"
-//	@Failure		405					{object}	string				"Method Not Allowed"
-//	@Failure		500					{object}	string				"Server Error"
+//	@Failure		400					{object}	ErrorResponse		"Bad Request"
+//	@Failure		405					{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415					{object}	ErrorResponse		"Unsupported Media Type"
+//	@Failure		500					{object}	ErrorResponse		"Server Error"
+//	@Failure		503					{object}	ErrorResponse		"Chain Backends Unavailable"
 //	@Router			/api/tx/transfer [post]
 func (a *Api) handleTxTransfer(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
 		return
 	}
 
 	var req TxTransferRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(`{"error":"Invalid request"}`))
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
 		return
 	}
 
+	start := time.Now()
 	txCbor, err := txbuilder.BuildRenewTransferTx(
 		txbuilder.RenewDeps{DB: a.db},
 		req.PaymentAddress,
@@ -224,25 +506,16 @@ func (a *Api) handleTxTransfer(w http.ResponseWriter, r *http.Request) {
 		req.ClientId,
 		0,
 		0,
+		"",
 	)
+	observeTxBuild(txTypeTransfer, start, err)
 	if err != nil {
 		slog.Error(
 			"failed to build transfer TX",
 			"error",
 			err,
 		)
-		var validationErr txbuilder.InputValidationError
-		if errors.As(err, &validationErr) {
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = fmt.Fprintf(
-				w,
-				`{"error":"Invalid request: %s"}`,
-				validationErr,
-			)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(`{"error":"Internal server error"}`))
-		}
+		writeTxBuildError(w, err)
 		return
 	}
 
@@ -254,44 +527,149 @@ func (a *Api) handleTxTransfer(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(resp)
 }
 
+// TxSubmitResponse is the response body for POST /api/tx/submit
+type TxSubmitResponse struct {
+	TxHash string `json:"txHash"`
+}
+
 // handleTxSubmit godoc
 //
 //	@Summary		TxSubmit
 //	@Description	Submit a signed transaction to the blockchain
 //	@Produce		json
 //	@Accept			application/cbor
-//	@Param			Content-Type	header		string	true	"Content type"	Enums(application/cbor)
-//	@Success		200				{object}	string	"Ok"
-//	@Failure		400				{object}	string	"Bad Request"
-//	@Failure		405				{object}	string	"Method Not Allowed"
-//	@Failure		415				{object}	string	"Unsupported Media Type"
-//	@Failure		500				{object}	string	"Server Error"
+//	@Param			Content-Type	header		string				true	"Content type"	Enums(application/cbor)
+//	@Success		200				{object}	TxSubmitResponse	"Ok"
+//	@Failure		400				{object}	ErrorResponse		"Invalid or rejected transaction"
+//	@Failure		405				{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		413				{object}	ErrorResponse		"Request Entity Too Large"
+//	@Failure		415				{object}	ErrorResponse		"Unsupported Media Type"
+//	@Failure		500				{object}	ErrorResponse		"Server Error"
+//	@Failure		502				{object}	ErrorResponse		"Submit API unavailable"
 //	@Router			/api/tx/submit [post]
 func (a *Api) handleTxSubmit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
 		return
 	}
 
 	if r.Header.Get("Content-Type") != "application/cbor" {
-		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		writeErrorResponse(
+			w,
+			http.StatusUnsupportedMediaType,
+			ErrCodeUnsupportedMediaType,
+			"Unsupported Media Type",
+			"Content-Type must be application/cbor",
+		)
 		return
 	}
 
 	// Read raw transaction bytes from the request body and store in a byte array
 	txRawBytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Server Error", http.StatusInternalServerError)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
 		return
 	}
 	defer r.Body.Close() //nolint:errcheck
 
-	txHash, err := txbuilder.SubmitTx(txRawBytes)
+	// The transaction is decoded before it's submitted, so data that isn't a
+	// transaction gets a clear error instead of one from the submit API
+	tx, err := txbuilder.DecodeTx(txRawBytes)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("%s", err), http.StatusBadRequest)
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidTransaction,
+			"Invalid transaction",
+			err.Error(),
+		)
 		return
 	}
+	txHash := tx.Hash().String()
+
+	// A transaction submitted again, such as by a client retrying after a
+	// lost response, gets the earlier success instead of being resubmitted.
+	// A duplicate of a submission in flight waits for its result, and makes
+	// its own submission if that one fails.
+	sub, first := a.submittedTxs.claim(txHash, time.Now())
+	for !first {
+		select {
+		case <-sub.done:
+		case <-r.Context().Done():
+			return
+		}
+		if sub.ok {
+			slog.Info(
+				"ignoring duplicate transaction submission",
+				"txHash", txHash,
+			)
+			w.Header().Set("Content-Type", "application/json")
+			resp, _ := json.Marshal(TxSubmitResponse{TxHash: txHash})
+			_, _ = w.Write(resp)
+			return
+		}
+		sub, first = a.submittedTxs.claim(txHash, time.Now())
+	}
+
+	if a.cfg.TxBuilder.SanityCheck {
+		err := checkTxSanity(tx)
+		var validationErr txbuilder.InputValidationError
+		if errors.As(err, &validationErr) {
+			a.submittedTxs.complete(txHash, sub, false, time.Now())
+			writeErrorResponse(
+				w,
+				http.StatusBadRequest,
+				ErrCodeInvalidTransaction,
+				"Invalid transaction",
+				validationErr.Error(),
+			)
+			return
+		}
+		// The submit API validates the transaction anyway, so it's still
+		// submitted when the check can't be made
+		if err != nil {
+			slog.Warn("failed to check transaction", "error", err)
+		}
+	}
+
+	submittedHash, err := submitTx(txRawBytes)
+	a.submittedTxs.complete(txHash, sub, err == nil, time.Now())
+	if err != nil {
+		var rejectedErr txbuilder.TxRejectedError
+		if errors.As(err, &rejectedErr) {
+			writeErrorResponse(
+				w,
+				http.StatusBadRequest,
+				ErrCodeTransactionRejected,
+				"Transaction rejected",
+				rejectedErr.Reason,
+			)
+			return
+		}
+		slog.Error("failed to submit transaction", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusBadGateway,
+			ErrCodeUnavailable,
+			"Submit API unavailable",
+			"",
+		)
+		return
+	}
+	if submittedHash != txHash {
+		slog.Warn(
+			"submit API returned an unexpected transaction hash",
+			"txHash", txHash,
+			"submittedHash", submittedHash,
+		)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	resp, _ := json.Marshal(txHash)
+	resp, _ := json.Marshal(TxSubmitResponse{TxHash: txHash})
 	_, _ = w.Write(resp)
 }
@@ -0,0 +1,627 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/ledger"
+	"github.com/blinklabs-io/vpn-indexer/internal/txbuilder"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// txBuildMetric returns the current tx_build_total counter value for a type
+// and result
+func txBuildMetric(t *testing.T, txType, result string) int {
+	t.Helper()
+	var m dto.Metric
+	if err := metricTxBuild.WithLabelValues(txType, result).Write(&m); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	return int(m.GetCounter().GetValue())
+}
+
+// txBuildDurationCount returns the number of tx_build_duration_seconds
+// observations for a type
+func txBuildDurationCount(t *testing.T, txType string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	histogram := metricTxBuildDuration.WithLabelValues(txType)
+	if err := histogram.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestTxBuildMetrics(t *testing.T) {
+	a := newTestApi(t)
+
+	// Each request fails input validation before any backend is contacted
+	tests := []struct {
+		name    string
+		path    string
+		txType  string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{
+			name:    "signup without region",
+			path:    "/api/tx/signup",
+			txType:  txTypeSignup,
+			handler: a.handleTxSignup,
+			body:    `{"paymentAddress":"addr_test1","price":1,"duration":1}`,
+		},
+		{
+			name:    "renew without payment address",
+			path:    "/api/tx/renew",
+			txType:  txTypeRenew,
+			handler: a.handleTxRenew,
+			body:    `{"clientId":"00"}`,
+		},
+		{
+			name:    "renew with invalid client ID",
+			path:    "/api/tx/renew",
+			txType:  txTypeRenew,
+			handler: a.handleTxRenew,
+			body: `{"paymentAddress":"addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5",` +
+				`"clientId":"not-hex"}`,
+		},
+		{
+			name:    "transfer without payment address",
+			path:    "/api/tx/transfer",
+			txType:  txTypeTransfer,
+			handler: a.handleTxTransfer,
+			body:    `{"clientId":"00"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validationBefore := txBuildMetric(t, tt.txType, "validation_error")
+			errorBefore := txBuildMetric(t, tt.txType, "error")
+			durationBefore := txBuildDurationCount(t, tt.txType)
+
+			req := httptest.NewRequest(
+				http.MethodPost,
+				tt.path,
+				strings.NewReader(tt.body),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					http.StatusBadRequest,
+					w.Body.String(),
+				)
+			}
+			got := txBuildMetric(t, tt.txType, "validation_error")
+			if got != validationBefore+1 {
+				t.Errorf(
+					"validation_error count = %d, want %d",
+					got,
+					validationBefore+1,
+				)
+			}
+			errorCount := txBuildMetric(t, tt.txType, "error")
+			if errorCount != errorBefore {
+				t.Errorf("error count = %d, want %d", errorCount, errorBefore)
+			}
+			count := txBuildDurationCount(t, tt.txType)
+			if count != durationBefore+1 {
+				t.Errorf(
+					"duration observations = %d, want %d",
+					count,
+					durationBefore+1,
+				)
+			}
+		})
+	}
+}
+
+func TestTxEstimate(t *testing.T) {
+	a := newTestApi(t)
+
+	origEstimate := estimateSignupTx
+	t.Cleanup(func() { estimateSignupTx = origEstimate })
+	var gotRegion string
+	estimateSignupTx = func(
+		_ txbuilder.SignupDeps,
+		_ string,
+		_ string,
+		price int,
+		_ int,
+		_ string,
+		region string,
+	) (txbuilder.SignupEstimate, error) {
+		gotRegion = region
+		if region == "" {
+			return txbuilder.SignupEstimate{}, txbuilder.NewInputValidationError(
+				"empty region provided",
+			)
+		}
+		if region == "kupo-down" {
+			return txbuilder.SignupEstimate{}, fmt.Errorf(
+				"lookup UTxOs for address: %w: connection refused",
+				txbuilder.ErrKupoUnavailable,
+			)
+		}
+		if region == "breaker-open" {
+			return txbuilder.SignupEstimate{}, fmt.Errorf(
+				"query latest block slot: %w",
+				txbuilder.ErrBackendUnavailable,
+			)
+		}
+		estimate := txbuilder.SignupEstimate{
+			ServicePrice: int64(price),
+			Fee:          210_000,
+			MinAda:       1_500_000,
+		}
+		estimate.Total = estimate.ServicePrice + estimate.Fee + estimate.MinAda
+		return estimate, nil
+	}
+
+	t.Run("breakdown", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/api/tx/estimate",
+			strings.NewReader(
+				`{"paymentAddress":"addr_test1","price":10000000,"duration":1,"region":"us-east-1"}`,
+			),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		a.handleTxEstimate(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf(
+				"status = %d, want %d (body: %s)",
+				w.Code,
+				http.StatusOK,
+				w.Body.String(),
+			)
+		}
+		if gotRegion != "us-east-1" {
+			t.Errorf("region = %q, want %q", gotRegion, "us-east-1")
+		}
+		var resp TxEstimateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.ServicePrice != 10_000_000 {
+			t.Errorf("servicePrice = %d, want %d", resp.ServicePrice, 10_000_000)
+		}
+		if resp.Total != resp.ServicePrice+resp.Fee+resp.MinAda {
+			t.Errorf(
+				"total = %d, want %d + %d + %d",
+				resp.Total,
+				resp.ServicePrice,
+				resp.Fee,
+				resp.MinAda,
+			)
+		}
+	})
+
+	t.Run("validation error", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/api/tx/estimate",
+			strings.NewReader(`{"paymentAddress":"addr_test1","price":1}`),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		a.handleTxEstimate(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	for _, region := range []string{"kupo-down", "breaker-open"} {
+		t.Run(region, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/tx/estimate",
+				strings.NewReader(
+					`{"paymentAddress":"addr_test1","price":1,"duration":1,"region":"`+region+`"}`,
+				),
+			)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			a.handleTxEstimate(w, req)
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf(
+					"status = %d, want %d",
+					w.Code,
+					http.StatusServiceUnavailable,
+				)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if !strings.Contains(resp.Reason, "try again later") {
+				t.Errorf("reason = %q, want a retry message", resp.Reason)
+			}
+		})
+	}
+}
+
+// newTestSignedTx returns the CBOR of a minimal signed transaction and its
+// hash
+func newTestSignedTx(t *testing.T, fee uint64) ([]byte, string) {
+	t.Helper()
+	txCbor, err := cbor.Encode([]any{
+		map[uint]any{2: fee},
+		map[uint]any{},
+		true,
+		nil,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode tx: %v", err)
+	}
+	txHash, err := txbuilder.TxHash(txCbor)
+	if err != nil {
+		t.Fatalf("TxHash: %v", err)
+	}
+	return txCbor, txHash
+}
+
+func TestTxSubmit(t *testing.T) {
+	origSubmit := submitTx
+	t.Cleanup(func() { submitTx = origSubmit })
+	txCbor, txHash := newTestSignedTx(t, 200_000)
+	notTxCbor, err := cbor.Encode(map[string]int{"fee": 200_000})
+	if err != nil {
+		t.Fatalf("failed to encode CBOR: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       []byte
+		submitErr  error
+		wantStatus int
+		wantError  string
+		wantReason string
+	}{
+		{name: "accepted", body: txCbor, wantStatus: http.StatusOK},
+		{
+			name: "rejected",
+			body: txCbor,
+			submitErr: txbuilder.TxRejectedError{
+				StatusCode: http.StatusBadRequest,
+				Reason:     "bad inputs",
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Transaction rejected",
+		},
+		{
+			name:       "submit API unreachable",
+			body:       txCbor,
+			submitErr:  errors.New("connection refused"),
+			wantStatus: http.StatusBadGateway,
+			wantError:  "Submit API unavailable",
+		},
+		{
+			name:       "malformed",
+			body:       []byte("signed tx"),
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid transaction",
+		},
+		{
+			name:       "not a transaction",
+			body:       notTxCbor,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid transaction",
+			wantReason: "not a transaction",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			submitTx = func(txRawBytes []byte) (string, error) {
+				if string(txRawBytes) != string(tt.body) {
+					t.Errorf("submitted tx = %x, want %x", txRawBytes, tt.body)
+				}
+				if tt.submitErr != nil {
+					return "", tt.submitErr
+				}
+				return txHash, nil
+			}
+			req := httptest.NewRequest(
+				http.MethodPost,
+				txSubmitPath,
+				bytes.NewReader(tt.body),
+			)
+			req.Header.Set("Content-Type", "application/cbor")
+			w := httptest.NewRecorder()
+			a.handleTxSubmit(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantError != "" {
+				var resp ErrorResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if resp.Error != tt.wantError {
+					t.Errorf("error = %q, want %q", resp.Error, tt.wantError)
+				}
+				if !strings.Contains(resp.Reason, tt.wantReason) {
+					t.Errorf(
+						"reason = %q, want it to contain %q",
+						resp.Reason,
+						tt.wantReason,
+					)
+				}
+				return
+			}
+			var resp TxSubmitResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.TxHash != txHash {
+				t.Errorf("txHash = %q, want %q", resp.TxHash, txHash)
+			}
+		})
+	}
+}
+
+func TestTxSubmitDuplicate(t *testing.T) {
+	a := newTestApi(t)
+	origSubmit := submitTx
+	t.Cleanup(func() { submitTx = origSubmit })
+	txCbor, txHash := newTestSignedTx(t, 200_000)
+	otherCbor, otherHash := newTestSignedTx(t, 300_000)
+
+	// The submit API rejects transactions it has already seen
+	submitted := make(map[string]int)
+	submitTx = func(txRawBytes []byte) (string, error) {
+		hash, err := txbuilder.TxHash(txRawBytes)
+		if err != nil {
+			t.Fatalf("TxHash: %v", err)
+		}
+		submitted[hash]++
+		if submitted[hash] > 1 {
+			return "", txbuilder.TxRejectedError{
+				StatusCode: http.StatusBadRequest,
+				Reason:     "inputs already spent",
+			}
+		}
+		return hash, nil
+	}
+	submit := func(body []byte) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(
+			http.MethodPost,
+			txSubmitPath,
+			bytes.NewReader(body),
+		)
+		req.Header.Set("Content-Type", "application/cbor")
+		w := httptest.NewRecorder()
+		a.handleTxSubmit(w, req)
+		var resp TxSubmitResponse
+		_ = json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.TxHash
+	}
+
+	for i := range 2 {
+		code, hash := submit(txCbor)
+		if code != http.StatusOK || hash != txHash {
+			t.Errorf(
+				"submission %d = %d, %q, want 200, %q",
+				i+1,
+				code,
+				hash,
+				txHash,
+			)
+		}
+	}
+	if submitted[txHash] != 1 {
+		t.Errorf("tx submitted %d times, want 1", submitted[txHash])
+	}
+
+	// Other transactions are still submitted
+	if code, hash := submit(otherCbor); code != http.StatusOK ||
+		hash != otherHash {
+		t.Errorf("other tx = %d, %q, want 200, %q", code, hash, otherHash)
+	}
+
+	// Once forgotten, the transaction is submitted again
+	a.submittedTxs.txs[txHash].expires = time.Now()
+	if code, _ := submit(txCbor); code != http.StatusBadRequest {
+		t.Errorf("expired duplicate status = %d, want 400", code)
+	}
+}
+
+func TestTxSubmitConcurrentDuplicate(t *testing.T) {
+	a := newTestApi(t)
+	origSubmit := submitTx
+	t.Cleanup(func() { submitTx = origSubmit })
+	txCbor, txHash := newTestSignedTx(t, 200_000)
+
+	// The first submission is held until the duplicates have started, then
+	// fails
+	var submitted atomic.Int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	submitTx = func(txRawBytes []byte) (string, error) {
+		if submitted.Add(1) == 1 {
+			close(started)
+			<-unblock
+			return "", errors.New("connection refused")
+		}
+		return txbuilder.TxHash(txRawBytes)
+	}
+	submit := func() int {
+		req := httptest.NewRequest(
+			http.MethodPost,
+			txSubmitPath,
+			bytes.NewReader(txCbor),
+		)
+		req.Header.Set("Content-Type", "application/cbor")
+		w := httptest.NewRecorder()
+		a.handleTxSubmit(w, req)
+		return w.Code
+	}
+
+	firstCode := make(chan int, 1)
+	go func() { firstCode <- submit() }()
+	<-started
+
+	const duplicates = 10
+	var wg sync.WaitGroup
+	codes := make(chan int, duplicates)
+	for range duplicates {
+		wg.Go(func() { codes <- submit() })
+	}
+	// Duplicates wait for the submission in flight instead of reporting
+	// its success early
+	time.Sleep(50 * time.Millisecond)
+	if len(codes) != 0 {
+		t.Fatal("duplicate returned before the submission in flight")
+	}
+
+	// When it fails, the duplicates submit the transaction once between them
+	close(unblock)
+	if code := <-firstCode; code != http.StatusBadGateway {
+		t.Errorf("failed submission status = %d, want 502", code)
+	}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("duplicate status = %d, want 200", code)
+		}
+	}
+	if got := submitted.Load(); got != 2 {
+		t.Errorf("tx submitted %d times, want 2", got)
+	}
+	if _, first := a.submittedTxs.claim(txHash, time.Now()); first {
+		t.Error("submitted tx wasn't recorded")
+	}
+}
+
+func TestTxSubmitSanityCheck(t *testing.T) {
+	origSubmit, origCheck := submitTx, checkTxSanity
+	t.Cleanup(func() {
+		submitTx = origSubmit
+		checkTxSanity = origCheck
+	})
+	txCbor, txHash := newTestSignedTx(t, 100)
+
+	tests := []struct {
+		name          string
+		enabled       bool
+		checkErr      error
+		wantStatus    int
+		wantChecked   bool
+		wantSubmitted bool
+	}{
+		{
+			name:          "disabled",
+			checkErr:      txbuilder.NewInputValidationError("fee too low"),
+			wantStatus:    http.StatusOK,
+			wantSubmitted: true,
+		},
+		{
+			name:          "passed",
+			enabled:       true,
+			wantStatus:    http.StatusOK,
+			wantChecked:   true,
+			wantSubmitted: true,
+		},
+		{
+			name:        "under fee",
+			enabled:     true,
+			checkErr:    txbuilder.NewInputValidationError("fee too low"),
+			wantStatus:  http.StatusBadRequest,
+			wantChecked: true,
+		},
+		{
+			// The check fails open when protocol params are unavailable
+			name:          "check unavailable",
+			enabled:       true,
+			checkErr:      errors.New("ogmios unavailable"),
+			wantStatus:    http.StatusOK,
+			wantChecked:   true,
+			wantSubmitted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.TxBuilder.SanityCheck = tt.enabled
+			var checked, submitted bool
+			checkTxSanity = func(tx ledger.Transaction) error {
+				checked = true
+				if tx.Hash().String() != txHash {
+					t.Errorf("checked tx %s, want %s", tx.Hash(), txHash)
+				}
+				return tt.checkErr
+			}
+			submitTx = func([]byte) (string, error) {
+				submitted = true
+				return txHash, nil
+			}
+
+			req := httptest.NewRequest(
+				http.MethodPost,
+				txSubmitPath,
+				bytes.NewReader(txCbor),
+			)
+			req.Header.Set("Content-Type", "application/cbor")
+			w := httptest.NewRecorder()
+			a.handleTxSubmit(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if checked != tt.wantChecked {
+				t.Errorf("checked = %v, want %v", checked, tt.wantChecked)
+			}
+			if submitted != tt.wantSubmitted {
+				t.Errorf("submitted = %v, want %v", submitted, tt.wantSubmitted)
+			}
+		})
+	}
+}
@@ -22,11 +22,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
 	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/blinklabs-io/vpn-indexer/internal/requestid"
 	"github.com/blinklabs-io/vpn-indexer/internal/wireguard"
 )
 
@@ -58,22 +64,87 @@ const (
 	// This should point to the VPN server's internal DNS resolver.
 	DefaultDNS = "10.8.0.1"
 
+	// DefaultAllowedIPs routes all IPv4 traffic through the tunnel when no
+	// split-tunnel AllowedIPs are configured.
+	DefaultAllowedIPs = "0.0.0.0/0"
+
+	// WGInfoCacheTTL is how long server info from the WireGuard container is
+	// reused before being fetched again.
+	WGInfoCacheTTL = 30 * time.Second
+
+	// AddPeerRetryAttempts is how many times a failed AddPeer during
+	// registration is retried in the background before leaving the peer for
+	// the startup sync.
+	AddPeerRetryAttempts = 3
+
+	// AddPeerRetryInterval is the delay before the first background AddPeer
+	// retry. It doubles after each failed attempt.
+	AddPeerRetryInterval = 5 * time.Second
+
+	// CacheWriteRetryAttempts is how many times a failed database write of
+	// a newly registered peer is retried within the request when
+	// WGVerifyCacheWrite is enabled.
+	CacheWriteRetryAttempts = 3
+
+	// CacheWriteRetryInterval is the delay between those retries.
+	CacheWriteRetryInterval = 100 * time.Millisecond
+
 	// RequestTimeout is the maximum time for API request processing.
 	// This bounds the total time for all operations in a handler.
 	RequestTimeout = 45 * time.Second
 )
 
+// PeerStore persists the WireGuard peer files that are the source of truth
+// for registered devices. It's implemented by *client.Client on S3.
+type PeerStore interface {
+	SavePeerToS3WithContext(
+		ctx context.Context,
+		assetName []byte,
+		pubkey, assignedIP string,
+	) error
+	RemovePeerFromS3WithContext(
+		ctx context.Context,
+		assetName []byte,
+		pubkey string,
+	) error
+	RotatePeerInS3WithContext(
+		ctx context.Context,
+		assetName []byte,
+		oldPubkey, newPubkey string,
+	) error
+	// LoadPeersFromS3 returns nil and no error when the client has no peer
+	// file, such as before its first registration
+	LoadPeersFromS3(assetName []byte) (*client.PeerFile, error)
+}
+
+// PeerManager adds and removes peers in the WireGuard container. It's
+// implemented by *wireguard.Client.
+type PeerManager interface {
+	AddPeerWithContext(
+		ctx context.Context,
+		pubkey, allowedIP string,
+	) (*wireguard.AddPeerResponse, error)
+	RemovePeerWithContext(ctx context.Context, pubkey, allowedIP string) error
+}
+
+// addWGPeer adds a registered peer to the database cache. It's replaced in
+// tests.
+var addWGPeer = (*database.Database).AddWGPeer
+
 // WGPubkeyDecodedLength is the byte length of a decoded Curve25519 public key.
 const WGPubkeyDecodedLength = 32
 
 // isValidWGPubkey validates a WireGuard public key format
-// It checks: valid base64 encoding and decodes to exactly 32 bytes (Curve25519)
+// It checks: valid canonical base64 encoding and decodes to exactly 32 bytes
+// (Curve25519)
 func isValidWGPubkey(pubkey string) bool {
 	if len(pubkey) != WGPubkeyLength {
 		return false
 	}
-	// Decode base64 and verify it yields exactly 32 bytes
-	decoded, err := base64.StdEncoding.DecodeString(pubkey)
+	// Decode base64 and verify it yields exactly 32 bytes. Strict decoding
+	// rejects non-canonical encodings with nonzero trailing bits, which would
+	// otherwise let several strings map to the same key.
+	decoded, err := base64.StdEncoding.Strict().DecodeString(pubkey)
 	if err != nil {
 		return false
 	}
@@ -110,10 +181,43 @@ DNS = %s
 [Peer]
 PublicKey = %s
 Endpoint = %s
-AllowedIPs = 0.0.0.0/0
-PersistentKeepalive = 25
+AllowedIPs = %s
+PersistentKeepalive = %d
 `
 
+// wgDNSServers returns the DNS resolvers for WireGuard profiles, falling back
+// to the shared VPN DNS setting and then DefaultDNS
+func wgDNSServers(vpn *config.VpnConfig) []string {
+	if len(vpn.WGDNS) > 0 {
+		return slices.Clone(vpn.WGDNS)
+	}
+	if vpn.DNS != "" {
+		return []string{vpn.DNS}
+	}
+	return []string{DefaultDNS}
+}
+
+// renderWGConfig fills in the WireGuard config template for a peer using the
+// configured server details
+func renderWGConfig(vpn *config.VpnConfig, assignedIP string) string {
+	dns := wgDNSServers(vpn)
+	// wg-quick accepts search domains alongside resolvers on the DNS line
+	dns = append(dns, vpn.WGDNSSearch...)
+	allowedIPs := DefaultAllowedIPs
+	if len(vpn.WGAllowedIPs) > 0 {
+		allowedIPs = strings.Join(vpn.WGAllowedIPs, ", ")
+	}
+	return fmt.Sprintf(
+		wgConfigTemplate,
+		assignedIP,
+		strings.Join(dns, ", "),
+		vpn.WGServerPubkey,
+		vpn.WGEndpoint,
+		allowedIPs,
+		vpn.WGKeepalive,
+	)
+}
+
 // WGRegisterRequest is the request body for WireGuard device registration.
 // Embeds WGBaseRequest for the target client_id.
 type WGRegisterRequest struct {
@@ -138,6 +242,10 @@ type WGRegisterResponse struct {
 	AssignedIP  string `json:"assigned_ip"`
 	DeviceCount int    `json:"device_count"`
 	DeviceLimit int    `json:"device_limit"`
+	// CacheStale is set when the device was registered but couldn't be
+	// written to the indexer's database, so other endpoints may not report it
+	// yet. Clients should re-query the device list later.
+	CacheStale bool `json:"cache_stale,omitempty"`
 }
 
 // WGProfileRequest is the request body for WireGuard profile generation.
@@ -157,6 +265,37 @@ func (r *WGProfileRequest) UnmarshalJSON(data []byte) error {
 	return r.parseBaseFields()
 }
 
+// WGProfileResponse is the JSON form of a WireGuard profile, returned when
+// the client sends Accept: application/json
+type WGProfileResponse struct {
+	Config string `json:"config"`
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above
+// text/plain. Wildcards and an empty header favor text/plain.
+func prefersJSON(accept string) bool {
+	var jsonQ, textQ float64
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/plain", "text/*", "*/*":
+			textQ = max(textQ, q)
+		}
+	}
+	return jsonQ > textQ
+}
+
 // WGDeleteRequest is the request body for WireGuard device deletion.
 // Embeds WGBaseRequest for the target client_id.
 type WGDeleteRequest struct {
@@ -180,6 +319,30 @@ type WGDeleteResponse struct {
 	RemainingDevices int  `json:"remaining_devices"`
 }
 
+// WGRotateRequest is the request body for replacing a device's pubkey.
+// Embeds WGBaseRequest for the target client_id.
+type WGRotateRequest struct {
+	WGBaseRequest
+	OldWGPubkey string `json:"old_wg_pubkey"`
+	NewWGPubkey string `json:"new_wg_pubkey"`
+}
+
+func (r *WGRotateRequest) UnmarshalJSON(data []byte) error {
+	type alias WGRotateRequest
+	var tmp alias
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*r = WGRotateRequest(tmp)
+	return r.parseBaseFields()
+}
+
+// WGRotateResponse is the response from a WireGuard pubkey rotation
+type WGRotateResponse struct {
+	Success    bool   `json:"success"`
+	AssignedIP string `json:"assigned_ip"`
+}
+
 // WGDevicesRequest is the request body for listing WireGuard devices.
 // Only needs the base client_id field.
 type WGDevicesRequest struct {
@@ -209,19 +372,29 @@ type WGDeviceInfo struct {
 	CreatedAt  int64  `json:"created_at"`
 }
 
-// ErrorResponse is a JSON error response structure
-type ErrorResponse struct {
-	Error  string `json:"error"`
-	Reason string `json:"reason,omitempty"`
+// WGInfoResponse contains the WireGuard server details a client needs to
+// build its own config
+type WGInfoResponse struct {
+	ServerPubkey string   `json:"server_pubkey"`
+	Endpoint     string   `json:"endpoint"`
+	DNS          []string `json:"dns"`
+	DNSSearch    []string `json:"dns_search,omitempty"`
 }
 
-// writeErrorResponse writes a properly escaped JSON error response
-func writeErrorResponse(w http.ResponseWriter, status int, err, reason string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	resp := ErrorResponse{Error: err, Reason: reason}
-	data, _ := json.Marshal(resp)
-	_, _ = w.Write(data)
+// writeExpiredResponse writes a 403 for an expired subscription, including
+// the expiration time so clients can prompt for renewal
+func writeExpiredResponse(w http.ResponseWriter, expiration time.Time) {
+	writeErrorResponseWithDetails(
+		w,
+		http.StatusForbidden,
+		ErrCodeSubscriptionExpired,
+		"Forbidden",
+		"subscription has expired at "+
+			expiration.UTC().Format(time.RFC3339),
+		map[string]any{
+			"expiration": expiration.UTC(),
+		},
+	)
 }
 
 // wgRegisterImpl handles POST /api/client/wg-register
@@ -235,27 +408,35 @@ func writeErrorResponse(w http.ResponseWriter, status int, err, reason string) {
 //	@Failure		400					{object}	ErrorResponse		"Bad Request (includes generic error for duplicate pubkey to prevent enumeration)"
 //	@Failure		401					{object}	ErrorResponse		"Unauthorized"
 //	@Failure		403					{object}	ErrorResponse		"Forbidden (device limit reached or subscription expired)"
-//	@Failure		405					{object}	string				"Method Not Allowed"
+//	@Failure		405					{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415					{object}	ErrorResponse		"Unsupported Media Type"
 //	@Failure		500					{object}	ErrorResponse		"Server Error"
 //	@Security		BearerAuth
 //	@Router			/api/client/wg-register [post]
 func (a *Api) wgRegisterImpl(
 	w http.ResponseWriter,
 	r *http.Request,
-	wgClient *wireguard.Client,
-	s3Client *client.Client,
+	wgClient PeerManager,
+	peerStore PeerStore,
 ) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
 		return
 	}
 
+	if !requireJSON(w, r) {
+		return
+	}
+
+	logger := requestid.Logger(r.Context())
+
 	var req WGRegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Debug("failed to decode WG register request", "error", err)
+		logger.Debug("failed to decode WG register request", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"malformed request body",
 		)
@@ -267,6 +448,7 @@ func (a *Api) wgRegisterImpl(
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"wg_pubkey is required",
 		)
@@ -276,6 +458,7 @@ func (a *Api) wgRegisterImpl(
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidWGPubkey,
 			"Invalid request",
 			"invalid wg_pubkey format",
 		)
@@ -285,34 +468,32 @@ func (a *Api) wgRegisterImpl(
 	// Authenticate via session token
 	tmpClient, err := a.authenticate(r, req.innerClientID)
 	if err != nil {
-		slog.Error("authentication failed", "error", err)
-		writeErrorResponse(
-			w,
-			http.StatusUnauthorized,
-			"Unauthorized",
-			"authentication failed",
-		)
+		a.writeAuthError(w, err)
 		return
 	}
 
 	// Check subscription not expired
 	if time.Now().After(tmpClient.Expiration) {
-		writeErrorResponse(
-			w, http.StatusForbidden, "Forbidden", "subscription has expired",
-		)
+		writeExpiredResponse(w, tmpClient.Expiration)
 		return
 	}
 
-	maxDevices := a.cfg.Vpn.WGMaxDevices
+	maxDevices := tmpClient.EffectiveDeviceLimit(
+		a.wgMaxDevices(tmpClient.Region),
+	)
 
 	// Check if pubkey already registered (fast path)
-	existingPeer, err := a.db.GetWGPeerByPubkey(req.WGPubkey)
+	existingPeer, err := a.db.GetWGPeerByPubkeyContext(
+		r.Context(),
+		req.WGPubkey,
+	)
 	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
 		// Actual DB error (not just "not found") - fail the request
-		slog.Error("failed to lookup WG peer by pubkey", "error", err)
+		logger.Error("failed to lookup WG peer by pubkey", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -324,25 +505,30 @@ func (a *Api) wgRegisterImpl(
 			string(existingPeer.AssetName) != string(req.innerClientID) {
 			// Return generic error to prevent device enumeration attacks
 			// Don't reveal that the pubkey exists or belongs to someone else
-			slog.Warn(
+			logger.Warn(
 				"pubkey registration attempt for key owned by another client",
 				"client_id", req.ClientID,
 			)
 			writeErrorResponse(
 				w,
 				http.StatusBadRequest,
+				ErrCodeInvalidRequest,
 				"Invalid request",
 				"unable to register device",
 			)
 			return
 		}
 		// Pubkey already registered to this client - return existing info
-		deviceCount, countErr := a.db.CountWGPeersByAsset(req.innerClientID)
+		deviceCount, countErr := a.db.CountWGPeersByAssetContext(
+			r.Context(),
+			req.innerClientID,
+		)
 		if countErr != nil {
-			slog.Error("failed to count WG peers", "error", countErr)
+			logger.Error("failed to count WG peers", "error", countErr)
 			writeErrorResponse(
 				w,
 				http.StatusInternalServerError,
+				ErrCodeInternal,
 				"Internal server error",
 				"",
 			)
@@ -361,12 +547,17 @@ func (a *Api) wgRegisterImpl(
 	}
 
 	// Check device count < limit (only for new registrations)
-	deviceCount, err := a.db.CountWGPeersByAsset(req.innerClientID)
+	deviceCount, err := a.countWGDevices(
+		r.Context(),
+		req.innerClientID,
+		peerStore,
+	)
 	if err != nil {
-		slog.Error("failed to count WG peers", "error", err)
+		logger.Error("failed to count WG peers", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -374,22 +565,28 @@ func (a *Api) wgRegisterImpl(
 	}
 
 	if deviceCount >= int64(maxDevices) {
-		writeErrorResponse(
+		writeErrorResponseWithDetails(
 			w,
 			http.StatusForbidden,
+			ErrCodeDeviceLimitReached,
 			"Forbidden",
 			"device limit reached",
+			map[string]any{
+				"device_count": deviceCount,
+				"device_limit": maxDevices,
+			},
 		)
 		return
 	}
 
 	// Allocate IP from pool
-	assignedIP, err := a.db.AllocateIP(a.cfg.Vpn.Region)
+	assignedIP, err := a.db.AllocateIPContext(r.Context(), a.cfg.Vpn.Region)
 	if err != nil {
-		slog.Error("failed to allocate IP", "error", err)
+		logger.Error("failed to allocate IP", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -399,22 +596,22 @@ func (a *Api) wgRegisterImpl(
 	// Save to S3 first (source of truth)
 	// If this fails, nothing is persisted and we must release the allocated IP
 	// Use request context with timeout to bound operation time
-	if s3Client != nil {
+	if peerStore != nil {
 		ctx, cancel := context.WithTimeout(r.Context(), RequestTimeout)
 		defer cancel()
-		if err := s3Client.SavePeerToS3WithContext(
+		if err := peerStore.SavePeerToS3WithContext(
 			ctx,
 			req.innerClientID,
 			req.WGPubkey,
 			assignedIP,
 		); err != nil {
-			slog.Error("failed to save peer to S3", "error", err)
+			logger.Error("failed to save peer to S3", "error", err)
 			// Release the allocated IP back to the pool since S3 save failed
 			if deallocErr := a.db.DeallocateIP(
 				a.cfg.Vpn.Region,
 				assignedIP,
 			); deallocErr != nil {
-				slog.Error(
+				logger.Error(
 					"failed to deallocate IP after S3 failure",
 					"ip", assignedIP,
 					"error", deallocErr,
@@ -423,6 +620,7 @@ func (a *Api) wgRegisterImpl(
 			writeErrorResponse(
 				w,
 				http.StatusInternalServerError,
+				ErrCodeInternal,
 				"Failed to persist peer",
 				"",
 			)
@@ -433,25 +631,28 @@ func (a *Api) wgRegisterImpl(
 	// Save to DB (cache) - if this fails, S3 has the data and
 	// the next startup will rebuild the DB from S3.
 	// We continue to return success since S3 (source of truth) succeeded.
-	if err := a.db.AddWGPeer(
+	cached := a.cacheRegisteredPeer(
+		r.Context(),
 		req.innerClientID,
 		req.WGPubkey,
 		assignedIP,
-	); err != nil {
-		slog.Warn(
-			"failed to add WG peer to database cache, will sync from S3 on restart",
-			"error", err,
-			"pubkey", req.WGPubkey[:8]+"...",
-		)
-		// Continue - S3 is the source of truth and has the peer
-	}
+	)
 
 	// Call WG container to add peer - best effort, can be retried
 	// via SyncPeersToContainer on startup
 	if wgClient != nil {
-		if _, err := wgClient.AddPeer(req.WGPubkey, assignedIP); err != nil {
-			slog.Error("failed to add peer to WG container", "error", err)
-			// Continue anyway - peer is registered in S3/DB and will sync on restart
+		if _, err := wgClient.AddPeerWithContext(
+			r.Context(),
+			req.WGPubkey,
+			assignedIP,
+		); err != nil {
+			logger.Error("failed to add peer to WG container", "error", err)
+			// Continue anyway - peer is registered in S3/DB. Retry in the
+			// background, keeping the request ID but not the cancellation.
+			ctx := context.WithoutCancel(r.Context())
+			a.peerRetries.Go(func() {
+				a.retryAddPeer(ctx, wgClient, req.WGPubkey, assignedIP)
+			})
 		}
 	}
 
@@ -462,24 +663,156 @@ func (a *Api) wgRegisterImpl(
 		AssignedIP:  assignedIP,
 		DeviceCount: int(deviceCount) + 1,
 		DeviceLimit: maxDevices,
+		CacheStale:  !cached && a.cfg.Vpn.WGVerifyCacheWrite,
 	}
 	respBytes, _ := json.Marshal(resp)
 	_, _ = w.Write(respBytes)
 }
 
+// countWGDevices returns the number of devices registered for a client,
+// counted from WGDeviceCountSource. The S3 count is used when configured and
+// a peer store is available, since the database is only a cache of it.
+func (a *Api) countWGDevices(
+	ctx context.Context,
+	assetName []byte,
+	peerStore PeerStore,
+) (int64, error) {
+	if a.cfg.Vpn.WGDeviceCountSource != config.WGDeviceCountS3 ||
+		peerStore == nil {
+		return a.db.CountWGPeersByAssetContext(ctx, assetName)
+	}
+	peerFile, err := peerStore.LoadPeersFromS3(assetName)
+	if err != nil {
+		return 0, fmt.Errorf("load peers from S3: %w", err)
+	}
+	// A client has no peer file until its first device is registered
+	if peerFile == nil {
+		return 0, nil
+	}
+	return int64(len(peerFile.Peers)), nil
+}
+
+// cacheRegisteredPeer adds a peer that was saved to S3 to the database cache
+// and reports whether it succeeded. With WGVerifyCacheWrite, a failed write
+// is retried up to CacheWriteRetryAttempts times before giving up.
+func (a *Api) cacheRegisteredPeer(
+	ctx context.Context,
+	assetName []byte,
+	pubkey string,
+	assignedIP string,
+) bool {
+	logger := requestid.Logger(ctx).With("pubkey", pubkey[:8]+"...")
+	retries := 0
+	if a.cfg.Vpn.WGVerifyCacheWrite {
+		retries = CacheWriteRetryAttempts
+	}
+	interval := a.cacheRetryInterval
+	if interval <= 0 {
+		interval = CacheWriteRetryInterval
+	}
+	for attempt := 0; ; attempt++ {
+		err := addWGPeer(a.db, assetName, pubkey, assignedIP)
+		if err == nil {
+			if attempt > 0 {
+				logger.Info(
+					"added WG peer to database cache on retry",
+					"attempt", attempt,
+				)
+			}
+			return true
+		}
+		if attempt >= retries {
+			logger.Warn(
+				"failed to add WG peer to database cache, will sync from S3 on restart",
+				"error", err,
+			)
+			return false
+		}
+		logger.Warn(
+			"failed to add WG peer to database cache, retrying",
+			"attempt", attempt+1,
+			"error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
+
+// retryAddPeer re-attempts adding a registered peer to the WG container
+// with doubling delays, giving up after AddPeerRetryAttempts. It stops early
+// if the peer has been removed in the meantime. Peers it gives up on are
+// added by SyncPeersToContainer on the next startup.
+func (a *Api) retryAddPeer(
+	ctx context.Context,
+	wgClient PeerManager,
+	pubkey string,
+	assignedIP string,
+) {
+	logger := requestid.Logger(ctx).With("pubkey", pubkey[:8]+"...")
+	interval := a.peerRetryInterval
+	if interval <= 0 {
+		interval = AddPeerRetryInterval
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	var err error
+	for attempt := 1; attempt <= AddPeerRetryAttempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if _, dbErr := a.db.GetWGPeerByPubkey(pubkey); errors.Is(
+			dbErr,
+			database.ErrRecordNotFound,
+		) {
+			logger.Debug("peer removed before WG container retry")
+			return
+		}
+		if _, err = wgClient.AddPeerWithContext(
+			ctx,
+			pubkey,
+			assignedIP,
+		); err == nil {
+			logger.Info(
+				"added peer to WG container on retry",
+				"attempt", attempt,
+			)
+			return
+		}
+		logger.Warn(
+			"retry adding peer to WG container failed",
+			"attempt", attempt,
+			"error", err,
+		)
+		interval *= 2
+		timer.Reset(interval)
+	}
+	logger.Error(
+		"giving up adding peer to WG container, will sync on restart",
+		"attempts", AddPeerRetryAttempts,
+		"error", err,
+	)
+}
+
 // wgProfileImpl handles POST /api/client/wg-profile
 //
 //	@Summary		WGProfile
-//	@Description	Get a WireGuard configuration profile for a registered device
+//	@Description	Get a WireGuard configuration profile for a registered device. Returns the raw config as text/plain by default, or a WGProfileResponse when application/json is preferred by the Accept header.
 //	@Accept			json
 //	@Produce		text/plain,application/json
 //	@Param			WGProfileRequest	body		WGProfileRequest	true	"Profile Request"
+//	@Param			Accept				header		string				false	"text/plain (default) or application/json"
 //	@Success		200					{string}	string				"WireGuard config file"
 //	@Failure		400					{object}	ErrorResponse		"Bad Request"
 //	@Failure		401					{object}	ErrorResponse		"Unauthorized"
 //	@Failure		403					{object}	ErrorResponse		"Forbidden (subscription expired)"
 //	@Failure		404					{object}	ErrorResponse		"Not Found"
-//	@Failure		405					{object}	string				"Method Not Allowed"
+//	@Failure		405					{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415					{object}	ErrorResponse		"Unsupported Media Type"
 //	@Failure		500					{object}	ErrorResponse		"Server Error"
 //	@Security		BearerAuth
 //	@Router			/api/client/wg-profile [post]
@@ -488,16 +821,23 @@ func (a *Api) wgProfileImpl(
 	r *http.Request,
 ) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
 		return
 	}
 
+	if !requireJSON(w, r) {
+		return
+	}
+
+	logger := requestid.Logger(r.Context())
+
 	var req WGProfileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Debug("failed to decode WG profile request", "error", err)
+		logger.Debug("failed to decode WG profile request", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"malformed request body",
 		)
@@ -509,6 +849,7 @@ func (a *Api) wgProfileImpl(
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"wg_pubkey is required",
 		)
@@ -518,6 +859,7 @@ func (a *Api) wgProfileImpl(
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidWGPubkey,
 			"Invalid request",
 			"invalid wg_pubkey format",
 		)
@@ -527,26 +869,18 @@ func (a *Api) wgProfileImpl(
 	// Authenticate via session token
 	tmpClient, err := a.authenticate(r, req.innerClientID)
 	if err != nil {
-		slog.Error("authentication failed", "error", err)
-		writeErrorResponse(
-			w,
-			http.StatusUnauthorized,
-			"Unauthorized",
-			"authentication failed",
-		)
+		a.writeAuthError(w, err)
 		return
 	}
 
 	// Check subscription not expired
 	if time.Now().After(tmpClient.Expiration) {
-		writeErrorResponse(
-			w, http.StatusForbidden, "Forbidden", "subscription has expired",
-		)
+		writeExpiredResponse(w, tmpClient.Expiration)
 		return
 	}
 
 	// Lookup peer by pubkey - device must be explicitly registered first
-	peer, err := a.db.GetWGPeerByPubkey(req.WGPubkey)
+	peer, err := a.db.GetWGPeerByPubkeyContext(r.Context(), req.WGPubkey)
 	if err != nil {
 		if errors.Is(err, database.ErrRecordNotFound) {
 			// Device not registered - return 404
@@ -554,15 +888,17 @@ func (a *Api) wgProfileImpl(
 			writeErrorResponse(
 				w,
 				http.StatusNotFound,
+				ErrCodeDeviceNotFound,
 				"Not found",
 				"device not registered - use wg-register endpoint first",
 			)
 			return
 		}
-		slog.Error("failed to lookup WG peer", "error", err)
+		logger.Error("failed to lookup WG peer", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -573,13 +909,14 @@ func (a *Api) wgProfileImpl(
 	if peer.AssetName == nil ||
 		string(peer.AssetName) != string(req.innerClientID) {
 		// Return generic error to prevent device enumeration
-		slog.Warn(
+		logger.Warn(
 			"profile request for key owned by another client",
 			"client_id", req.ClientID,
 		)
 		writeErrorResponse(
 			w,
 			http.StatusNotFound,
+			ErrCodeDeviceNotFound,
 			"Not found",
 			"device not registered",
 		)
@@ -587,17 +924,12 @@ func (a *Api) wgProfileImpl(
 	}
 
 	// Generate WireGuard config
-	dns := a.cfg.Vpn.DNS
-	if dns == "" {
-		dns = DefaultDNS
-	}
-
 	serverPubkey := a.cfg.Vpn.WGServerPubkey
 	endpoint := a.cfg.Vpn.WGEndpoint
 
 	// Validate WG server config before generating config
 	if serverPubkey == "" || endpoint == "" {
-		slog.Error(
+		logger.Error(
 			"WG server configuration incomplete",
 			"serverPubkey_set", serverPubkey != "",
 			"endpoint_set", endpoint != "",
@@ -605,22 +937,36 @@ func (a *Api) wgProfileImpl(
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeServerMisconfigured,
 			"Server configuration incomplete",
 			"",
 		)
 		return
 	}
 
-	config := fmt.Sprintf(
-		wgConfigTemplate,
-		peer.AssignedIP,
-		dns,
-		serverPubkey,
-		endpoint,
-	)
+	if err := config.ValidateWGEndpoint(endpoint); err != nil {
+		logger.Error("WG server endpoint invalid", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeServerMisconfigured,
+			"Server configuration invalid",
+			"WireGuard endpoint is not a valid host:port",
+		)
+		return
+	}
+
+	profile := renderWGConfig(&a.cfg.Vpn, peer.AssignedIP)
 
+	w.Header().Add("Vary", "Accept")
+	if prefersJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json")
+		respBytes, _ := json.Marshal(WGProfileResponse{Config: profile})
+		_, _ = w.Write(respBytes)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain")
-	_, _ = w.Write([]byte(config))
+	_, _ = w.Write([]byte(profile))
 }
 
 // wgPeerDeleteImpl handles DELETE /api/client/wg-peer
@@ -635,27 +981,35 @@ func (a *Api) wgProfileImpl(
 //	@Failure		401				{object}	ErrorResponse		"Unauthorized"
 //	@Failure		403				{object}	ErrorResponse		"Forbidden"
 //	@Failure		404				{object}	ErrorResponse		"Not Found"
-//	@Failure		405				{object}	string				"Method Not Allowed"
+//	@Failure		405				{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415				{object}	ErrorResponse		"Unsupported Media Type"
 //	@Failure		500				{object}	ErrorResponse		"Server Error"
 //	@Security		BearerAuth
 //	@Router			/api/client/wg-peer [delete]
 func (a *Api) wgPeerDeleteImpl(
 	w http.ResponseWriter,
 	r *http.Request,
-	wgClient *wireguard.Client,
-	s3Client *client.Client,
+	wgClient PeerManager,
+	peerStore PeerStore,
 ) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
 		return
 	}
 
+	if !requireJSON(w, r) {
+		return
+	}
+
+	logger := requestid.Logger(r.Context())
+
 	var req WGDeleteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Debug("failed to decode WG delete request", "error", err)
+		logger.Debug("failed to decode WG delete request", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"malformed request body",
 		)
@@ -667,6 +1021,7 @@ func (a *Api) wgPeerDeleteImpl(
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"wg_pubkey is required",
 		)
@@ -676,6 +1031,7 @@ func (a *Api) wgPeerDeleteImpl(
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidWGPubkey,
 			"Invalid request",
 			"invalid wg_pubkey format",
 		)
@@ -685,40 +1041,34 @@ func (a *Api) wgPeerDeleteImpl(
 	// Authenticate via session token
 	tmpClient, err := a.authenticate(r, req.innerClientID)
 	if err != nil {
-		slog.Error("authentication failed", "error", err)
-		writeErrorResponse(
-			w,
-			http.StatusUnauthorized,
-			"Unauthorized",
-			"authentication failed",
-		)
+		a.writeAuthError(w, err)
 		return
 	}
 
 	// Check subscription not expired
 	if time.Now().After(tmpClient.Expiration) {
-		writeErrorResponse(
-			w, http.StatusForbidden, "Forbidden", "subscription has expired",
-		)
+		writeExpiredResponse(w, tmpClient.Expiration)
 		return
 	}
 
 	// Lookup peer by pubkey
-	peer, err := a.db.GetWGPeerByPubkey(req.WGPubkey)
+	peer, err := a.db.GetWGPeerByPubkeyContext(r.Context(), req.WGPubkey)
 	if err != nil {
 		if errors.Is(err, database.ErrRecordNotFound) {
 			writeErrorResponse(
 				w,
 				http.StatusNotFound,
+				ErrCodeDeviceNotFound,
 				"Not found",
 				"peer not registered",
 			)
 			return
 		}
-		slog.Error("failed to lookup WG peer", "error", err)
+		logger.Error("failed to lookup WG peer", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -729,13 +1079,14 @@ func (a *Api) wgPeerDeleteImpl(
 	if peer.AssetName == nil ||
 		string(peer.AssetName) != string(req.innerClientID) {
 		// Return generic error to prevent device enumeration
-		slog.Warn(
+		logger.Warn(
 			"delete request for key owned by another client",
 			"client_id", req.ClientID,
 		)
 		writeErrorResponse(
 			w,
 			http.StatusNotFound,
+			ErrCodeDeviceNotFound,
 			"Not found",
 			"device not registered",
 		)
@@ -745,18 +1096,19 @@ func (a *Api) wgPeerDeleteImpl(
 	// Remove from S3 first (source of truth)
 	// If this fails, nothing is deleted and we return an error
 	// Use request context with timeout to bound operation time
-	if s3Client != nil {
+	if peerStore != nil {
 		ctx, cancel := context.WithTimeout(r.Context(), RequestTimeout)
 		defer cancel()
-		if err := s3Client.RemovePeerFromS3WithContext(
+		if err := peerStore.RemovePeerFromS3WithContext(
 			ctx,
 			req.innerClientID,
 			req.WGPubkey,
 		); err != nil {
-			slog.Error("failed to remove peer from S3", "error", err)
+			logger.Error("failed to remove peer from S3", "error", err)
 			writeErrorResponse(
 				w,
 				http.StatusInternalServerError,
+				ErrCodeInternal,
 				"Failed to remove peer",
 				"",
 			)
@@ -768,7 +1120,7 @@ func (a *Api) wgPeerDeleteImpl(
 	// and startup rebuild will sync the state.
 	// We continue to return success since S3 (source of truth) succeeded.
 	if err := a.db.DeleteWGPeer(req.WGPubkey); err != nil {
-		slog.Warn(
+		logger.Warn(
 			"failed to delete WG peer from database cache, will sync from S3 on restart",
 			"error", err,
 			"pubkey", req.WGPubkey[:8]+"...",
@@ -778,7 +1130,7 @@ func (a *Api) wgPeerDeleteImpl(
 
 	// Release IP back to pool for reuse
 	if err := a.db.DeallocateIP(a.cfg.Vpn.Region, peer.AssignedIP); err != nil {
-		slog.Warn(
+		logger.Warn(
 			"failed to deallocate IP",
 			"ip", peer.AssignedIP,
 			"error", err,
@@ -789,8 +1141,12 @@ func (a *Api) wgPeerDeleteImpl(
 	// Remove from WG container - best effort, will be cleaned up
 	// via SyncPeersToContainer which only adds active peers
 	if wgClient != nil {
-		if err := wgClient.RemovePeer(peer.Pubkey, peer.AssignedIP); err != nil {
-			slog.Error(
+		if err := wgClient.RemovePeerWithContext(
+			r.Context(),
+			peer.Pubkey,
+			peer.AssignedIP,
+		); err != nil {
+			logger.Error(
 				"failed to remove peer from WG container",
 				"error",
 				err,
@@ -800,9 +1156,12 @@ func (a *Api) wgPeerDeleteImpl(
 	}
 
 	// Get remaining device count
-	remainingCount, err := a.db.CountWGPeersByAsset(req.innerClientID)
+	remainingCount, err := a.db.CountWGPeersByAssetContext(
+		r.Context(),
+		req.innerClientID,
+	)
 	if err != nil {
-		slog.Error("failed to count remaining devices", "error", err)
+		logger.Error("failed to count remaining devices", "error", err)
 		remainingCount = 0
 	}
 
@@ -816,6 +1175,234 @@ func (a *Api) wgPeerDeleteImpl(
 	_, _ = w.Write(respBytes)
 }
 
+// wgRotateImpl handles POST /api/client/wg-rotate
+//
+//	@Summary		WGRotate
+//	@Description	Replace a WireGuard device's pubkey, keeping its assigned IP
+//	@Accept			json
+//	@Produce		json
+//	@Param			WGRotateRequest	body		WGRotateRequest		true	"Rotate Request"
+//	@Success		200				{object}	WGRotateResponse	"Rotation successful"
+//	@Failure		400				{object}	ErrorResponse		"Bad Request"
+//	@Failure		401				{object}	ErrorResponse		"Unauthorized"
+//	@Failure		403				{object}	ErrorResponse		"Forbidden"
+//	@Failure		404				{object}	ErrorResponse		"Not Found"
+//	@Failure		405				{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415				{object}	ErrorResponse		"Unsupported Media Type"
+//	@Failure		409				{object}	ErrorResponse		"Conflict"
+//	@Failure		500				{object}	ErrorResponse		"Server Error"
+//	@Security		BearerAuth
+//	@Router			/api/client/wg-rotate [post]
+func (a *Api) wgRotateImpl(
+	w http.ResponseWriter,
+	r *http.Request,
+	wgClient PeerManager,
+	peerStore PeerStore,
+) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	logger := requestid.Logger(r.Context())
+
+	var req WGRotateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Debug("failed to decode WG rotate request", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"malformed request body",
+		)
+		return
+	}
+
+	// Validate both pubkeys
+	if req.OldWGPubkey == "" || req.NewWGPubkey == "" {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"old_wg_pubkey and new_wg_pubkey are required",
+		)
+		return
+	}
+	if !isValidWGPubkey(req.OldWGPubkey) {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidWGPubkey,
+			"Invalid request",
+			"invalid old_wg_pubkey format",
+		)
+		return
+	}
+	if !isValidWGPubkey(req.NewWGPubkey) {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidWGPubkey,
+			"Invalid request",
+			"invalid new_wg_pubkey format",
+		)
+		return
+	}
+	if req.OldWGPubkey == req.NewWGPubkey {
+		writeErrorResponse(
+			w,
+			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
+			"Invalid request",
+			"new_wg_pubkey must differ from old_wg_pubkey",
+		)
+		return
+	}
+
+	// Authenticate via session token
+	tmpClient, err := a.authenticate(r, req.innerClientID)
+	if err != nil {
+		a.writeAuthError(w, err)
+		return
+	}
+
+	// Check subscription not expired
+	if time.Now().After(tmpClient.Expiration) {
+		writeExpiredResponse(w, tmpClient.Expiration)
+		return
+	}
+
+	// Lookup peer by old pubkey and verify it belongs to this client
+	peer, err := a.db.GetWGPeerByPubkeyContext(r.Context(), req.OldWGPubkey)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		logger.Error("failed to lookup WG peer", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+	if peer == nil || string(peer.AssetName) != string(req.innerClientID) {
+		// Return generic error to prevent device enumeration
+		writeErrorResponse(
+			w,
+			http.StatusNotFound,
+			ErrCodeDeviceNotFound,
+			"Not found",
+			"device not registered",
+		)
+		return
+	}
+
+	// The new pubkey must not already be registered
+	if _, err := a.db.GetWGPeerByPubkeyContext(
+		r.Context(),
+		req.NewWGPubkey,
+	); err == nil {
+		writeErrorResponse(
+			w,
+			http.StatusConflict,
+			ErrCodeDeviceAlreadyRegistered,
+			"Conflict",
+			"new_wg_pubkey already registered",
+		)
+		return
+	} else if !errors.Is(err, database.ErrRecordNotFound) {
+		logger.Error("failed to lookup WG peer", "error", err)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeInternal,
+			"Internal server error",
+			"",
+		)
+		return
+	}
+
+	// Update S3 first (source of truth)
+	if peerStore != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), RequestTimeout)
+		defer cancel()
+		if err := peerStore.RotatePeerInS3WithContext(
+			ctx,
+			req.innerClientID,
+			req.OldWGPubkey,
+			req.NewWGPubkey,
+		); err != nil {
+			logger.Error("failed to rotate peer in S3", "error", err)
+			writeErrorResponse(
+				w,
+				http.StatusInternalServerError,
+				ErrCodeInternal,
+				"Failed to rotate peer",
+				"",
+			)
+			return
+		}
+	}
+
+	// Update DB (cache) - if this fails, S3 has the new pubkey and the next
+	// startup will rebuild the DB from S3
+	if err := a.db.RotateWGPeerPubkey(
+		req.OldWGPubkey,
+		req.NewWGPubkey,
+	); err != nil {
+		logger.Warn(
+			"failed to rotate WG peer in database cache, will sync from S3 on restart",
+			"error", err,
+			"pubkey", req.NewWGPubkey[:8]+"...",
+		)
+	}
+
+	// Swap the peer in the WG container - best effort. The old key is
+	// removed first so it stops working even if the add fails.
+	if wgClient != nil {
+		if err := wgClient.RemovePeerWithContext(
+			r.Context(),
+			req.OldWGPubkey,
+			peer.AssignedIP,
+		); err != nil {
+			logger.Error(
+				"failed to remove old peer from WG container",
+				"error", err,
+			)
+		}
+		if _, err := wgClient.AddPeerWithContext(
+			r.Context(),
+			req.NewWGPubkey,
+			peer.AssignedIP,
+		); err != nil {
+			logger.Error("failed to add peer to WG container", "error", err)
+			ctx := context.WithoutCancel(r.Context())
+			a.peerRetries.Go(func() {
+				a.retryAddPeer(
+					ctx,
+					wgClient,
+					req.NewWGPubkey,
+					peer.AssignedIP,
+				)
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := WGRotateResponse{
+		Success:    true,
+		AssignedIP: peer.AssignedIP,
+	}
+	respBytes, _ := json.Marshal(resp)
+	_, _ = w.Write(respBytes)
+}
+
 // wgDevicesImpl handles POST /api/client/wg-devices
 //
 //	@Summary		WGDevices
@@ -826,22 +1413,30 @@ func (a *Api) wgPeerDeleteImpl(
 //	@Success		200					{object}	WGDevicesResponse	"Device list"
 //	@Failure		400					{object}	ErrorResponse		"Bad Request"
 //	@Failure		401					{object}	ErrorResponse		"Unauthorized"
-//	@Failure		405					{object}	string				"Method Not Allowed"
+//	@Failure		405					{object}	ErrorResponse		"Method Not Allowed"
+//	@Failure		415					{object}	ErrorResponse		"Unsupported Media Type"
 //	@Failure		500					{object}	ErrorResponse		"Server Error"
 //	@Security		BearerAuth
 //	@Router			/api/client/wg-devices [post]
 func (a *Api) wgDevicesImpl(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w)
 		return
 	}
 
+	if !requireJSON(w, r) {
+		return
+	}
+
+	logger := requestid.Logger(r.Context())
+
 	var req WGDevicesRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		slog.Debug("failed to decode WG devices request", "error", err)
+		logger.Debug("failed to decode WG devices request", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusBadRequest,
+			ErrCodeInvalidRequest,
 			"Invalid request",
 			"malformed request body",
 		)
@@ -851,31 +1446,24 @@ func (a *Api) wgDevicesImpl(w http.ResponseWriter, r *http.Request) {
 	// Authenticate via session token
 	tmpClient, err := a.authenticate(r, req.innerClientID)
 	if err != nil {
-		slog.Error("authentication failed", "error", err)
-		writeErrorResponse(
-			w,
-			http.StatusUnauthorized,
-			"Unauthorized",
-			"authentication failed",
-		)
+		a.writeAuthError(w, err)
 		return
 	}
 
 	// Check subscription not expired
 	if time.Now().After(tmpClient.Expiration) {
-		writeErrorResponse(
-			w, http.StatusForbidden, "Forbidden", "subscription has expired",
-		)
+		writeExpiredResponse(w, tmpClient.Expiration)
 		return
 	}
 
 	// Query DB for peers by asset name
-	peers, err := a.db.GetWGPeersByAsset(req.innerClientID)
+	peers, err := a.db.GetWGPeersByAssetContext(r.Context(), req.innerClientID)
 	if err != nil {
-		slog.Error("failed to get WG peers", "error", err)
+		logger.Error("failed to get WG peers", "error", err)
 		writeErrorResponse(
 			w,
 			http.StatusInternalServerError,
+			ErrCodeInternal,
 			"Internal server error",
 			"",
 		)
@@ -892,7 +1480,9 @@ func (a *Api) wgDevicesImpl(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	maxDevices := a.cfg.Vpn.WGMaxDevices
+	maxDevices := tmpClient.EffectiveDeviceLimit(
+		a.wgMaxDevices(tmpClient.Region),
+	)
 
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
@@ -903,3 +1493,85 @@ func (a *Api) wgDevicesImpl(w http.ResponseWriter, r *http.Request) {
 	respBytes, _ := json.Marshal(resp)
 	_, _ = w.Write(respBytes)
 }
+
+// wgInfoImpl handles GET /api/wg/info
+//
+//	@Summary		WGInfo
+//	@Description	Get the WireGuard server public key, endpoint, and DNS settings
+//	@Produce		json
+//	@Success		200	{object}	WGInfoResponse	"Server info"
+//	@Failure		405	{object}	ErrorResponse	"Method Not Allowed"
+//	@Failure		500	{object}	ErrorResponse	"Server Error"
+//	@Router			/api/wg/info [get]
+func (a *Api) wgInfoImpl(
+	w http.ResponseWriter,
+	r *http.Request,
+	wgClient *wireguard.Client,
+) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	logger := requestid.Logger(r.Context())
+	info := a.getWGInfo(wgClient)
+	if info.ServerPubkey == "" || info.Endpoint == "" {
+		logger.Error(
+			"WireGuard server info unavailable",
+			"serverPubkey_set", info.ServerPubkey != "",
+			"endpoint_set", info.Endpoint != "",
+		)
+		writeErrorResponse(
+			w,
+			http.StatusInternalServerError,
+			ErrCodeServerMisconfigured,
+			"Internal server error",
+			"server configuration error",
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBytes, _ := json.Marshal(info)
+	_, _ = w.Write(respBytes)
+}
+
+// getWGInfo returns the WireGuard server info, preferring live values from
+// the container and falling back to the configured ones. Results are cached
+// for WGInfoCacheTTL so clients polling this endpoint don't hit the container
+// on every request.
+func (a *Api) getWGInfo(wgClient *wireguard.Client) WGInfoResponse {
+	a.wgInfoMutex.Lock()
+	defer a.wgInfoMutex.Unlock()
+
+	if a.wgInfo != nil && time.Now().Before(a.wgInfoExpires) {
+		return *a.wgInfo
+	}
+
+	info := WGInfoResponse{
+		ServerPubkey: a.cfg.Vpn.WGServerPubkey,
+		Endpoint:     a.cfg.Vpn.WGEndpoint,
+		DNS:          wgDNSServers(&a.cfg.Vpn),
+		DNSSearch:    a.cfg.Vpn.WGDNSSearch,
+	}
+	if wgClient != nil {
+		live, err := wgClient.GetInfo()
+		if err != nil {
+			slog.Warn(
+				"failed to get WireGuard container info, using configured values",
+				"error", err,
+			)
+		} else {
+			if live.ServerPubkey != "" {
+				info.ServerPubkey = live.ServerPubkey
+			}
+			if live.Endpoint != "" {
+				info.Endpoint = live.Endpoint
+			}
+		}
+	}
+
+	a.wgInfo = &info
+	a.wgInfoExpires = time.Now().Add(WGInfoCacheTTL)
+	return info
+}
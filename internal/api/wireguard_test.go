@@ -15,11 +15,28 @@
 package api
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/blinklabs-io/vpn-indexer/internal/requestid"
+	"github.com/blinklabs-io/vpn-indexer/internal/wireguard"
 )
 
 func TestIsValidWGPubkey(t *testing.T) {
@@ -65,6 +82,17 @@ func TestIsValidWGPubkey(t *testing.T) {
 			pubkey:  "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
 			isValid: false, // No padding, decodes to 33 bytes
 		},
+		{
+			// The final character's low 2 bits are padding and must be zero
+			name:    "non-canonical trailing bits",
+			pubkey:  "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAB=",
+			isValid: false,
+		},
+		{
+			name:    "non-canonical trailing bits all Zs",
+			pubkey:  "ZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZZ=",
+			isValid: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -132,6 +160,7 @@ func TestWriteErrorResponse(t *testing.T) {
 	tests := []struct {
 		name       string
 		status     int
+		code       ErrorCode
 		errMsg     string
 		reason     string
 		wantStatus int
@@ -140,6 +169,7 @@ func TestWriteErrorResponse(t *testing.T) {
 		{
 			name:       "bad request with reason",
 			status:     http.StatusBadRequest,
+			code:       ErrCodeInvalidRequest,
 			errMsg:     "Invalid request",
 			reason:     "missing field",
 			wantStatus: http.StatusBadRequest,
@@ -148,6 +178,7 @@ func TestWriteErrorResponse(t *testing.T) {
 		{
 			name:       "unauthorized without reason",
 			status:     http.StatusUnauthorized,
+			code:       ErrCodeUnauthorized,
 			errMsg:     "Unauthorized",
 			reason:     "",
 			wantStatus: http.StatusUnauthorized,
@@ -156,6 +187,7 @@ func TestWriteErrorResponse(t *testing.T) {
 		{
 			name:       "internal error",
 			status:     http.StatusInternalServerError,
+			code:       ErrCodeInternal,
 			errMsg:     "Internal server error",
 			reason:     "",
 			wantStatus: http.StatusInternalServerError,
@@ -166,7 +198,7 @@ func TestWriteErrorResponse(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
-			writeErrorResponse(w, tt.status, tt.errMsg, tt.reason)
+			writeErrorResponse(w, tt.status, tt.code, tt.errMsg, tt.reason)
 
 			if w.Code != tt.wantStatus {
 				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
@@ -186,6 +218,9 @@ func TestWriteErrorResponse(t *testing.T) {
 				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 					t.Errorf("failed to parse response JSON: %v", err)
 				}
+				if resp.Code != tt.code {
+					t.Errorf("code = %q, want %q", resp.Code, tt.code)
+				}
 				if resp.Error != tt.errMsg {
 					t.Errorf("error = %q, want %q", resp.Error, tt.errMsg)
 				}
@@ -219,6 +254,91 @@ func TestWGConfigTemplate(t *testing.T) {
 	}
 }
 
+func TestRenderWGConfigSplitTunnel(t *testing.T) {
+	vpn := &config.VpnConfig{
+		WGServerPubkey: "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+		WGEndpoint:     "vpn.example.com:51820",
+		WGAllowedIPs:   []string{"10.8.0.0/24", "192.168.10.0/24"},
+		WGKeepalive:    15,
+	}
+	profile := renderWGConfig(vpn, "10.8.0.42")
+
+	wantLines := []string{
+		"Address = 10.8.0.42/24",
+		"DNS = " + DefaultDNS,
+		"PublicKey = " + vpn.WGServerPubkey,
+		"Endpoint = vpn.example.com:51820",
+		"AllowedIPs = 10.8.0.0/24, 192.168.10.0/24",
+		"PersistentKeepalive = 15",
+	}
+	for _, line := range wantLines {
+		if !strings.Contains(profile, line+"\n") {
+			t.Errorf("profile missing %q:\n%s", line, profile)
+		}
+	}
+	if strings.Contains(profile, "0.0.0.0/0") {
+		t.Errorf("split-tunnel profile should not route all traffic:\n%s", profile)
+	}
+}
+
+func TestRenderWGConfigDefaultAllowedIPs(t *testing.T) {
+	vpn := &config.VpnConfig{
+		WGEndpoint:  "vpn.example.com:51820",
+		WGKeepalive: 25,
+	}
+	profile := renderWGConfig(vpn, "10.8.0.2")
+	if !strings.Contains(profile, "AllowedIPs = "+DefaultAllowedIPs+"\n") {
+		t.Errorf("profile missing default AllowedIPs:\n%s", profile)
+	}
+	if !strings.Contains(profile, "PersistentKeepalive = 25\n") {
+		t.Errorf("profile missing keepalive:\n%s", profile)
+	}
+}
+
+func TestRenderWGConfigDNS(t *testing.T) {
+	tests := []struct {
+		name    string
+		vpn     config.VpnConfig
+		wantDNS string
+	}{
+		{
+			name:    "default",
+			vpn:     config.VpnConfig{},
+			wantDNS: "DNS = " + DefaultDNS,
+		},
+		{
+			name:    "shared VPN DNS",
+			vpn:     config.VpnConfig{DNS: "10.9.0.1"},
+			wantDNS: "DNS = 10.9.0.1",
+		},
+		{
+			name: "multiple DNS servers",
+			vpn: config.VpnConfig{
+				DNS:   "10.9.0.1",
+				WGDNS: []string{"10.8.0.1", "10.8.0.2"},
+			},
+			wantDNS: "DNS = 10.8.0.1, 10.8.0.2",
+		},
+		{
+			name: "search domains",
+			vpn: config.VpnConfig{
+				WGDNS:       []string{"10.8.0.1", "10.8.0.2"},
+				WGDNSSearch: []string{"vpn.internal", "example.com"},
+			},
+			wantDNS: "DNS = 10.8.0.1, 10.8.0.2, vpn.internal, example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := renderWGConfig(&tt.vpn, "10.8.0.2")
+			if !strings.Contains(profile, tt.wantDNS+"\n") {
+				t.Errorf("profile missing %q:\n%s", tt.wantDNS, profile)
+			}
+		})
+	}
+}
+
 func TestWGBaseRequestParseFields(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -288,3 +408,1556 @@ func TestConstants(t *testing.T) {
 		)
 	}
 }
+
+func TestWGInfo(t *testing.T) {
+	const (
+		livePubkey   = "bGl2ZS1zZXJ2ZXItcHVia2V5LXBsYWNlaG9sZGVyLTA="
+		cfgPubkey    = "Y2ZnLXNlcnZlci1wdWJrZXktcGxhY2Vob2xkZXItMDA="
+		liveEndpoint = "live.example.com:51820"
+		cfgEndpoint  = "cfg.example.com:51820"
+	)
+
+	tests := []struct {
+		name          string
+		containerCode int
+		wantPubkey    string
+		wantEndpoint  string
+	}{
+		{
+			name:          "live container info",
+			containerCode: http.StatusOK,
+			wantPubkey:    livePubkey,
+			wantEndpoint:  liveEndpoint,
+		},
+		{
+			name:          "container unavailable falls back to config",
+			containerCode: http.StatusServiceUnavailable,
+			wantPubkey:    cfgPubkey,
+			wantEndpoint:  cfgEndpoint,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			container := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls.Add(1)
+					if r.URL.Path != "/info" {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					if tt.containerCode != http.StatusOK {
+						w.WriteHeader(tt.containerCode)
+						return
+					}
+					_ = json.NewEncoder(w).Encode(wireguard.InfoResponse{
+						ServerPubkey: livePubkey,
+						Endpoint:     liveEndpoint,
+					})
+				}),
+			)
+			defer container.Close()
+
+			a := &Api{
+				cfg: &config.Config{
+					Vpn: config.VpnConfig{
+						WGServerPubkey: cfgPubkey,
+						WGEndpoint:     cfgEndpoint,
+						WGDNS:          []string{"10.8.0.1", "10.8.0.2"},
+					},
+				},
+			}
+			wgClient := wireguard.NewClient(container.URL, nil, 0)
+
+			for range 2 {
+				req := httptest.NewRequest(http.MethodGet, "/api/wg/info", nil)
+				w := httptest.NewRecorder()
+				a.wgInfoImpl(w, req, wgClient)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf(
+						"status = %d, want %d (body: %s)",
+						w.Code,
+						http.StatusOK,
+						w.Body.String(),
+					)
+				}
+				var resp WGInfoResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if resp.ServerPubkey != tt.wantPubkey {
+					t.Errorf(
+						"server_pubkey = %q, want %q",
+						resp.ServerPubkey,
+						tt.wantPubkey,
+					)
+				}
+				if resp.Endpoint != tt.wantEndpoint {
+					t.Errorf(
+						"endpoint = %q, want %q",
+						resp.Endpoint,
+						tt.wantEndpoint,
+					)
+				}
+				if strings.Join(resp.DNS, ",") != "10.8.0.1,10.8.0.2" {
+					t.Errorf("dns = %v, want [10.8.0.1 10.8.0.2]", resp.DNS)
+				}
+			}
+
+			// The second request should be served from the cache
+			if got := calls.Load(); got != 1 {
+				t.Errorf("container calls = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestWGInfoMethodNotAllowed(t *testing.T) {
+	a := &Api{cfg: &config.Config{}}
+	req := httptest.NewRequest(http.MethodPost, "/api/wg/info", nil)
+	w := httptest.NewRecorder()
+	a.wgInfoImpl(w, req, nil)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf(
+			"status = %d, want %d",
+			w.Code,
+			http.StatusMethodNotAllowed,
+		)
+	}
+}
+
+func TestWGProfileEndpointValidation(t *testing.T) {
+	const peerPubkey = "cGVlci1wdWJrZXktcGxhY2Vob2xkZXItMDAwMDAwMDA="
+	a := newTestApi(t)
+	a.cfg.Vpn.WGServerPubkey = "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA="
+	assetName := []byte("test-client")
+	clientId := hex.EncodeToString(assetName)
+	credential := []byte("credential")
+
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	if err := a.db.AddWGPeer(assetName, peerPubkey, "10.8.0.2"); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+		hex.EncodeToString(credential),
+	)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		endpoint   string
+		wantStatus int
+	}{
+		{
+			name:       "valid endpoint",
+			endpoint:   "vpn.example.com:51820",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unset endpoint",
+			endpoint:   "",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "missing port",
+			endpoint:   "vpn.example.com",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "invalid port",
+			endpoint:   "vpn.example.com:99999",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.cfg.Vpn.WGEndpoint = tt.endpoint
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/wg-profile",
+				strings.NewReader(
+					`{"client_id":"`+clientId+`","wg_pubkey":"`+peerPubkey+`"}`,
+				),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			a.wgProfileImpl(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if !strings.Contains(
+					w.Body.String(),
+					"Endpoint = "+tt.endpoint+"\n",
+				) {
+					t.Errorf("profile missing endpoint:\n%s", w.Body.String())
+				}
+				return
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if resp.Error == "" {
+				t.Error("expected error message in response")
+			}
+		})
+	}
+}
+
+func TestWGDeviceLimitOverride(t *testing.T) {
+	const (
+		existingPubkey = "ZXhpc3RpbmctcHVia2V5LXBsYWNlaG9sZGVyLTAwMDA="
+		newPubkey      = "bmV3LXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDAwMDA="
+	)
+	a := newTestApi(t)
+	a.cfg.Vpn.WGMaxDevices = 3
+	a.cfg.Vpn.WGRegions = map[string]config.WGRegionConfig{
+		"limited": {MaxDevices: 1},
+	}
+	credential := []byte("credential")
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+		hex.EncodeToString(credential),
+	)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		assetName   string
+		region      string
+		deviceLimit int
+		wantLimit   int
+		// Expected status when registering a second device
+		wantRegisterStatus int
+	}{
+		{
+			name:               "custom limit",
+			assetName:          "limited-client",
+			deviceLimit:        1,
+			wantLimit:          1,
+			wantRegisterStatus: http.StatusForbidden,
+		},
+		{
+			name:        "premium limit",
+			assetName:   "premium-client",
+			deviceLimit: 10,
+			wantLimit:   10,
+		},
+		{
+			name:        "global default",
+			assetName:   "default-client",
+			deviceLimit: 0,
+			wantLimit:   3,
+		},
+		{
+			name:               "region limit",
+			assetName:          "regional-client",
+			region:             "limited",
+			deviceLimit:        0,
+			wantLimit:          1,
+			wantRegisterStatus: http.StatusForbidden,
+		},
+	}
+
+	for idx, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assetName := []byte(tt.assetName)
+			clientId := hex.EncodeToString(assetName)
+			region := cmp.Or(tt.region, "test")
+			if err := a.db.AddClient(
+				assetName,
+				time.Now().Add(time.Hour),
+				credential,
+				region,
+				[]byte("txhash"),
+				uint(idx), // nolint:gosec
+				tt.deviceLimit,
+			); err != nil {
+				t.Fatalf("failed to add client: %v", err)
+			}
+			// Pubkeys are globally unique, so suffix them per client, keeping
+			// the final character canonical
+			pubkey := existingPubkey[:40] + fmt.Sprintf("%02d", idx) + "A="
+			if err := a.db.AddWGPeer(assetName, pubkey, "10.8.0.2"); err != nil {
+				t.Fatalf("failed to add peer: %v", err)
+			}
+
+			// Device listing reports the effective limit
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/wg-devices",
+				strings.NewReader(`{"client_id":"`+clientId+`"}`),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			a.wgDevicesImpl(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf(
+					"devices status = %d, want %d (body: %s)",
+					w.Code,
+					http.StatusOK,
+					w.Body.String(),
+				)
+			}
+			var devicesResp WGDevicesResponse
+			if err := json.NewDecoder(w.Body).Decode(&devicesResp); err != nil {
+				t.Fatalf("failed to decode devices response: %v", err)
+			}
+			if devicesResp.Limit != tt.wantLimit {
+				t.Errorf("limit = %d, want %d", devicesResp.Limit, tt.wantLimit)
+			}
+
+			// Re-registering an existing device reports the effective limit
+			req = httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/wg-register",
+				strings.NewReader(
+					`{"client_id":"`+clientId+`","wg_pubkey":"`+pubkey+`"}`,
+				),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w = httptest.NewRecorder()
+			a.wgRegisterImpl(w, req, nil, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf(
+					"register status = %d, want %d (body: %s)",
+					w.Code,
+					http.StatusOK,
+					w.Body.String(),
+				)
+			}
+			var registerResp WGRegisterResponse
+			if err := json.NewDecoder(w.Body).Decode(&registerResp); err != nil {
+				t.Fatalf("failed to decode register response: %v", err)
+			}
+			if registerResp.DeviceLimit != tt.wantLimit {
+				t.Errorf(
+					"device_limit = %d, want %d",
+					registerResp.DeviceLimit,
+					tt.wantLimit,
+				)
+			}
+
+			if tt.wantRegisterStatus == 0 {
+				return
+			}
+			// Registering a new device beyond the limit is rejected
+			req = httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/wg-register",
+				strings.NewReader(
+					`{"client_id":"`+clientId+`","wg_pubkey":"`+newPubkey+`"}`,
+				),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w = httptest.NewRecorder()
+			a.wgRegisterImpl(w, req, nil, nil)
+			if w.Code != tt.wantRegisterStatus {
+				t.Fatalf(
+					"register status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantRegisterStatus,
+					w.Body.String(),
+				)
+			}
+			var errResp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			// JSON numbers decode as float64
+			if got := errResp.Details["device_limit"]; got != float64(tt.wantLimit) {
+				t.Errorf(
+					"details.device_limit = %v, want %d",
+					got,
+					tt.wantLimit,
+				)
+			}
+		})
+	}
+}
+
+func TestWGDeviceLimitReload(t *testing.T) {
+	a := newTestApi(t)
+	a.cfg.Vpn.WGMaxDevices = 3
+	var current atomic.Pointer[config.Config]
+	current.Store(a.cfg)
+	a.liveConfig = current.Load
+	credential := []byte("credential")
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+		hex.EncodeToString(credential),
+	)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+	assetName := []byte("reload-client")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+
+	deviceLimit := func() int {
+		t.Helper()
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/api/client/wg-devices",
+			strings.NewReader(
+				`{"client_id":"`+hex.EncodeToString(assetName)+`"}`,
+			),
+		)
+		req.Header.Set("Authorization", "Bearer "+sessionToken)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		a.wgDevicesImpl(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf(
+				"devices status = %d, want %d (body: %s)",
+				w.Code,
+				http.StatusOK,
+				w.Body.String(),
+			)
+		}
+		var resp WGDevicesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode devices response: %v", err)
+		}
+		return resp.Limit
+	}
+
+	if got := deviceLimit(); got != 3 {
+		t.Fatalf("limit before reload = %d, want %d", got, 3)
+	}
+	// Simulate a reload swapping in a config with a new device limit
+	reloaded := *a.cfg
+	reloaded.Vpn.WGMaxDevices = 5
+	current.Store(&reloaded)
+	if got := deviceLimit(); got != 5 {
+		t.Errorf("limit after reload = %d, want %d", got, 5)
+	}
+}
+
+func TestWGRegisterExpiredDetails(t *testing.T) {
+	const pubkey = "ZXhwaXJlZC1wdWJrZXktcGxhY2Vob2xkZXItMDAwMDA="
+	a := newTestApi(t)
+	a.cfg.Vpn.WGMaxDevices = 3
+	assetName := []byte("expired-client")
+	clientId := hex.EncodeToString(assetName)
+	credential := []byte("credential")
+	expiration := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	if err := a.db.AddClient(
+		assetName,
+		expiration,
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+		hex.EncodeToString(credential),
+	)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/api/client/wg-register",
+		strings.NewReader(
+			`{"client_id":"`+clientId+`","wg_pubkey":"`+pubkey+`"}`,
+		),
+	)
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	a.wgRegisterImpl(w, req, nil, nil)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf(
+			"status = %d, want %d (body: %s)",
+			w.Code,
+			http.StatusForbidden,
+			w.Body.String(),
+		)
+	}
+	var resp struct {
+		Error   string `json:"error"`
+		Reason  string `json:"reason"`
+		Details struct {
+			Expiration time.Time `json:"expiration"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	wantTimestamp := expiration.UTC().Format(time.RFC3339)
+	if !strings.Contains(resp.Reason, wantTimestamp) {
+		t.Errorf("reason = %q, want it to contain %q", resp.Reason, wantTimestamp)
+	}
+	if !resp.Details.Expiration.Equal(expiration) {
+		t.Errorf(
+			"details.expiration = %v, want %v",
+			resp.Details.Expiration,
+			expiration,
+		)
+	}
+}
+
+// newTestWGRegisterRequest adds an active client and returns an authenticated
+// wg-register request for pubkey
+func newTestWGRegisterRequest(
+	t *testing.T,
+	a *Api,
+	pubkey string,
+) *http.Request {
+	t.Helper()
+
+	assetName := []byte("register-client")
+	credential := []byte("credential")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+		hex.EncodeToString(credential),
+	)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/api/client/wg-register",
+		strings.NewReader(
+			`{"client_id":"`+hex.EncodeToString(assetName)+
+				`","wg_pubkey":"`+pubkey+`"}`,
+		),
+	)
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// memPeerStore is an in-memory PeerStore keyed by the hex client ID
+type memPeerStore struct {
+	mu    sync.Mutex
+	files map[string]*client.PeerFile
+	// saveErr is returned by SavePeerToS3WithContext when set
+	saveErr error
+}
+
+func newMemPeerStore() *memPeerStore {
+	return &memPeerStore{files: make(map[string]*client.PeerFile)}
+}
+
+func (s *memPeerStore) SavePeerToS3WithContext(
+	_ context.Context,
+	assetName []byte,
+	pubkey, assignedIP string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	key := hex.EncodeToString(assetName)
+	peerFile, ok := s.files[key]
+	if !ok {
+		peerFile = &client.PeerFile{AssetName: key}
+		s.files[key] = peerFile
+	}
+	peerFile.Peers = slices.DeleteFunc(
+		peerFile.Peers,
+		func(peer client.PeerInfo) bool { return peer.Pubkey == pubkey },
+	)
+	peerFile.Peers = append(peerFile.Peers, client.PeerInfo{
+		Pubkey:     pubkey,
+		AssignedIP: assignedIP,
+		CreatedAt:  time.Now().Unix(),
+	})
+	peerFile.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func (s *memPeerStore) RemovePeerFromS3WithContext(
+	_ context.Context,
+	assetName []byte,
+	pubkey string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peerFile, ok := s.files[hex.EncodeToString(assetName)]
+	if !ok {
+		return nil
+	}
+	peerFile.Peers = slices.DeleteFunc(
+		peerFile.Peers,
+		func(peer client.PeerInfo) bool { return peer.Pubkey == pubkey },
+	)
+	peerFile.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func (s *memPeerStore) RotatePeerInS3WithContext(
+	_ context.Context,
+	assetName []byte,
+	oldPubkey, newPubkey string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peerFile, ok := s.files[hex.EncodeToString(assetName)]
+	if !ok {
+		return errors.New("peer file not found")
+	}
+	for i, peer := range peerFile.Peers {
+		if peer.Pubkey == oldPubkey {
+			peerFile.Peers[i].Pubkey = newPubkey
+			peerFile.UpdatedAt = time.Now().Unix()
+			return nil
+		}
+	}
+	return errors.New("peer not found")
+}
+
+func (s *memPeerStore) LoadPeersFromS3(
+	assetName []byte,
+) (*client.PeerFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peerFile, ok := s.files[hex.EncodeToString(assetName)]
+	if !ok {
+		// Like S3, a missing peer file isn't an error
+		return nil, nil
+	}
+	ret := *peerFile
+	ret.Peers = slices.Clone(peerFile.Peers)
+	return &ret, nil
+}
+
+func TestWGRegisterPeerStore(t *testing.T) {
+	const pubkey = "cGVlci1zdG9yZS1wdWJrZXktcGxhY2Vob2xkZXItMDA="
+	a := newTestApi(t)
+	a.cfg.Vpn.WGMaxDevices = 3
+	store := newMemPeerStore()
+
+	// Record the peers added to the container
+	var mu sync.Mutex
+	var added []wireguard.AddPeerRequest
+	container := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req wireguard.AddPeerRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			added = append(added, req)
+			mu.Unlock()
+			_ = json.NewEncoder(w).Encode(wireguard.AddPeerResponse{
+				Success: true,
+			})
+		}),
+	)
+	defer container.Close()
+	wgClient := wireguard.NewClient(container.URL, a.jwtIssuer, 0)
+
+	req := newTestWGRegisterRequest(t, a, pubkey)
+	w := httptest.NewRecorder()
+	a.wgRegisterImpl(w, req, wgClient, store)
+	a.peerRetries.Wait()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf(
+			"status = %d, want %d (body: %s)",
+			w.Code,
+			http.StatusOK,
+			w.Body.String(),
+		)
+	}
+	var resp WGRegisterResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !resp.Success || resp.AssignedIP == "" || resp.DeviceCount != 1 ||
+		resp.DeviceLimit != 3 {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// The peer is in the store, the database cache and the container
+	peerFile, err := store.LoadPeersFromS3([]byte("register-client"))
+	if err != nil {
+		t.Fatalf("failed to load peers: %v", err)
+	}
+	if len(peerFile.Peers) != 1 ||
+		peerFile.Peers[0].Pubkey != pubkey ||
+		peerFile.Peers[0].AssignedIP != resp.AssignedIP {
+		t.Errorf("stored peers = %+v", peerFile.Peers)
+	}
+	peer, err := a.db.GetWGPeerByPubkey(pubkey)
+	if err != nil {
+		t.Fatalf("peer not cached: %v", err)
+	}
+	if peer.AssignedIP != resp.AssignedIP {
+		t.Errorf(
+			"cached IP = %q, want %q",
+			peer.AssignedIP,
+			resp.AssignedIP,
+		)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(added) != 1 || added[0].Pubkey != pubkey {
+		t.Errorf("container peers = %+v", added)
+	}
+}
+
+// TestWGRegisterDeviceCountSource registers a device for a client whose
+// devices are all in S3 but missing from the database cache
+func TestWGRegisterDeviceCountSource(t *testing.T) {
+	const pubkey = "Y291bnQtc291cmNlLXB1YmtleS1wbGFjZWhvbGRlciE="
+	tests := []struct {
+		name   string
+		source string
+		// noPeerFile registers the client's first device, before it has a
+		// peer file
+		noPeerFile bool
+		wantStatus int
+	}{
+		{
+			name:       "db",
+			source:     config.WGDeviceCountDB,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "s3",
+			source:     config.WGDeviceCountS3,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "s3 first device",
+			source:     config.WGDeviceCountS3,
+			noPeerFile: true,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.Vpn.WGMaxDevices = 2
+			a.cfg.Vpn.WGDeviceCountSource = tt.source
+			store := newMemPeerStore()
+			storedPeers := []client.PeerInfo{
+				{Pubkey: "s3-only-peer-1", AssignedIP: "10.8.0.2"},
+				{Pubkey: "s3-only-peer-2", AssignedIP: "10.8.0.3"},
+			}
+			if tt.noPeerFile {
+				storedPeers = nil
+			}
+			for _, peer := range storedPeers {
+				if err := store.SavePeerToS3WithContext(
+					t.Context(),
+					[]byte("register-client"),
+					peer.Pubkey,
+					peer.AssignedIP,
+				); err != nil {
+					t.Fatalf("failed to save peer: %v", err)
+				}
+			}
+
+			req := newTestWGRegisterRequest(t, a, pubkey)
+			w := httptest.NewRecorder()
+			a.wgRegisterImpl(w, req, &fakePeerManager{}, store)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tt.wantStatus != http.StatusForbidden {
+				return
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Details["device_count"] != float64(2) {
+				t.Errorf("details = %+v, want device_count 2", resp.Details)
+			}
+			peerFile, _ := store.LoadPeersFromS3([]byte("register-client"))
+			if len(peerFile.Peers) != 2 {
+				t.Errorf("rejected device was stored: %+v", peerFile.Peers)
+			}
+		})
+	}
+}
+
+// fakePeerManager records the peers added to the WG container
+type fakePeerManager struct {
+	mu sync.Mutex
+	// store is checked for the peer when it's added, when set
+	store   *memPeerStore
+	addErr  error
+	added   []string
+	inStore []bool
+	removed []string
+}
+
+func (m *fakePeerManager) AddPeerWithContext(
+	_ context.Context,
+	pubkey, allowedIP string,
+) (*wireguard.AddPeerResponse, error) {
+	inStore := false
+	if m.store != nil {
+		peerFile, _ := m.store.LoadPeersFromS3([]byte("register-client"))
+		inStore = peerFile != nil && slices.ContainsFunc(
+			peerFile.Peers,
+			func(peer client.PeerInfo) bool {
+				return peer.Pubkey == pubkey && peer.AssignedIP == allowedIP
+			},
+		)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.added = append(m.added, pubkey)
+	m.inStore = append(m.inStore, inStore)
+	if m.addErr != nil {
+		return nil, m.addErr
+	}
+	return &wireguard.AddPeerResponse{Success: true}, nil
+}
+
+func (m *fakePeerManager) RemovePeerWithContext(
+	_ context.Context,
+	pubkey, _ string,
+) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removed = append(m.removed, pubkey)
+	return nil
+}
+
+func TestWGRegisterPeerManager(t *testing.T) {
+	const pubkey = "cGVlci1tYW5hZ2VyLXB1YmtleS1wbGFjZWhvbGRlciE="
+	tests := []struct {
+		name       string
+		saveErr    error
+		addErr     error
+		wantStatus int
+		wantAdds   int
+	}{
+		{
+			name:       "added after save",
+			wantStatus: http.StatusOK,
+			wantAdds:   1,
+		},
+		{
+			name:       "not added when save fails",
+			saveErr:    errors.New("S3 unavailable"),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "retried when add fails",
+			addErr:     errors.New("container unavailable"),
+			wantStatus: http.StatusOK,
+			wantAdds:   1 + AddPeerRetryAttempts,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.Vpn.WGMaxDevices = 3
+			a.peerRetryInterval = time.Millisecond
+			store := newMemPeerStore()
+			store.saveErr = tt.saveErr
+			manager := &fakePeerManager{store: store, addErr: tt.addErr}
+
+			req := newTestWGRegisterRequest(t, a, pubkey)
+			w := httptest.NewRecorder()
+			a.wgRegisterImpl(w, req, manager, store)
+			a.peerRetries.Wait()
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tt.wantStatus,
+					w.Body.String(),
+				)
+			}
+			manager.mu.Lock()
+			defer manager.mu.Unlock()
+			if len(manager.added) != tt.wantAdds {
+				t.Fatalf(
+					"container adds = %d, want %d",
+					len(manager.added),
+					tt.wantAdds,
+				)
+			}
+			for i, inStore := range manager.inStore {
+				if !inStore {
+					t.Errorf("container add %d happened before the S3 save", i)
+				}
+			}
+		})
+	}
+}
+
+func TestWGRegisterRequestID(t *testing.T) {
+	const (
+		pubkey    = "cmVxdWVzdC1pZC1wdWJrZXktcGxhY2Vob2xkZXItMDA="
+		requestID = "test-request-id"
+	)
+	a := newTestApi(t)
+	a.cfg.Vpn.WGMaxDevices = 3
+	newTestS3Store(t, a)
+	a.peerRetryInterval = time.Millisecond
+
+	// The container rejects the peer so the handler logs the failure
+	var containerRequestID atomic.Value
+	container := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			containerRequestID.Store(r.Header.Get(requestid.Header))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	)
+	defer container.Close()
+	wgClient := wireguard.NewClient(container.URL, a.jwtIssuer, 0)
+
+	var logBuf syncBuffer
+	prevLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(
+		&logBuf,
+		&slog.HandlerOptions{Level: slog.LevelDebug},
+	)))
+	t.Cleanup(func() { slog.SetDefault(prevLogger) })
+
+	handler := a.requestIDMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a.wgRegisterImpl(w, r, wgClient, client.NewWithConfig(a.cfg))
+		}),
+	)
+	req := newTestWGRegisterRequest(t, a, pubkey)
+	req.Header.Set(requestid.Header, requestID)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	a.peerRetries.Wait()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf(
+			"status = %d, want %d (body: %s)",
+			w.Code,
+			http.StatusOK,
+			w.Body.String(),
+		)
+	}
+	if got := w.Header().Get(requestid.Header); got != requestID {
+		t.Errorf("response %s = %q, want %q", requestid.Header, got, requestID)
+	}
+	if got, _ := containerRequestID.Load().(string); got != requestID {
+		t.Errorf("container %s = %q, want %q", requestid.Header, got, requestID)
+	}
+
+	// The S3 write, the container failure, and the background retries are
+	// all logged with the ID
+	wantMessages := map[string]bool{
+		"saved peer to S3":                                            false,
+		"failed to add peer to WG container":                          false,
+		"giving up adding peer to WG container, will sync on restart": false,
+	}
+	for line := range strings.SplitSeq(strings.TrimSpace(logBuf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode log line %q: %v", line, err)
+		}
+		msg, _ := record["msg"].(string)
+		if _, ok := wantMessages[msg]; !ok {
+			continue
+		}
+		if record["request_id"] != requestID {
+			t.Errorf("log %q request_id = %v, want %q", msg, record["request_id"], requestID)
+		}
+		wantMessages[msg] = true
+	}
+	for msg, found := range wantMessages {
+		if !found {
+			t.Errorf("missing log %q:\n%s", msg, logBuf.String())
+		}
+	}
+}
+
+func TestWGRegisterRetryAddPeer(t *testing.T) {
+	const pubkey = "cmV0cnktYWRkLXBlZXItcHVia2V5LXBsYWNlaG9sZCE="
+
+	tests := []struct {
+		name      string
+		failures  int32
+		wantPosts int32
+		wantAdded bool
+	}{
+		{
+			name:      "transient failure",
+			failures:  2,
+			wantPosts: 3,
+			wantAdded: true,
+		},
+		{
+			name:      "persistent failure",
+			failures:  100,
+			wantPosts: 1 + AddPeerRetryAttempts,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.Vpn.WGMaxDevices = 3
+			a.peerRetryInterval = time.Millisecond
+			newTestS3Store(t, a)
+
+			// The container fails the first few adds, then accepts the peer
+			var posts atomic.Int32
+			var added atomic.Bool
+			container := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method != http.MethodPost {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					if posts.Add(1) <= tc.failures {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					added.Store(true)
+					_ = json.NewEncoder(w).Encode(wireguard.AddPeerResponse{
+						Success: true,
+					})
+				}),
+			)
+			defer container.Close()
+			wgClient := wireguard.NewClient(container.URL, a.jwtIssuer, 0)
+
+			w := httptest.NewRecorder()
+			a.wgRegisterImpl(
+				w,
+				newTestWGRegisterRequest(t, a, pubkey),
+				wgClient,
+				client.NewWithConfig(a.cfg),
+			)
+			if w.Code != http.StatusOK {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					http.StatusOK,
+					w.Body.String(),
+				)
+			}
+
+			// Retries are bounded, so this returns once they are done
+			a.peerRetries.Wait()
+			if got := posts.Load(); got != tc.wantPosts {
+				t.Errorf("container adds = %d, want %d", got, tc.wantPosts)
+			}
+			if added.Load() != tc.wantAdded {
+				t.Errorf("peer added = %v, want %v", added.Load(), tc.wantAdded)
+			}
+		})
+	}
+}
+
+func TestWGRegisterCacheWriteRetry(t *testing.T) {
+	const pubkey = "Y2FjaGUtd3JpdGUtcmV0cnktcHVia2V5LXBsYWNlaG8="
+
+	tests := []struct {
+		name       string
+		verify     bool
+		failures   int32
+		wantCalls  int32
+		wantCached bool
+		wantStale  bool
+	}{
+		{
+			name:      "failure without verification",
+			failures:  1,
+			wantCalls: 1,
+		},
+		{
+			name:       "transient failure",
+			verify:     true,
+			failures:   2,
+			wantCalls:  3,
+			wantCached: true,
+		},
+		{
+			name:      "persistent failure",
+			verify:    true,
+			failures:  100,
+			wantCalls: 1 + CacheWriteRetryAttempts,
+			wantStale: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.Vpn.WGMaxDevices = 3
+			a.cfg.Vpn.WGVerifyCacheWrite = tc.verify
+			a.cacheRetryInterval = time.Millisecond
+			newTestS3Store(t, a)
+
+			// The database fails the first few writes, then accepts the peer
+			var calls atomic.Int32
+			origAddWGPeer := addWGPeer
+			t.Cleanup(func() { addWGPeer = origAddWGPeer })
+			addWGPeer = func(
+				d *database.Database,
+				assetName []byte,
+				pubkey string,
+				assignedIP string,
+			) error {
+				if calls.Add(1) <= tc.failures {
+					return errors.New("database is locked")
+				}
+				return origAddWGPeer(d, assetName, pubkey, assignedIP)
+			}
+
+			w := httptest.NewRecorder()
+			a.wgRegisterImpl(
+				w,
+				newTestWGRegisterRequest(t, a, pubkey),
+				nil,
+				client.NewWithConfig(a.cfg),
+			)
+			if w.Code != http.StatusOK {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					http.StatusOK,
+					w.Body.String(),
+				)
+			}
+			var resp WGRegisterResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if !resp.Success {
+				t.Error("success = false, want true")
+			}
+			if resp.CacheStale != tc.wantStale {
+				t.Errorf(
+					"cache_stale = %v, want %v",
+					resp.CacheStale,
+					tc.wantStale,
+				)
+			}
+			if got := calls.Load(); got != tc.wantCalls {
+				t.Errorf("database writes = %d, want %d", got, tc.wantCalls)
+			}
+			_, err := a.db.GetWGPeerByPubkey(pubkey)
+			if cached := err == nil; cached != tc.wantCached {
+				t.Errorf("peer cached = %v, want %v", cached, tc.wantCached)
+			}
+		})
+	}
+}
+
+func TestRetryAddPeerStopsForRemovedPeer(t *testing.T) {
+	a := newTestApi(t)
+	a.peerRetryInterval = time.Millisecond
+
+	var posts atomic.Int32
+	container := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			posts.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	)
+	defer container.Close()
+	wgClient := wireguard.NewClient(container.URL, a.jwtIssuer, 0)
+
+	// The peer is not in the database, as if it was deleted after register
+	a.retryAddPeer(
+		context.Background(),
+		wgClient,
+		"cmVtb3ZlZC1wZWVyLXB1YmtleS1wbGFjZWhvbGRlci0w",
+		"10.8.0.2",
+	)
+	if got := posts.Load(); got != 0 {
+		t.Errorf("container adds = %d, want 0", got)
+	}
+}
+
+func TestRequestIDMiddlewareGeneratesID(t *testing.T) {
+	a := &Api{cfg: &config.Config{}}
+	var seen string
+	handler := a.requestIDMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = requestid.FromContext(r.Context())
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/healthcheck", nil)
+	// Unsafe IDs are replaced rather than propagated
+	req.Header.Set(requestid.Header, "bad id\ninjected")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if !requestid.Valid(seen) {
+		t.Fatalf("generated request ID %q is not valid", seen)
+	}
+	if got := w.Header().Get(requestid.Header); got != seen {
+		t.Errorf("response %s = %q, want %q", requestid.Header, got, seen)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes from log handlers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWGRotate(t *testing.T) {
+	const (
+		oldPubkey   = "cm90YXRlLW9sZC1wdWJrZXktcGxhY2Vob2xkZXItMDA="
+		newPubkey   = "cm90YXRlLW5ldy1wdWJrZXktcGxhY2Vob2xkZXItMDA="
+		otherPubkey = "cm90YXRlLW90aGVyLXB1YmtleS1wbGFjZWhvbGRlci0="
+	)
+
+	tests := []struct {
+		name       string
+		oldPubkey  string
+		newPubkey  string
+		clientID   string
+		wantStatus int
+	}{
+		{
+			name:       "rotate",
+			oldPubkey:  oldPubkey,
+			newPubkey:  newPubkey,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid new pubkey",
+			oldPubkey:  oldPubkey,
+			newPubkey:  "not-a-key",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "same pubkey",
+			oldPubkey:  oldPubkey,
+			newPubkey:  oldPubkey,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "new pubkey in use",
+			oldPubkey:  oldPubkey,
+			newPubkey:  otherPubkey,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "unknown device",
+			oldPubkey:  newPubkey,
+			newPubkey:  otherPubkey,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "device owned by another client",
+			oldPubkey:  otherPubkey,
+			newPubkey:  newPubkey,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestApi(t)
+			a.cfg.Vpn.WGMaxDevices = 3
+			newTestS3Store(t, a)
+			s3Client := client.NewWithConfig(a.cfg)
+
+			// Register the device to rotate, plus one owned by someone else
+			w := httptest.NewRecorder()
+			a.wgRegisterImpl(
+				w,
+				newTestWGRegisterRequest(t, a, oldPubkey),
+				nil,
+				s3Client,
+			)
+			if w.Code != http.StatusOK {
+				t.Fatalf("register status = %d: %s", w.Code, w.Body.String())
+			}
+			if err := a.db.AddWGPeer(
+				[]byte("other-client"),
+				otherPubkey,
+				"10.8.0.99",
+			); err != nil {
+				t.Fatalf("failed to add peer: %v", err)
+			}
+			before, err := a.db.GetWGPeerByPubkey(oldPubkey)
+			if err != nil {
+				t.Fatalf("failed to get peer: %v", err)
+			}
+
+			// Record the container operations
+			var mu sync.Mutex
+			var ops []string
+			container := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					pubkey := r.URL.Query().Get("pubkey")
+					if r.Method == http.MethodPost {
+						var req wireguard.AddPeerRequest
+						_ = json.NewDecoder(r.Body).Decode(&req)
+						pubkey = req.Pubkey
+					}
+					mu.Lock()
+					ops = append(ops, r.Method+" "+pubkey)
+					mu.Unlock()
+					_ = json.NewEncoder(w).Encode(wireguard.AddPeerResponse{
+						Success: true,
+					})
+				}),
+			)
+			defer container.Close()
+			wgClient := wireguard.NewClient(container.URL, a.jwtIssuer, 0)
+
+			sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+				hex.EncodeToString([]byte("credential")),
+			)
+			if err != nil {
+				t.Fatalf("failed to issue session token: %v", err)
+			}
+			body, _ := json.Marshal(map[string]string{
+				"client_id":     hex.EncodeToString([]byte("register-client")),
+				"old_wg_pubkey": tc.oldPubkey,
+				"new_wg_pubkey": tc.newPubkey,
+			})
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/wg-rotate",
+				bytes.NewReader(body),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			w = httptest.NewRecorder()
+			a.wgRotateImpl(w, req, wgClient, s3Client)
+			a.peerRetries.Wait()
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf(
+					"status = %d, want %d (body: %s)",
+					w.Code,
+					tc.wantStatus,
+					w.Body.String(),
+				)
+			}
+			if tc.wantStatus != http.StatusOK {
+				if len(ops) != 0 {
+					t.Errorf("unexpected container operations: %v", ops)
+				}
+				if _, err := a.db.GetWGPeerByPubkey(oldPubkey); err != nil {
+					t.Errorf("original peer missing after failed rotate: %v", err)
+				}
+				return
+			}
+
+			var resp WGRotateResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.AssignedIP != before.AssignedIP {
+				t.Errorf(
+					"assigned_ip = %q, want %q",
+					resp.AssignedIP,
+					before.AssignedIP,
+				)
+			}
+
+			// DB row keeps its IP and creation time under the new key
+			after, err := a.db.GetWGPeerByPubkey(newPubkey)
+			if err != nil {
+				t.Fatalf("failed to get rotated peer: %v", err)
+			}
+			if after.AssignedIP != before.AssignedIP ||
+				!after.CreatedAt.Equal(before.CreatedAt) {
+				t.Errorf("rotated peer %+v does not match %+v", after, before)
+			}
+
+			// S3 registry holds only the new key with the same IP
+			peerFile, err := s3Client.LoadPeersFromS3([]byte("register-client"))
+			if err != nil {
+				t.Fatalf("failed to load peer file: %v", err)
+			}
+			if len(peerFile.Peers) != 1 ||
+				peerFile.Peers[0].Pubkey != newPubkey ||
+				peerFile.Peers[0].AssignedIP != before.AssignedIP {
+				t.Errorf("unexpected S3 peers: %+v", peerFile.Peers)
+			}
+
+			// Container swapped the old key for the new one
+			wantOps := []string{
+				http.MethodDelete + " " + oldPubkey,
+				http.MethodPost + " " + newPubkey,
+			}
+			if !slices.Equal(ops, wantOps) {
+				t.Errorf("container operations = %v, want %v", ops, wantOps)
+			}
+		})
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "*/*", want: false},
+		{accept: "text/plain", want: false},
+		{accept: "application/json", want: true},
+		{accept: "application/json, text/plain", want: false},
+		{accept: "text/plain;q=0.5, application/json", want: true},
+		{accept: "application/json;q=0.9, */*;q=0.1", want: true},
+		{accept: "application/json;q=0.5, text/*", want: false},
+		{accept: "application/xml", want: false},
+		{accept: "application/json;q=bad", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := prefersJSON(tt.accept); got != tt.want {
+				t.Errorf("prefersJSON(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWGProfileContentNegotiation(t *testing.T) {
+	const peerPubkey = "cGVlci1wdWJrZXktcGxhY2Vob2xkZXItMDAwMDAwMDA="
+	a := newTestApi(t)
+	a.cfg.Vpn.WGServerPubkey = "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA="
+	a.cfg.Vpn.WGEndpoint = "vpn.example.com:51820"
+	assetName := []byte("test-client")
+	credential := []byte("credential")
+	if err := a.db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		credential,
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	if err := a.db.AddWGPeer(assetName, peerPubkey, "10.8.0.2"); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	sessionToken, _, err := a.jwtIssuer.IssueSessionJWT(
+		hex.EncodeToString(credential),
+	)
+	if err != nil {
+		t.Fatalf("failed to issue session token: %v", err)
+	}
+	wantConfig := renderWGConfig(&a.cfg.Vpn, "10.8.0.2")
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+	}{
+		{name: "no accept", accept: "", wantContentType: "text/plain"},
+		{name: "text", accept: "text/plain", wantContentType: "text/plain"},
+		{name: "json", accept: "application/json", wantContentType: "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/api/client/wg-profile",
+				strings.NewReader(
+					`{"client_id":"`+hex.EncodeToString(assetName)+
+						`","wg_pubkey":"`+peerPubkey+`"}`,
+				),
+			)
+			req.Header.Set("Authorization", "Bearer "+sessionToken)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			a.wgProfileImpl(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d (body: %s)", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.wantContentType)
+			}
+			got := w.Body.String()
+			if tt.wantContentType == "application/json" {
+				var resp WGProfileResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				got = resp.Config
+			}
+			if got != wantConfig {
+				t.Errorf("config = %q, want %q", got, wantConfig)
+			}
+		})
+	}
+}
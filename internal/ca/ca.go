@@ -17,35 +17,52 @@ package ca
 import (
 	"bytes"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"math/big"
 	"os"
 	"time"
 
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
 	"github.com/youmark/pkcs8"
 	"golang.org/x/crypto/blake2b"
 )
 
+// clientSerialNumber derives the serial number for a client cert. It's
+// replaced in tests.
+var clientSerialNumber = ClientNameToSerialNumber
+
 type Ca struct {
 	caCert    *x509.Certificate
 	caKey     crypto.Signer
 	caCertPem []byte
+	// db records issued serials to detect collisions. It's optional.
+	db *database.Database
 }
 
 type ClientCert struct {
 	CaCert string
 	Cert   string
 	Key    string
+	// Serial is the serial number of Cert
+	Serial *big.Int
+	// NotAfter is when Cert expires
+	NotAfter time.Time
 }
 
-func New(cfg *config.Config) (*Ca, error) {
-	c := &Ca{}
+// New loads the CA cert and key from the config. When db is provided, the
+// serials of issued client certs are recorded in it so collisions are caught.
+func New(cfg *config.Config, db *database.Database) (*Ca, error) {
+	c := &Ca{
+		db: db,
+	}
 	// Certificate
 	if err := c.loadCert(cfg); err != nil {
 		return nil, err
@@ -54,9 +71,53 @@ func New(cfg *config.Config) (*Ca, error) {
 	if err := c.loadKey(cfg); err != nil {
 		return nil, err
 	}
+	// Make sure the key can sign for the cert now rather than when the first
+	// client cert is generated
+	if err := c.selfTest(); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
+// selfTest signs a throwaway cert with the CA key and verifies it against the
+// CA cert, which fails when the key doesn't match the cert
+func (c *Ca) selfTest() error {
+	pubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "vpn-indexer CA self-test",
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Minute),
+	}
+	certBytes, err := x509.CreateCertificate(
+		rand.Reader,
+		cert,
+		c.caCert,
+		pubKey,
+		c.caKey,
+	)
+	if err != nil {
+		return fmt.Errorf("CA key does not match CA certificate: %w", err)
+	}
+	tmpCert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return fmt.Errorf("parse CA self-test certificate: %w", err)
+	}
+	if err := c.caCert.CheckSignature(
+		tmpCert.SignatureAlgorithm,
+		tmpCert.RawTBSCertificate,
+		tmpCert.Signature,
+	); err != nil {
+		return fmt.Errorf("CA key does not match CA certificate: %w", err)
+	}
+	return nil
+}
+
 func (c *Ca) loadCert(cfg *config.Config) error {
 	var certData []byte
 	if cfg.Ca.Cert != "" {
@@ -122,9 +183,43 @@ func (c *Ca) loadKey(cfg *config.Config) error {
 	return nil
 }
 
+// GenerateClientCert issues a client cert with a serial derived from the
+// client name. It returns database.ErrSerialCollision when that serial was
+// already issued to another client.
 func (c *Ca) GenerateClientCert(clientName string) (*ClientCert, error) {
 	// Generate cert serial number from client name
-	clientSerial := ClientNameToSerialNumber(clientName)
+	return c.issueClientCert(clientName, clientSerialNumber(clientName))
+}
+
+// ReissueClientCert issues a replacement client cert. The serial is derived
+// from the client name and reissue count instead of only the client name, so
+// the cert it replaces can be revoked without revoking the new one. The
+// reissue count must be unique for the client and greater than zero.
+func (c *Ca) ReissueClientCert(
+	clientName string,
+	reissue int,
+) (*ClientCert, error) {
+	if reissue < 1 {
+		return nil, fmt.Errorf("invalid reissue count: %d", reissue)
+	}
+	return c.issueClientCert(
+		clientName,
+		clientSerialNumber(fmt.Sprintf("%s/%d", clientName, reissue)),
+	)
+}
+
+func (c *Ca) issueClientCert(
+	clientName string,
+	clientSerial *big.Int,
+) (*ClientCert, error) {
+	if c.db != nil {
+		if err := c.db.RecordIssuedSerial(
+			clientSerial.Bytes(),
+			clientName,
+		); err != nil {
+			return nil, err
+		}
+	}
 	// Cert template
 	cert := &x509.Certificate{
 		SerialNumber: clientSerial,
@@ -177,9 +272,11 @@ func (c *Ca) GenerateClientCert(clientName string) (*ClientCert, error) {
 		return nil, err
 	}
 	ret := &ClientCert{
-		CaCert: string(c.caCertPem),
-		Cert:   certPem.String(),
-		Key:    keyPem.String(),
+		CaCert:   string(c.caCertPem),
+		Cert:     certPem.String(),
+		Key:      keyPem.String(),
+		Serial:   clientSerial,
+		NotAfter: cert.NotAfter,
 	}
 	return ret, nil
 }
@@ -212,6 +309,8 @@ func (c *Ca) GenerateCRL(
 	return ret, nil
 }
 
+// ClientNameToSerialNumber returns the serial number of the cert issued to a
+// client, which is also used to revoke it in the CRL
 func ClientNameToSerialNumber(clientName string) *big.Int {
 	// Hash client name using blake2b-160 to use as cert serial number
 	hasher, _ := blake2b.New(20, nil)
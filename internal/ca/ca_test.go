@@ -15,16 +15,20 @@
 package ca
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"math/big"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
 )
 
 // Generated with:
@@ -102,7 +106,7 @@ func TestCaLoadCertKey(t *testing.T) {
 			Key:  testCaKey,
 		},
 	}
-	_, err := New(cfg)
+	_, err := New(cfg, nil)
 	if err != nil {
 		t.Fatalf("unexpected error creating CA: %s", err)
 	}
@@ -116,12 +120,41 @@ func TestCaLoadCertKeyEnc(t *testing.T) {
 			Passphrase: testCaKeyEncPassphrase,
 		},
 	}
-	_, err := New(cfg)
+	_, err := New(cfg, nil)
 	if err != nil {
 		t.Fatalf("unexpected error creating CA: %s", err)
 	}
 }
 
+func TestCaLoadCertKeyMismatch(t *testing.T) {
+	// A key other than the one the CA cert was issued for
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(otherKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	cfg := &config.Config{
+		Ca: config.CaConfig{
+			Cert: testCaCert,
+			Key: string(
+				pem.EncodeToMemory(
+					&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes},
+				),
+			),
+		},
+	}
+	_, err = New(cfg, nil)
+	if err == nil {
+		t.Fatal("expected error creating CA with mismatched cert and key")
+	}
+	if !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("unexpected error creating CA: %s", err)
+	}
+}
+
 func TestCaCreateClient(t *testing.T) {
 	testClientName := "test-client"
 	expectedCertSerial := "1257c92663bc26742ef2230f60e585466f48e514"
@@ -131,7 +164,7 @@ func TestCaCreateClient(t *testing.T) {
 			Key:  testCaKey,
 		},
 	}
-	c, err := New(cfg)
+	c, err := New(cfg, nil)
 	if err != nil {
 		t.Fatalf("unexpected error creating CA: %s", err)
 	}
@@ -174,7 +207,7 @@ func TestCaCreateClientEncKey(t *testing.T) {
 			Passphrase: testCaKeyEncPassphrase,
 		},
 	}
-	c, err := New(cfg)
+	c, err := New(cfg, nil)
 	if err != nil {
 		t.Fatalf("unexpected error creating CA: %s", err)
 	}
@@ -207,6 +240,49 @@ func TestCaCreateClientEncKey(t *testing.T) {
 	}
 }
 
+func TestCaSerialCollision(t *testing.T) {
+	// Derive the same serial for every client name
+	origSerial := clientSerialNumber
+	t.Cleanup(func() { clientSerialNumber = origSerial })
+	clientSerialNumber = func(string) *big.Int {
+		return big.NewInt(1234)
+	}
+
+	cfg := &config.Config{
+		Ca: config.CaConfig{
+			Cert: testCaCert,
+			Key:  testCaKey,
+		},
+		Database: config.DatabaseConfig{
+			Directory: t.TempDir(),
+		},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error opening database: %s", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	c, err := New(cfg, db)
+	if err != nil {
+		t.Fatalf("unexpected error creating CA: %s", err)
+	}
+	if _, err := c.GenerateClientCert("client-a"); err != nil {
+		t.Fatalf("unexpected error generating client cert: %s", err)
+	}
+	// Reissuing to the same client keeps its serial
+	if _, err := c.GenerateClientCert("client-a"); err != nil {
+		t.Fatalf("unexpected error regenerating client cert: %s", err)
+	}
+	_, err = c.GenerateClientCert("client-b")
+	if !errors.Is(err, database.ErrSerialCollision) {
+		t.Fatalf(
+			"did not get expected error: got %v, wanted %v",
+			err,
+			database.ErrSerialCollision,
+		)
+	}
+}
+
 func TestCaGenerateCRL(t *testing.T) {
 	testRevokedCerts := []pkix.RevokedCertificate{
 		{
@@ -241,7 +317,7 @@ oc9CHTH5lsWcmzc=
 			Passphrase: testCaKeyEncPassphrase,
 		},
 	}
-	c, err := New(cfg)
+	c, err := New(cfg, nil)
 	if err != nil {
 		t.Fatalf("unexpected error creating CA: %s", err)
 	}
@@ -262,3 +338,37 @@ oc9CHTH5lsWcmzc=
 		)
 	}
 }
+
+func TestCaReissueClient(t *testing.T) {
+	testClientName := "test-client"
+	cfg := &config.Config{
+		Ca: config.CaConfig{
+			Cert: testCaCert,
+			Key:  testCaKey,
+		},
+	}
+	c, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating CA: %s", err)
+	}
+	serials := []*big.Int{ClientNameToSerialNumber(testClientName)}
+	for _, reissue := range []int{1, 2} {
+		client, err := c.ReissueClientCert(testClientName, reissue)
+		if err != nil {
+			t.Fatalf("unexpected error reissuing client cert: %s", err)
+		}
+		for _, serial := range serials {
+			if client.Serial.Cmp(serial) == 0 {
+				t.Fatalf(
+					"reissue %d reused serial %s",
+					reissue,
+					serial,
+				)
+			}
+		}
+		serials = append(serials, client.Serial)
+	}
+	if _, err := c.ReissueClientCert(testClientName, 0); err == nil {
+		t.Fatal("expected error reissuing with a zero reissue count")
+	}
+}
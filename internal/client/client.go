@@ -16,10 +16,15 @@ package client
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -29,61 +34,39 @@ import (
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/blinklabs-io/vpn-indexer/internal/ca"
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
 )
 
-const profileTemplate = `
-client
-dev tun
-proto tcp
-remote %s %d
-nobind
-persist-tun
-persist-remote-ip
-
-# Encryption and TLS
-cipher AES-256-GCM
-tls-version-min 1.3
-auth SHA256
-remote-cert-tls server
-auth-nocache
-
-# Security hardening
-pull-filter ignore "auth-user-pass"
-
-# Disable compression for privacy
-comp-lzo no
-
-# Minimize logging
-verb 0
-mute 10
-
-# DNS and routing
-dhcp-option DNS %s
-redirect-gateway def1
-
-<cert>
-%s
-</cert>
-
-<key>
-%s
-</key>
-
-<ca>
-%s
-</ca>
-`
+// profileHTTPClient fetches profiles from pre-signed S3 URLs
+var profileHTTPClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// ErrS3NotConfigured is returned by NewS3 when no S3 client bucket is
+// configured
+var ErrS3NotConfigured = errors.New(
+	"S3 client bucket not configured (set S3_CLIENT_BUCKET)",
+)
 
 type Client struct {
 	config    *config.Config
 	ca        *ca.Ca
+	db        *database.Database
 	assetName []byte
 }
 
-func New(cfg *config.Config, caObj *ca.Ca, assetName []byte) *Client {
+// New creates a Client for the profile of an asset. When db is provided,
+// generated certs are recorded in it.
+func New(
+	cfg *config.Config,
+	caObj *ca.Ca,
+	db *database.Database,
+	assetName []byte,
+) *Client {
 	return &Client{
 		config:    cfg,
 		ca:        caObj,
+		db:        db,
 		assetName: assetName,
 	}
 }
@@ -97,12 +80,32 @@ func NewWithConfig(cfg *config.Config) *Client {
 	}
 }
 
+// NewS3 creates a Client like NewWithConfig for callers that need S3. It
+// returns ErrS3NotConfigured if the config has no client bucket.
+func NewS3(cfg *config.Config) (*Client, error) {
+	if strings.TrimSpace(cfg.S3.ClientBucket) == "" {
+		return nil, ErrS3NotConfigured
+	}
+	return NewWithConfig(cfg), nil
+}
+
 func (c *Client) Generate(host string, port int, dns string) (string, error) {
 	if ok, err := c.ProfileExists(); err != nil {
 		return "", err
 	} else if ok {
 		return c.identifier(), nil
 	}
+	return c.generate(host, port, dns, false)
+}
+
+// generate issues a cert for the client and uploads a profile containing it,
+// replacing any existing profile. reissue is passed to newCert.
+func (c *Client) generate(
+	host string,
+	port int,
+	dns string,
+	reissue bool,
+) (string, error) {
 	// Validate and set default DNS
 	if dns == "" {
 		dns = "10.8.0.1"
@@ -111,27 +114,24 @@ func (c *Client) Generate(host string, port int, dns string) (string, error) {
 		return "", fmt.Errorf("invalid DNS IP: %s", dns)
 	}
 	// Generate certs for client
-	certs, err := c.ca.GenerateClientCert(c.identifier())
+	certs, err := c.newCert(reissue)
 	if err != nil {
 		return "", err
 	}
+	if err := verifyClientCert(certs); err != nil {
+		return "", err
+	}
 	// Generate profile from template
-	profile := fmt.Sprintf(
-		profileTemplate,
-		host,
-		port,
-		dns,
-		certs.Cert,
-		certs.Key,
-		certs.CaCert,
-	)
+	profile := c.renderProfile(host, port, dns, certs)
 	// Upload profile to S3
 	svc, err := c.createS3Client()
 	if err != nil {
 		return "", err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.opTimeout())
+	defer cancel()
 	_, err = svc.PutObject(
-		context.TODO(),
+		ctx,
 		&s3.PutObjectInput{
 			Bucket: aws.String(c.config.S3.ClientBucket),
 			Key:    aws.String(c.profileKey()),
@@ -141,16 +141,152 @@ func (c *Client) Generate(host string, port int, dns string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// Record the issued cert. The profile is already in S3, so a failure here
+	// doesn't fail generation: the CRL falls back to the derived serial.
+	if c.db != nil {
+		if err := c.db.AddIssuedCert(
+			certs.Serial.Bytes(),
+			c.assetName,
+			certs.NotAfter,
+			c.profileKey(),
+		); err != nil {
+			slog.Warn(
+				"failed to record issued client cert",
+				"client", c.identifier(),
+				"error", err,
+			)
+		}
+	}
 	return c.identifier(), nil
 }
 
+// Regenerate replaces the client's profile with one containing a newly issued
+// cert. The new profile is uploaded over the old one before the certs
+// previously issued to the client are revoked, so the client always has a
+// usable profile. The revocation takes effect on the next CRL update.
+func (c *Client) Regenerate(
+	host string,
+	port int,
+	dns string,
+) (string, error) {
+	if c.db == nil {
+		return "", errors.New("regenerating a profile requires a database")
+	}
+	issued, err := c.db.IssuedCertsByAsset(c.assetName)
+	if err != nil {
+		return "", err
+	}
+	if len(issued) == 0 {
+		// Profiles generated before issued certs were recorded use the serial
+		// derived from the client name. Record it so it's revoked below.
+		if err := c.db.AddIssuedCert(
+			ca.ClientNameToSerialNumber(c.identifier()).Bytes(),
+			c.assetName,
+			time.Time{},
+			c.profileKey(),
+		); err != nil {
+			return "", err
+		}
+		issued, err = c.db.IssuedCertsByAsset(c.assetName)
+		if err != nil {
+			return "", err
+		}
+	}
+	oldCerts := make([]uint, 0, len(issued))
+	for _, cert := range issued {
+		oldCerts = append(oldCerts, cert.ID)
+	}
+	clientId, err := c.generate(host, port, dns, true)
+	if err != nil {
+		return "", err
+	}
+	if err := c.db.RevokeIssuedCerts(oldCerts, time.Now()); err != nil {
+		return "", err
+	}
+	return clientId, nil
+}
+
+// newCert issues a cert for the client. Once any cert issued to the client
+// has been revoked, or when reissue is set because it's about to be, the
+// serial derived from the client name can't be used again, so a replacement
+// cert is issued with a serial based on the number of certs issued so far.
+func (c *Client) newCert(reissue bool) (*ca.ClientCert, error) {
+	if c.db == nil {
+		return c.ca.GenerateClientCert(c.identifier())
+	}
+	issued, err := c.db.IssuedCertsByAsset(c.assetName)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range issued {
+		if cert.RevokedAt != nil {
+			reissue = true
+		}
+	}
+	if reissue {
+		return c.ca.ReissueClientCert(c.identifier(), len(issued))
+	}
+	return c.ca.GenerateClientCert(c.identifier())
+}
+
+// verifyClientCert checks that a client cert chains to the CA cert delivered
+// with it, since a profile with a mismatched CA cert can't connect
+func verifyClientCert(certs *ca.ClientCert) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(certs.CaCert)) {
+		return errors.New("verify client cert: no CA certificate found")
+	}
+	block, _ := pem.Decode([]byte(certs.Cert))
+	if block == nil {
+		return errors.New("verify client cert: failed to decode PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("verify client cert: %w", err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf(
+			"client cert does not chain to the CA certificate: %w",
+			err,
+		)
+	}
+	return nil
+}
+
+// renderProfile fills in the configured profile template
+func (c *Client) renderProfile(
+	host string,
+	port int,
+	dns string,
+	certs *ca.ClientCert,
+) string {
+	tmpl := c.config.Vpn.ProfileTemplate
+	if tmpl == "" {
+		tmpl = config.DefaultProfileTemplate
+	}
+	return fmt.Sprintf(
+		tmpl,
+		host,
+		port,
+		dns,
+		certs.Cert,
+		certs.Key,
+		certs.CaCert,
+	)
+}
+
 func (c *Client) ProfileExists() (bool, error) {
 	svc, err := c.createS3Client()
 	if err != nil {
 		return false, err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.opTimeout())
+	defer cancel()
 	_, err = svc.HeadObject(
-		context.TODO(),
+		ctx,
 		&s3.HeadObjectInput{
 			Bucket: aws.String(c.config.S3.ClientBucket),
 			Key:    aws.String(c.profileKey()),
@@ -167,12 +303,38 @@ func (c *Client) ProfileExists() (bool, error) {
 	return true, nil
 }
 
+// CheckS3 returns an error if the client bucket can't be reached with the
+// configured credentials
+func (c *Client) CheckS3(ctx context.Context) error {
+	svc, err := c.createS3Client()
+	if err != nil {
+		return fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	_, err = svc.HeadBucket(
+		ctx,
+		&s3.HeadBucketInput{
+			Bucket: aws.String(c.config.S3.ClientBucket),
+		},
+	)
+	return err
+}
+
 func (c *Client) PresignedUrl() (string, error) {
 	svc, err := c.createS3Client()
 	if err != nil {
 		return "", err
 	}
-	presignClient := s3.NewPresignClient(svc)
+	presignClient := s3.NewPresignClient(
+		svc,
+		func(o *s3.PresignOptions) {
+			o.ClientOptions = append(
+				o.ClientOptions,
+				func(o *s3.Options) {
+					o.APIOptions = append(o.APIOptions, removeS3Metrics)
+				},
+			)
+		},
+	)
 	request, err := presignClient.PresignGetObject(
 		context.Background(),
 		&s3.GetObjectInput{
@@ -188,6 +350,51 @@ func (c *Client) PresignedUrl() (string, error) {
 	return request.URL, nil
 }
 
+// OpenProfile fetches the client profile object from S3. The returned reader
+// streams the object body and must be closed by the caller.
+func (c *Client) OpenProfile(ctx context.Context) (io.ReadCloser, error) {
+	svc, err := c.createS3Client()
+	if err != nil {
+		return nil, err
+	}
+	result, err := svc.GetObject(
+		ctx,
+		&s3.GetObjectInput{
+			Bucket: aws.String(c.config.S3.ClientBucket),
+			Key:    aws.String(c.profileKey()),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+// DownloadProfile fetches the client profile through a pre-signed URL and
+// returns its body. The caller is responsible for closing the returned reader.
+func (c *Client) DownloadProfile(ctx context.Context) (io.ReadCloser, error) {
+	url, err := c.PresignedUrl()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := profileHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch profile: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf(
+			"fetch profile failed with status: %d",
+			resp.StatusCode,
+		)
+	}
+	return resp.Body, nil
+}
+
 func (c *Client) profileKey() string {
 	return fmt.Sprintf(
 		"%s%s.ovpn",
@@ -201,7 +408,11 @@ func (c *Client) createS3Client() (*s3.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	var clientOpts []func(o *s3.Options)
+	clientOpts := []func(o *s3.Options){
+		func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, addS3Metrics)
+		},
+	}
 	if c.config.S3.Endpoint != "" {
 		clientOpts = append(
 			clientOpts,
@@ -0,0 +1,181 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/ca"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+)
+
+func TestRenderProfileCustomTemplate(t *testing.T) {
+	cfg := &config.Config{
+		Vpn: config.VpnConfig{
+			ProfileTemplate: "client\nproto udp\nremote %s %d\ndhcp-option DNS %s\n<cert>\n%s</cert>\n<key>\n%s</key>\n<ca>\n%s</ca>\n",
+		},
+	}
+	c := New(cfg, nil, nil, []byte("test"))
+	certs := &ca.ClientCert{
+		CaCert: "CA-CERT\n",
+		Cert:   "CLIENT-CERT\n",
+		Key:    "CLIENT-KEY\n",
+	}
+
+	profile := c.renderProfile("us1.vpn.example.com", 1194, "10.8.0.1", certs)
+
+	expected := "client\nproto udp\nremote us1.vpn.example.com 1194\ndhcp-option DNS 10.8.0.1\n<cert>\nCLIENT-CERT\n</cert>\n<key>\nCLIENT-KEY\n</key>\n<ca>\nCA-CERT\n</ca>\n"
+	if profile != expected {
+		t.Fatalf("profile = %q, want %q", profile, expected)
+	}
+}
+
+func TestRenderProfileDefaultTemplate(t *testing.T) {
+	// A config without a template falls back to the default
+	c := New(&config.Config{}, nil, nil, []byte("test"))
+	certs := &ca.ClientCert{
+		CaCert: "CA-CERT",
+		Cert:   "CLIENT-CERT",
+		Key:    "CLIENT-KEY",
+	}
+
+	profile := c.renderProfile("us1.vpn.example.com", 443, "10.8.0.1", certs)
+
+	for _, want := range []string{
+		"proto tcp",
+		"remote us1.vpn.example.com 443",
+		"dhcp-option DNS 10.8.0.1",
+		"<cert>\nCLIENT-CERT\n</cert>",
+	} {
+		if !strings.Contains(profile, want) {
+			t.Errorf("profile missing %q", want)
+		}
+	}
+}
+
+func TestNewS3(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  string
+		wantErr error
+	}{
+		{
+			name:    "no bucket",
+			wantErr: ErrS3NotConfigured,
+		},
+		{
+			name:    "blank bucket",
+			bucket:  "  ",
+			wantErr: ErrS3NotConfigured,
+		},
+		{
+			name:   "bucket",
+			bucket: "clients",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{
+				S3: config.S3Config{ClientBucket: tc.bucket},
+			}
+			c, err := NewS3(cfg)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tc.wantErr)
+			}
+			if (c == nil) != (tc.wantErr != nil) {
+				t.Errorf("client = %v, want nil only on error", c)
+			}
+		})
+	}
+}
+
+// newTestCa creates a CA with a freshly generated key and self-signed cert
+func newTestCa(t *testing.T) *ca.Ca {
+	t.Helper()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	certBytes, err := x509.CreateCertificate(
+		rand.Reader,
+		tmpl,
+		tmpl,
+		pubKey,
+		privKey,
+	)
+	if err != nil {
+		t.Fatalf("failed to create CA cert: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatalf("failed to marshal CA key: %v", err)
+	}
+	cfg := &config.Config{
+		Ca: config.CaConfig{
+			Cert: string(pem.EncodeToMemory(
+				&pem.Block{Type: "CERTIFICATE", Bytes: certBytes},
+			)),
+			Key: string(pem.EncodeToMemory(
+				&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes},
+			)),
+		},
+	}
+	caObj, err := ca.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	return caObj
+}
+
+func TestVerifyClientCert(t *testing.T) {
+	certs, err := newTestCa(t).GenerateClientCert("test-client")
+	if err != nil {
+		t.Fatalf("failed to generate client cert: %v", err)
+	}
+	otherCerts, err := newTestCa(t).GenerateClientCert("test-client")
+	if err != nil {
+		t.Fatalf("failed to generate client cert: %v", err)
+	}
+	// The cert is delivered with a CA cert that didn't issue it
+	mismatched := *certs
+	mismatched.CaCert = otherCerts.CaCert
+
+	if err := verifyClientCert(certs); err != nil {
+		t.Errorf("verifyClientCert with matching CA: %v", err)
+	}
+	if err := verifyClientCert(&mismatched); err == nil {
+		t.Error("verifyClientCert with mismatched CA succeeded")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// s3MetricsMiddlewareID identifies the S3 metrics middleware in an operation
+// stack
+const s3MetricsMiddlewareID = "S3Metrics"
+
+var (
+	metricS3OpDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "s3_op_duration_seconds",
+			Help:    "Time taken by S3 operations, including retries",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"op"},
+	)
+	metricS3OpErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "s3_op_errors_total",
+			Help: "Number of failed S3 operations by error code",
+		},
+		[]string{"op", "code"},
+	)
+)
+
+// s3OpLabels maps S3 API operation names to metric op labels
+var s3OpLabels = map[string]string{
+	"GetObject":     "get",
+	"PutObject":     "put",
+	"ListObjectsV2": "list",
+	"HeadObject":    "head",
+	"DeleteObject":  "delete",
+	"HeadBucket":    "head_bucket",
+}
+
+// addS3Metrics adds a middleware recording the duration and errors of each
+// S3 operation
+func addS3Metrics(stack *middleware.Stack) error {
+	return stack.Initialize.Add(
+		middleware.InitializeMiddlewareFunc(
+			s3MetricsMiddlewareID,
+			func(
+				ctx context.Context,
+				in middleware.InitializeInput,
+				next middleware.InitializeHandler,
+			) (middleware.InitializeOutput, middleware.Metadata, error) {
+				start := time.Now()
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				observeS3Op(awsmiddleware.GetOperationName(ctx), start, err)
+				return out, metadata, err
+			},
+		),
+		// After the operation name is registered in the context
+		middleware.After,
+	)
+}
+
+// removeS3Metrics removes the S3 metrics middleware. Presigning a request
+// doesn't contact S3, so it isn't measured.
+func removeS3Metrics(stack *middleware.Stack) error {
+	// The presign client can apply its options more than once
+	if _, ok := stack.Initialize.Get(s3MetricsMiddlewareID); !ok {
+		return nil
+	}
+	_, err := stack.Initialize.Remove(s3MetricsMiddlewareID)
+	return err
+}
+
+// observeS3Op records the duration of an S3 operation and, when it failed,
+// its error code
+func observeS3Op(operation string, start time.Time, err error) {
+	op, ok := s3OpLabels[operation]
+	if !ok {
+		op = operation
+	}
+	metricS3OpDuration.WithLabelValues(op).
+		Observe(time.Since(start).Seconds())
+	if err == nil {
+		return
+	}
+	code := "unknown"
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	}
+	metricS3OpErrors.WithLabelValues(op, code).Inc()
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// s3OpDurationCount returns the number of s3_op_duration_seconds
+// observations for an op
+func s3OpDurationCount(t *testing.T, op string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	histogram := metricS3OpDuration.WithLabelValues(op)
+	if err := histogram.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// s3OpErrorCount returns the s3_op_errors_total count for an op and code
+func s3OpErrorCount(t *testing.T, op, code string) int {
+	t.Helper()
+	var m dto.Metric
+	if err := metricS3OpErrors.WithLabelValues(op, code).Write(&m); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	return int(m.GetCounter().GetValue())
+}
+
+func TestS3OpMetrics(t *testing.T) {
+	cfg := &config.Config{}
+	newTestS3Bucket(
+		t,
+		cfg,
+		map[string]string{"peers/client.json": `{}`},
+		nil,
+		nil,
+	)
+	c := New(cfg, nil, nil, []byte("client"))
+
+	listCount := s3OpDurationCount(t, "list")
+	if _, err := c.ListAllPeerFiles(); err != nil {
+		t.Fatalf("ListAllPeerFiles: %v", err)
+	}
+	if got := s3OpDurationCount(t, "list"); got != listCount+1 {
+		t.Errorf("list samples = %d, want %d", got, listCount+1)
+	}
+
+	// The profile doesn't exist, which S3 reports as an error
+	headCount := s3OpDurationCount(t, "head")
+	headErrors := s3OpErrorCount(t, "head", "NotFound")
+	if ok, err := c.ProfileExists(); err != nil || ok {
+		t.Fatalf("ProfileExists = %t, %v, want false, nil", ok, err)
+	}
+	if got := s3OpDurationCount(t, "head"); got != headCount+1 {
+		t.Errorf("head samples = %d, want %d", got, headCount+1)
+	}
+	if got := s3OpErrorCount(t, "head", "NotFound"); got != headErrors+1 {
+		t.Errorf("head NotFound errors = %d, want %d", got, headErrors+1)
+	}
+
+	// Presigning doesn't contact S3
+	getCount := s3OpDurationCount(t, "get")
+	if _, err := c.PresignedUrl(); err != nil {
+		t.Fatalf("PresignedUrl: %v", err)
+	}
+	if got := s3OpDurationCount(t, "get"); got != getCount {
+		t.Errorf("get samples = %d, want %d", got, getCount)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+// compactPeerGracePeriod is how long a peer missing from the database is kept
+// in its S3 peer file. Registration saves a peer to S3 before the database,
+// so a recent peer may belong to a registration that is still in progress.
+const compactPeerGracePeriod = time.Hour
+
+// CompactSummary reports the outcome of a peer file compaction
+type CompactSummary struct {
+	// PeerFiles is the number of peer files listed in S3
+	PeerFiles int
+	// Rewritten is the number of peer files rewritten without stale peers
+	Rewritten int
+	// Deleted is the number of empty peer files deleted
+	Deleted int
+	// StalePeers is the number of peers removed from peer files
+	StalePeers int
+	// Errors describes each peer file that was skipped
+	Errors []string
+}
+
+// CompactPeerFiles removes stale peers from the S3 peer files of
+// subscriptions in a region. A peer is stale when the database no longer has
+// it, and the peer file is deleted once it's empty and the subscription has
+// expired. Peer files for subscriptions that aren't in the database or belong
+// to another region are left alone. With dryRun, nothing is written to S3.
+func (c *Client) CompactPeerFiles(
+	db *database.Database,
+	region string,
+	dryRun bool,
+) (CompactSummary, error) {
+	var summary CompactSummary
+	slog.Info("Compacting WG peer files in S3...", "dry_run", dryRun)
+
+	keys, err := c.ListAllPeerFiles()
+	if err != nil {
+		return summary, fmt.Errorf("failed to list peer files from S3: %w", err)
+	}
+	summary.PeerFiles = len(keys)
+
+	svc, err := c.createS3Client()
+	if err != nil {
+		return summary, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	// skip logs a skipped peer file and records it in the summary
+	skip := func(msg string, key string, err error) {
+		if err != nil {
+			slog.Warn(msg+", skipping", "key", key, "error", err)
+			summary.Errors = append(
+				summary.Errors,
+				fmt.Sprintf("%s: %s: %s", key, msg, err),
+			)
+			return
+		}
+		slog.Warn(msg+", skipping", "key", key)
+		summary.Errors = append(summary.Errors, key+": "+msg)
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		assetNameHex := c.extractAssetNameFromKey(key)
+		if assetNameHex == "" {
+			skip("Failed to extract asset name from key", key, nil)
+			continue
+		}
+		assetName, err := hex.DecodeString(assetNameHex)
+		if err != nil {
+			skip("Failed to decode asset name hex", key, err)
+			continue
+		}
+
+		dbClient, err := db.ClientByAssetName(assetName)
+		if errors.Is(err, database.ErrRecordNotFound) {
+			slog.Debug("No client for peer file, skipping", "key", key)
+			continue
+		}
+		if err != nil {
+			skip("Failed to look up client", key, err)
+			continue
+		}
+		if dbClient.Region != region {
+			continue
+		}
+
+		dbPeers, err := db.GetWGPeersByAsset(assetName)
+		if err != nil {
+			skip("Failed to look up WG peers", key, err)
+			continue
+		}
+		active := make(map[string]bool, len(dbPeers))
+		for _, peer := range dbPeers {
+			active[peer.Pubkey] = true
+		}
+
+		ctx, cancel := context.WithTimeout(
+			context.Background(),
+			c.opTimeout(),
+		)
+		removed, deleted, err := c.compactPeerFile(
+			ctx,
+			svc,
+			key,
+			active,
+			dbClient.Expiration.Before(now),
+			dryRun,
+		)
+		cancel()
+		if err != nil {
+			skip("Failed to compact peer file", key, err)
+			continue
+		}
+		summary.StalePeers += removed
+		switch {
+		case deleted:
+			summary.Deleted++
+		case removed > 0:
+			summary.Rewritten++
+		}
+	}
+
+	slog.Info(
+		"Compacted WG peer files in S3",
+		"dry_run", dryRun,
+		"rewritten", summary.Rewritten,
+		"deleted", summary.Deleted,
+		"stale_peers", summary.StalePeers,
+		"errors", len(summary.Errors),
+	)
+	return summary, nil
+}
+
+// compactPeerFile removes the peers that aren't in active and are older than
+// compactPeerGracePeriod from a peer file, deleting the file instead if it's
+// left empty and expired is set. It returns the number of peers removed and
+// whether the file was deleted. Uses ETag-based conditional writes like
+// SavePeerToS3WithContext.
+func (c *Client) compactPeerFile(
+	ctx context.Context,
+	svc *s3.Client,
+	key string,
+	active map[string]bool,
+	expired bool,
+	dryRun bool,
+) (int, bool, error) {
+	maxRetries := c.maxS3Retries()
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if err := s3RetryBackoff(ctx, attempt); err != nil {
+			return 0, false, err
+		}
+		peerFile, err := c.loadPeerFileFromS3(ctx, svc, key)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to load peer file: %w", err)
+		}
+		if peerFile == nil {
+			return 0, false, nil
+		}
+
+		cutoff := time.Now().Add(-compactPeerGracePeriod).Unix()
+		kept := make([]PeerInfo, 0, len(peerFile.Peers))
+		for _, p := range peerFile.Peers {
+			if active[p.Pubkey] || p.CreatedAt > cutoff {
+				kept = append(kept, p)
+			}
+		}
+		removed := len(peerFile.Peers) - len(kept)
+
+		// An expired subscription can't register new peers, so its empty
+		// file won't be written again
+		if len(kept) == 0 && expired {
+			if dryRun {
+				return removed, true, nil
+			}
+			_, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket:  aws.String(c.config.S3.ClientBucket),
+				Key:     aws.String(key),
+				IfMatch: aws.String(peerFile.etag), // Conditional delete
+			})
+			if err != nil {
+				if isConditionalWriteConflict(err) {
+					continue // Retry with fresh data
+				}
+				return 0, false, fmt.Errorf(
+					"failed to delete peer file from S3: %w",
+					err,
+				)
+			}
+			return removed, true, nil
+		}
+		if removed == 0 || dryRun {
+			return removed, false, nil
+		}
+
+		peerFile.Peers = kept
+		peerFile.UpdatedAt = time.Now().Unix()
+		data, err := json.Marshal(peerFile)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to marshal peer file: %w", err)
+		}
+		_, err = svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(c.config.S3.ClientBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/json"),
+			Metadata:    peerFileMetadata(data),
+			IfMatch:     aws.String(peerFile.etag), // Conditional write
+		})
+		if err != nil {
+			if isConditionalWriteConflict(err) {
+				continue // Retry with fresh data
+			}
+			return 0, false, fmt.Errorf(
+				"failed to update peer file in S3: %w",
+				err,
+			)
+		}
+		return removed, false, nil
+	}
+
+	return 0, false, fmt.Errorf(
+		"failed to compact peer file after %d retries due to concurrent modifications",
+		maxRetries,
+	)
+}
+
+// isConditionalWriteConflict reports whether an S3 error is a failed
+// precondition of a conditional write, meaning the object changed since it
+// was read. S3 returns HTTP 412 PreconditionFailed or 409
+// ConditionalRequestConflict.
+func isConditionalWriteConflict(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ErrorCode()
+	return code == "PreconditionFailed" || code == "ConditionalRequestConflict"
+}
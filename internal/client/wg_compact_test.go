@@ -0,0 +1,182 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+func TestCompactPeerFiles(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		name := "compact"
+		if dryRun {
+			name = "dry run"
+		}
+		t.Run(name, func(t *testing.T) {
+			testCompactPeerFiles(t, dryRun)
+		})
+	}
+}
+
+func testCompactPeerFiles(t *testing.T, dryRun bool) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		Vpn:      config.VpnConfig{Region: "test", WGSubnet: "10.8.0"},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	c := NewWithConfig(cfg)
+
+	old := time.Now().Add(-2 * compactPeerGracePeriod).Unix()
+	recent := time.Now().Unix()
+	objects := make(map[string]string)
+	addPeerFile := func(assetName []byte, peers ...PeerInfo) {
+		data, err := json.Marshal(PeerFile{
+			AssetName: hex.EncodeToString(assetName),
+			Peers:     peers,
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal peer file: %v", err)
+		}
+		objects[c.peerFileKey(assetName)] = string(data)
+	}
+	addClient := func(assetName []byte, expiration time.Time, region string) {
+		if err := db.AddClient(
+			assetName,
+			expiration,
+			[]byte("credential"),
+			region,
+			[]byte("txhash"),
+			0,
+			0,
+		); err != nil {
+			t.Fatalf("failed to add client: %v", err)
+		}
+	}
+
+	// An active subscription with one current, one stale and one recently
+	// registered peer
+	active := []byte("active")
+	addClient(active, time.Now().Add(time.Hour), cfg.Vpn.Region)
+	if err := db.AddWGPeer(active, "pubkey-current", "10.8.0.2"); err != nil {
+		t.Fatalf("failed to add WG peer: %v", err)
+	}
+	addPeerFile(
+		active,
+		PeerInfo{Pubkey: "pubkey-current", AssignedIP: "10.8.0.2"},
+		PeerInfo{
+			Pubkey:     "pubkey-stale",
+			AssignedIP: "10.8.0.3",
+			CreatedAt:  old,
+		},
+		PeerInfo{
+			Pubkey:     "pubkey-recent",
+			AssignedIP: "10.8.0.4",
+			CreatedAt:  recent,
+		},
+	)
+	// Expired subscriptions with a stale peer and with an empty file
+	expired := []byte("expired")
+	addClient(expired, time.Now().Add(-time.Hour), cfg.Vpn.Region)
+	addPeerFile(
+		expired,
+		PeerInfo{
+			Pubkey:     "pubkey-expired",
+			AssignedIP: "10.8.0.5",
+			CreatedAt:  old,
+		},
+	)
+	empty := []byte("empty")
+	addClient(empty, time.Now().Add(-time.Hour), cfg.Vpn.Region)
+	addPeerFile(empty)
+	// Subscriptions this indexer doesn't own are left alone
+	other := []byte("other")
+	addClient(other, time.Now().Add(-time.Hour), "other")
+	addPeerFile(
+		other,
+		PeerInfo{Pubkey: "pubkey-other", AssignedIP: "10.8.0.6"},
+	)
+	addPeerFile(
+		[]byte("unknown"),
+		PeerInfo{Pubkey: "pubkey-unknown", AssignedIP: "10.8.0.7"},
+	)
+
+	before := maps.Clone(objects)
+	newTestS3Bucket(t, cfg, objects, nil, nil)
+
+	summary, err := c.CompactPeerFiles(db, cfg.Vpn.Region, dryRun)
+	if err != nil {
+		t.Fatalf("CompactPeerFiles: %v", err)
+	}
+	want := CompactSummary{
+		PeerFiles:  5,
+		Rewritten:  1,
+		Deleted:    2,
+		StalePeers: 2,
+	}
+	if summary.PeerFiles != want.PeerFiles ||
+		summary.Rewritten != want.Rewritten ||
+		summary.Deleted != want.Deleted ||
+		summary.StalePeers != want.StalePeers ||
+		len(summary.Errors) != 0 {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+
+	if dryRun {
+		if !maps.Equal(objects, before) {
+			t.Error("dry run modified the peer files")
+		}
+		return
+	}
+
+	// The stale peer is removed and the recent one is kept
+	peerFile, err := c.LoadPeersFromS3(active)
+	if err != nil {
+		t.Fatalf("LoadPeersFromS3: %v", err)
+	}
+	var pubkeys []string
+	if peerFile != nil {
+		for _, p := range peerFile.Peers {
+			pubkeys = append(pubkeys, p.Pubkey)
+		}
+	}
+	wantPubkeys := []string{"pubkey-current", "pubkey-recent"}
+	if !slices.Equal(pubkeys, wantPubkeys) {
+		t.Errorf("peers = %v, want %v", pubkeys, wantPubkeys)
+	}
+
+	// The expired subscriptions' files are deleted
+	for _, assetName := range [][]byte{expired, empty} {
+		if _, ok := objects[c.peerFileKey(assetName)]; ok {
+			t.Errorf("peer file for %s not deleted", assetName)
+		}
+	}
+	for _, assetName := range [][]byte{other, []byte("unknown")} {
+		key := c.peerFileKey(assetName)
+		if objects[key] != before[key] {
+			t.Errorf("peer file for %s modified", assetName)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+// reconcilePeerGracePeriod is how long a new peer is left alone by a
+// reconcile. Registration saves a peer to S3 before the database, and
+// deletion removes it from S3 first, so a recent difference may belong to a
+// request that is still in progress. It's replaced in tests.
+var reconcilePeerGracePeriod = time.Minute
+
+// ReconcileSummary reports the outcome of a reconcile of WG peers from S3
+type ReconcileSummary struct {
+	// PeerFiles is the number of peer files listed in S3
+	PeerFiles int
+	// Added is the number of peers missing from the database that were added
+	Added int
+	// Updated is the number of peers whose assigned IP was corrected
+	Updated int
+	// Removed is the number of peers not in S3 that were removed from the
+	// database
+	Removed int
+	// Errors describes each peer file or peer that was skipped
+	Errors []string
+}
+
+// s3Peer is a peer from an S3 peer file along with the subscription it
+// belongs to
+type s3Peer struct {
+	assetName []byte
+	peer      PeerInfo
+}
+
+// ReconcileWGPeersFromS3 corrects the database cache of WG peers for
+// subscriptions in a region to match their S3 peer files: peers missing from
+// the database are added, peers whose assigned IP differs are updated, and
+// peers that are no longer in S3 are removed. S3 is never written. Peers of a
+// subscription whose peer file can't be read are left alone.
+func (c *Client) ReconcileWGPeersFromS3(
+	db *database.Database,
+	region string,
+) (ReconcileSummary, error) {
+	var summary ReconcileSummary
+	slog.Debug("Reconciling WG peers from S3...", "region", region)
+
+	keys, err := c.ListAllPeerFiles()
+	if err != nil {
+		return summary, fmt.Errorf("failed to list peer files from S3: %w", err)
+	}
+	summary.PeerFiles = len(keys)
+
+	svc, err := c.createS3Client()
+	if err != nil {
+		return summary, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	// skip logs a skipped peer file or peer and records it in the summary
+	skip := func(msg string, key string, err error) {
+		if err != nil {
+			slog.Warn(msg+", skipping", "key", key, "error", err)
+			summary.Errors = append(
+				summary.Errors,
+				fmt.Sprintf("%s: %s: %s", key, msg, err),
+			)
+			return
+		}
+		slog.Warn(msg+", skipping", "key", key)
+		summary.Errors = append(summary.Errors, key+": "+msg)
+	}
+
+	// Collect the peers in S3 for subscriptions in the region
+	s3Peers := make(map[string]s3Peer)
+	unreadable := make(map[string]bool)
+	for _, key := range keys {
+		assetNameHex := c.extractAssetNameFromKey(key)
+		if assetNameHex == "" {
+			skip("Failed to extract asset name from key", key, nil)
+			continue
+		}
+		assetName, err := hex.DecodeString(assetNameHex)
+		if err != nil {
+			skip("Failed to decode asset name hex", key, err)
+			continue
+		}
+
+		dbClient, err := db.ClientByAssetName(assetName)
+		if errors.Is(err, database.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			unreadable[assetNameHex] = true
+			skip("Failed to look up client", key, err)
+			continue
+		}
+		if dbClient.Region != region {
+			continue
+		}
+
+		peerFile, err := c.loadListedPeerFile(svc, key)
+		if err != nil || peerFile == nil {
+			unreadable[assetNameHex] = true
+			skip("Failed to load peer file from S3", key, err)
+			continue
+		}
+		for _, peer := range peerFile.Peers {
+			s3Peers[peer.Pubkey] = s3Peer{assetName: assetName, peer: peer}
+		}
+	}
+
+	dbPeers, err := db.GetWGPeersForRegion(region)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get WG peers: %w", err)
+	}
+
+	// Correct or remove the peers in the database
+	cutoff := time.Now().Add(-reconcilePeerGracePeriod)
+	for _, dbPeer := range dbPeers {
+		// Truncate pubkey safely for logging
+		pubkeyPrefix := dbPeer.Pubkey
+		if len(pubkeyPrefix) > 8 {
+			pubkeyPrefix = pubkeyPrefix[:8] + "..."
+		}
+		key := c.peerFileKey(dbPeer.AssetName)
+		stored, ok := s3Peers[dbPeer.Pubkey]
+		if ok && bytes.Equal(stored.assetName, dbPeer.AssetName) {
+			delete(s3Peers, dbPeer.Pubkey)
+			if stored.peer.AssignedIP == dbPeer.AssignedIP {
+				continue
+			}
+			if err := db.UpdateWGPeerIP(
+				dbPeer.Pubkey,
+				stored.peer.AssignedIP,
+			); err != nil {
+				skip("Failed to update WG peer "+pubkeyPrefix, key, err)
+				continue
+			}
+			summary.Updated++
+			continue
+		}
+		if unreadable[hex.EncodeToString(dbPeer.AssetName)] ||
+			dbPeer.CreatedAt.After(cutoff) {
+			continue
+		}
+		// The peer is gone from its peer file, or has moved to another
+		// subscription's and is added again below
+		if err := db.DeleteWGPeer(dbPeer.Pubkey); err != nil {
+			skip("Failed to remove WG peer "+pubkeyPrefix, key, err)
+			continue
+		}
+		summary.Removed++
+	}
+
+	// Add the peers missing from the database
+	for _, stored := range s3Peers {
+		if time.Unix(stored.peer.CreatedAt, 0).After(cutoff) {
+			continue
+		}
+		pubkeyPrefix := stored.peer.Pubkey
+		if len(pubkeyPrefix) > 8 {
+			pubkeyPrefix = pubkeyPrefix[:8] + "..."
+		}
+		if err := db.AddWGPeer(
+			stored.assetName,
+			stored.peer.Pubkey,
+			stored.peer.AssignedIP,
+		); err != nil {
+			skip(
+				"Failed to add WG peer "+pubkeyPrefix+" to database",
+				c.peerFileKey(stored.assetName),
+				err,
+			)
+			continue
+		}
+		summary.Added++
+	}
+
+	if summary.Added > 0 || summary.Updated > 0 || summary.Removed > 0 {
+		if err := db.RebuildIPPool(region); err != nil {
+			return summary, fmt.Errorf("failed to rebuild IP pool: %w", err)
+		}
+		slog.Info(
+			"Reconciled WG peers from S3",
+			"region", region,
+			"added", summary.Added,
+			"updated", summary.Updated,
+			"removed", summary.Removed,
+			"errors", len(summary.Errors),
+		)
+	}
+	return summary, nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+func TestReconcileWGPeersFromS3(t *testing.T) {
+	origGrace := reconcilePeerGracePeriod
+	reconcilePeerGracePeriod = 0
+	t.Cleanup(func() { reconcilePeerGracePeriod = origGrace })
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		Vpn:      config.VpnConfig{Region: "test", WGSubnet: "10.8.0"},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	alpha := []byte("alpha")
+	beta := []byte("beta")
+	other := []byte("other")
+	for assetName, region := range map[string]string{
+		string(alpha): cfg.Vpn.Region,
+		string(beta):  cfg.Vpn.Region,
+		string(other): "elsewhere",
+	} {
+		if err := db.AddClient(
+			[]byte(assetName),
+			time.Now().Add(time.Hour),
+			[]byte("credential"),
+			region,
+			[]byte("txhash-"+assetName),
+			0,
+			0,
+		); err != nil {
+			t.Fatalf("failed to add client: %v", err)
+		}
+	}
+
+	// S3 holds the peers that should be cached
+	c := NewWithConfig(cfg)
+	peerFile := func(assetName []byte, peers string) string {
+		return fmt.Sprintf(
+			`{"asset_name":%q,"peers":[%s]}`,
+			hex.EncodeToString(assetName),
+			peers,
+		)
+	}
+	objects := map[string]string{
+		c.peerFileKey(alpha): peerFile(
+			alpha,
+			`{"pubkey":"pubkey-1","assigned_ip":"10.8.0.2"},`+
+				`{"pubkey":"pubkey-2","assigned_ip":"10.8.0.3"}`,
+		),
+		c.peerFileKey(beta): peerFile(
+			beta,
+			`{"pubkey":"pubkey-3","assigned_ip":"10.8.0.4"}`,
+		),
+		c.peerFileKey(other): peerFile(
+			other,
+			`{"pubkey":"pubkey-other","assigned_ip":"10.8.0.2"}`,
+		),
+	}
+	origObjects := maps.Clone(objects)
+	newTestS3Bucket(t, cfg, objects, nil, nil)
+
+	// The database has drifted: pubkey-2 is missing, pubkey-3 has the wrong
+	// IP, and pubkey-4 was removed from S3 but not the database. Peers in
+	// other regions aren't reconciled.
+	for _, peer := range []database.WGPeer{
+		{AssetName: alpha, Pubkey: "pubkey-1", AssignedIP: "10.8.0.2"},
+		{AssetName: alpha, Pubkey: "pubkey-4", AssignedIP: "10.8.0.5"},
+		{AssetName: beta, Pubkey: "pubkey-3", AssignedIP: "10.8.0.9"},
+		{AssetName: other, Pubkey: "pubkey-other-db", AssignedIP: "10.8.0.7"},
+	} {
+		if err := db.AddWGPeer(
+			peer.AssetName,
+			peer.Pubkey,
+			peer.AssignedIP,
+		); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+	}
+
+	// peers returns the cached peers of a subscription as pubkey=IP
+	peers := func(assetName []byte) []string {
+		t.Helper()
+		dbPeers, err := db.GetWGPeersByAsset(assetName)
+		if err != nil {
+			t.Fatalf("GetWGPeersByAsset: %v", err)
+		}
+		var ret []string
+		for _, peer := range dbPeers {
+			ret = append(ret, peer.Pubkey+"="+peer.AssignedIP)
+		}
+		slices.Sort(ret)
+		return ret
+	}
+
+	summary, err := c.ReconcileWGPeersFromS3(db, cfg.Vpn.Region)
+	if err != nil {
+		t.Fatalf("ReconcileWGPeersFromS3: %v", err)
+	}
+	if summary.PeerFiles != 3 || summary.Added != 1 || summary.Updated != 1 ||
+		summary.Removed != 1 || len(summary.Errors) != 0 {
+		t.Errorf("summary = %+v", summary)
+	}
+	for _, tc := range []struct {
+		assetName []byte
+		want      []string
+	}{
+		{alpha, []string{"pubkey-1=10.8.0.2", "pubkey-2=10.8.0.3"}},
+		{beta, []string{"pubkey-3=10.8.0.4"}},
+		{other, []string{"pubkey-other-db=10.8.0.7"}},
+	} {
+		if got := peers(tc.assetName); !slices.Equal(got, tc.want) {
+			t.Errorf("%s peers = %v, want %v", tc.assetName, got, tc.want)
+		}
+	}
+	if !maps.Equal(objects, origObjects) {
+		t.Errorf("S3 was modified: %v", objects)
+	}
+
+	// A converged database is left alone
+	summary, err = c.ReconcileWGPeersFromS3(db, cfg.Vpn.Region)
+	if err != nil {
+		t.Fatalf("ReconcileWGPeersFromS3: %v", err)
+	}
+	if summary.Added != 0 || summary.Updated != 0 || summary.Removed != 0 {
+		t.Errorf("second reconcile summary = %+v, want no changes", summary)
+	}
+
+	// A peer cached moments ago may belong to a registration in progress
+	reconcilePeerGracePeriod = time.Hour
+	if err := db.AddWGPeer(alpha, "pubkey-5", "10.8.0.6"); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+	if _, err := c.ReconcileWGPeersFromS3(db, cfg.Vpn.Region); err != nil {
+		t.Fatalf("ReconcileWGPeersFromS3: %v", err)
+	}
+	want := []string{
+		"pubkey-1=10.8.0.2",
+		"pubkey-2=10.8.0.3",
+		"pubkey-5=10.8.0.6",
+	}
+	if got := peers(alpha); !slices.Equal(got, want) {
+		t.Errorf("alpha peers = %v, want %v", got, want)
+	}
+}
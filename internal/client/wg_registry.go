@@ -17,31 +17,79 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/blinklabs-io/vpn-indexer/internal/requestid"
 )
 
-const peersPrefix = "peers/"
+// defaultPeersPrefix is the S3 key prefix for peer files when
+// S3.PeerKeyPrefix isn't configured
+const defaultPeersPrefix = "peers/"
 
-// maxS3Retries is the maximum number of retry attempts for S3 conditional writes
-const maxS3Retries = 3
+// defaultMaxS3Retries is the maximum number of attempts at an S3 conditional
+// write when S3.MaxWriteRetries isn't configured
+const defaultMaxS3Retries = 3
+
+// s3RetryBaseDelay bounds the backoff before the first retry of an S3
+// conditional write. The bound doubles with each retry up to s3RetryMaxDelay.
+const (
+	s3RetryBaseDelay = 50 * time.Millisecond
+	s3RetryMaxDelay  = time.Second
+)
+
+// s3RetrySleep waits between attempts at an S3 conditional write, returning
+// early if ctx is done. It's replaced in tests.
+var s3RetrySleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rebuildReadAttempts bounds the reads of each listed peer file during a
+// rebuild
+const rebuildReadAttempts = 3
+
+// rebuildReadRetryDelay is the wait between reads of a listed peer file that
+// was missing or failed transiently. It's replaced in tests.
+var rebuildReadRetryDelay = 500 * time.Millisecond
+
+// peerFileChecksumKey is the object metadata key holding the hex SHA-256 of a
+// peer file's body
+const peerFileChecksumKey = "sha256"
+
+// ErrPeerFileChecksum is returned when a peer file's body doesn't match the
+// checksum stored in its metadata
+var ErrPeerFileChecksum = errors.New("peer file checksum mismatch")
 
 // defaultS3Timeout is the default timeout for S3 operations when no context
-// is provided. This prevents indefinite hangs on slow/unresponsive S3.
+// is provided and S3.OpTimeout isn't configured. This prevents indefinite
+// hangs on slow/unresponsive S3.
 const defaultS3Timeout = 30 * time.Second
 
+// defaultS3ListTimeout is the default timeout for listing all peer files when
+// S3.ListTimeout isn't configured
+const defaultS3ListTimeout = 2 * time.Minute
+
 // PeerFile represents a JSON file stored in S3 for a subscription's peers
 type PeerFile struct {
 	AssetName string     `json:"asset_name"`
@@ -59,12 +107,12 @@ type PeerInfo struct {
 
 // SavePeerToS3 adds or updates a peer in the S3 registry.
 // Uses ETag-based conditional writes to prevent lost updates from concurrent
-// modifications. Uses a default 30s timeout to prevent indefinite hangs.
+// modifications. Uses the S3 op timeout to prevent indefinite hangs.
 func (c *Client) SavePeerToS3(
 	assetName []byte,
 	pubkey, assignedIP string,
 ) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultS3Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), c.opTimeout())
 	defer cancel()
 	return c.SavePeerToS3WithContext(
 		ctx,
@@ -85,10 +133,14 @@ func (c *Client) SavePeerToS3WithContext(
 		return fmt.Errorf("failed to create S3 client: %w", err)
 	}
 
-	key := peerFileKey(assetName)
+	key := c.peerFileKey(assetName)
 
 	// Retry loop for handling concurrent modifications
-	for attempt := 0; attempt < maxS3Retries; attempt++ {
+	maxRetries := c.maxS3Retries()
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if err := s3RetryBackoff(ctx, attempt); err != nil {
+			return err
+		}
 		// Load existing file or create new
 		peerFile, loadErr := c.loadPeerFileFromS3(ctx, svc, key)
 		if loadErr != nil {
@@ -133,6 +185,7 @@ func (c *Client) SavePeerToS3WithContext(
 			Key:         aws.String(key),
 			Body:        bytes.NewReader(data),
 			ContentType: aws.String("application/json"),
+			Metadata:    peerFileMetadata(data),
 		}
 
 		// Use conditional write based on whether file existed
@@ -153,7 +206,7 @@ func (c *Client) SavePeerToS3WithContext(
 				code := apiErr.ErrorCode()
 				if code == "PreconditionFailed" ||
 					code == "ConditionalRequestConflict" {
-					slog.Debug(
+					requestid.Logger(ctx).Debug(
 						"S3 conditional write failed, retrying",
 						"attempt", attempt+1,
 						"key", key,
@@ -166,21 +219,25 @@ func (c *Client) SavePeerToS3WithContext(
 			return fmt.Errorf("failed to upload peer file to S3: %w", putErr)
 		}
 
-		// Success
+		requestid.Logger(ctx).Debug(
+			"saved peer to S3",
+			"key", key,
+			"attempt", attempt+1,
+		)
 		return nil
 	}
 
 	return fmt.Errorf(
 		"failed to save peer file after %d retries due to concurrent modifications",
-		maxS3Retries,
+		maxRetries,
 	)
 }
 
 // RemovePeerFromS3 removes a peer from the S3 registry.
 // Uses ETag-based conditional writes to prevent lost updates from concurrent
-// modifications. Uses a default 30s timeout to prevent indefinite hangs.
+// modifications. Uses the S3 op timeout to prevent indefinite hangs.
 func (c *Client) RemovePeerFromS3(assetName []byte, pubkey string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultS3Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), c.opTimeout())
 	defer cancel()
 	return c.RemovePeerFromS3WithContext(ctx, assetName, pubkey)
 }
@@ -197,10 +254,14 @@ func (c *Client) RemovePeerFromS3WithContext(
 		return fmt.Errorf("failed to create S3 client: %w", err)
 	}
 
-	key := peerFileKey(assetName)
+	key := c.peerFileKey(assetName)
 
 	// Retry loop for handling concurrent modifications
-	for attempt := 0; attempt < maxS3Retries; attempt++ {
+	maxRetries := c.maxS3Retries()
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if err := s3RetryBackoff(ctx, attempt); err != nil {
+			return err
+		}
 		// Load existing file
 		peerFile, loadErr := c.loadPeerFileFromS3(ctx, svc, key)
 		if loadErr != nil {
@@ -238,6 +299,7 @@ func (c *Client) RemovePeerFromS3WithContext(
 				Key:         aws.String(key),
 				Body:        bytes.NewReader(data),
 				ContentType: aws.String("application/json"),
+				Metadata:    peerFileMetadata(data),
 				IfMatch:     aws.String(peerFile.etag), // Conditional write
 			}
 
@@ -248,7 +310,7 @@ func (c *Client) RemovePeerFromS3WithContext(
 					code := apiErr.ErrorCode()
 					if code == "PreconditionFailed" ||
 						code == "ConditionalRequestConflict" {
-						slog.Debug(
+						requestid.Logger(ctx).Debug(
 							"S3 conditional write failed for empty file, retrying",
 							"attempt", attempt+1,
 							"key", key,
@@ -279,6 +341,7 @@ func (c *Client) RemovePeerFromS3WithContext(
 			Key:         aws.String(key),
 			Body:        bytes.NewReader(data),
 			ContentType: aws.String("application/json"),
+			Metadata:    peerFileMetadata(data),
 			IfMatch:     aws.String(peerFile.etag), // Conditional write
 		}
 
@@ -291,7 +354,7 @@ func (c *Client) RemovePeerFromS3WithContext(
 				code := apiErr.ErrorCode()
 				if code == "PreconditionFailed" ||
 					code == "ConditionalRequestConflict" {
-					slog.Debug(
+					requestid.Logger(ctx).Debug(
 						"S3 conditional write failed, retrying",
 						"attempt", attempt+1,
 						"key", key,
@@ -310,29 +373,169 @@ func (c *Client) RemovePeerFromS3WithContext(
 
 	return fmt.Errorf(
 		"failed to remove peer after %d retries due to concurrent modifications",
-		maxS3Retries,
+		maxRetries,
+	)
+}
+
+// ErrPeerNotInS3 is returned when a peer to update is missing from its
+// subscription's S3 peer file
+var ErrPeerNotInS3 = errors.New("peer not found in S3 registry")
+
+// RotatePeerInS3WithContext replaces a peer's pubkey in the S3 registry,
+// keeping its assigned IP and creation time. Uses ETag-based conditional
+// writes like SavePeerToS3WithContext.
+func (c *Client) RotatePeerInS3WithContext(
+	ctx context.Context,
+	assetName []byte,
+	oldPubkey, newPubkey string,
+) error {
+	svc, err := c.createS3Client()
+	if err != nil {
+		return fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	key := c.peerFileKey(assetName)
+
+	// Retry loop for handling concurrent modifications
+	maxRetries := c.maxS3Retries()
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if err := s3RetryBackoff(ctx, attempt); err != nil {
+			return err
+		}
+		peerFile, loadErr := c.loadPeerFileFromS3(ctx, svc, key)
+		if loadErr != nil {
+			return fmt.Errorf("failed to load peer file: %w", loadErr)
+		}
+		if peerFile == nil {
+			return ErrPeerNotInS3
+		}
+
+		// Swap the pubkey in place
+		found := false
+		for i, p := range peerFile.Peers {
+			if p.Pubkey == newPubkey {
+				return fmt.Errorf("new pubkey already in S3 registry")
+			}
+			if p.Pubkey == oldPubkey {
+				peerFile.Peers[i].Pubkey = newPubkey
+				found = true
+			}
+		}
+		if !found {
+			return ErrPeerNotInS3
+		}
+		peerFile.UpdatedAt = time.Now().Unix()
+
+		data, marshalErr := json.Marshal(peerFile)
+		if marshalErr != nil {
+			return fmt.Errorf("failed to marshal peer file: %w", marshalErr)
+		}
+
+		putInput := &s3.PutObjectInput{
+			Bucket:      aws.String(c.config.S3.ClientBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/json"),
+			Metadata:    peerFileMetadata(data),
+			IfMatch:     aws.String(peerFile.etag), // Conditional write
+		}
+
+		_, putErr := svc.PutObject(ctx, putInput)
+		if putErr != nil {
+			// Check if this is a precondition failure (concurrent modification)
+			var apiErr smithy.APIError
+			if errors.As(putErr, &apiErr) {
+				code := apiErr.ErrorCode()
+				if code == "PreconditionFailed" ||
+					code == "ConditionalRequestConflict" {
+					requestid.Logger(ctx).Debug(
+						"S3 conditional write failed, retrying",
+						"attempt", attempt+1,
+						"key", key,
+						"code", code,
+					)
+					continue // Retry with fresh data
+				}
+			}
+			return fmt.Errorf("failed to update peer file in S3: %w", putErr)
+		}
+
+		requestid.Logger(ctx).Debug(
+			"rotated peer in S3",
+			"key", key,
+			"attempt", attempt+1,
+		)
+		return nil
+	}
+
+	return fmt.Errorf(
+		"failed to rotate peer after %d retries due to concurrent modifications",
+		maxRetries,
 	)
 }
 
+// maxS3Retries returns the maximum number of attempts at an S3 conditional
+// write
+func (c *Client) maxS3Retries() int {
+	if c.config.S3.MaxWriteRetries > 0 {
+		return c.config.S3.MaxWriteRetries
+	}
+	return defaultMaxS3Retries
+}
+
+// opTimeout returns the timeout for S3 operations made without a caller
+// deadline
+func (c *Client) opTimeout() time.Duration {
+	if c.config.S3.OpTimeout > 0 {
+		return c.config.S3.OpTimeout
+	}
+	return defaultS3Timeout
+}
+
+// listTimeout returns the timeout for listing all peer files
+func (c *Client) listTimeout() time.Duration {
+	if c.config.S3.ListTimeout > 0 {
+		return c.config.S3.ListTimeout
+	}
+	return defaultS3ListTimeout
+}
+
+// s3RetryBackoff waits before retrying an S3 conditional write that lost to a
+// concurrent write. The first attempt doesn't wait. The delay is randomized
+// between half and all of an exponentially growing bound, so writers that
+// conflicted don't retry in lockstep.
+func s3RetryBackoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return nil
+	}
+	bound := s3RetryBaseDelay
+	for i := 1; i < attempt && bound < s3RetryMaxDelay; i++ {
+		bound *= 2
+	}
+	bound = min(bound, s3RetryMaxDelay)
+	delay := bound/2 + rand.N(bound/2+1)
+	return s3RetrySleep(ctx, delay)
+}
+
 // LoadPeersFromS3 loads and parses a peer file from S3.
 // Returns nil if the file is not found (not an error).
-// Uses a default 30s timeout to prevent indefinite hangs.
+// Uses the S3 op timeout to prevent indefinite hangs.
 func (c *Client) LoadPeersFromS3(assetName []byte) (*PeerFile, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultS3Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), c.opTimeout())
 	defer cancel()
 	svc, err := c.createS3Client()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create S3 client: %w", err)
 	}
 
-	key := peerFileKey(assetName)
+	key := c.peerFileKey(assetName)
 	return c.loadPeerFileFromS3(ctx, svc, key)
 }
 
-// ListAllPeerFiles lists all keys with prefix "peers/"
-// Uses a 2 minute timeout as listing can take longer with many files.
+// ListAllPeerFiles lists all keys with the peer file prefix
+// Uses the S3 list timeout, as listing can take longer with many files.
 func (c *Client) ListAllPeerFiles() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), c.listTimeout())
 	defer cancel()
 	svc, err := c.createS3Client()
 	if err != nil {
@@ -342,7 +545,7 @@ func (c *Client) ListAllPeerFiles() ([]string, error) {
 	var keys []string
 	paginator := s3.NewListObjectsV2Paginator(svc, &s3.ListObjectsV2Input{
 		Bucket: aws.String(c.config.S3.ClientBucket),
-		Prefix: aws.String(peersPrefix),
+		Prefix: aws.String(c.peersPrefix()),
 	})
 
 	for paginator.HasMorePages() {
@@ -394,6 +597,12 @@ func (c *Client) loadPeerFileFromS3(
 		return nil, fmt.Errorf("failed to read peer file body: %w", err)
 	}
 
+	// Files written before checksums were stored have none to verify
+	if checksum, ok := result.Metadata[peerFileChecksumKey]; ok &&
+		!strings.EqualFold(checksum, peerFileChecksum(data)) {
+		return nil, fmt.Errorf("%w: %s", ErrPeerFileChecksum, key)
+	}
+
 	var peerFile PeerFile
 	if err := json.Unmarshal(data, &peerFile); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal peer file: %w", err)
@@ -407,109 +616,281 @@ func (c *Client) loadPeerFileFromS3(
 	return &peerFile, nil
 }
 
+// peerFileChecksum returns the hex SHA-256 of a peer file body
+func peerFileChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// peerFileMetadata returns the object metadata stored with a peer file body
+func peerFileMetadata(data []byte) map[string]string {
+	return map[string]string{peerFileChecksumKey: peerFileChecksum(data)}
+}
+
+// peersPrefix returns the configured S3 key prefix for peer files
+func (c *Client) peersPrefix() string {
+	if c.config.S3.PeerKeyPrefix == "" {
+		return defaultPeersPrefix
+	}
+	return c.config.S3.PeerKeyPrefix
+}
+
 // peerFileKey generates the S3 key for a peer file
-func peerFileKey(assetName []byte) string {
-	return fmt.Sprintf("%s%s.json", peersPrefix, hex.EncodeToString(assetName))
+func (c *Client) peerFileKey(assetName []byte) string {
+	return fmt.Sprintf(
+		"%s%s.json",
+		c.peersPrefix(),
+		hex.EncodeToString(assetName),
+	)
+}
+
+// RebuildSummary reports the outcome of a rebuild of WG peers from S3
+type RebuildSummary struct {
+	// PeerFiles is the number of peer files listed in S3
+	PeerFiles int
+	// Peers is the number of peers imported, or that would be imported in a
+	// dry run
+	Peers int
+	// Errors describes each peer file or peer that was skipped
+	Errors []string
 }
 
 // RebuildWGPeersFromS3 loads all peer files from S3 and populates the database.
 // This is called on startup when the database is empty (ephemeral indexer support).
+// With dryRun, the peer files are loaded and validated without writing to the
+// database, which may then be nil.
 func (c *Client) RebuildWGPeersFromS3(
 	db *database.Database,
 	region string,
-) error {
-	slog.Info("Rebuilding WG peers from S3...")
+	dryRun bool,
+) (RebuildSummary, error) {
+	var summary RebuildSummary
+	slog.Info("Rebuilding WG peers from S3...", "dry_run", dryRun)
 
 	// 1. List all peer files using ListAllPeerFiles()
 	keys, err := c.ListAllPeerFiles()
 	if err != nil {
-		return fmt.Errorf("failed to list peer files from S3: %w", err)
+		return summary, fmt.Errorf("failed to list peer files from S3: %w", err)
 	}
+	summary.PeerFiles = len(keys)
 
 	slog.Info("Found peer files in S3", "count", len(keys))
 
+	svc, err := c.createS3Client()
+	if err != nil {
+		return summary, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	// skip logs a skipped peer file or peer and records it in the summary
+	skip := func(msg string, key string, err error) {
+		if err != nil {
+			slog.Warn(msg+", skipping", "key", key, "error", err)
+			summary.Errors = append(
+				summary.Errors,
+				fmt.Sprintf("%s: %s: %s", key, msg, err),
+			)
+			return
+		}
+		slog.Warn(msg+", skipping", "key", key)
+		summary.Errors = append(summary.Errors, key+": "+msg)
+	}
+
 	// 2. For each file, load using LoadPeersFromS3 (extract asset name from key)
-	loadedCount := 0
+	seenPubkeys := make(map[string]bool)
 	for _, key := range keys {
-		// Extract asset name hex from key (format: peers/{hex_asset_name}.json)
-		assetNameHex := extractAssetNameFromKey(key)
+		// Extract asset name hex from key (format: {prefix}{hex_asset_name}.json)
+		assetNameHex := c.extractAssetNameFromKey(key)
 		if assetNameHex == "" {
-			slog.Warn(
-				"Failed to extract asset name from key, skipping",
-				"key", key,
-			)
+			skip("Failed to extract asset name from key", key, nil)
 			continue
 		}
 
 		assetName, err := hex.DecodeString(assetNameHex)
 		if err != nil {
-			slog.Warn(
-				"Failed to decode asset name hex, skipping",
-				"key", key,
-				"error", err,
-			)
+			skip("Failed to decode asset name hex", key, err)
 			continue
 		}
 
 		// Load the peer file
-		peerFile, err := c.LoadPeersFromS3(assetName)
-		if err != nil {
-			slog.Warn(
-				"Failed to load peer file from S3, skipping",
+		peerFile, err := c.loadListedPeerFile(svc, key)
+		if errors.Is(err, ErrPeerFileChecksum) {
+			slog.Error(
+				"Peer file failed checksum verification, skipping",
 				"key", key,
-				"error", err,
 			)
+			summary.Errors = append(
+				summary.Errors,
+				key+": Peer file failed checksum verification",
+			)
+			continue
+		}
+		if err != nil {
+			skip("Failed to load peer file from S3", key, err)
 			continue
 		}
 
 		if peerFile == nil {
-			slog.Warn("Peer file is nil, skipping", "key", key)
+			skip("Listed peer file not found in S3", key, nil)
 			continue
 		}
 
 		// 3. For each peer in the file, call db.AddWGPeer()
 		for _, peer := range peerFile.Peers {
+			// Truncate pubkey safely for logging
+			pubkeyPrefix := peer.Pubkey
+			if len(pubkeyPrefix) > 8 {
+				pubkeyPrefix = pubkeyPrefix[:8] + "..."
+			}
+			if dryRun {
+				// Pubkeys are unique in the database, so a repeat would fail
+				// to import
+				if seenPubkeys[peer.Pubkey] {
+					skip(
+						"Duplicate WG peer pubkey "+pubkeyPrefix,
+						key,
+						nil,
+					)
+					continue
+				}
+				seenPubkeys[peer.Pubkey] = true
+				summary.Peers++
+				continue
+			}
 			if err := db.AddWGPeer(
 				assetName,
 				peer.Pubkey,
 				peer.AssignedIP,
 			); err != nil {
-				// Truncate pubkey safely for logging
-				pubkeyPrefix := peer.Pubkey
-				if len(pubkeyPrefix) > 8 {
-					pubkeyPrefix = pubkeyPrefix[:8] + "..."
-				}
-				slog.Warn(
-					"Failed to add WG peer to database",
-					"pubkey", pubkeyPrefix,
-					"error", err,
+				skip(
+					"Failed to add WG peer "+pubkeyPrefix+" to database",
+					key,
+					err,
 				)
 				continue
 			}
-			loadedCount++
+			summary.Peers++
 		}
 	}
 
-	slog.Info("Loaded WG peers from S3", "count", loadedCount)
+	if dryRun {
+		slog.Info(
+			"Dry run: would load WG peers from S3",
+			"count", summary.Peers,
+			"errors", len(summary.Errors),
+		)
+		return summary, nil
+	}
+
+	slog.Info("Loaded WG peers from S3", "count", summary.Peers)
 
 	// 4. After all peers loaded, call db.RebuildIPPool(region)
 	if err := db.RebuildIPPool(region); err != nil {
-		return fmt.Errorf("failed to rebuild IP pool: %w", err)
+		return summary, fmt.Errorf("failed to rebuild IP pool: %w", err)
+	}
+
+	// 5. Give a fresh IP to any peer whose IP another peer already claims
+	if err := c.resolveDuplicateWGPeerIPs(db, region); err != nil {
+		return summary, fmt.Errorf(
+			"failed to resolve duplicate peer IPs: %w",
+			err,
+		)
 	}
 
 	slog.Info("Rebuilt IP pool for region", "region", region)
 
+	return summary, nil
+}
+
+// resolveDuplicateWGPeerIPs reassigns peers that share an assigned IP with an
+// earlier peer in the region, which would give the WG container conflicting
+// routes. Each reassignment is written back to the peer's S3 file so the next
+// rebuild doesn't import the conflict again.
+func (c *Client) resolveDuplicateWGPeerIPs(
+	db *database.Database,
+	region string,
+) error {
+	duplicates, err := db.DuplicateWGPeerIPs(region)
+	if err != nil {
+		return err
+	}
+	for _, peer := range duplicates {
+		newIP, err := db.AllocateIP(region)
+		if err != nil {
+			return fmt.Errorf("failed to allocate IP: %w", err)
+		}
+		slog.Error(
+			"Duplicate WG peer IP found in S3, reassigning peer",
+			"asset_name", hex.EncodeToString(peer.AssetName),
+			"duplicate_ip", peer.AssignedIP,
+			"new_ip", newIP,
+		)
+		if err := db.UpdateWGPeerIP(peer.Pubkey, newIP); err != nil {
+			return fmt.Errorf("failed to update peer IP: %w", err)
+		}
+		if err := c.SavePeerToS3(peer.AssetName, peer.Pubkey, newIP); err != nil {
+			slog.Error(
+				"Failed to save reassigned WG peer IP to S3",
+				"asset_name", hex.EncodeToString(peer.AssetName),
+				"new_ip", newIP,
+				"error", err,
+			)
+		}
+	}
 	return nil
 }
 
+// loadListedPeerFile loads a peer file that was just listed. Stores that are
+// only eventually consistent can briefly report a listed key as missing, so
+// a not-found read is retried a bounded number of times, as are transient
+// errors. It returns nil if the file is still not found.
+func (c *Client) loadListedPeerFile(
+	svc *s3.Client,
+	key string,
+) (*PeerFile, error) {
+	var peerFile *PeerFile
+	var err error
+	for attempt := 1; attempt <= rebuildReadAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(rebuildReadRetryDelay)
+		}
+		ctx, cancel := context.WithTimeout(
+			context.Background(),
+			c.opTimeout(),
+		)
+		peerFile, err = c.loadPeerFileFromS3(ctx, svc, key)
+		cancel()
+		if err == nil && peerFile != nil {
+			return peerFile, nil
+		}
+		if err != nil && !isTransientS3Error(err) {
+			return nil, err
+		}
+		slog.Debug(
+			"peer file read failed during rebuild, retrying",
+			"key", key,
+			"attempt", attempt,
+			"error", err,
+		)
+	}
+	return peerFile, err
+}
+
+// isTransientS3Error reports whether an S3 error is worth retrying, using the
+// AWS SDK's own classification (throttling, 5xx responses, connection errors)
+func isTransientS3Error(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).
+		IsErrorRetryable(err) == aws.TrueTernary
+}
+
 // extractAssetNameFromKey extracts the hex asset name from an S3 key.
-// Key format: peers/{hex_asset_name}.json
-func extractAssetNameFromKey(key string) string {
-	// Remove "peers/" prefix
-	if !strings.HasPrefix(key, peersPrefix) {
+// Key format: {prefix}{hex_asset_name}.json
+func (c *Client) extractAssetNameFromKey(key string) string {
+	// Remove peer file prefix
+	prefix := c.peersPrefix()
+	if !strings.HasPrefix(key, prefix) {
 		return ""
 	}
-	name := strings.TrimPrefix(key, peersPrefix)
+	name := strings.TrimPrefix(key, prefix)
 
 	// Remove ".json" suffix
 	if !strings.HasSuffix(name, ".json") {
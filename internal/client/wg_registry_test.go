@@ -0,0 +1,728 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+// newTestS3Bucket starts a fake S3 endpoint that lists and serves the given
+// peer files, with any checksum for a key returned in its metadata. Files
+// written to it are stored back into objects, and deleted files are removed
+// from it. A key in notFoundReads is reported missing for that many reads
+// before being served, like a store that's only eventually consistent.
+func newTestS3Bucket(
+	t *testing.T,
+	cfg *config.Config,
+	objects map[string]string,
+	checksums map[string]string,
+	notFoundReads map[string]int,
+) map[string]int {
+	t.Helper()
+
+	// Static credentials keep the AWS SDK from searching the environment
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	if checksums == nil {
+		checksums = make(map[string]string)
+	}
+	var mu sync.Mutex
+	reads := make(map[string]int)
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.URL.Path == "/test-bucket" || r.URL.Path == "/test-bucket/" {
+				// S3 lists keys in lexicographic order
+				var contents strings.Builder
+				for _, key := range slices.Sorted(maps.Keys(objects)) {
+					fmt.Fprintf(
+						&contents,
+						"<Contents><Key>%s</Key></Contents>",
+						key,
+					)
+				}
+				w.Header().Set("Content-Type", "application/xml")
+				fmt.Fprintf(
+					w,
+					`<ListBucketResult><Name>test-bucket</Name><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>%s</ListBucketResult>`,
+					len(objects),
+					contents.String(),
+				)
+				return
+			}
+			key := strings.TrimPrefix(r.URL.Path, "/test-bucket/")
+			if r.Method == http.MethodDelete {
+				delete(objects, key)
+				delete(checksums, key)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if r.Method == http.MethodPut {
+				body, _ := io.ReadAll(r.Body)
+				objects[key] = string(body)
+				checksums[key] = r.Header.Get(
+					"x-amz-meta-" + peerFileChecksumKey,
+				)
+				w.Header().Set("ETag", `"etag"`)
+				return
+			}
+			reads[key]++
+			body, ok := objects[key]
+			if !ok || reads[key] <= notFoundReads[key] {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+				return
+			}
+			w.Header().Set("ETag", `"etag"`)
+			if checksum, ok := checksums[key]; ok {
+				w.Header().Set("x-amz-meta-"+peerFileChecksumKey, checksum)
+			}
+			_, _ = w.Write([]byte(body))
+		}),
+	)
+	t.Cleanup(server.Close)
+
+	cfg.S3.ClientBucket = "test-bucket"
+	cfg.S3.Endpoint = server.URL
+	return reads
+}
+
+func TestRebuildWGPeersFromS3EventualConsistency(t *testing.T) {
+	origDelay := rebuildReadRetryDelay
+	rebuildReadRetryDelay = 0
+	t.Cleanup(func() { rebuildReadRetryDelay = origDelay })
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		Vpn:      config.VpnConfig{Region: "test", WGSubnet: "10.8.0"},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	lagging := []byte("lagging")
+	missing := []byte("missing")
+	c := NewWithConfig(cfg)
+	laggingKey := c.peerFileKey(lagging)
+	missingKey := c.peerFileKey(missing)
+	reads := newTestS3Bucket(
+		t,
+		cfg,
+		map[string]string{
+			laggingKey: fmt.Sprintf(
+				`{"asset_name":%q,"peers":[{"pubkey":"pubkey-1","assigned_ip":"10.8.0.2"}]}`,
+				hex.EncodeToString(lagging),
+			),
+			missingKey: `{}`,
+		},
+		nil,
+		map[string]int{
+			// Not found once, then served
+			laggingKey: 1,
+			// Never found within the bounded attempts
+			missingKey: rebuildReadAttempts,
+		},
+	)
+
+	if _, err := c.RebuildWGPeersFromS3(
+		db,
+		cfg.Vpn.Region,
+		false,
+	); err != nil {
+		t.Fatalf("RebuildWGPeersFromS3: %v", err)
+	}
+
+	if reads[laggingKey] != 2 {
+		t.Errorf("lagging key reads = %d, want 2", reads[laggingKey])
+	}
+	peers, err := db.GetWGPeersByAsset(lagging)
+	if err != nil {
+		t.Fatalf("GetWGPeersByAsset: %v", err)
+	}
+	if len(peers) != 1 || peers[0].Pubkey != "pubkey-1" {
+		t.Errorf("peers = %+v, want pubkey-1 restored", peers)
+	}
+
+	// A key that stays missing is skipped after the bounded attempts
+	if reads[missingKey] != rebuildReadAttempts {
+		t.Errorf(
+			"missing key reads = %d, want %d",
+			reads[missingKey],
+			rebuildReadAttempts,
+		)
+	}
+	peers, err = db.GetWGPeersByAsset(missing)
+	if err != nil {
+		t.Fatalf("GetWGPeersByAsset: %v", err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("peers = %+v, want none for missing key", peers)
+	}
+}
+
+func TestPeerFileKeyPrefix(t *testing.T) {
+	assetName := []byte("client")
+	assetNameHex := hex.EncodeToString(assetName)
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+		// other is a key outside the prefix that must not be extracted
+		other string
+	}{
+		{
+			name:   "default",
+			prefix: "",
+			want:   "peers/" + assetNameHex + ".json",
+			other:  "tenant-a/peers/" + assetNameHex + ".json",
+		},
+		{
+			name:   "custom",
+			prefix: "tenant-a/peers/",
+			want:   "tenant-a/peers/" + assetNameHex + ".json",
+			other:  "peers/" + assetNameHex + ".json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewWithConfig(&config.Config{
+				S3: config.S3Config{PeerKeyPrefix: tt.prefix},
+			})
+			key := c.peerFileKey(assetName)
+			if key != tt.want {
+				t.Errorf("peerFileKey() = %q, want %q", key, tt.want)
+			}
+			if got := c.extractAssetNameFromKey(key); got != assetNameHex {
+				t.Errorf(
+					"extractAssetNameFromKey(%q) = %q, want %q",
+					key,
+					got,
+					assetNameHex,
+				)
+			}
+			if got := c.extractAssetNameFromKey(tt.other); got != "" {
+				t.Errorf(
+					"extractAssetNameFromKey(%q) = %q, want empty",
+					tt.other,
+					got,
+				)
+			}
+		})
+	}
+}
+
+func TestRebuildWGPeersFromS3Checksum(t *testing.T) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		Vpn:      config.VpnConfig{Region: "test", WGSubnet: "10.8.0"},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	intact := []byte("intact")
+	tampered := []byte("tampered")
+	c := NewWithConfig(cfg)
+	intactKey := c.peerFileKey(intact)
+	tamperedKey := c.peerFileKey(tampered)
+	intactBody := fmt.Sprintf(
+		`{"asset_name":%q,"peers":[{"pubkey":"pubkey-1","assigned_ip":"10.8.0.2"}]}`,
+		hex.EncodeToString(intact),
+	)
+	originalBody := fmt.Sprintf(
+		`{"asset_name":%q,"peers":[{"pubkey":"pubkey-2","assigned_ip":"10.8.0.3"}]}`,
+		hex.EncodeToString(tampered),
+	)
+	tamperedBody := strings.Replace(originalBody, "10.8.0.3", "10.8.0.9", 1)
+	newTestS3Bucket(
+		t,
+		cfg,
+		map[string]string{
+			intactKey:   intactBody,
+			tamperedKey: tamperedBody,
+		},
+		map[string]string{
+			intactKey: peerFileChecksum([]byte(intactBody)),
+			// Checksum of the body as written, not as served
+			tamperedKey: peerFileChecksum([]byte(originalBody)),
+		},
+		nil,
+	)
+
+	_, err = c.LoadPeersFromS3(tampered)
+	if !errors.Is(err, ErrPeerFileChecksum) {
+		t.Errorf(
+			"LoadPeersFromS3() error = %v, want %v",
+			err,
+			ErrPeerFileChecksum,
+		)
+	}
+
+	if _, err := c.RebuildWGPeersFromS3(
+		db,
+		cfg.Vpn.Region,
+		false,
+	); err != nil {
+		t.Fatalf("RebuildWGPeersFromS3: %v", err)
+	}
+	peers, err := db.GetWGPeersByAsset(intact)
+	if err != nil {
+		t.Fatalf("GetWGPeersByAsset: %v", err)
+	}
+	if len(peers) != 1 || peers[0].Pubkey != "pubkey-1" {
+		t.Errorf("peers = %+v, want pubkey-1 restored", peers)
+	}
+	peers, err = db.GetWGPeersByAsset(tampered)
+	if err != nil {
+		t.Fatalf("GetWGPeersByAsset: %v", err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("peers = %+v, want none for tampered file", peers)
+	}
+}
+
+func TestRebuildWGPeersFromS3DuplicateIPs(t *testing.T) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		Vpn:      config.VpnConfig{Region: "test", WGSubnet: "10.8.0"},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	// Two subscriptions' peer files claim the same IP
+	first := []byte("first")
+	second := []byte("second")
+	c := NewWithConfig(cfg)
+	objects := make(map[string]string)
+	for _, assetName := range [][]byte{first, second} {
+		if err := db.AddClient(
+			assetName,
+			time.Now().Add(time.Hour),
+			[]byte("credential"),
+			cfg.Vpn.Region,
+			[]byte("txhash"),
+			0,
+			0,
+		); err != nil {
+			t.Fatalf("failed to add client: %v", err)
+		}
+		objects[c.peerFileKey(assetName)] = fmt.Sprintf(
+			`{"asset_name":%q,"peers":[{"pubkey":"pubkey-%s","assigned_ip":"10.8.0.2"}]}`,
+			hex.EncodeToString(assetName),
+			assetName,
+		)
+	}
+	newTestS3Bucket(t, cfg, objects, nil, nil)
+
+	if _, err := c.RebuildWGPeersFromS3(
+		db,
+		cfg.Vpn.Region,
+		false,
+	); err != nil {
+		t.Fatalf("RebuildWGPeersFromS3: %v", err)
+	}
+
+	// The first peer keeps the IP and the second gets a new one
+	firstPeers, err := db.GetWGPeersByAsset(first)
+	if err != nil {
+		t.Fatalf("GetWGPeersByAsset: %v", err)
+	}
+	secondPeers, err := db.GetWGPeersByAsset(second)
+	if err != nil {
+		t.Fatalf("GetWGPeersByAsset: %v", err)
+	}
+	if len(firstPeers) != 1 || len(secondPeers) != 1 {
+		t.Fatalf("peers = %+v and %+v, want one each", firstPeers, secondPeers)
+	}
+	if firstPeers[0].AssignedIP != "10.8.0.2" {
+		t.Errorf("first peer IP = %s, want 10.8.0.2", firstPeers[0].AssignedIP)
+	}
+	newIP := secondPeers[0].AssignedIP
+	if newIP == "10.8.0.2" {
+		t.Fatalf("second peer still has the duplicate IP %s", newIP)
+	}
+	duplicates, err := db.DuplicateWGPeerIPs(cfg.Vpn.Region)
+	if err != nil {
+		t.Fatalf("DuplicateWGPeerIPs: %v", err)
+	}
+	if len(duplicates) != 0 {
+		t.Errorf("duplicates = %+v, want none", duplicates)
+	}
+
+	// The fix is persisted to S3
+	peerFile, err := c.LoadPeersFromS3(second)
+	if err != nil {
+		t.Fatalf("LoadPeersFromS3: %v", err)
+	}
+	if peerFile == nil || len(peerFile.Peers) != 1 ||
+		peerFile.Peers[0].AssignedIP != newIP {
+		t.Errorf("S3 peer file = %+v, want peer with IP %s", peerFile, newIP)
+	}
+}
+
+func TestRebuildWGPeersFromS3DryRun(t *testing.T) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		Vpn:      config.VpnConfig{Region: "test", WGSubnet: "10.8.0"},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	valid := []byte("valid")
+	c := NewWithConfig(cfg)
+	newTestS3Bucket(
+		t,
+		cfg,
+		map[string]string{
+			c.peerFileKey(valid): fmt.Sprintf(
+				`{"asset_name":%q,"peers":[{"pubkey":"pubkey-1","assigned_ip":"10.8.0.2"},{"pubkey":"pubkey-2","assigned_ip":"10.8.0.3"}]}`,
+				hex.EncodeToString(valid),
+			),
+			c.peerFileKey([]byte("corrupt")): `not json`,
+		},
+		nil,
+		nil,
+	)
+
+	summary, err := c.RebuildWGPeersFromS3(db, cfg.Vpn.Region, true)
+	if err != nil {
+		t.Fatalf("RebuildWGPeersFromS3: %v", err)
+	}
+	if summary.PeerFiles != 2 {
+		t.Errorf("peer files = %d, want 2", summary.PeerFiles)
+	}
+	if summary.Peers != 2 {
+		t.Errorf("peers = %d, want 2", summary.Peers)
+	}
+	if len(summary.Errors) != 1 {
+		t.Errorf("errors = %v, want one for the corrupt file", summary.Errors)
+	}
+
+	// Nothing is written to the database
+	hasPeers, err := db.HasWGPeers()
+	if err != nil {
+		t.Fatalf("HasWGPeers: %v", err)
+	}
+	if hasPeers {
+		t.Error("dry run added WG peers to the database")
+	}
+	regions, err := db.GetWGIPPoolRegions()
+	if err != nil {
+		t.Fatalf("GetWGIPPoolRegions: %v", err)
+	}
+	if len(regions) != 0 {
+		t.Errorf("dry run created IP pools for %v", regions)
+	}
+}
+
+func TestSavePeerToS3RetryBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		conflicts  int
+		wantSleeps int
+		wantErr    bool
+	}{
+		{
+			name:       "no conflict",
+			maxRetries: 3,
+		},
+		{
+			name:       "conflicts then success",
+			maxRetries: 3,
+			conflicts:  2,
+			wantSleeps: 2,
+		},
+		{
+			name:       "retries exhausted",
+			maxRetries: 3,
+			conflicts:  3,
+			wantSleeps: 2,
+			wantErr:    true,
+		},
+		{
+			name:       "more retries configured",
+			maxRetries: 5,
+			conflicts:  4,
+			wantSleeps: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sleeps []time.Duration
+			origSleep := s3RetrySleep
+			t.Cleanup(func() { s3RetrySleep = origSleep })
+			s3RetrySleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			t.Setenv("AWS_ACCESS_KEY_ID", "test")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+			t.Setenv("AWS_REGION", "us-east-1")
+			// Fake S3 endpoint with no peer file, rejecting the first writes
+			// as if they lost to concurrent writers
+			var mu sync.Mutex
+			puts := 0
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					defer mu.Unlock()
+					w.Header().Set("Content-Type", "application/xml")
+					if r.Method != http.MethodPut {
+						w.WriteHeader(http.StatusNotFound)
+						_, _ = w.Write(
+							[]byte(`<Error><Code>NoSuchKey</Code></Error>`),
+						)
+						return
+					}
+					puts++
+					if puts <= tt.conflicts {
+						w.WriteHeader(http.StatusPreconditionFailed)
+						_, _ = w.Write(
+							[]byte(`<Error><Code>PreconditionFailed</Code></Error>`),
+						)
+						return
+					}
+					w.Header().Set("ETag", `"etag"`)
+				}),
+			)
+			t.Cleanup(server.Close)
+
+			cfg := &config.Config{
+				S3: config.S3Config{
+					ClientBucket:    "test-bucket",
+					Endpoint:        server.URL,
+					MaxWriteRetries: tt.maxRetries,
+				},
+			}
+			err := NewWithConfig(cfg).SavePeerToS3(
+				[]byte("client"),
+				"pubkey",
+				"10.8.0.2",
+			)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf(
+					"SavePeerToS3 error = %v, want error %t",
+					err,
+					tt.wantErr,
+				)
+			}
+			if len(sleeps) != tt.wantSleeps {
+				t.Fatalf("slept %d times, want %d", len(sleeps), tt.wantSleeps)
+			}
+			// Each wait is at least half of a bound that doubles per retry
+			bound := s3RetryBaseDelay
+			for i, d := range sleeps {
+				if d < bound/2 || d > bound {
+					t.Errorf(
+						"sleep %d = %s, want between %s and %s",
+						i,
+						d,
+						bound/2,
+						bound,
+					)
+				}
+				bound = min(bound*2, s3RetryMaxDelay)
+			}
+		})
+	}
+}
+
+func TestSavePeerToS3RetryCancelled(t *testing.T) {
+	origSleep := s3RetrySleep
+	t.Cleanup(func() { s3RetrySleep = origSleep })
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	// Every write conflicts
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			if r.Method != http.MethodPut {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+				return
+			}
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = w.Write(
+				[]byte(`<Error><Code>PreconditionFailed</Code></Error>`),
+			)
+		}),
+	)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	// Cancel while waiting to retry
+	s3RetrySleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return origSleep(ctx, d)
+	}
+	cfg := &config.Config{
+		S3: config.S3Config{
+			ClientBucket: "test-bucket",
+			Endpoint:     server.URL,
+		},
+	}
+	err := NewWithConfig(cfg).SavePeerToS3WithContext(
+		ctx,
+		[]byte("client"),
+		"pubkey",
+		"10.8.0.2",
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf(
+			"SavePeerToS3WithContext error = %v, want %v",
+			err,
+			context.Canceled,
+		)
+	}
+}
+
+func TestS3ConfigApplied(t *testing.T) {
+	tests := []struct {
+		name        string
+		s3          config.S3Config
+		wantRetries int
+		wantOp      time.Duration
+		wantList    time.Duration
+	}{
+		{
+			name:        "defaults",
+			wantRetries: defaultMaxS3Retries,
+			wantOp:      defaultS3Timeout,
+			wantList:    defaultS3ListTimeout,
+		},
+		{
+			name: "custom",
+			s3: config.S3Config{
+				MaxWriteRetries: 7,
+				OpTimeout:       5 * time.Second,
+				ListTimeout:     10 * time.Minute,
+			},
+			wantRetries: 7,
+			wantOp:      5 * time.Second,
+			wantList:    10 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewWithConfig(&config.Config{S3: tt.s3})
+			if got := c.maxS3Retries(); got != tt.wantRetries {
+				t.Errorf("max retries = %d, want %d", got, tt.wantRetries)
+			}
+			if got := c.opTimeout(); got != tt.wantOp {
+				t.Errorf("op timeout = %s, want %s", got, tt.wantOp)
+			}
+			if got := c.listTimeout(); got != tt.wantList {
+				t.Errorf("list timeout = %s, want %s", got, tt.wantList)
+			}
+		})
+	}
+}
+
+func TestS3TimeoutsEnforced(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	// Fake S3 endpoint that never answers
+	done := make(chan struct{})
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-done:
+			}
+		}),
+	)
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(done) })
+
+	c := NewWithConfig(&config.Config{
+		S3: config.S3Config{
+			ClientBucket: "test-bucket",
+			Endpoint:     server.URL,
+			OpTimeout:    50 * time.Millisecond,
+			ListTimeout:  100 * time.Millisecond,
+		},
+	})
+	tests := []struct {
+		name string
+		op   func() error
+	}{
+		{
+			name: "load peers",
+			op: func() error {
+				_, err := c.LoadPeersFromS3([]byte("client"))
+				return err
+			},
+		},
+		{
+			name: "save peer",
+			op: func() error {
+				return c.SavePeerToS3([]byte("client"), "pubkey", "10.8.0.2")
+			},
+		},
+		{
+			name: "list peer files",
+			op: func() error {
+				_, err := c.ListAllPeerFiles()
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := tt.op()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("took %s, want the configured timeout", elapsed)
+			}
+		})
+	}
+}
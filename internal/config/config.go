@@ -15,12 +15,22 @@
 package config
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"net"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	ouroboros "github.com/blinklabs-io/gouroboros"
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
 	"github.com/kelseyhightower/envconfig"
 	"gopkg.in/yaml.v3"
 )
@@ -40,17 +50,88 @@ type Config struct {
 }
 
 type LoggingConfig struct {
-	Debug bool `yaml:"debug" envconfig:"LOGGING_DEBUG"`
+	Debug bool `yaml:"debug"  envconfig:"LOGGING_DEBUG"`
+	// Format selects the log output format: "json" (default) or "text"
+	Format string `yaml:"format" envconfig:"LOGGING_FORMAT"`
+	// Level sets the minimum log level ("debug", "info", "warn", "error").
+	// When set, it takes precedence over Debug.
+	Level string `yaml:"level"  envconfig:"LOGGING_LEVEL"`
+}
+
+// Log output formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// SlogLevel returns the configured minimum log level, falling back to Debug
+// when Level is unset. Level is assumed to have been validated by Load.
+func (c *LoggingConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	if c.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Level)); err == nil {
+			return level
+		}
+	}
+	if c.Debug {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// validateLoggingConfig normalizes and validates the logging format and level
+func validateLoggingConfig(logging *LoggingConfig) error {
+	logging.Format = strings.ToLower(strings.TrimSpace(logging.Format))
+	switch logging.Format {
+	case "":
+		logging.Format = LogFormatJSON
+	case LogFormatJSON, LogFormatText:
+	default:
+		return fmt.Errorf(
+			"invalid logging format %q: must be one of: json, text",
+			logging.Format,
+		)
+	}
+	logging.Level = strings.TrimSpace(logging.Level)
+	if logging.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(logging.Level)); err != nil {
+			return fmt.Errorf(
+				"invalid logging level %q: must be one of: debug, info, warn, error",
+				logging.Level,
+			)
+		}
+	}
+	return nil
 }
 
 type DebugConfig struct {
 	ListenAddress string `yaml:"address" envconfig:"DEBUG_ADDRESS"`
 	ListenPort    uint   `yaml:"port"    envconfig:"DEBUG_PORT"`
+	// EnablePprof serves the pprof profiling endpoints under /debug/pprof/ on
+	// the debug listener. Default: false
+	EnablePprof bool `yaml:"enablePprof" envconfig:"DEBUG_ENABLE_PPROF"`
 }
 
 type MetricsConfig struct {
 	ListenAddress string `yaml:"address" envconfig:"METRICS_ADDRESS"`
 	ListenPort    uint   `yaml:"port"    envconfig:"METRICS_PORT"`
+	// Token requires scrapes to send it as a Bearer token, and Username and
+	// Password require HTTP basic auth. Either is accepted when both are set.
+	// /metrics is open when neither is set.
+	Token    string `yaml:"token"    envconfig:"METRICS_TOKEN"`
+	Username string `yaml:"username" envconfig:"METRICS_USERNAME"`
+	Password string `yaml:"password" envconfig:"METRICS_PASSWORD"`
+}
+
+// validateMetricsConfig ensures basic auth credentials are set as a pair
+func validateMetricsConfig(metrics *MetricsConfig) error {
+	if (metrics.Username == "") != (metrics.Password == "") {
+		return errors.New(
+			"metrics Username and Password must be set together",
+		)
+	}
+	return nil
 }
 
 type IndexerConfig struct {
@@ -65,6 +146,38 @@ type IndexerConfig struct {
 	ReferenceToken     string `yaml:"referenceToken"     envconfig:"INDEXER_REFERENCE_TOKEN"`
 }
 
+// AddressNetworkId returns the network ID that addresses on the configured
+// network carry in their header. NetworkMagic takes precedence over Network,
+// as it does when syncing, and any magic other than mainnet's belongs to a
+// testnet. It returns false when neither identifies a network.
+func (c *IndexerConfig) AddressNetworkId() (uint8, bool) {
+	if c.NetworkMagic > 0 {
+		if network, ok := ouroboros.NetworkByNetworkMagic(
+			c.NetworkMagic,
+		); ok {
+			return network.Id, true
+		}
+		return lcommon.AddressNetworkTestnet, true
+	}
+	if network, ok := ouroboros.NetworkByName(c.Network); ok {
+		return network.Id, true
+	}
+	return 0, false
+}
+
+func validateIndexerConfig(indexer *IndexerConfig) error {
+	if indexer.ScriptAddress != "" {
+		if _, err := lcommon.NewAddress(indexer.ScriptAddress); err != nil {
+			return fmt.Errorf(
+				"invalid Indexer ScriptAddress %q: %w",
+				indexer.ScriptAddress,
+				err,
+			)
+		}
+	}
+	return nil
+}
+
 type DatabaseConfig struct {
 	Directory string `yaml:"dir" envconfig:"DATABASE_DIR"`
 }
@@ -81,7 +194,40 @@ type CaConfig struct {
 type S3Config struct {
 	ClientBucket    string `yaml:"clientBucket"    envconfig:"S3_CLIENT_BUCKET"`
 	ClientKeyPrefix string `yaml:"clientKeyPrefix" envconfig:"S3_CLIENT_KEY_PREFIX"`
+	PeerKeyPrefix   string `yaml:"peerKeyPrefix"   envconfig:"S3_PEER_KEY_PREFIX"`
 	Endpoint        string `yaml:"endpoint"        envconfig:"S3_ENDPOINT"`
+	// MaxWriteRetries is the number of attempts at a conditional write to a
+	// peer file that keeps losing to concurrent writes. Default: 3
+	MaxWriteRetries int `yaml:"maxWriteRetries" envconfig:"S3_MAX_WRITE_RETRIES"`
+	// OpTimeout bounds S3 operations made without a caller deadline, such as
+	// peer file and profile reads and writes. Default: 30s
+	OpTimeout time.Duration `yaml:"opTimeout" envconfig:"S3_OP_TIMEOUT"`
+	// ListTimeout bounds listing all peer files. Default: 2m
+	ListTimeout time.Duration `yaml:"listTimeout" envconfig:"S3_LIST_TIMEOUT"`
+}
+
+// validateS3Config ensures at least one conditional write attempt is made and
+// that the timeouts are usable
+func validateS3Config(s3 *S3Config) error {
+	if s3.MaxWriteRetries < 1 {
+		return fmt.Errorf(
+			"S3 MaxWriteRetries must be at least 1, got %d",
+			s3.MaxWriteRetries,
+		)
+	}
+	if s3.OpTimeout <= 0 {
+		return fmt.Errorf(
+			"S3 OpTimeout must be positive, got %s",
+			s3.OpTimeout,
+		)
+	}
+	if s3.ListTimeout <= 0 {
+		return fmt.Errorf(
+			"S3 ListTimeout must be positive, got %s",
+			s3.ListTimeout,
+		)
+	}
+	return nil
 }
 
 type VpnConfig struct {
@@ -89,6 +235,14 @@ type VpnConfig struct {
 	Region string `yaml:"region"         envconfig:"VPN_REGION"`
 	Port   int    `yaml:"port"           envconfig:"VPN_PORT"`
 	DNS    string `yaml:"dns"            envconfig:"VPN_DNS"`
+	// ProfileTemplate is the OpenVPN client profile template. It is rendered
+	// with fmt.Sprintf and must contain, in order, the placeholders for the
+	// remote host (%s), remote port (%d), DNS server (%s), client cert (%s),
+	// client key (%s), and CA cert (%s).
+	ProfileTemplate string `yaml:"profileTemplate" envconfig:"VPN_PROFILE_TEMPLATE"`
+	// LazyProfiles defers generating OpenVPN client profiles from indexing
+	// time to the first API request for the profile
+	LazyProfiles bool `yaml:"lazyProfiles" envconfig:"VPN_LAZY_PROFILES"`
 	// JWTKeyFile is the Ed25519 private key used to sign API session tokens
 	// (required for all protocols) and to authenticate the indexer to the
 	// WireGuard container.
@@ -101,8 +255,84 @@ type VpnConfig struct {
 	WGMaxDevices     int           `yaml:"wgMaxDevices"   envconfig:"VPN_WG_MAX_DEVICES"`       // Default: 3
 	WGSubnet         string        `yaml:"wgSubnet"       envconfig:"VPN_WG_SUBNET"`            // Default: "10.8.0" (forms 10.8.0.X)
 	WGExpireInterval time.Duration `yaml:"wgExpireInterval" envconfig:"VPN_WG_EXPIRE_INTERVAL"` // Default: 1h
+	WGAllowedIPs     []string      `yaml:"wgAllowedIPs"   envconfig:"VPN_WG_ALLOWED_IPS"`       // CIDRs routed through the tunnel. Default: "0.0.0.0/0"
+	WGKeepalive      int           `yaml:"wgKeepalive"    envconfig:"VPN_WG_KEEPALIVE"`         // PersistentKeepalive seconds (0 disables). Default: 25
+	WGDNS            []string      `yaml:"wgDNS"          envconfig:"VPN_WG_DNS"`               // DNS server IPs for WG profiles. Default: DNS, then "10.8.0.1"
+	WGDNSSearch      []string      `yaml:"wgDNSSearch"    envconfig:"VPN_WG_DNS_SEARCH"`        // Optional DNS search domains for WG profiles
+	// WGAllocationStrategy selects how AllocateIP picks an address: "next"
+	// scans forward from the last allocation, "lowest" always picks the lowest
+	// free address. Default: "next"
+	WGAllocationStrategy string `yaml:"wgAllocationStrategy" envconfig:"VPN_WG_ALLOCATION_STRATEGY"`
+	// WGContainerTimeout bounds each request to the WG container API.
+	// Default: 10s
+	WGContainerTimeout time.Duration `yaml:"wgContainerTimeout" envconfig:"VPN_WG_CONTAINER_TIMEOUT"`
+	// Startup health probe of the WG container. Attempts of 0 skips the
+	// probe. When Required is set the indexer exits if the container never
+	// becomes healthy, otherwise it logs a warning and starts anyway.
+	WGStartupProbeAttempts int           `yaml:"wgStartupProbeAttempts" envconfig:"VPN_WG_STARTUP_PROBE_ATTEMPTS"` // Default: 5
+	WGStartupProbeInterval time.Duration `yaml:"wgStartupProbeInterval" envconfig:"VPN_WG_STARTUP_PROBE_INTERVAL"` // Default: 2s
+	WGStartupProbeRequired bool          `yaml:"wgStartupProbeRequired" envconfig:"VPN_WG_STARTUP_PROBE_REQUIRED"`
+	// WGVerifyCacheWrite retries the database write of a newly registered
+	// peer within the request when it fails after the peer was saved to S3.
+	// If it still fails, registration succeeds with cache_stale set in the
+	// response. Default: false
+	WGVerifyCacheWrite bool `yaml:"wgVerifyCacheWrite" envconfig:"VPN_WG_VERIFY_CACHE_WRITE"`
+	// WGDeviceCountSource selects where registration counts a client's
+	// devices for the device limit check: "db" uses the database cache, "s3"
+	// loads the client's peer file from S3, which is slower but can't lag
+	// behind it. Default: "db"
+	WGDeviceCountSource string `yaml:"wgDeviceCountSource" envconfig:"VPN_WG_DEVICE_COUNT_SOURCE"`
+	// WGReconcileInterval is how often the database cache of WG peers is
+	// corrected from the S3 peer files while running, rather than only
+	// rebuilt when it's empty at startup. 0 disables it. Default: 0
+	WGReconcileInterval time.Duration `yaml:"wgReconcileInterval" envconfig:"VPN_WG_RECONCILE_INTERVAL"`
+	// WGReassignSubnet moves peers whose assigned IP is outside WGSubnet into
+	// it at startup, such as after the subnet is changed. Their new IPs are
+	// saved to S3 and the WG container. Default: false
+	WGReassignSubnet bool `yaml:"wgReassignSubnet" envconfig:"VPN_WG_REASSIGN_SUBNET"`
+	// WGRegions overrides the device limit and subnet for a region, keyed by
+	// region name. Regions without an override, and unset override fields,
+	// use WGMaxDevices and WGSubnet. It's only set in the config file.
+	WGRegions map[string]WGRegionConfig `yaml:"wgRegions" ignored:"true"`
 }
 
+// WGRegionConfig holds the WireGuard settings that can differ by region
+type WGRegionConfig struct {
+	MaxDevices int    `yaml:"maxDevices"`
+	Subnet     string `yaml:"subnet"`
+}
+
+// WGMaxDevicesFor returns the WireGuard device limit for a region
+func (v VpnConfig) WGMaxDevicesFor(region string) int {
+	override := v.WGRegions[NormalizeRegion(region)]
+	if override.MaxDevices > 0 {
+		return override.MaxDevices
+	}
+	return v.WGMaxDevices
+}
+
+// WGSubnetFor returns the WireGuard subnet, the first 3 octets of the
+// assigned IPs, for a region. It's empty when no subnet is configured.
+func (v VpnConfig) WGSubnetFor(region string) string {
+	override := v.WGRegions[NormalizeRegion(region)]
+	if override.Subnet != "" {
+		return override.Subnet
+	}
+	return v.WGSubnet
+}
+
+// WireGuard IP allocation strategies
+const (
+	WGAllocationNext   = "next"
+	WGAllocationLowest = "lowest"
+)
+
+// WireGuard device count sources
+const (
+	WGDeviceCountDB = "db"
+	WGDeviceCountS3 = "s3"
+)
+
 type CrlConfig struct {
 	UpdateInterval     time.Duration `yaml:"updateInterval"     envconfig:"CRL_UPDATE_INTERVAL"`
 	RevokeSerials      []string      `yaml:"revokeSerials"      envconfig:"CRL_REVOKE_SERIALS"`
@@ -113,8 +343,48 @@ type CrlConfig struct {
 }
 
 type ApiConfig struct {
-	ListenAddress string `yaml:"address" envconfig:"API_LISTEN_ADDRESS"`
-	ListenPort    uint   `yaml:"port"    envconfig:"API_LISTEN_PORT"`
+	ListenAddress string `yaml:"address"    envconfig:"API_LISTEN_ADDRESS"`
+	ListenPort    uint   `yaml:"port"       envconfig:"API_LISTEN_PORT"`
+	// AdminToken is the Bearer token required for /api/admin/* routes. The
+	// admin routes are not registered when it is empty. AdminTokenFile reads
+	// it from a file instead, such as a mounted secret.
+	AdminToken     string `yaml:"adminToken"     envconfig:"API_ADMIN_TOKEN"`
+	AdminTokenFile string `yaml:"adminTokenFile" envconfig:"API_ADMIN_TOKEN_FILE"`
+	// Swagger enables serving the OpenAPI spec and Swagger UI under
+	// /swagger/. Default: true
+	Swagger bool `yaml:"swagger" envconfig:"API_SWAGGER"`
+	// MaxBodyBytes limits request bodies, and MaxTxBodyBytes the CBOR body
+	// of /api/tx/submit. Larger requests are rejected with 413.
+	MaxBodyBytes   int64 `yaml:"maxBodyBytes"   envconfig:"API_MAX_BODY_BYTES"`    // Default: 64 KiB
+	MaxTxBodyBytes int64 `yaml:"maxTxBodyBytes" envconfig:"API_MAX_TX_BODY_BYTES"` // Default: 256 KiB
+	// COSEAlgorithms lists the algorithms accepted for wallet-signed session
+	// challenges. Signatures using any other algorithm are rejected before
+	// verification. Default: "EdDSA"
+	COSEAlgorithms []string `yaml:"coseAlgorithms" envconfig:"API_COSE_ALGORITHMS"`
+}
+
+// SupportedCOSEAlgorithms are the algorithms that can be allowed in
+// ApiConfig.COSEAlgorithms. A Cardano payment credential is the hash of an
+// Ed25519 key, so only EdDSA signatures can resolve to a subscription owner.
+var SupportedCOSEAlgorithms = []string{
+	"EdDSA",
+}
+
+// validateApiConfig ensures the allowed COSE algorithms are supported
+func validateApiConfig(api *ApiConfig) error {
+	if len(api.COSEAlgorithms) == 0 {
+		return errors.New("API COSEAlgorithms must not be empty")
+	}
+	for _, alg := range api.COSEAlgorithms {
+		if !slices.Contains(SupportedCOSEAlgorithms, alg) {
+			return fmt.Errorf(
+				"invalid API COSE algorithm %q: must be one of: %s",
+				alg,
+				strings.Join(SupportedCOSEAlgorithms, ", "),
+			)
+		}
+	}
+	return nil
 }
 
 type TxBuilderConfig struct {
@@ -124,12 +394,146 @@ type TxBuilderConfig struct {
 	ProviderAddress string `yaml:"providerAddress" envconfig:"TXBUILDER_PROVIDER_ADDRESS"`
 	ScriptRefInput  string `yaml:"scriptRefInput"  envconfig:"TXBUILDER_SCRIPT_REF_INPUT"`
 	TTLOffset       uint64 `yaml:"ttlOffset"       envconfig:"TXBUILDER_TTL_OFFSET"`
+	InputBuffer     uint64 `yaml:"inputBuffer"     envconfig:"TXBUILDER_INPUT_BUFFER"`  // Lovelace added to the price for fees and min-ADA
+	ChangeBuffer    uint64 `yaml:"changeBuffer"    envconfig:"TXBUILDER_CHANGE_BUFFER"` // Lovelace reserved for the change output
+	// KupoTimeout bounds each Kupo request made to look up UTxOs while
+	// building a transaction. Default: 1s
+	KupoTimeout time.Duration `yaml:"kupoTimeout" envconfig:"TXBUILDER_KUPO_TIMEOUT"`
+	// BreakerThreshold is the number of consecutive Ogmios or Kupo failures
+	// (unreachable, timed out or erroring backends, not errors for the
+	// request) after which transaction builds fail fast for BreakerCooldown,
+	// before a single request probes the backends again. Zero disables the
+	// breaker.
+	// Default: 5
+	BreakerThreshold int           `yaml:"breakerThreshold" envconfig:"TXBUILDER_BREAKER_THRESHOLD"`
+	BreakerCooldown  time.Duration `yaml:"breakerCooldown"  envconfig:"TXBUILDER_BREAKER_COOLDOWN"` // Default: 30s
+	// SanityCheck rejects submitted transactions whose fee is below the
+	// protocol minimum or with outputs below the minimum ADA, before they're
+	// sent to the submit API
+	SanityCheck bool `yaml:"sanityCheck" envconfig:"TXBUILDER_SANITY_CHECK"`
 }
 
-// Singleton config instance with default values
-var globalConfig = &Config{
+func validateTxBuilderConfig(txBuilder *TxBuilderConfig) error {
+	if txBuilder.KupoTimeout <= 0 {
+		return fmt.Errorf(
+			"TxBuilder KupoTimeout must be positive, got %s",
+			txBuilder.KupoTimeout,
+		)
+	}
+	if txBuilder.BreakerThreshold < 0 {
+		return fmt.Errorf(
+			"TxBuilder BreakerThreshold must be non-negative, got %d",
+			txBuilder.BreakerThreshold,
+		)
+	}
+	if txBuilder.BreakerThreshold > 0 && txBuilder.BreakerCooldown <= 0 {
+		return fmt.Errorf(
+			"TxBuilder BreakerCooldown must be positive when the breaker is enabled, got %s",
+			txBuilder.BreakerCooldown,
+		)
+	}
+	if txBuilder.ProviderAddress != "" {
+		if _, err := lcommon.NewAddress(txBuilder.ProviderAddress); err != nil {
+			return fmt.Errorf(
+				"invalid TxBuilder ProviderAddress %q: %w",
+				txBuilder.ProviderAddress,
+				err,
+			)
+		}
+	}
+	if txBuilder.ScriptRefInput != "" {
+		if _, _, err := ParseTxOutputRef(txBuilder.ScriptRefInput); err != nil {
+			return fmt.Errorf(
+				"invalid TxBuilder ScriptRefInput %q: %w",
+				txBuilder.ScriptRefInput,
+				err,
+			)
+		}
+	}
+	return nil
+}
+
+// ParseTxOutputRef parses a transaction output reference of the form
+// "<txid>#<index>", where txid is the 64-character hex transaction hash
+func ParseTxOutputRef(ref string) ([]byte, uint32, error) {
+	txIdHex, indexStr, ok := strings.Cut(ref, "#")
+	if !ok {
+		return nil, 0, errors.New(
+			"must be of the form <txid>#<index>: missing '#'",
+		)
+	}
+	txId, err := hex.DecodeString(txIdHex)
+	if err != nil || len(txId) != lcommon.Blake2b256Size {
+		return nil, 0, fmt.Errorf(
+			"txid %q must be %d hex characters",
+			txIdHex,
+			lcommon.Blake2b256Size*2,
+		)
+	}
+	index, err := strconv.ParseUint(indexStr, 10, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf(
+			"output index %q must be a non-negative integer",
+			indexStr,
+		)
+	}
+	return txId, uint32(index), nil
+}
+
+// DefaultProfileTemplate is the default OpenVPN client profile template
+const DefaultProfileTemplate = `
+client
+dev tun
+proto tcp
+remote %s %d
+nobind
+persist-tun
+persist-remote-ip
+
+# Encryption and TLS
+cipher AES-256-GCM
+tls-version-min 1.3
+auth SHA256
+remote-cert-tls server
+auth-nocache
+
+# Security hardening
+pull-filter ignore "auth-user-pass"
+
+# Disable compression for privacy
+comp-lzo no
+
+# Minimize logging
+verb 0
+mute 10
+
+# DNS and routing
+dhcp-option DNS %s
+redirect-gateway def1
+
+<cert>
+%s
+</cert>
+
+<key>
+%s
+</key>
+
+<ca>
+%s
+</ca>
+`
+
+// profileTemplateVerbs is the sequence of format verbs a profile template must
+// contain: remote host, remote port, DNS server, client cert, client key, and
+// CA cert
+var profileTemplateVerbs = []rune{'s', 'd', 's', 's', 's', 's'}
+
+// defaultConfig holds the default config values
+var defaultConfig = Config{
 	Logging: LoggingConfig{
-		Debug: false,
+		Debug:  false,
+		Format: LogFormatJSON,
 	},
 	Debug: DebugConfig{
 		ListenAddress: "localhost",
@@ -150,14 +554,28 @@ var globalConfig = &Config{
 	Database: DatabaseConfig{
 		Directory: "./.vpn-indexer",
 	},
+	S3: S3Config{
+		PeerKeyPrefix:   "peers/",
+		MaxWriteRetries: 3,
+		OpTimeout:       30 * time.Second,
+		ListTimeout:     2 * time.Minute,
+	},
 	Vpn: VpnConfig{
-		Domain:           "test.domain",
-		Region:           "test",
-		Port:             443,
-		Protocol:         "openvpn",
-		WGMaxDevices:     3,
-		WGSubnet:         "10.8.0",
-		WGExpireInterval: 60 * time.Minute,
+		Domain:                 "test.domain",
+		Region:                 "test",
+		Port:                   443,
+		ProfileTemplate:        DefaultProfileTemplate,
+		Protocol:               "openvpn",
+		WGMaxDevices:           3,
+		WGSubnet:               "10.8.0",
+		WGExpireInterval:       60 * time.Minute,
+		WGAllowedIPs:           []string{"0.0.0.0/0"},
+		WGKeepalive:            25,
+		WGAllocationStrategy:   WGAllocationNext,
+		WGDeviceCountSource:    WGDeviceCountDB,
+		WGContainerTimeout:     10 * time.Second,
+		WGStartupProbeAttempts: 5,
+		WGStartupProbeInterval: 2 * time.Second,
 	},
 	Crl: CrlConfig{
 		UpdateInterval: 60 * time.Minute,
@@ -165,24 +583,103 @@ var globalConfig = &Config{
 		RevokeTime: time.Date(2025, 06, 11, 15, 45, 03, 0, time.UTC),
 	},
 	Api: ApiConfig{
-		ListenPort: 8080,
+		ListenPort:     8080,
+		Swagger:        true,
+		MaxBodyBytes:   64 << 10,
+		MaxTxBodyBytes: 256 << 10,
+		COSEAlgorithms: []string{"EdDSA"},
 	},
 	TxBuilder: TxBuilderConfig{
 		// NOTE: this shares a stake key with the indexer script address
-		ProviderAddress: "addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5",
-		ScriptRefInput:  "ea7e4f0147eeba9a17c519e1652ed933262d30fe462bf418ece18dc27a2c13ba#1",
-		TTLOffset:       500,
+		ProviderAddress:  "addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5",
+		ScriptRefInput:   "ea7e4f0147eeba9a17c519e1652ed933262d30fe462bf418ece18dc27a2c13ba#1",
+		TTLOffset:        500,
+		InputBuffer:      5_000_000,
+		ChangeBuffer:     1_000_000,
+		KupoTimeout:      1 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
 	},
 }
 
+// globalConfig is the current config. Load replaces it instead of modifying it
+// in place, so a *Config from GetConfig can be read while a new one is loaded.
+var globalConfig atomic.Pointer[Config]
+
+func init() {
+	globalConfig.Store(defaultConfig.clone())
+}
+
+// clone returns a copy of the config that shares no slices with it, so the
+// copy's elements can be modified without affecting readers of the original
+func (c *Config) clone() *Config {
+	ret := *c
+	ret.Vpn.WGAllowedIPs = slices.Clone(c.Vpn.WGAllowedIPs)
+	ret.Vpn.WGDNS = slices.Clone(c.Vpn.WGDNS)
+	ret.Vpn.WGDNSSearch = slices.Clone(c.Vpn.WGDNSSearch)
+	ret.Vpn.WGRegions = maps.Clone(c.Vpn.WGRegions)
+	ret.Crl.RevokeSerials = slices.Clone(c.Crl.RevokeSerials)
+	ret.Api.COSEAlgorithms = slices.Clone(c.Api.COSEAlgorithms)
+	return &ret
+}
+
+// Load builds a config from the current one, the config file (if provided),
+// and environment variables, and makes it the global config once it's valid
 func Load(configFile string) (*Config, error) {
+	tmpConfig, err := load(globalConfig.Load().clone(), configFile)
+	if err != nil {
+		return nil, err
+	}
+	globalConfig.Store(tmpConfig)
+	return tmpConfig, nil
+}
+
+// Reload re-reads the config file and environment variables and makes a new
+// global config from the current one with the settings that are safe to
+// change at runtime: the log level and the WireGuard device limit. Any other
+// changes need a restart, so they're ignored and the names of their config
+// sections are returned.
+func Reload(configFile string) (*Config, []string, error) {
+	reloaded, err := load(defaultConfig.clone(), configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpConfig := globalConfig.Load().clone()
+	tmpConfig.Logging.Debug = reloaded.Logging.Debug
+	tmpConfig.Logging.Level = reloaded.Logging.Level
+	tmpConfig.Vpn.WGMaxDevices = reloaded.Vpn.WGMaxDevices
+	ignored := changedSections(tmpConfig, reloaded)
+	globalConfig.Store(tmpConfig)
+	return tmpConfig, ignored, nil
+}
+
+// changedSections returns the YAML names of the top-level config sections
+// that differ between two configs
+func changedSections(a, b *Config) []string {
+	var ret []string
+	aVal := reflect.ValueOf(a).Elem()
+	bVal := reflect.ValueOf(b).Elem()
+	for i := range aVal.NumField() {
+		if !reflect.DeepEqual(
+			aVal.Field(i).Interface(),
+			bVal.Field(i).Interface(),
+		) {
+			ret = append(ret, aVal.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return ret
+}
+
+// load applies the config file (if provided) and environment variables to
+// tmpConfig and validates the result
+func load(tmpConfig *Config, configFile string) (*Config, error) {
 	// Load config file as YAML if provided
 	if configFile != "" {
 		buf, err := os.ReadFile(configFile)
 		if err != nil {
 			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
-		err = yaml.Unmarshal(buf, globalConfig)
+		err = yaml.Unmarshal(buf, tmpConfig)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing config file: %w", err)
 		}
@@ -190,40 +687,101 @@ func Load(configFile string) (*Config, error) {
 	// Load config values from environment variables
 	// We use "dummy" as the app name here to (mostly) prevent picking up env
 	// vars that we hadn't explicitly specified in annotations above
-	err := envconfig.Process("dummy", globalConfig)
+	err := envconfig.Process("dummy", tmpConfig)
 	if err != nil {
 		return nil, fmt.Errorf("error processing environment: %w", err)
 	}
+	if err := validateLoggingConfig(&tmpConfig.Logging); err != nil {
+		return nil, err
+	}
+	if err := validateMetricsConfig(&tmpConfig.Metrics); err != nil {
+		return nil, err
+	}
+	if err := validateS3Config(&tmpConfig.S3); err != nil {
+		return nil, err
+	}
+	if err := validateIndexerConfig(&tmpConfig.Indexer); err != nil {
+		return nil, err
+	}
+	if err := validateTxBuilderConfig(&tmpConfig.TxBuilder); err != nil {
+		return nil, err
+	}
+
 	// Normalize VPN protocol to lowercase for case-insensitive matching
-	globalConfig.Vpn.Protocol = strings.ToLower(globalConfig.Vpn.Protocol)
+	tmpConfig.Vpn.Protocol = strings.ToLower(tmpConfig.Vpn.Protocol)
+
+	// Normalize VPN region to match the normalized client regions
+	tmpConfig.Vpn.Region = NormalizeRegion(tmpConfig.Vpn.Region)
 
 	// Validate VPN protocol is one of the allowed values
 	// Empty string defaults to openvpn for backwards compatibility
-	if globalConfig.Vpn.Protocol == "" {
-		globalConfig.Vpn.Protocol = "openvpn"
+	if tmpConfig.Vpn.Protocol == "" {
+		tmpConfig.Vpn.Protocol = "openvpn"
 	}
 	allowedProtocols := map[string]bool{"openvpn": true, "wireguard": true}
-	if !allowedProtocols[globalConfig.Vpn.Protocol] {
+	if !allowedProtocols[tmpConfig.Vpn.Protocol] {
 		return nil, fmt.Errorf(
 			"invalid VPN protocol %q: must be one of: openvpn, wireguard",
-			globalConfig.Vpn.Protocol,
+			tmpConfig.Vpn.Protocol,
 		)
 	}
 
+	if err := validateProfileTemplate(tmpConfig.Vpn.ProfileTemplate); err != nil {
+		return nil, fmt.Errorf("invalid profile template: %w", err)
+	}
+
+	if err := loadAdminToken(&tmpConfig.Api); err != nil {
+		return nil, err
+	}
+	if err := validateApiConfig(&tmpConfig.Api); err != nil {
+		return nil, err
+	}
+
 	// The JWT key is required for all protocols: it signs the session tokens
 	// used to authenticate every API client.
-	if err := validateJWTKeyFile(&globalConfig.Vpn); err != nil {
+	if err := validateJWTKeyFile(&tmpConfig.Vpn); err != nil {
 		return nil, err
 	}
 
 	// Validate WireGuard configuration if enabled
-	if globalConfig.Vpn.Protocol == "wireguard" {
-		if err := validateWireGuardConfig(&globalConfig.Vpn); err != nil {
+	if tmpConfig.Vpn.Protocol == "wireguard" {
+		if err := validateWireGuardConfig(&tmpConfig.Vpn); err != nil {
 			return nil, fmt.Errorf("invalid WireGuard config: %w", err)
 		}
 	}
 
-	return globalConfig, nil
+	return tmpConfig, nil
+}
+
+// ProfileHost returns the VPN server host written to OpenVPN profiles for
+// clients in a region
+func (v VpnConfig) ProfileHost(region string) string {
+	return fmt.Sprintf("%s.%s", NormalizeRegion(region), v.Domain)
+}
+
+// NormalizeRegion trims and lowercases a region name so that regions from
+// datums, config, and requests compare equal regardless of case
+func NormalizeRegion(region string) string {
+	return strings.ToLower(strings.TrimSpace(region))
+}
+
+// loadAdminToken reads the admin token from AdminTokenFile when AdminToken
+// isn't set directly. Surrounding whitespace, such as a trailing newline, is
+// trimmed.
+func loadAdminToken(api *ApiConfig) error {
+	if api.AdminToken != "" || api.AdminTokenFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(api.AdminTokenFile)
+	if err != nil {
+		return fmt.Errorf("error reading AdminTokenFile: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return fmt.Errorf("AdminTokenFile %q is empty", api.AdminTokenFile)
+	}
+	api.AdminToken = token
+	return nil
 }
 
 // validateJWTKeyFile ensures the Ed25519 key used to sign session tokens (all
@@ -248,12 +806,53 @@ func validateJWTKeyFile(vpn *VpnConfig) error {
 	return nil
 }
 
+// validateProfileTemplate checks that an OpenVPN profile template contains
+// exactly the format verbs expected by the profile generator, in order
+func validateProfileTemplate(tmpl string) error {
+	var verbs []rune
+	runes := []rune(tmpl)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' {
+			continue
+		}
+		i++
+		if i >= len(runes) {
+			return errors.New("trailing % at end of template")
+		}
+		// Literal percent sign
+		if runes[i] == '%' {
+			continue
+		}
+		verbs = append(verbs, runes[i])
+	}
+	if !slices.Equal(verbs, profileTemplateVerbs) {
+		return fmt.Errorf(
+			"placeholders %q do not match expected %q (host, port, DNS, cert, key, CA)",
+			formatVerbs(verbs),
+			formatVerbs(profileTemplateVerbs),
+		)
+	}
+	return nil
+}
+
+// formatVerbs renders a list of format verbs for error messages
+func formatVerbs(verbs []rune) string {
+	tmpVerbs := make([]string, 0, len(verbs))
+	for _, verb := range verbs {
+		tmpVerbs = append(tmpVerbs, "%"+string(verb))
+	}
+	return strings.Join(tmpVerbs, " ")
+}
+
 // validateWireGuardConfig validates WireGuard-specific configuration
 func validateWireGuardConfig(vpn *VpnConfig) error {
 	// Validate required fields are non-empty
 	if strings.TrimSpace(vpn.WGEndpoint) == "" {
 		return fmt.Errorf("WGEndpoint is required for WireGuard protocol")
 	}
+	if err := ValidateWGEndpoint(vpn.WGEndpoint); err != nil {
+		return err
+	}
 	if strings.TrimSpace(vpn.WGContainerURL) == "" {
 		return fmt.Errorf("WGContainerURL is required for WireGuard protocol")
 	}
@@ -261,30 +860,121 @@ func validateWireGuardConfig(vpn *VpnConfig) error {
 		return fmt.Errorf("WGServerPubkey is required for WireGuard protocol")
 	}
 
-	// Validate WGSubnet format (should be like "10.8.0" - first 3 octets)
-	if vpn.WGSubnet != "" {
-		// Ensure no trailing dot
-		if strings.HasSuffix(vpn.WGSubnet, ".") {
+	if err := validateWGSubnet(vpn.WGSubnet); err != nil {
+		return err
+	}
+	if err := validateWGRegions(vpn); err != nil {
+		return err
+	}
+
+	// Validate WGAllowedIPs entries are CIDRs (e.g. "10.0.0.0/8" for split
+	// tunneling)
+	for idx, allowedIP := range vpn.WGAllowedIPs {
+		allowedIP = strings.TrimSpace(allowedIP)
+		if _, _, err := net.ParseCIDR(allowedIP); err != nil {
 			return fmt.Errorf(
-				"invalid WGSubnet %q: must not have trailing dot",
-				vpn.WGSubnet,
+				"invalid WGAllowedIPs entry %q: must be a CIDR like '10.0.0.0/8'",
+				allowedIP,
 			)
 		}
-		// Count dots - should be exactly 2
-		if strings.Count(vpn.WGSubnet, ".") != 2 {
+		vpn.WGAllowedIPs[idx] = allowedIP
+	}
+
+	// Validate WGDNS entries are IP addresses
+	for idx, dns := range vpn.WGDNS {
+		dns = strings.TrimSpace(dns)
+		if net.ParseIP(dns) == nil {
 			return fmt.Errorf(
-				"invalid WGSubnet %q: must have exactly 3 octets like '10.8.0'",
-				vpn.WGSubnet,
+				"invalid WGDNS entry %q: must be an IP address",
+				dns,
 			)
 		}
-		// Parse as a /24 network to validate octet values
-		_, _, err := net.ParseCIDR(vpn.WGSubnet + ".0/24")
-		if err != nil {
+		vpn.WGDNS[idx] = dns
+	}
+
+	// Validate WGDNSSearch entries are plain domain names. wg-quick treats
+	// any DNS entry that isn't an IP as a search domain, so an IP here would
+	// silently become a resolver.
+	for idx, domain := range vpn.WGDNSSearch {
+		domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+		if domain == "" ||
+			strings.ContainsAny(domain, ", \t") ||
+			net.ParseIP(domain) != nil {
 			return fmt.Errorf(
-				"invalid WGSubnet %q: must be first 3 octets like '10.8.0'",
-				vpn.WGSubnet,
+				"invalid WGDNSSearch entry %q: must be a domain name",
+				vpn.WGDNSSearch[idx],
 			)
 		}
+		vpn.WGDNSSearch[idx] = domain
+	}
+
+	// Validate WGAllocationStrategy ("" means the default)
+	switch vpn.WGAllocationStrategy {
+	case "":
+		vpn.WGAllocationStrategy = WGAllocationNext
+	case WGAllocationNext, WGAllocationLowest:
+	default:
+		return fmt.Errorf(
+			"invalid WGAllocationStrategy %q: must be %q or %q",
+			vpn.WGAllocationStrategy,
+			WGAllocationNext,
+			WGAllocationLowest,
+		)
+	}
+
+	// Validate WGDeviceCountSource ("" means the default)
+	switch vpn.WGDeviceCountSource {
+	case "":
+		vpn.WGDeviceCountSource = WGDeviceCountDB
+	case WGDeviceCountDB, WGDeviceCountS3:
+	default:
+		return fmt.Errorf(
+			"invalid WGDeviceCountSource %q: must be %q or %q",
+			vpn.WGDeviceCountSource,
+			WGDeviceCountDB,
+			WGDeviceCountS3,
+		)
+	}
+
+	// Validate WGKeepalive is within the range WireGuard accepts
+	if vpn.WGKeepalive < 0 || vpn.WGKeepalive > 65535 {
+		return fmt.Errorf(
+			"WGKeepalive must be between 0 and 65535, got %d",
+			vpn.WGKeepalive,
+		)
+	}
+
+	if vpn.WGContainerTimeout < 0 {
+		return fmt.Errorf(
+			"WGContainerTimeout must be non-negative, got %s",
+			vpn.WGContainerTimeout,
+		)
+	}
+
+	if vpn.WGReconcileInterval < 0 {
+		return fmt.Errorf(
+			"WGReconcileInterval must be non-negative, got %s",
+			vpn.WGReconcileInterval,
+		)
+	}
+
+	// Validate the startup probe settings
+	if vpn.WGStartupProbeAttempts < 0 {
+		return fmt.Errorf(
+			"WGStartupProbeAttempts must be non-negative, got %d",
+			vpn.WGStartupProbeAttempts,
+		)
+	}
+	if vpn.WGStartupProbeInterval < 0 {
+		return fmt.Errorf(
+			"WGStartupProbeInterval must be non-negative, got %s",
+			vpn.WGStartupProbeInterval,
+		)
+	}
+	if vpn.WGStartupProbeRequired && vpn.WGStartupProbeAttempts == 0 {
+		return fmt.Errorf(
+			"WGStartupProbeRequired needs WGStartupProbeAttempts greater than 0",
+		)
 	}
 
 	// WGMaxDevices: 0 means "use default", negative is invalid
@@ -302,7 +992,92 @@ func validateWireGuardConfig(vpn *VpnConfig) error {
 	return nil
 }
 
+// validateWGSubnet checks the format of a WireGuard subnet, which should be
+// the first 3 octets like "10.8.0". An empty subnet uses the default.
+func validateWGSubnet(subnet string) error {
+	if subnet == "" {
+		return nil
+	}
+	// Ensure no trailing dot
+	if strings.HasSuffix(subnet, ".") {
+		return fmt.Errorf(
+			"invalid WGSubnet %q: must not have trailing dot",
+			subnet,
+		)
+	}
+	// Count dots - should be exactly 2
+	if strings.Count(subnet, ".") != 2 {
+		return fmt.Errorf(
+			"invalid WGSubnet %q: must have exactly 3 octets like '10.8.0'",
+			subnet,
+		)
+	}
+	// Parse as a /24 network to validate octet values
+	_, _, err := net.ParseCIDR(subnet + ".0/24")
+	if err != nil {
+		return fmt.Errorf(
+			"invalid WGSubnet %q: must be first 3 octets like '10.8.0'",
+			subnet,
+		)
+	}
+	return nil
+}
+
+// validateWGRegions validates the per-region WireGuard overrides and
+// normalizes their region names to match the client regions
+func validateWGRegions(vpn *VpnConfig) error {
+	if len(vpn.WGRegions) == 0 {
+		return nil
+	}
+	regions := make(map[string]WGRegionConfig, len(vpn.WGRegions))
+	for region, override := range vpn.WGRegions {
+		normalized := NormalizeRegion(region)
+		if normalized == "" {
+			return errors.New("WGRegions has an empty region name")
+		}
+		if _, ok := regions[normalized]; ok {
+			return fmt.Errorf("WGRegions has region %q more than once", region)
+		}
+		if override.MaxDevices < 0 {
+			return fmt.Errorf(
+				"WGRegions %q: maxDevices must be non-negative, got %d",
+				region,
+				override.MaxDevices,
+			)
+		}
+		if err := validateWGSubnet(override.Subnet); err != nil {
+			return fmt.Errorf("WGRegions %q: %w", region, err)
+		}
+		regions[normalized] = override
+	}
+	vpn.WGRegions = regions
+	return nil
+}
+
+// ValidateWGEndpoint checks that a WireGuard endpoint is in host:port form
+// with a non-empty host and a port between 1 and 65535
+func ValidateWGEndpoint(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return fmt.Errorf(
+			"invalid WGEndpoint %q: must be host:port like 'vpn.example.com:51820'",
+			endpoint,
+		)
+	}
+	if host == "" || strings.ContainsAny(host, " \t") {
+		return fmt.Errorf("invalid WGEndpoint %q: invalid host", endpoint)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil || portNum < 1 || portNum > 65535 {
+		return fmt.Errorf(
+			"invalid WGEndpoint %q: port must be between 1 and 65535",
+			endpoint,
+		)
+	}
+	return nil
+}
+
 // GetConfig returns the global config instance
 func GetConfig() *Config {
-	return globalConfig
+	return globalConfig.Load()
 }
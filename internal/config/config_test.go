@@ -0,0 +1,1060 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidateProfileTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		tmpl        string
+		shouldError bool
+	}{
+		{
+			name:        "default template",
+			tmpl:        DefaultProfileTemplate,
+			shouldError: false,
+		},
+		{
+			name:        "custom UDP template",
+			tmpl:        "client\nproto udp\nremote %s %d\ndhcp-option DNS %s\n<cert>%s</cert><key>%s</key><ca>%s</ca>\n",
+			shouldError: false,
+		},
+		{
+			name:        "literal percent sign",
+			tmpl:        "# 100%% custom\nremote %s %d\n%s %s %s %s",
+			shouldError: false,
+		},
+		{
+			name:        "missing CA placeholder",
+			tmpl:        "remote %s %d\n%s %s %s",
+			shouldError: true,
+		},
+		{
+			name:        "port placeholder out of order",
+			tmpl:        "remote %d %s\n%s %s %s %s",
+			shouldError: true,
+		},
+		{
+			name:        "extra placeholder",
+			tmpl:        "remote %s %d\n%s %s %s %s %s",
+			shouldError: true,
+		},
+		{
+			name:        "trailing percent",
+			tmpl:        "remote %s %d\n%s %s %s %s %",
+			shouldError: true,
+		},
+		{
+			name:        "empty template",
+			tmpl:        "",
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateProfileTemplate(tt.tmpl)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWireGuardConfigAllowedIPs(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowedIPs  []string
+		keepalive   int
+		shouldError bool
+	}{
+		{
+			name:        "full tunnel",
+			allowedIPs:  []string{"0.0.0.0/0"},
+			keepalive:   25,
+			shouldError: false,
+		},
+		{
+			name:        "split tunnel",
+			allowedIPs:  []string{"10.8.0.0/24", " 192.168.0.0/16"},
+			keepalive:   0,
+			shouldError: false,
+		},
+		{
+			name:        "IPv6 CIDR",
+			allowedIPs:  []string{"::/0"},
+			keepalive:   25,
+			shouldError: false,
+		},
+		{
+			name:        "bare IP",
+			allowedIPs:  []string{"10.8.0.1"},
+			keepalive:   25,
+			shouldError: true,
+		},
+		{
+			name:        "garbage entry",
+			allowedIPs:  []string{"10.8.0.0/24", "not-a-cidr"},
+			keepalive:   25,
+			shouldError: true,
+		},
+		{
+			name:        "negative keepalive",
+			allowedIPs:  []string{"0.0.0.0/0"},
+			keepalive:   -1,
+			shouldError: true,
+		},
+		{
+			name:        "keepalive too large",
+			allowedIPs:  []string{"0.0.0.0/0"},
+			keepalive:   65536,
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpn := &VpnConfig{
+				WGEndpoint:     "vpn.example.com:51820",
+				WGContainerURL: "http://localhost:8080",
+				WGServerPubkey: "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+				WGAllowedIPs:   tt.allowedIPs,
+				WGKeepalive:    tt.keepalive,
+			}
+			err := validateWireGuardConfig(vpn)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWireGuardConfigDNS(t *testing.T) {
+	tests := []struct {
+		name        string
+		dns         []string
+		search      []string
+		shouldError bool
+	}{
+		{
+			name:        "unset",
+			shouldError: false,
+		},
+		{
+			name:        "multiple IPv4 servers",
+			dns:         []string{"10.8.0.1", " 10.8.0.2"},
+			shouldError: false,
+		},
+		{
+			name:        "IPv6 server",
+			dns:         []string{"fd00::1"},
+			shouldError: false,
+		},
+		{
+			name:        "search domains",
+			dns:         []string{"10.8.0.1"},
+			search:      []string{"vpn.internal", "example.com."},
+			shouldError: false,
+		},
+		{
+			name:        "hostname as server",
+			dns:         []string{"dns.example.com"},
+			shouldError: true,
+		},
+		{
+			name:        "IP as search domain",
+			search:      []string{"10.8.0.1"},
+			shouldError: true,
+		},
+		{
+			name:        "empty search domain",
+			search:      []string{" "},
+			shouldError: true,
+		},
+		{
+			name:        "comma in search domain",
+			search:      []string{"a.internal,b.internal"},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpn := &VpnConfig{
+				WGEndpoint:     "vpn.example.com:51820",
+				WGContainerURL: "http://localhost:8080",
+				WGServerPubkey: "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+				WGDNS:          tt.dns,
+				WGDNSSearch:    tt.search,
+			}
+			err := validateWireGuardConfig(vpn)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWGEndpoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoint    string
+		shouldError bool
+	}{
+		{name: "hostname", endpoint: "us1.vpn.example.com:51820"},
+		{name: "IPv4", endpoint: "203.0.113.10:51820"},
+		{name: "IPv6", endpoint: "[2001:db8::1]:51820"},
+		{name: "empty", endpoint: "", shouldError: true},
+		{name: "missing port", endpoint: "vpn.example.com", shouldError: true},
+		{name: "missing host", endpoint: ":51820", shouldError: true},
+		{name: "non-numeric port", endpoint: "vpn.example.com:wg", shouldError: true},
+		{name: "port out of range", endpoint: "vpn.example.com:70000", shouldError: true},
+		{name: "port zero", endpoint: "vpn.example.com:0", shouldError: true},
+		{name: "URL", endpoint: "udp://vpn.example.com:51820", shouldError: true},
+		{name: "unbracketed IPv6", endpoint: "2001:db8::1:51820", shouldError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWGEndpoint(tt.endpoint)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWireGuardConfigAllocationStrategy(t *testing.T) {
+	tests := []struct {
+		name         string
+		strategy     string
+		wantStrategy string
+		shouldError  bool
+	}{
+		{name: "unset", strategy: "", wantStrategy: WGAllocationNext},
+		{name: "next", strategy: "next", wantStrategy: WGAllocationNext},
+		{name: "lowest", strategy: "lowest", wantStrategy: WGAllocationLowest},
+		{name: "unknown", strategy: "random", shouldError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpn := &VpnConfig{
+				WGEndpoint:           "vpn.example.com:51820",
+				WGContainerURL:       "http://localhost:8080",
+				WGServerPubkey:       "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+				WGAllocationStrategy: tt.strategy,
+			}
+			err := validateWireGuardConfig(vpn)
+			if tt.shouldError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vpn.WGAllocationStrategy != tt.wantStrategy {
+				t.Errorf(
+					"WGAllocationStrategy = %q, want %q",
+					vpn.WGAllocationStrategy,
+					tt.wantStrategy,
+				)
+			}
+		})
+	}
+}
+
+func TestValidateWireGuardConfigDeviceCountSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		wantSource  string
+		shouldError bool
+	}{
+		{name: "unset", source: "", wantSource: WGDeviceCountDB},
+		{name: "db", source: "db", wantSource: WGDeviceCountDB},
+		{name: "s3", source: "s3", wantSource: WGDeviceCountS3},
+		{name: "unknown", source: "container", shouldError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpn := &VpnConfig{
+				WGEndpoint:          "vpn.example.com:51820",
+				WGContainerURL:      "http://localhost:8080",
+				WGServerPubkey:      "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+				WGDeviceCountSource: tt.source,
+			}
+			err := validateWireGuardConfig(vpn)
+			if tt.shouldError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vpn.WGDeviceCountSource != tt.wantSource {
+				t.Errorf(
+					"WGDeviceCountSource = %q, want %q",
+					vpn.WGDeviceCountSource,
+					tt.wantSource,
+				)
+			}
+		})
+	}
+}
+
+func TestValidateWireGuardConfigStartupProbe(t *testing.T) {
+	tests := []struct {
+		name        string
+		attempts    int
+		interval    time.Duration
+		required    bool
+		shouldError bool
+	}{
+		{name: "disabled", attempts: 0},
+		{name: "enabled", attempts: 5, interval: 2 * time.Second},
+		{name: "required", attempts: 3, interval: time.Second, required: true},
+		{name: "negative attempts", attempts: -1, shouldError: true},
+		{name: "negative interval", attempts: 1, interval: -time.Second, shouldError: true},
+		{name: "required but disabled", attempts: 0, required: true, shouldError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpn := &VpnConfig{
+				WGEndpoint:             "vpn.example.com:51820",
+				WGContainerURL:         "http://localhost:8080",
+				WGServerPubkey:         "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+				WGStartupProbeAttempts: tt.attempts,
+				WGStartupProbeInterval: tt.interval,
+				WGStartupProbeRequired: tt.required,
+			}
+			err := validateWireGuardConfig(vpn)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWireGuardConfigRegions(t *testing.T) {
+	tests := []struct {
+		name        string
+		regions     map[string]WGRegionConfig
+		shouldError bool
+	}{
+		{name: "unset"},
+		{
+			name: "overrides",
+			regions: map[string]WGRegionConfig{
+				"US-East": {MaxDevices: 10, Subnet: "10.9.0"},
+				"eu-west": {MaxDevices: 2},
+			},
+		},
+		{
+			name:        "empty region",
+			regions:     map[string]WGRegionConfig{" ": {MaxDevices: 1}},
+			shouldError: true,
+		},
+		{
+			name: "duplicate region",
+			regions: map[string]WGRegionConfig{
+				"us-east": {MaxDevices: 1},
+				"US-EAST": {MaxDevices: 2},
+			},
+			shouldError: true,
+		},
+		{
+			name:        "negative max devices",
+			regions:     map[string]WGRegionConfig{"us-east": {MaxDevices: -1}},
+			shouldError: true,
+		},
+		{
+			name:        "invalid subnet",
+			regions:     map[string]WGRegionConfig{"us-east": {Subnet: "10.9"}},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpn := &VpnConfig{
+				WGEndpoint:     "vpn.example.com:51820",
+				WGContainerURL: "http://localhost:8080",
+				WGServerPubkey: "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+				WGRegions:      tt.regions,
+			}
+			err := validateWireGuardConfig(vpn)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestWGRegionOverrides(t *testing.T) {
+	vpn := &VpnConfig{
+		WGEndpoint:     "vpn.example.com:51820",
+		WGContainerURL: "http://localhost:8080",
+		WGServerPubkey: "c2VydmVyLXB1YmtleS1wbGFjZWhvbGRlci0wMDAwMDA=",
+		WGMaxDevices:   5,
+		WGSubnet:       "10.8.0",
+		WGRegions: map[string]WGRegionConfig{
+			"US-East": {MaxDevices: 10, Subnet: "10.9.0"},
+			"eu-west": {MaxDevices: 2},
+		},
+	}
+	if err := validateWireGuardConfig(vpn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		region         string
+		wantMaxDevices int
+		wantSubnet     string
+	}{
+		{region: "us-east", wantMaxDevices: 10, wantSubnet: "10.9.0"},
+		{region: " US-East ", wantMaxDevices: 10, wantSubnet: "10.9.0"},
+		{region: "eu-west", wantMaxDevices: 2, wantSubnet: "10.8.0"},
+		{region: "ap-south", wantMaxDevices: 5, wantSubnet: "10.8.0"},
+	}
+	for _, tt := range tests {
+		if got := vpn.WGMaxDevicesFor(tt.region); got != tt.wantMaxDevices {
+			t.Errorf(
+				"WGMaxDevicesFor(%q) = %d, want %d",
+				tt.region,
+				got,
+				tt.wantMaxDevices,
+			)
+		}
+		if got := vpn.WGSubnetFor(tt.region); got != tt.wantSubnet {
+			t.Errorf(
+				"WGSubnetFor(%q) = %q, want %q",
+				tt.region,
+				got,
+				tt.wantSubnet,
+			)
+		}
+	}
+}
+
+func TestValidateLoggingConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         LoggingConfig
+		wantFormat  string
+		wantLevel   slog.Level
+		shouldError bool
+	}{
+		{
+			name:       "defaults",
+			wantFormat: LogFormatJSON,
+			wantLevel:  slog.LevelInfo,
+		},
+		{
+			name:       "debug flag",
+			cfg:        LoggingConfig{Debug: true},
+			wantFormat: LogFormatJSON,
+			wantLevel:  slog.LevelDebug,
+		},
+		{
+			name:       "text format and warn level",
+			cfg:        LoggingConfig{Format: "TEXT", Level: "warn"},
+			wantFormat: LogFormatText,
+			wantLevel:  slog.LevelWarn,
+		},
+		{
+			name:       "level takes precedence over debug flag",
+			cfg:        LoggingConfig{Debug: true, Level: "error"},
+			wantFormat: LogFormatJSON,
+			wantLevel:  slog.LevelError,
+		},
+		{
+			name:        "invalid format",
+			cfg:         LoggingConfig{Format: "xml"},
+			shouldError: true,
+		},
+		{
+			name:        "invalid level",
+			cfg:         LoggingConfig{Level: "verbose"},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLoggingConfig(&tt.cfg)
+			if tt.shouldError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.cfg.Format != tt.wantFormat {
+				t.Errorf("Format = %q, want %q", tt.cfg.Format, tt.wantFormat)
+			}
+			if got := tt.cfg.SlogLevel(); got != tt.wantLevel {
+				t.Errorf("SlogLevel() = %v, want %v", got, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestLoadAdminToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "admin-token")
+	if err := os.WriteFile(tokenFile, []byte("file-secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0o600); err != nil {
+		t.Fatalf("failed to write empty file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		cfg         ApiConfig
+		wantToken   string
+		shouldError bool
+	}{
+		{
+			name: "no token",
+		},
+		{
+			name:      "direct token",
+			cfg:       ApiConfig{AdminToken: "secret"},
+			wantToken: "secret",
+		},
+		{
+			name:      "token file",
+			cfg:       ApiConfig{AdminTokenFile: tokenFile},
+			wantToken: "file-secret",
+		},
+		{
+			name: "direct token takes precedence",
+			cfg: ApiConfig{
+				AdminToken:     "secret",
+				AdminTokenFile: tokenFile,
+			},
+			wantToken: "secret",
+		},
+		{
+			name:        "missing file",
+			cfg:         ApiConfig{AdminTokenFile: filepath.Join(dir, "missing")},
+			shouldError: true,
+		},
+		{
+			name:        "empty file",
+			cfg:         ApiConfig{AdminTokenFile: emptyFile},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadAdminToken(&tt.cfg)
+			if tt.shouldError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.cfg.AdminToken != tt.wantToken {
+				t.Errorf(
+					"AdminToken = %q, want %q",
+					tt.cfg.AdminToken,
+					tt.wantToken,
+				)
+			}
+		})
+	}
+}
+
+func TestNormalizeRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		want   string
+	}{
+		{"us", "us"},
+		{"US", "us"},
+		{" Us-East-1\n", "us-east-1"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeRegion(tt.region); got != tt.want {
+			t.Errorf(
+				"NormalizeRegion(%q) = %q, want %q",
+				tt.region,
+				got,
+				tt.want,
+			)
+		}
+	}
+}
+
+func TestValidateMetricsConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         MetricsConfig
+		shouldError bool
+	}{
+		{name: "open"},
+		{name: "token", cfg: MetricsConfig{Token: "secret"}},
+		{
+			name: "basic auth",
+			cfg:  MetricsConfig{Username: "prometheus", Password: "secret"},
+		},
+		{
+			name:        "username only",
+			cfg:         MetricsConfig{Username: "prometheus"},
+			shouldError: true,
+		},
+		{
+			name:        "password only",
+			cfg:         MetricsConfig{Password: "secret"},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMetricsConfig(&tt.cfg)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateS3Config(t *testing.T) {
+	valid := S3Config{
+		MaxWriteRetries: 3,
+		OpTimeout:       30 * time.Second,
+		ListTimeout:     2 * time.Minute,
+	}
+	tests := []struct {
+		name        string
+		modify      func(*S3Config)
+		shouldError bool
+	}{
+		{name: "default", modify: func(*S3Config) {}},
+		{
+			name:   "single attempt",
+			modify: func(c *S3Config) { c.MaxWriteRetries = 1 },
+		},
+		{
+			name:        "zero retries",
+			modify:      func(c *S3Config) { c.MaxWriteRetries = 0 },
+			shouldError: true,
+		},
+		{
+			name:        "negative retries",
+			modify:      func(c *S3Config) { c.MaxWriteRetries = -1 },
+			shouldError: true,
+		},
+		{
+			name:        "zero op timeout",
+			modify:      func(c *S3Config) { c.OpTimeout = 0 },
+			shouldError: true,
+		},
+		{
+			name:        "negative list timeout",
+			modify:      func(c *S3Config) { c.ListTimeout = -time.Second },
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := validateS3Config(&cfg)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateTxBuilderConfig(t *testing.T) {
+	valid := TxBuilderConfig{
+		KupoTimeout:      time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+	tests := []struct {
+		name        string
+		modify      func(*TxBuilderConfig)
+		shouldError bool
+	}{
+		{name: "default", modify: func(*TxBuilderConfig) {}},
+		{
+			name:        "zero kupo timeout",
+			modify:      func(c *TxBuilderConfig) { c.KupoTimeout = 0 },
+			shouldError: true,
+		},
+		{
+			name:        "negative kupo timeout",
+			modify:      func(c *TxBuilderConfig) { c.KupoTimeout = -time.Second },
+			shouldError: true,
+		},
+		{
+			name: "breaker disabled",
+			modify: func(c *TxBuilderConfig) {
+				c.BreakerThreshold = 0
+				c.BreakerCooldown = 0
+			},
+		},
+		{
+			name:        "negative breaker threshold",
+			modify:      func(c *TxBuilderConfig) { c.BreakerThreshold = -1 },
+			shouldError: true,
+		},
+		{
+			name:        "zero breaker cooldown",
+			modify:      func(c *TxBuilderConfig) { c.BreakerCooldown = 0 },
+			shouldError: true,
+		},
+		{
+			name: "default addresses",
+			modify: func(c *TxBuilderConfig) {
+				c.ProviderAddress = defaultConfig.TxBuilder.ProviderAddress
+				c.ScriptRefInput = defaultConfig.TxBuilder.ScriptRefInput
+			},
+		},
+		{
+			name:        "invalid provider address",
+			modify:      func(c *TxBuilderConfig) { c.ProviderAddress = "addr_test1" },
+			shouldError: true,
+		},
+		{
+			name: "script ref input without index",
+			modify: func(c *TxBuilderConfig) {
+				c.ScriptRefInput = strings.Repeat("ab", 32)
+			},
+			shouldError: true,
+		},
+		{
+			name: "script ref input with non-hex txid",
+			modify: func(c *TxBuilderConfig) {
+				c.ScriptRefInput = strings.Repeat("zz", 32) + "#1"
+			},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := validateTxBuilderConfig(&cfg)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateIndexerConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		scriptAddress string
+		shouldError   bool
+	}{
+		{name: "unset"},
+		{
+			name:          "default",
+			scriptAddress: defaultConfig.Indexer.ScriptAddress,
+		},
+		{
+			name:          "invalid",
+			scriptAddress: "addr_test1invalid",
+			shouldError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := IndexerConfig{ScriptAddress: tt.scriptAddress}
+			err := validateIndexerConfig(&cfg)
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestIndexerConfigAddressNetworkId(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    IndexerConfig
+		wantId uint8
+		wantOk bool
+	}{
+		{name: "unset"},
+		{name: "unknown name", cfg: IndexerConfig{Network: "custom"}},
+		{
+			name:   "default",
+			cfg:    defaultConfig.Indexer,
+			wantId: 0,
+			wantOk: true,
+		},
+		{
+			name:   "mainnet",
+			cfg:    IndexerConfig{Network: "mainnet"},
+			wantId: 1,
+			wantOk: true,
+		},
+		{
+			name:   "mainnet magic",
+			cfg:    IndexerConfig{NetworkMagic: 764824073},
+			wantId: 1,
+			wantOk: true,
+		},
+		{
+			name: "magic takes precedence",
+			cfg: IndexerConfig{
+				Network:      "mainnet",
+				NetworkMagic: 2,
+			},
+			wantId: 0,
+			wantOk: true,
+		},
+		{
+			name:   "custom magic",
+			cfg:    IndexerConfig{NetworkMagic: 42},
+			wantId: 0,
+			wantOk: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := tt.cfg.AddressNetworkId()
+			if id != tt.wantId || ok != tt.wantOk {
+				t.Errorf(
+					"AddressNetworkId() = %d, %t, want %d, %t",
+					id,
+					ok,
+					tt.wantId,
+					tt.wantOk,
+				)
+			}
+		})
+	}
+}
+
+func TestValidateApiConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		algorithms  []string
+		shouldError bool
+	}{
+		{name: "default", algorithms: []string{"EdDSA"}},
+		{
+			name:        "ECDSA and RSA",
+			algorithms:  []string{"ES256", "PS256"},
+			shouldError: true,
+		},
+		{name: "empty", shouldError: true},
+		{
+			name:        "unsupported",
+			algorithms:  []string{"EdDSA", "RS256"},
+			shouldError: true,
+		},
+		{
+			name:        "wrong case",
+			algorithms:  []string{"eddsa"},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateApiConfig(&ApiConfig{COSEAlgorithms: tt.algorithms})
+			if tt.shouldError && err == nil {
+				t.Error("expected error, got nil")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConcurrentReads(t *testing.T) {
+	orig := globalConfig.Load()
+	t.Cleanup(func() { globalConfig.Store(orig) })
+
+	keyFile := filepath.Join(t.TempDir(), "jwt.key")
+	if err := os.WriteFile(keyFile, []byte("key"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	t.Setenv("VPN_JWT_KEY_FILE", keyFile)
+	t.Setenv("VPN_REGION", " US-East-1 ")
+	t.Setenv("VPN_WG_DNS", "10.8.0.1")
+
+	// Readers hold on to whichever config they get while it's replaced, as
+	// long-running goroutines do. Run with -race to check for data races.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				cfg := GetConfig()
+				_ = cfg.Vpn.Region
+				_ = cfg.Vpn.Protocol
+				_ = slices.Clone(cfg.Vpn.WGDNS)
+			}
+		}()
+	}
+	for range 50 {
+		if _, err := Load(""); err != nil {
+			close(done)
+			wg.Wait()
+			t.Fatalf("Load: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if got := GetConfig().Vpn.Region; got != "us-east-1" {
+		t.Errorf("region = %q, want %q", got, "us-east-1")
+	}
+	if orig.Vpn.Region == "us-east-1" {
+		t.Error("Load modified the previous config in place")
+	}
+}
+
+func TestReload(t *testing.T) {
+	orig := globalConfig.Load()
+	t.Cleanup(func() { globalConfig.Store(orig) })
+
+	tmpDir := t.TempDir()
+	keyFile := filepath.Join(tmpDir, "jwt.key")
+	if err := os.WriteFile(keyFile, []byte("key"), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	t.Setenv("VPN_JWT_KEY_FILE", keyFile)
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	writeConfig := func(level string, maxDevices int, apiPort int) {
+		t.Helper()
+		data := fmt.Sprintf(
+			"logging:\n  level: %s\n"+
+				"vpn:\n  wgMaxDevices: %d\n"+
+				"api:\n  port: %d\n",
+			level,
+			maxDevices,
+			apiPort,
+		)
+		if err := os.WriteFile(configFile, []byte(data), 0o600); err != nil {
+			t.Fatalf("failed to write config file: %v", err)
+		}
+	}
+
+	writeConfig("info", 3, 8080)
+	if _, err := Load(configFile); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	writeConfig("debug", 5, 9090)
+	cfg, ignored, err := Reload(configFile)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if cfg != GetConfig() {
+		t.Error("Reload did not replace the global config")
+	}
+	if cfg.Vpn.WGMaxDevices != 5 {
+		t.Errorf("wgMaxDevices = %d, want %d", cfg.Vpn.WGMaxDevices, 5)
+	}
+	if cfg.Logging.Level != "debug" {
+		t.Errorf("logging level = %q, want %q", cfg.Logging.Level, "debug")
+	}
+	if cfg.Api.ListenPort != 8080 {
+		t.Errorf("api port = %d, want %d", cfg.Api.ListenPort, 8080)
+	}
+	if !slices.Equal(ignored, []string{"api"}) {
+		t.Errorf("ignored = %v, want %v", ignored, []string{"api"})
+	}
+
+	// An invalid config leaves the current one in place
+	writeConfig("verbose", 7, 8080)
+	if _, _, err := Reload(configFile); err == nil {
+		t.Fatal("Reload: expected error for invalid log level")
+	}
+	if got := GetConfig().Vpn.WGMaxDevices; got != 5 {
+		t.Errorf("wgMaxDevices after failed reload = %d, want %d", got, 5)
+	}
+}
@@ -117,10 +117,8 @@ func (c *Crl) scheduleUpdateConfigMap() {
 					c.needsUpdateMutex.Lock()
 					if err != nil {
 						c.logger.Error(
-							fmt.Sprintf(
-								"failed to update CRL ConfigMap: %s",
-								err,
-							),
+							"failed to update CRL ConfigMap",
+							"error", err,
 						)
 						// Set needsUpdate true so retry happens next tick
 						c.needsUpdate = true
@@ -161,17 +159,22 @@ func (c *Crl) updateConfigMap() error {
 	if err != nil {
 		return err
 	}
-	for _, client := range expiredClients {
-		revokedCerts = append(
-			revokedCerts,
-			pkix.RevokedCertificate{
-				SerialNumber: ca.ClientNameToSerialNumber(
-					hex.EncodeToString(client.AssetName),
-				),
-				RevocationTime: client.Expiration,
-			},
-		)
+	issuedCerts, err := c.db.ExpiredIssuedCerts()
+	if err != nil {
+		return err
+	}
+	revokedIssuedCerts, err := c.db.RevokedIssuedCerts()
+	if err != nil {
+		return err
 	}
+	revokedCerts = append(
+		revokedCerts,
+		revokedClientCerts(
+			expiredClients,
+			issuedCerts,
+			revokedIssuedCerts,
+		)...,
+	)
 	crlData, err := c.ca.GenerateCRL(
 		revokedCerts,
 		time.Now(),
@@ -212,11 +215,9 @@ func (c *Crl) updateConfigMap() error {
 			return fmt.Errorf("update ConfigMap: %w", err)
 		}
 		c.logger.Info(
-			fmt.Sprintf(
-				"updated CRL ConfigMap %s/%s",
-				c.config.Crl.ConfigMapNamespace,
-				c.config.Crl.ConfigMapName,
-			),
+			"updated CRL ConfigMap",
+			"namespace", c.config.Crl.ConfigMapNamespace,
+			"name", c.config.Crl.ConfigMapName,
 		)
 	} else {
 		_, err = client.CoreV1().ConfigMaps(c.config.Crl.ConfigMapNamespace).Create(context.TODO(), configMap, metav1.CreateOptions{})
@@ -224,12 +225,72 @@ func (c *Crl) updateConfigMap() error {
 			return fmt.Errorf("create ConfigMap: %w", err)
 		}
 		c.logger.Info(
-			fmt.Sprintf(
-				"created CRL ConfigMap %s/%s",
-				c.config.Crl.ConfigMapNamespace,
-				c.config.Crl.ConfigMapName,
-			),
+			"created CRL ConfigMap",
+			"namespace", c.config.Crl.ConfigMapNamespace,
+			"name", c.config.Crl.ConfigMapName,
 		)
 	}
 	return nil
 }
+
+// revokedClientCerts builds the CRL entries for certs revoked before their
+// client expired and for expired clients from the certs issued to them.
+// Clients without a recorded cert, such as those issued before certs were
+// recorded, fall back to the serial derived from their asset name.
+func revokedClientCerts(
+	expiredClients []database.Client,
+	issuedCerts []database.IssuedCert,
+	revokedIssuedCerts []database.IssuedCert,
+) []pkix.RevokedCertificate {
+	var ret []pkix.RevokedCertificate
+	// Reissued certs can share a serial, and a revoked cert can belong to an
+	// expired client, but each serial only needs one entry
+	seen := make(map[string]bool)
+	for _, cert := range revokedIssuedCerts {
+		if seen[string(cert.Serial)] {
+			continue
+		}
+		seen[string(cert.Serial)] = true
+		ret = append(
+			ret,
+			pkix.RevokedCertificate{
+				SerialNumber:   new(big.Int).SetBytes(cert.Serial),
+				RevocationTime: *cert.RevokedAt,
+			},
+		)
+	}
+	certsByAsset := make(map[string][]database.IssuedCert)
+	for _, cert := range issuedCerts {
+		key := string(cert.AssetName)
+		certsByAsset[key] = append(certsByAsset[key], cert)
+	}
+	for _, client := range expiredClients {
+		certs := certsByAsset[string(client.AssetName)]
+		if len(certs) == 0 {
+			ret = append(
+				ret,
+				pkix.RevokedCertificate{
+					SerialNumber: ca.ClientNameToSerialNumber(
+						hex.EncodeToString(client.AssetName),
+					),
+					RevocationTime: client.Expiration,
+				},
+			)
+			continue
+		}
+		for _, cert := range certs {
+			if seen[string(cert.Serial)] {
+				continue
+			}
+			seen[string(cert.Serial)] = true
+			ret = append(
+				ret,
+				pkix.RevokedCertificate{
+					SerialNumber:   new(big.Int).SetBytes(cert.Serial),
+					RevocationTime: client.Expiration,
+				},
+			)
+		}
+	}
+	return ret
+}
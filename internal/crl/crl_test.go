@@ -0,0 +1,94 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crl
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/ca"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+func TestRevokedClientCerts(t *testing.T) {
+	expiration := time.Now().Add(-time.Hour)
+	revokedAt := time.Now().Add(-2 * time.Hour)
+	expiredClients := []database.Client{
+		{AssetName: []byte("recorded"), Expiration: expiration},
+		{AssetName: []byte("legacy"), Expiration: expiration},
+	}
+	issuedCerts := []database.IssuedCert{
+		{AssetName: []byte("recorded"), Serial: []byte{0x01}},
+		// Reissued with the same serial
+		{AssetName: []byte("recorded"), Serial: []byte{0x01}},
+		{AssetName: []byte("recorded"), Serial: []byte{0x02}},
+	}
+	revokedIssuedCerts := []database.IssuedCert{
+		// Revoked before the client expired
+		{
+			AssetName: []byte("recorded"),
+			Serial:    []byte{0x01},
+			RevokedAt: &revokedAt,
+		},
+		// Replaced in an active client's regenerated profile
+		{
+			AssetName: []byte("active"),
+			Serial:    []byte{0x03},
+			RevokedAt: &revokedAt,
+		},
+	}
+
+	revoked := revokedClientCerts(
+		expiredClients,
+		issuedCerts,
+		revokedIssuedCerts,
+	)
+	want := []struct {
+		serial         *big.Int
+		revocationTime time.Time
+	}{
+		{big.NewInt(1), revokedAt},
+		{big.NewInt(3), revokedAt},
+		{big.NewInt(2), expiration},
+		// No recorded cert, so the serial is derived from the asset name
+		{
+			ca.ClientNameToSerialNumber(hex.EncodeToString([]byte("legacy"))),
+			expiration,
+		},
+	}
+	if len(revoked) != len(want) {
+		t.Fatalf("got %d revoked certs, want %d", len(revoked), len(want))
+	}
+	for i, cert := range revoked {
+		if cert.SerialNumber.Cmp(want[i].serial) != 0 {
+			t.Errorf(
+				"revoked cert %d serial = %s, want %s",
+				i,
+				cert.SerialNumber,
+				want[i].serial,
+			)
+		}
+		if !cert.RevocationTime.Equal(want[i].revocationTime) {
+			t.Errorf(
+				"revoked cert %d revocation time = %s, want %s",
+				i,
+				cert.RevocationTime,
+				want[i].revocationTime,
+			)
+		}
+	}
+}
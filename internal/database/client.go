@@ -15,11 +15,23 @@
 package database
 
 import (
+	"context"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
 	"gorm.io/gorm/clause"
 )
 
+var metricActiveSubscriptions = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "active_subscriptions",
+		Help: "Number of non-expired subscriptions in a region",
+	},
+	[]string{"region"},
+)
+
 type Client struct {
 	ID            uint   `gorm:"primaryKey"`
 	AssetName     []byte `gorm:"uniqueIndex"`
@@ -28,6 +40,18 @@ type Client struct {
 	Region        string
 	TxHash        []byte
 	TxOutputIndex uint
+	// DeviceLimit overrides the global WireGuard device limit for this
+	// subscription. Zero means use the global limit.
+	DeviceLimit int
+}
+
+// EffectiveDeviceLimit returns the client's device limit, falling back to
+// the provided default when no override is set
+func (c *Client) EffectiveDeviceLimit(defaultLimit int) int {
+	if c.DeviceLimit > 0 {
+		return c.DeviceLimit
+	}
+	return defaultLimit
 }
 
 func (Client) TableName() string {
@@ -41,6 +65,7 @@ func (d *Database) AddClient(
 	region string,
 	txHash []byte,
 	txOutputIndex uint,
+	deviceLimit int,
 ) error {
 	tmpItem := Client{
 		AssetName:     assetName,
@@ -49,6 +74,7 @@ func (d *Database) AddClient(
 		Region:        region,
 		TxHash:        txHash,
 		TxOutputIndex: txOutputIndex,
+		DeviceLimit:   deviceLimit,
 	}
 	onConflict := clause.OnConflict{
 		Columns:   []clause.Column{{Name: "asset_name"}},
@@ -77,9 +103,19 @@ func (d *Database) ExpiredClients() ([]Client, error) {
 
 func (d *Database) ClientsByCredential(
 	paymentKeyHash []byte,
+) ([]Client, error) {
+	return d.ClientsByCredentialContext(context.Background(), paymentKeyHash)
+}
+
+// ClientsByCredentialContext is like ClientsByCredential but aborts the query
+// when ctx is done
+func (d *Database) ClientsByCredentialContext(
+	ctx context.Context,
+	paymentKeyHash []byte,
 ) ([]Client, error) {
 	var ret []Client
-	result := d.db.Where("credential = ?", paymentKeyHash).
+	result := d.db.WithContext(ctx).
+		Where("credential = ?", paymentKeyHash).
 		Order("id").
 		Find(&ret)
 	if result.Error != nil {
@@ -88,11 +124,70 @@ func (d *Database) ClientsByCredential(
 	return ret, nil
 }
 
+// ClientByAssetName returns the client with the given asset name. It returns
+// ErrRecordNotFound when there is no such client, so callers can tell a
+// missing client apart from a database failure.
 func (d *Database) ClientByAssetName(assetName []byte) (Client, error) {
+	return d.ClientByAssetNameContext(context.Background(), assetName)
+}
+
+// ClientByAssetNameContext is like ClientByAssetName but aborts the query when
+// ctx is done
+func (d *Database) ClientByAssetNameContext(
+	ctx context.Context,
+	assetName []byte,
+) (Client, error) {
 	var ret Client
-	result := d.db.Where("asset_name = ?", assetName).First(&ret)
+	result := d.db.WithContext(ctx).
+		Where("asset_name = ?", assetName).
+		First(&ret)
 	if result.Error != nil {
 		return ret, result.Error
 	}
 	return ret, nil
 }
+
+// CountActiveClients returns the number of non-expired clients in a region
+func (d *Database) CountActiveClients(region string) (int64, error) {
+	return d.CountActiveClientsContext(context.Background(), region)
+}
+
+// CountActiveClientsContext is like CountActiveClients but aborts the query
+// when ctx is done
+func (d *Database) CountActiveClientsContext(
+	ctx context.Context,
+	region string,
+) (int64, error) {
+	var count int64
+	result := d.db.WithContext(ctx).
+		Model(&Client{}).
+		Where("region = ? AND expiration > ?", region, time.Now()).
+		Count(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return count, nil
+}
+
+// UpdateActiveSubscriptionsMetric recalculates the active subscriptions
+// gauge for every region with a client. Regions whose clients have all
+// expired are reported as zero.
+func (d *Database) UpdateActiveSubscriptionsMetric(ctx context.Context) error {
+	var regions []string
+	result := d.db.WithContext(ctx).
+		Model(&Client{}).
+		Distinct("region").
+		Order("region").
+		Pluck("region", &regions)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, region := range regions {
+		count, err := d.CountActiveClientsContext(ctx, region)
+		if err != nil {
+			return err
+		}
+		metricActiveSubscriptions.WithLabelValues(region).Set(float64(count))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestClientDeviceLimit(t *testing.T) {
+	d := newTestDatabase(t)
+	assetName := []byte("premium-client")
+	expiration := time.Now().Add(time.Hour)
+
+	if err := d.AddClient(
+		assetName,
+		expiration,
+		[]byte("credential"),
+		"test",
+		[]byte("txhash"),
+		0,
+		10,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	client, err := d.ClientByAssetName(assetName)
+	if err != nil {
+		t.Fatalf("failed to lookup client: %v", err)
+	}
+	if client.DeviceLimit != 10 {
+		t.Errorf("DeviceLimit = %d, want 10", client.DeviceLimit)
+	}
+	if got := client.EffectiveDeviceLimit(3); got != 10 {
+		t.Errorf("EffectiveDeviceLimit(3) = %d, want 10", got)
+	}
+
+	// A later datum without an override reverts to the global limit
+	if err := d.AddClient(
+		assetName,
+		expiration,
+		[]byte("credential"),
+		"test",
+		[]byte("txhash2"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to update client: %v", err)
+	}
+	client, err = d.ClientByAssetName(assetName)
+	if err != nil {
+		t.Fatalf("failed to lookup client: %v", err)
+	}
+	if client.DeviceLimit != 0 {
+		t.Errorf("DeviceLimit = %d, want 0", client.DeviceLimit)
+	}
+	if got := client.EffectiveDeviceLimit(3); got != 3 {
+		t.Errorf("EffectiveDeviceLimit(3) = %d, want 3", got)
+	}
+}
+
+func TestCountActiveClients(t *testing.T) {
+	d := newTestDatabase(t)
+	clients := []struct {
+		assetName  string
+		region     string
+		expiration time.Time
+	}{
+		{"active-1", "us-east", time.Now().Add(time.Hour)},
+		{"active-2", "us-east", time.Now().Add(24 * time.Hour)},
+		{"expired-1", "us-east", time.Now().Add(-time.Hour)},
+		{"active-3", "eu-west", time.Now().Add(time.Hour)},
+		{"expired-2", "ap-south", time.Now().Add(-time.Hour)},
+	}
+	for idx, c := range clients {
+		if err := d.AddClient(
+			[]byte(c.assetName),
+			c.expiration,
+			[]byte("credential"),
+			c.region,
+			[]byte("txhash"),
+			uint(idx), // nolint:gosec
+			0,
+		); err != nil {
+			t.Fatalf("failed to add client: %v", err)
+		}
+	}
+	// Mark a previously active region as stale, so it's reset to zero
+	metricActiveSubscriptions.WithLabelValues("ap-south").Set(1)
+
+	if err := d.UpdateActiveSubscriptionsMetric(t.Context()); err != nil {
+		t.Fatalf("failed to update metric: %v", err)
+	}
+
+	tests := []struct {
+		region string
+		want   int64
+	}{
+		{region: "us-east", want: 2},
+		{region: "eu-west", want: 1},
+		{region: "ap-south", want: 0},
+		{region: "unknown", want: 0},
+	}
+	for _, tt := range tests {
+		count, err := d.CountActiveClients(tt.region)
+		if err != nil {
+			t.Fatalf("failed to count clients: %v", err)
+		}
+		if count != tt.want {
+			t.Errorf(
+				"CountActiveClients(%q) = %d, want %d",
+				tt.region,
+				count,
+				tt.want,
+			)
+		}
+		var m dto.Metric
+		if err := metricActiveSubscriptions.WithLabelValues(tt.region).Write(&m); err != nil {
+			t.Fatalf("failed to read metric: %v", err)
+		}
+		if got := int64(m.GetGauge().GetValue()); got != tt.want {
+			t.Errorf(
+				"active_subscriptions{region=%q} = %d, want %d",
+				tt.region,
+				got,
+				tt.want,
+			)
+		}
+	}
+}
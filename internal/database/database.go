@@ -83,5 +83,18 @@ func New(cfg *config.Config, logger *slog.Logger) (*Database, error) {
 			return nil, err
 		}
 	}
+	// Apply versioned migrations
+	if err := d.runMigrations(Migrations); err != nil {
+		return nil, err
+	}
 	return d, nil
 }
+
+// Close closes the underlying database connection
+func (d *Database) Close() error {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
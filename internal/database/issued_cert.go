@@ -0,0 +1,123 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"time"
+)
+
+// IssuedCert records a client certificate issued for a subscription and the
+// S3 key of the profile it was delivered in
+type IssuedCert struct {
+	ID        uint   `gorm:"primarykey"`
+	Serial    []byte `gorm:"index;not null"`
+	AssetName []byte `gorm:"index;not null"`
+	NotAfter  time.Time
+	S3Key     string
+	CreatedAt time.Time
+	// RevokedAt is set when the cert is revoked before the client expires,
+	// such as when its profile is regenerated
+	RevokedAt *time.Time `gorm:"index"`
+}
+
+func (IssuedCert) TableName() string {
+	return "issued_cert"
+}
+
+// AddIssuedCert records a client certificate issued for an asset
+func (d *Database) AddIssuedCert(
+	serial []byte,
+	assetName []byte,
+	notAfter time.Time,
+	s3Key string,
+) error {
+	cert := IssuedCert{
+		Serial:    serial,
+		AssetName: assetName,
+		NotAfter:  notAfter,
+		S3Key:     s3Key,
+	}
+	if result := d.db.Create(&cert); result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// IssuedCertsByAsset returns the certificates issued for an asset, oldest
+// first
+func (d *Database) IssuedCertsByAsset(assetName []byte) ([]IssuedCert, error) {
+	var ret []IssuedCert
+	result := d.db.Where("asset_name = ?", assetName).
+		Order("id").
+		Find(&ret)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return ret, nil
+}
+
+// ExpiredIssuedCerts returns the certificates issued for the clients returned
+// by ExpiredClients
+func (d *Database) ExpiredIssuedCerts() ([]IssuedCert, error) {
+	var ret []IssuedCert
+	result := d.db.
+		Joins("JOIN client ON client.asset_name = issued_cert.asset_name").
+		Where(
+			"client.expiration < datetime('now') AND client.region == ?",
+			d.config.Vpn.Region,
+		).
+		Order("issued_cert.id").
+		Find(&ret)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return ret, nil
+}
+
+// RevokeIssuedCerts marks the given certificates that aren't already revoked
+// as revoked at the given time
+func (d *Database) RevokeIssuedCerts(
+	ids []uint,
+	revokedAt time.Time,
+) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := d.db.Model(&IssuedCert{}).
+		Where("id IN ? AND revoked_at IS NULL", ids).
+		Update("revoked_at", revokedAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// RevokedIssuedCerts returns the revoked certificates issued for clients in
+// the configured region
+func (d *Database) RevokedIssuedCerts() ([]IssuedCert, error) {
+	var ret []IssuedCert
+	result := d.db.
+		Joins("JOIN client ON client.asset_name = issued_cert.asset_name").
+		Where(
+			"issued_cert.revoked_at IS NOT NULL AND client.region == ?",
+			d.config.Vpn.Region,
+		).
+		Order("issued_cert.id").
+		Find(&ret)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return ret, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIssuedCerts(t *testing.T) {
+	d := newTestDatabase(t)
+	notAfter := time.Now().AddDate(10, 0, 0)
+
+	clients := []struct {
+		assetName  string
+		expiration time.Time
+		region     string
+	}{
+		{"expired", time.Now().Add(-time.Hour), "test"},
+		{"active", time.Now().Add(time.Hour), "test"},
+		{"other-region", time.Now().Add(-time.Hour), "other"},
+	}
+	for idx, client := range clients {
+		if err := d.AddClient(
+			[]byte(client.assetName),
+			client.expiration,
+			[]byte("credential"),
+			client.region,
+			[]byte("txhash"),
+			uint(idx), // nolint:gosec
+			0,
+		); err != nil {
+			t.Fatalf("AddClient: %v", err)
+		}
+		if err := d.AddIssuedCert(
+			[]byte(client.assetName+"-serial"),
+			[]byte(client.assetName),
+			notAfter,
+			"profiles/"+client.assetName+".ovpn",
+		); err != nil {
+			t.Fatalf("AddIssuedCert: %v", err)
+		}
+	}
+	// A reissued cert for the expired client
+	if err := d.AddIssuedCert(
+		[]byte("expired-serial"),
+		[]byte("expired"),
+		notAfter,
+		"profiles/expired.ovpn",
+	); err != nil {
+		t.Fatalf("AddIssuedCert: %v", err)
+	}
+
+	certs, err := d.IssuedCertsByAsset([]byte("expired"))
+	if err != nil {
+		t.Fatalf("IssuedCertsByAsset: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("got %d certs, want 2", len(certs))
+	}
+	if string(certs[0].Serial) != "expired-serial" ||
+		certs[0].S3Key != "profiles/expired.ovpn" ||
+		!certs[0].NotAfter.Equal(notAfter) {
+		t.Errorf("unexpected cert: %+v", certs[0])
+	}
+
+	// Only certs for expired clients in our region
+	expired, err := d.ExpiredIssuedCerts()
+	if err != nil {
+		t.Fatalf("ExpiredIssuedCerts: %v", err)
+	}
+	var assetNames []string
+	for _, cert := range expired {
+		assetNames = append(assetNames, string(cert.AssetName))
+	}
+	if want := []string{"expired", "expired"}; !slices.Equal(
+		assetNames,
+		want,
+	) {
+		t.Errorf("expired certs for %v, want %v", assetNames, want)
+	}
+}
+
+func TestRevokeIssuedCerts(t *testing.T) {
+	d := newTestDatabase(t)
+	for idx, region := range []string{"test", "other"} {
+		assetName := []byte(region + "-client")
+		if err := d.AddClient(
+			assetName,
+			time.Now().Add(time.Hour),
+			[]byte("credential"),
+			region,
+			[]byte("txhash"),
+			uint(idx), // nolint:gosec
+			0,
+		); err != nil {
+			t.Fatalf("AddClient: %v", err)
+		}
+		if err := d.AddIssuedCert(
+			[]byte("old-serial"),
+			assetName,
+			time.Now().AddDate(10, 0, 0),
+			"profiles/client.ovpn",
+		); err != nil {
+			t.Fatalf("AddIssuedCert: %v", err)
+		}
+	}
+
+	firstRevoked := time.Now().Add(-time.Minute).UTC()
+	for _, region := range []string{"test", "other"} {
+		certs, err := d.IssuedCertsByAsset([]byte(region + "-client"))
+		if err != nil {
+			t.Fatalf("IssuedCertsByAsset: %v", err)
+		}
+		ids := make([]uint, 0, len(certs))
+		for _, cert := range certs {
+			ids = append(ids, cert.ID)
+		}
+		if err := d.RevokeIssuedCerts(ids, firstRevoked); err != nil {
+			t.Fatalf("RevokeIssuedCerts: %v", err)
+		}
+	}
+	// A cert issued after the revocation isn't revoked
+	if err := d.AddIssuedCert(
+		[]byte("new-serial"),
+		[]byte("test-client"),
+		time.Now().AddDate(10, 0, 0),
+		"profiles/client.ovpn",
+	); err != nil {
+		t.Fatalf("AddIssuedCert: %v", err)
+	}
+
+	revoked, err := d.RevokedIssuedCerts()
+	if err != nil {
+		t.Fatalf("RevokedIssuedCerts: %v", err)
+	}
+	if len(revoked) != 1 {
+		t.Fatalf("got %d revoked certs, want 1", len(revoked))
+	}
+	if string(revoked[0].Serial) != "old-serial" ||
+		string(revoked[0].AssetName) != "test-client" {
+		t.Errorf("unexpected revoked cert: %+v", revoked[0])
+	}
+	if revoked[0].RevokedAt == nil ||
+		!revoked[0].RevokedAt.Equal(firstRevoked) {
+		t.Errorf(
+			"revoked at %v, want %s",
+			revoked[0].RevokedAt,
+			firstRevoked,
+		)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrSerialCollision is returned when a certificate serial has already been
+// issued to a different client
+var ErrSerialCollision = errors.New(
+	"certificate serial already issued to another client",
+)
+
+// IssuedSerial records the serial of a client certificate issued by the CA
+type IssuedSerial struct {
+	ID         uint   `gorm:"primarykey"`
+	Serial     []byte `gorm:"uniqueIndex;not null"`
+	ClientName string `gorm:"not null"`
+	CreatedAt  time.Time
+}
+
+func (IssuedSerial) TableName() string {
+	return "issued_serial"
+}
+
+// RecordIssuedSerial records a certificate serial issued to a client. Issuing
+// the same serial to the same client again is allowed, since its cert is
+// revoked with the same serial either way. It returns ErrSerialCollision if
+// the serial was issued to a different client.
+func (d *Database) RecordIssuedSerial(serial []byte, clientName string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var existing IssuedSerial
+		result := tx.Where("serial = ?", serial).First(&existing)
+		if result.Error == nil {
+			if existing.ClientName != clientName {
+				return fmt.Errorf(
+					"%w: serial %x issued to %s, requested for %s",
+					ErrSerialCollision,
+					serial,
+					existing.ClientName,
+					clientName,
+				)
+			}
+			return nil
+		}
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return result.Error
+		}
+		return tx.Create(&IssuedSerial{
+			Serial:     serial,
+			ClientName: clientName,
+		}).Error
+	})
+}
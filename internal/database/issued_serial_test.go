@@ -0,0 +1,49 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecordIssuedSerial(t *testing.T) {
+	d := newTestDatabase(t)
+	serial := []byte{0x12, 0x34}
+
+	if err := d.RecordIssuedSerial(serial, "client-a"); err != nil {
+		t.Fatalf("RecordIssuedSerial: %v", err)
+	}
+	// The same client can be issued the same serial again
+	if err := d.RecordIssuedSerial(serial, "client-a"); err != nil {
+		t.Fatalf("RecordIssuedSerial for same client: %v", err)
+	}
+	err := d.RecordIssuedSerial(serial, "client-b")
+	if !errors.Is(err, ErrSerialCollision) {
+		t.Fatalf("error = %v, want %v", err, ErrSerialCollision)
+	}
+	// Other serials are unaffected
+	if err := d.RecordIssuedSerial([]byte{0x56}, "client-b"); err != nil {
+		t.Fatalf("RecordIssuedSerial for new serial: %v", err)
+	}
+
+	var count int64
+	if err := d.db.Model(&IssuedSerial{}).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count serials: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("recorded %d serials, want 2", count)
+	}
+}
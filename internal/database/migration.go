@@ -0,0 +1,106 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaVersion records a versioned migration that has been applied
+type SchemaVersion struct {
+	Version   uint `gorm:"primarykey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// Migration is a change to the database that must run exactly once, such as
+// a data transformation that AutoMigrate can't express
+type Migration struct {
+	// Version orders the migrations and identifies them once applied
+	Version uint
+	// Name describes the migration in logs
+	Name string
+	// Migrate applies the migration. It runs in a transaction with the
+	// recording of its version, so a failed migration is retried on the next
+	// startup.
+	Migrate func(tx *gorm.DB) error
+}
+
+// Migrations contains the versioned migrations to apply at startup, after the
+// automatic migrations of MigrateModels. New migrations are appended with the
+// next version. Applied migrations must not be changed or removed.
+var Migrations = []Migration{}
+
+// runMigrations applies the migrations that haven't been recorded in the
+// schema version table, in order of version
+func (d *Database) runMigrations(migrations []Migration) error {
+	var lastVersion uint
+	for _, m := range migrations {
+		if m.Version <= lastVersion {
+			return fmt.Errorf(
+				"migration %d (%s) is out of order",
+				m.Version,
+				m.Name,
+			)
+		}
+		lastVersion = m.Version
+	}
+	for _, m := range migrations {
+		applied := false
+		err := d.db.Transaction(func(tx *gorm.DB) error {
+			var existing SchemaVersion
+			result := tx.First(&existing, m.Version)
+			if result.Error == nil {
+				return nil
+			}
+			if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return result.Error
+			}
+			if err := m.Migrate(tx); err != nil {
+				return err
+			}
+			applied = true
+			return tx.Create(&SchemaVersion{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf(
+				"migration %d (%s): %w",
+				m.Version,
+				m.Name,
+				err,
+			)
+		}
+		if applied {
+			d.logger.Info(
+				"applied database migration",
+				"version", m.Version,
+				"name", m.Name,
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"gorm.io/gorm"
+)
+
+func TestMigrationsAppliedOnce(t *testing.T) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		Vpn:      config.VpnConfig{Region: "test"},
+	}
+	origMigrations := Migrations
+	t.Cleanup(func() { Migrations = origMigrations })
+
+	// Start with a client from before the migration existed
+	Migrations = nil
+	d, err := New(cfg, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	assetName := []byte("client")
+	if err := d.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		nil,
+		"test",
+		nil,
+		0,
+		1,
+	); err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The migration raises every device limit by one. It fails on the first
+	// startup that runs it.
+	runs := 0
+	fail := true
+	Migrations = []Migration{
+		{
+			Version: 1,
+			Name:    "raise device limits",
+			Migrate: func(tx *gorm.DB) error {
+				runs++
+				if fail {
+					return errors.New("migration failed")
+				}
+				return tx.Model(&Client{}).
+					Where("1 = 1").
+					Update("device_limit", gorm.Expr("device_limit + 1")).
+					Error
+			},
+		},
+	}
+	if _, err := New(cfg, nil); err == nil {
+		t.Fatal("New succeeded with a failing migration")
+	}
+
+	fail = false
+	for startup := 1; startup <= 2; startup++ {
+		d, err := New(cfg, nil)
+		if err != nil {
+			t.Fatalf("startup %d: New: %v", startup, err)
+		}
+		client, err := d.ClientByAssetName(assetName)
+		if err != nil {
+			t.Fatalf("startup %d: ClientByAssetName: %v", startup, err)
+		}
+		if client.DeviceLimit != 2 {
+			t.Errorf(
+				"startup %d: device limit = %d, want 2",
+				startup,
+				client.DeviceLimit,
+			)
+		}
+		var versions []SchemaVersion
+		if err := d.db.Find(&versions).Error; err != nil {
+			t.Fatalf("startup %d: failed to list versions: %v", startup, err)
+		}
+		if len(versions) != 1 || versions[0].Version != 1 {
+			t.Errorf("startup %d: versions = %+v, want 1", startup, versions)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatalf("startup %d: Close: %v", startup, err)
+		}
+	}
+
+	// Retried after the failure, then skipped once recorded
+	if runs != 2 {
+		t.Errorf("migration ran %d times, want 2", runs)
+	}
+}
+
+func TestMigrationsOutOfOrder(t *testing.T) {
+	d := newTestDatabase(t)
+	noop := func(*gorm.DB) error { return nil }
+	err := d.runMigrations([]Migration{
+		{Version: 2, Name: "second", Migrate: noop},
+		{Version: 1, Name: "first", Migrate: noop},
+	})
+	if err == nil {
+		t.Fatal("runMigrations succeeded with migrations out of order")
+	}
+}
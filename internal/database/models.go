@@ -19,9 +19,14 @@ package database
 var MigrateModels = []any{
 	&Client{},
 	&Cursor{},
+	&IssuedCert{},
+	&IssuedSerial{},
 	&Reference{},
+	&ReferenceHistory{},
 	&ReferencePrice{},
 	&ReferenceRegion{},
+	&SchemaVersion{},
 	&WGPeer{},
 	&WGIPPool{},
+	&WGIPReservation{},
 }
@@ -15,6 +15,14 @@
 package database
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
 	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -30,12 +38,44 @@ type Reference struct {
 	OutputIdx int
 	Prices    []ReferencePrice
 	Regions   []ReferenceRegion
+	// Version and Metadata come from the optional trailing reference datum
+	// fields
+	Version  uint
+	Metadata map[string]string `gorm:"serializer:json"`
+	// UpdatedAt is when the reference data was last written
+	UpdatedAt time.Time
 }
 
 func (Reference) TableName() string {
 	return "reference"
 }
 
+// ReferenceEquals reports whether two references point at the same UTxO and
+// carry the same plans, regions, version and metadata. Database IDs and
+// update times are ignored.
+func ReferenceEquals(a, b Reference) bool {
+	if !bytes.Equal(a.TxId, b.TxId) || a.OutputIdx != b.OutputIdx ||
+		a.Version != b.Version || !maps.Equal(a.Metadata, b.Metadata) {
+		return false
+	}
+	if !slices.EqualFunc(
+		a.Prices,
+		b.Prices,
+		func(x, y ReferencePrice) bool {
+			return x.Duration == y.Duration && x.Price == y.Price
+		},
+	) {
+		return false
+	}
+	return slices.EqualFunc(
+		a.Regions,
+		b.Regions,
+		func(x, y ReferenceRegion) bool {
+			return x.Name == y.Name
+		},
+	)
+}
+
 type ReferencePrice struct {
 	ID          uint `gorm:"primaryKey"`
 	ReferenceID uint
@@ -43,15 +83,55 @@ type ReferencePrice struct {
 	Price       int
 }
 
+// PlanId returns a stable identifier for the plan derived from its price and
+// duration. Unlike the plan's index, it doesn't change when the on-chain plan
+// list is reordered.
+func (p ReferencePrice) PlanId() string {
+	hash := lcommon.Blake2b224Hash(
+		[]byte(strconv.Itoa(p.Price) + ":" + strconv.Itoa(p.Duration)),
+	)
+	return hex.EncodeToString(hash.Bytes()[:8])
+}
+
 type ReferenceRegion struct {
 	ID          uint `gorm:"primaryKey"`
 	ReferenceID uint
 	Name        string
 }
 
+// ReferenceHistory records a reference data update, so plan price and region
+// changes stay auditable after the current reference data is replaced
+type ReferenceHistory struct {
+	ID        uint `gorm:"primaryKey"`
+	TxId      []byte
+	OutputIdx int
+	Prices    []ReferenceHistoryPrice `gorm:"serializer:json"`
+	Regions   []string                `gorm:"serializer:json"`
+	CreatedAt time.Time               `gorm:"autoCreateTime"`
+}
+
+func (ReferenceHistory) TableName() string {
+	return "reference_history"
+}
+
+// ReferenceHistoryPrice is a plan as recorded in the reference history
+type ReferenceHistoryPrice struct {
+	Duration int `json:"duration"`
+	Price    int `json:"price"`
+}
+
 func (d *Database) ReferenceData() (Reference, error) {
+	return d.ReferenceDataContext(context.Background())
+}
+
+// ReferenceDataContext is like ReferenceData but aborts the query when ctx is
+// done
+func (d *Database) ReferenceDataContext(
+	ctx context.Context,
+) (Reference, error) {
 	var ret Reference
-	result := d.db.Where("id = ?", referenceId).
+	result := d.db.WithContext(ctx).
+		Where("id = ?", referenceId).
 		Preload("Prices").
 		Preload("Regions").
 		First(&ret)
@@ -65,6 +145,8 @@ func (d *Database) UpdateReferenceData(
 	txOutputId lcommon.TransactionInput,
 	prices []ReferencePrice,
 	regions []string,
+	version uint,
+	metadata map[string]string,
 ) error {
 	tmpRegions := make([]ReferenceRegion, 0, len(regions))
 	for _, region := range regions {
@@ -81,6 +163,9 @@ func (d *Database) UpdateReferenceData(
 		OutputIdx: int(txOutputId.Index()),
 		Prices:    prices,
 		Regions:   tmpRegions,
+		Version:   version,
+		Metadata:  metadata,
+		UpdatedAt: time.Now(),
 	}
 	err := d.db.Transaction(func(tx *gorm.DB) error {
 		if result := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&ReferencePrice{}); result.Error != nil {
@@ -99,6 +184,25 @@ func (d *Database) UpdateReferenceData(
 		if result.Error != nil {
 			return result.Error
 		}
+		historyPrices := make([]ReferenceHistoryPrice, 0, len(prices))
+		for _, price := range prices {
+			historyPrices = append(
+				historyPrices,
+				ReferenceHistoryPrice{
+					Duration: price.Duration,
+					Price:    price.Price,
+				},
+			)
+		}
+		history := ReferenceHistory{
+			TxId:      tmpItem.TxId,
+			OutputIdx: tmpItem.OutputIdx,
+			Prices:    historyPrices,
+			Regions:   regions,
+		}
+		if result := tx.Create(&history); result.Error != nil {
+			return result.Error
+		}
 		return nil
 	})
 	if err != nil {
@@ -106,3 +210,26 @@ func (d *Database) UpdateReferenceData(
 	}
 	return nil
 }
+
+// ReferenceHistory returns the most recent reference data updates, newest
+// first. A limit of zero or less returns all of them.
+func (d *Database) ReferenceHistory(limit int) ([]ReferenceHistory, error) {
+	return d.ReferenceHistoryContext(context.Background(), limit)
+}
+
+// ReferenceHistoryContext is like ReferenceHistory but aborts the query when
+// ctx is done
+func (d *Database) ReferenceHistoryContext(
+	ctx context.Context,
+	limit int,
+) ([]ReferenceHistory, error) {
+	var ret []ReferenceHistory
+	query := d.db.WithContext(ctx).Order("id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if result := query.Find(&ret); result.Error != nil {
+		return nil, result.Error
+	}
+	return ret, nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/blinklabs-io/gouroboros/ledger/shelley"
+)
+
+func TestReferenceHistory(t *testing.T) {
+	d := newTestDatabase(t)
+
+	updates := []struct {
+		txId    string
+		prices  []ReferencePrice
+		regions []string
+	}{
+		{
+			txId:    strings.Repeat("01", 32),
+			prices:  []ReferencePrice{{Duration: 30, Price: 5_000_000}},
+			regions: []string{"us-east-1"},
+		},
+		{
+			txId: strings.Repeat("02", 32),
+			prices: []ReferencePrice{
+				{Duration: 30, Price: 6_000_000},
+				{Duration: 90, Price: 15_000_000},
+			},
+			regions: []string{"us-east-1", "eu-west-1"},
+		},
+	}
+	for _, update := range updates {
+		if err := d.UpdateReferenceData(
+			shelley.NewShelleyTransactionInput(update.txId, 0),
+			update.prices,
+			update.regions,
+			0,
+			nil,
+		); err != nil {
+			t.Fatalf("UpdateReferenceData: %v", err)
+		}
+	}
+
+	// The current state only reflects the latest update
+	ref, err := d.ReferenceData()
+	if err != nil {
+		t.Fatalf("ReferenceData: %v", err)
+	}
+	if len(ref.Prices) != 2 || len(ref.Regions) != 2 {
+		t.Errorf(
+			"reference data has %d prices and %d regions, want 2 and 2",
+			len(ref.Prices),
+			len(ref.Regions),
+		)
+	}
+
+	history, err := d.ReferenceHistory(0)
+	if err != nil {
+		t.Fatalf("ReferenceHistory: %v", err)
+	}
+	if len(history) != len(updates) {
+		t.Fatalf("history has %d entries, want %d", len(history), len(updates))
+	}
+	// Newest first
+	for i, entry := range history {
+		update := updates[len(updates)-1-i]
+		if got := hex.EncodeToString(entry.TxId); got != update.txId {
+			t.Errorf("history[%d] tx ID = %s, want %s", i, got, update.txId)
+		}
+		if len(entry.Prices) != len(update.prices) {
+			t.Fatalf(
+				"history[%d] has %d prices, want %d",
+				i,
+				len(entry.Prices),
+				len(update.prices),
+			)
+		}
+		for j, price := range entry.Prices {
+			if price.Price != update.prices[j].Price ||
+				price.Duration != update.prices[j].Duration {
+				t.Errorf(
+					"history[%d] price %d = %+v, want %+v",
+					i,
+					j,
+					price,
+					update.prices[j],
+				)
+			}
+		}
+		if !slices.Equal(entry.Regions, update.regions) {
+			t.Errorf(
+				"history[%d] regions = %v, want %v",
+				i,
+				entry.Regions,
+				update.regions,
+			)
+		}
+		if entry.CreatedAt.IsZero() {
+			t.Errorf("history[%d] has no timestamp", i)
+		}
+	}
+
+	limited, err := d.ReferenceHistory(1)
+	if err != nil {
+		t.Fatalf("ReferenceHistory: %v", err)
+	}
+	if len(limited) != 1 || limited[0].ID != history[0].ID {
+		t.Errorf(
+			"ReferenceHistory(1) = %+v, want only the latest entry",
+			limited,
+		)
+	}
+}
+
+func TestReferenceEquals(t *testing.T) {
+	base := func() Reference {
+		return Reference{
+			ID:        1,
+			TxId:      []byte("txid"),
+			OutputIdx: 0,
+			Prices: []ReferencePrice{
+				{ID: 1, Duration: 30, Price: 5_000_000},
+			},
+			Regions:  []ReferenceRegion{{ID: 1, Name: "us-east-1"}},
+			Version:  1,
+			Metadata: map[string]string{"provider": "test"},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(*Reference)
+		want   bool
+	}{
+		{name: "identical", modify: func(*Reference) {}, want: true},
+		{
+			name: "different database IDs",
+			modify: func(r *Reference) {
+				r.ID = 2
+				r.Prices[0].ID = 2
+				r.Regions[0].ID = 2
+			},
+			want: true,
+		},
+		{
+			name:   "different tx",
+			modify: func(r *Reference) { r.TxId = []byte("other") },
+		},
+		{
+			name:   "different output",
+			modify: func(r *Reference) { r.OutputIdx = 1 },
+		},
+		{
+			name:   "different price",
+			modify: func(r *Reference) { r.Prices[0].Price = 6_000_000 },
+		},
+		{
+			name: "extra region",
+			modify: func(r *Reference) {
+				r.Regions = append(r.Regions, ReferenceRegion{Name: "eu"})
+			},
+		},
+		{
+			name:   "different version",
+			modify: func(r *Reference) { r.Version = 2 },
+		},
+		{
+			name:   "different metadata",
+			modify: func(r *Reference) { r.Metadata = nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := base()
+			tt.modify(&other)
+			if got := ReferenceEquals(base(), other); got != tt.want {
+				t.Errorf("ReferenceEquals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
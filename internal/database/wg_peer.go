@@ -15,12 +15,16 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -28,6 +32,26 @@ import (
 // ErrIPPoolExhausted is returned when no more IPs are available in the pool
 var ErrIPPoolExhausted = errors.New("IP pool exhausted: no available addresses")
 
+// ErrWGPubkeyInUse is returned when a pubkey is already registered to a peer
+var ErrWGPubkeyInUse = errors.New("WireGuard pubkey already registered")
+
+// WGUsableHosts is the number of assignable host addresses in a region's /24.
+// Octets .0 (network), .1 (gateway), and .255 (broadcast) are reserved.
+const WGUsableHosts = 253
+
+// wgIPReservationTTL is how long an allocated IP is held for the peer it's
+// allocated to, before the peer is added. It covers a registration's S3 write
+// and database insert.
+const wgIPReservationTTL = 5 * time.Minute
+
+var metricWGIPPoolFree = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "wg_ip_pool_free",
+		Help: "Number of unallocated WireGuard IPs in a region's pool",
+	},
+	[]string{"region"},
+)
+
 // WGPeer tracks WireGuard device registrations (cache of S3 data).
 // The database serves as a local cache; S3 is the source of truth.
 type WGPeer struct {
@@ -52,6 +76,19 @@ func (WGIPPool) TableName() string {
 	return "wg_ip_pool"
 }
 
+// WGIPReservation holds an allocated IP until the peer it's allocated to is
+// added or the reservation expires, so concurrent allocations don't hand out
+// the same IP
+type WGIPReservation struct {
+	Region    string    `gorm:"primaryKey"`
+	Octet     int       `gorm:"primaryKey;autoIncrement:false"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
+func (WGIPReservation) TableName() string {
+	return "wg_ip_reservation"
+}
+
 // AddWGPeer adds a new WireGuard peer to the database
 func (d *Database) AddWGPeer(
 	assetName []byte,
@@ -71,8 +108,18 @@ func (d *Database) AddWGPeer(
 
 // GetWGPeersByAsset returns all WireGuard peers for a given asset name
 func (d *Database) GetWGPeersByAsset(assetName []byte) ([]WGPeer, error) {
+	return d.GetWGPeersByAssetContext(context.Background(), assetName)
+}
+
+// GetWGPeersByAssetContext is like GetWGPeersByAsset but aborts the query when
+// ctx is done
+func (d *Database) GetWGPeersByAssetContext(
+	ctx context.Context,
+	assetName []byte,
+) ([]WGPeer, error) {
 	var peers []WGPeer
-	result := d.db.Where("asset_name = ?", assetName).
+	result := d.db.WithContext(ctx).
+		Where("asset_name = ?", assetName).
 		Order("created_at").
 		Find(&peers)
 	if result.Error != nil {
@@ -83,8 +130,19 @@ func (d *Database) GetWGPeersByAsset(assetName []byte) ([]WGPeer, error) {
 
 // GetWGPeerByPubkey returns a WireGuard peer by its public key
 func (d *Database) GetWGPeerByPubkey(pubkey string) (*WGPeer, error) {
+	return d.GetWGPeerByPubkeyContext(context.Background(), pubkey)
+}
+
+// GetWGPeerByPubkeyContext is like GetWGPeerByPubkey but aborts the query when
+// ctx is done
+func (d *Database) GetWGPeerByPubkeyContext(
+	ctx context.Context,
+	pubkey string,
+) (*WGPeer, error) {
 	var peer WGPeer
-	result := d.db.Where("pubkey = ?", pubkey).First(&peer)
+	result := d.db.WithContext(ctx).
+		Where("pubkey = ?", pubkey).
+		First(&peer)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -102,10 +160,47 @@ func (d *Database) DeleteWGPeer(pubkey string) error {
 	return nil
 }
 
+// RotateWGPeerPubkey replaces a peer's pubkey, keeping its assigned IP and
+// creation time. Returns ErrRecordNotFound if oldPubkey is not registered and
+// ErrWGPubkeyInUse if newPubkey already is.
+func (d *Database) RotateWGPeerPubkey(oldPubkey, newPubkey string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&WGPeer{}).
+			Where("pubkey = ?", newPubkey).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrWGPubkeyInUse
+		}
+		result := tx.Model(&WGPeer{}).
+			Where("pubkey = ?", oldPubkey).
+			UpdateColumn("pubkey", newPubkey)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
 // CountWGPeersByAsset returns the number of WireGuard peers for a given asset
 func (d *Database) CountWGPeersByAsset(assetName []byte) (int64, error) {
+	return d.CountWGPeersByAssetContext(context.Background(), assetName)
+}
+
+// CountWGPeersByAssetContext is like CountWGPeersByAsset but aborts the query
+// when ctx is done
+func (d *Database) CountWGPeersByAssetContext(
+	ctx context.Context,
+	assetName []byte,
+) (int64, error) {
 	var count int64
-	result := d.db.Model(&WGPeer{}).
+	result := d.db.WithContext(ctx).
+		Model(&WGPeer{}).
 		Where("asset_name = ?", assetName).
 		Count(&count)
 	if result.Error != nil {
@@ -119,14 +214,21 @@ func (d *Database) CountWGPeersByAsset(assetName []byte) (int64, error) {
 // Skips .0 (network), .1 (gateway), and .255 (broadcast).
 // Returns ErrIPPoolExhausted if all IPs (2-254) are in use.
 func (d *Database) AllocateIP(region string) (string, error) {
+	return d.AllocateIPContext(context.Background(), region)
+}
+
+// AllocateIPContext is like AllocateIP but rolls back the allocation when ctx
+// is done
+func (d *Database) AllocateIPContext(
+	ctx context.Context,
+	region string,
+) (string, error) {
 	var allocatedIP string
+	var freeIPs int
 
-	subnet := d.config.Vpn.WGSubnet
-	if subnet == "" {
-		subnet = "10.8.0"
-	}
+	subnet := d.wgSubnet(region)
 
-	err := d.db.Transaction(func(tx *gorm.DB) error {
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// Get or create the IP pool for this region
 		// Use SELECT ... FOR UPDATE to serialize concurrent allocations
 		var pool WGIPPool
@@ -184,8 +286,32 @@ func (d *Database) AllocateIP(region string) (string, error) {
 			}
 		}
 
-		// Find next available IP, starting from pool.NextIP
+		// IPs allocated to peers that haven't been added yet are also in
+		// use
+		now := time.Now()
+		if err := tx.Where(
+			"region = ? AND expires_at <= ?",
+			region,
+			now,
+		).Delete(&WGIPReservation{}).Error; err != nil {
+			return fmt.Errorf("failed to expire IP reservations: %w", err)
+		}
+		var reservedOctets []int
+		if err := tx.Model(&WGIPReservation{}).
+			Where("region = ?", region).
+			Pluck("octet", &reservedOctets).Error; err != nil {
+			return fmt.Errorf("failed to get reserved IPs: %w", err)
+		}
+		for _, octet := range reservedOctets {
+			usedOctets[octet] = true
+		}
+
+		// Find next available IP, starting from pool.NextIP or, with the
+		// "lowest" strategy, from the bottom of the range
 		startIP := pool.NextIP
+		if d.config.Vpn.WGAllocationStrategy == config.WGAllocationLowest {
+			startIP = 2
+		}
 		currentIP := startIP
 		found := false
 
@@ -223,8 +349,22 @@ func (d *Database) AllocateIP(region string) (string, error) {
 			return err
 		}
 
+		// Reserve the IP in the same transaction, since the peer isn't
+		// added until the caller has saved it
+		if err := tx.Create(&WGIPReservation{
+			Region:    region,
+			Octet:     currentIP,
+			ExpiresAt: now.Add(wgIPReservationTTL),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to reserve IP: %w", err)
+		}
+
 		// Format the allocated IP
 		allocatedIP = fmt.Sprintf("%s.%d", subnet, currentIP)
+
+		// The new IP isn't persisted as a peer yet, so count it as used
+		usedOctets[currentIP] = true
+		freeIPs = countFreeOctets(usedOctets)
 		return nil
 	})
 
@@ -232,9 +372,94 @@ func (d *Database) AllocateIP(region string) (string, error) {
 		return "", err
 	}
 
+	metricWGIPPoolFree.WithLabelValues(region).Set(float64(freeIPs))
+
 	return allocatedIP, nil
 }
 
+// CountFreeIPs returns the number of unallocated host addresses remaining in
+// a region's pool
+func (d *Database) CountFreeIPs(region string) (int, error) {
+	return d.CountFreeIPsContext(context.Background(), region)
+}
+
+// CountFreeIPsContext is like CountFreeIPs but aborts the query when ctx is
+// done
+func (d *Database) CountFreeIPsContext(
+	ctx context.Context,
+	region string,
+) (int, error) {
+	var assignedIPs []string
+	result := d.db.WithContext(ctx).
+		Model(&WGPeer{}).
+		Joins("JOIN client ON wg_peer.asset_name = client.asset_name").
+		Where("client.region = ?", region).
+		Pluck("wg_peer.assigned_ip", &assignedIPs)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	usedOctets := make(map[int]bool)
+	for _, ip := range assignedIPs {
+		parts := strings.Split(ip, ".")
+		if len(parts) != 4 {
+			continue
+		}
+		if octet, err := strconv.Atoi(parts[3]); err == nil {
+			usedOctets[octet] = true
+		}
+	}
+
+	return countFreeOctets(usedOctets), nil
+}
+
+// countFreeOctets returns the number of assignable octets (2-254) not present
+// in usedOctets
+func countFreeOctets(usedOctets map[int]bool) int {
+	used := 0
+	for octet := range usedOctets {
+		if octet >= 2 && octet <= 254 {
+			used++
+		}
+	}
+	return WGUsableHosts - used
+}
+
+// updateIPPoolFreeMetric recalculates the free IP gauge for a region
+func (d *Database) updateIPPoolFreeMetric(region string) {
+	freeIPs, err := d.CountFreeIPs(region)
+	if err != nil {
+		d.logger.Warn(
+			"failed to update IP pool metric",
+			"region", region,
+			"error", err,
+		)
+		return
+	}
+	metricWGIPPoolFree.WithLabelValues(region).Set(float64(freeIPs))
+}
+
+// GetWGIPPoolRegions returns the regions that have an IP pool
+func (d *Database) GetWGIPPoolRegions() ([]string, error) {
+	return d.GetWGIPPoolRegionsContext(context.Background())
+}
+
+// GetWGIPPoolRegionsContext is like GetWGIPPoolRegions but aborts the query
+// when ctx is done
+func (d *Database) GetWGIPPoolRegionsContext(
+	ctx context.Context,
+) ([]string, error) {
+	var regions []string
+	result := d.db.WithContext(ctx).
+		Model(&WGIPPool{}).
+		Order("region").
+		Pluck("region", &regions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return regions, nil
+}
+
 // GetExpiredWGPeers returns all WireGuard peers whose subscriptions have expired
 func (d *Database) GetExpiredWGPeers() ([]WGPeer, error) {
 	var peers []WGPeer
@@ -263,6 +488,72 @@ func (d *Database) HasWGPeers() (bool, error) {
 	return count > 0, nil
 }
 
+// DuplicateWGPeerIPs returns the peers in a region whose assigned IP is also
+// held by an earlier peer. The earliest peer holding an IP keeps it.
+func (d *Database) DuplicateWGPeerIPs(region string) ([]WGPeer, error) {
+	var peers []WGPeer
+	result := d.db.
+		Joins("JOIN client ON wg_peer.asset_name = client.asset_name").
+		Where("client.region = ?", region).
+		Order("wg_peer.id").
+		Find(&peers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	seen := make(map[string]bool, len(peers))
+	var duplicates []WGPeer
+	for _, peer := range peers {
+		if seen[peer.AssignedIP] {
+			duplicates = append(duplicates, peer)
+			continue
+		}
+		seen[peer.AssignedIP] = true
+	}
+	return duplicates, nil
+}
+
+// WGPeersOutsideSubnet returns the peers in a region whose assigned IP isn't
+// in the region's configured WG subnet, oldest first
+func (d *Database) WGPeersOutsideSubnet(region string) ([]WGPeer, error) {
+	var peers []WGPeer
+	result := d.db.
+		Joins("JOIN client ON wg_peer.asset_name = client.asset_name").
+		Where(
+			"client.region = ? AND wg_peer.assigned_ip NOT LIKE ?",
+			region,
+			d.wgSubnet(region)+".%",
+		).
+		Order("wg_peer.id").
+		Find(&peers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return peers, nil
+}
+
+// wgSubnet returns the configured WG subnet for a region, the first 3 octets
+// of the assigned IPs
+func (d *Database) wgSubnet(region string) string {
+	if subnet := d.config.Vpn.WGSubnetFor(region); subnet != "" {
+		return subnet
+	}
+	return "10.8.0"
+}
+
+// UpdateWGPeerIP changes the assigned IP of the peer with the given pubkey
+func (d *Database) UpdateWGPeerIP(pubkey, assignedIP string) error {
+	result := d.db.Model(&WGPeer{}).
+		Where("pubkey = ?", pubkey).
+		UpdateColumn("assigned_ip", assignedIP)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 // RebuildIPPool rebuilds the IP pool for a region based on existing peer IPs
 func (d *Database) RebuildIPPool(region string) error {
 	// Get all assigned IPs for peers in this region
@@ -298,12 +589,16 @@ func (d *Database) RebuildIPPool(region string) error {
 		}
 	}
 
-	return d.db.Save(&WGIPPool{Region: region, NextIP: nextIP}).Error
+	if err := d.db.Save(&WGIPPool{Region: region, NextIP: nextIP}).Error; err != nil {
+		return err
+	}
+	d.updateIPPoolFreeMetric(region)
+	return nil
 }
 
-// DeallocateIP releases an IP back to the pool by resetting NextIP to point
-// to the deallocated IP's octet. This ensures the IP is immediately available
-// for the next allocation attempt. Use this when an IP was allocated but the
+// DeallocateIP releases an IP back to the pool by releasing its reservation
+// and resetting NextIP to point to the deallocated IP's octet. This ensures
+// the IP is immediately available for the next allocation attempt. Use this when an IP was allocated but the
 // peer was not successfully persisted (e.g., S3 save failed).
 func (d *Database) DeallocateIP(region, ip string) error {
 	// Extract the last octet from the IP
@@ -322,11 +617,37 @@ func (d *Database) DeallocateIP(region, ip string) error {
 		)
 	}
 
-	// Update the pool's NextIP to point to the deallocated IP
-	// so it's the next one tried on allocation
-	return d.db.Model(&WGIPPool{}).
-		Where("region = ?", region).
-		Update("next_ip", octet).Error
+	// Release the IP's reservation and update the pool's NextIP to point to
+	// the deallocated IP so it's the next one tried on allocation
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("region = ? AND octet = ?", region, octet).
+			Delete(&WGIPReservation{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&WGIPPool{}).
+			Where("region = ?", region).
+			Update("next_ip", octet).Error
+	})
+	if err != nil {
+		return err
+	}
+	d.updateIPPoolFreeMetric(region)
+	return nil
+}
+
+// GetWGPeersForRegion returns all WireGuard peers for subscriptions in the
+// specified region
+func (d *Database) GetWGPeersForRegion(region string) ([]WGPeer, error) {
+	var peers []WGPeer
+	result := d.db.
+		Joins("JOIN client ON wg_peer.asset_name = client.asset_name").
+		Where("client.region = ?", region).
+		Order("wg_peer.id").
+		Find(&peers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return peers, nil
 }
 
 // GetActivePeersForRegion returns all WireGuard peers for active (non-expired)
@@ -15,11 +15,18 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
 	"github.com/glebarez/sqlite"
+	dto "github.com/prometheus/client_model/go"
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
 )
@@ -59,10 +66,11 @@ func newTestDatabase(t *testing.T) *Database {
 	d := &Database{
 		config: cfg,
 		db:     db,
+		logger: slog.New(slog.NewJSONHandler(io.Discard, nil)),
 	}
 
-	// Run migrations for WGPeer, WGIPPool, and Client
-	if err := db.AutoMigrate(&WGPeer{}, &WGIPPool{}, &Client{}); err != nil {
+	// Run migrations for all models
+	if err := db.AutoMigrate(MigrateModels...); err != nil {
 		t.Fatalf("failed to migrate tables: %v", err)
 	}
 
@@ -131,6 +139,71 @@ func TestAddWGPeerDuplicatePubkey(t *testing.T) {
 	}
 }
 
+func TestRotateWGPeerPubkey(t *testing.T) {
+	const (
+		oldPubkey   = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijk="
+		newPubkey   = "NEWDEFGHIJKLMNOPQRSTUVWXYZabcdefghijk="
+		otherPubkey = "OTHERFGHIJKLMNOPQRSTUVWXYZabcdefghijk="
+	)
+
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		wantErr error
+	}{
+		{name: "rotate", old: oldPubkey, new: newPubkey},
+		{name: "unknown old key", old: newPubkey, new: newPubkey + "x", wantErr: ErrRecordNotFound},
+		{name: "new key in use", old: oldPubkey, new: otherPubkey, wantErr: ErrWGPubkeyInUse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			assetName := []byte("test-asset-123")
+			if err := db.AddWGPeer(assetName, oldPubkey, "10.8.0.42"); err != nil {
+				t.Fatalf("unexpected error adding WG peer: %v", err)
+			}
+			if err := db.AddWGPeer(assetName, otherPubkey, "10.8.0.43"); err != nil {
+				t.Fatalf("unexpected error adding WG peer: %v", err)
+			}
+			before, err := db.GetWGPeerByPubkey(oldPubkey)
+			if err != nil {
+				t.Fatalf("unexpected error getting WG peer: %v", err)
+			}
+
+			err = db.RotateWGPeerPubkey(tt.old, tt.new)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+				}
+				// The original peer is untouched
+				if _, err := db.GetWGPeerByPubkey(oldPubkey); err != nil {
+					t.Fatalf("original peer missing after failed rotate: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error rotating pubkey: %v", err)
+			}
+
+			if _, err := db.GetWGPeerByPubkey(oldPubkey); !errors.Is(err, ErrRecordNotFound) {
+				t.Fatalf("expected old pubkey to be gone, got %v", err)
+			}
+			after, err := db.GetWGPeerByPubkey(tt.new)
+			if err != nil {
+				t.Fatalf("unexpected error getting rotated peer: %v", err)
+			}
+			if after.ID != before.ID ||
+				after.AssignedIP != before.AssignedIP ||
+				!after.CreatedAt.Equal(before.CreatedAt) ||
+				string(after.AssetName) != string(before.AssetName) {
+				t.Fatalf("rotated peer %+v does not match original %+v", after, before)
+			}
+		})
+	}
+}
+
 func TestGetWGPeersByAsset(t *testing.T) {
 	db := newTestDatabase(t)
 
@@ -565,6 +638,67 @@ func TestRebuildIPPool(t *testing.T) {
 	}
 }
 
+func TestDuplicateWGPeerIPs(t *testing.T) {
+	db := newTestDatabase(t)
+
+	region := "test-region"
+	for _, assetName := range []string{"asset1", "asset2", "asset3"} {
+		if err := db.db.Create(&Client{
+			AssetName: []byte(assetName),
+			Region:    region,
+		}).Error; err != nil {
+			t.Fatalf("failed to create client in setup: %v", err)
+		}
+	}
+	// A peer in another region doesn't conflict
+	if err := db.db.Create(&Client{
+		AssetName: []byte("other"),
+		Region:    "other-region",
+	}).Error; err != nil {
+		t.Fatalf("failed to create client in setup: %v", err)
+	}
+
+	if err := db.AddWGPeer([]byte("asset1"), "pubkey1", "10.8.0.5"); err != nil {
+		t.Fatalf("failed to add WG peer in setup: %v", err)
+	}
+	if err := db.AddWGPeer([]byte("asset2"), "pubkey2", "10.8.0.5"); err != nil {
+		t.Fatalf("failed to add WG peer in setup: %v", err)
+	}
+	if err := db.AddWGPeer([]byte("asset3"), "pubkey3", "10.8.0.6"); err != nil {
+		t.Fatalf("failed to add WG peer in setup: %v", err)
+	}
+	if err := db.AddWGPeer([]byte("other"), "pubkey4", "10.8.0.6"); err != nil {
+		t.Fatalf("failed to add WG peer in setup: %v", err)
+	}
+
+	// The earlier peer keeps the IP
+	duplicates, err := db.DuplicateWGPeerIPs(region)
+	if err != nil {
+		t.Fatalf("unexpected error finding duplicate IPs: %v", err)
+	}
+	if len(duplicates) != 1 || duplicates[0].Pubkey != "pubkey2" {
+		t.Fatalf("expected only pubkey2 to be a duplicate, got %+v", duplicates)
+	}
+
+	if err := db.UpdateWGPeerIP("pubkey2", "10.8.0.7"); err != nil {
+		t.Fatalf("unexpected error updating peer IP: %v", err)
+	}
+	duplicates, err = db.DuplicateWGPeerIPs(region)
+	if err != nil {
+		t.Fatalf("unexpected error finding duplicate IPs: %v", err)
+	}
+	if len(duplicates) != 0 {
+		t.Fatalf("expected no duplicates after update, got %+v", duplicates)
+	}
+
+	if err := db.UpdateWGPeerIP("unknown", "10.8.0.8"); !errors.Is(
+		err,
+		ErrRecordNotFound,
+	) {
+		t.Fatalf("expected ErrRecordNotFound for unknown peer, got %v", err)
+	}
+}
+
 func TestRebuildIPPoolEmpty(t *testing.T) {
 	db := newTestDatabase(t)
 
@@ -642,3 +776,319 @@ func TestDeallocateIPInvalidFormat(t *testing.T) {
 		t.Fatal("expected error for invalid octet")
 	}
 }
+
+func TestCountFreeIPs(t *testing.T) {
+	db := newTestDatabase(t)
+
+	region := "test-region"
+	otherRegion := "other-region"
+
+	if err := db.db.Create(&Client{AssetName: []byte("asset1"), Region: region}).Error; err != nil {
+		t.Fatalf("failed to create client in setup: %v", err)
+	}
+	if err := db.db.Create(&Client{AssetName: []byte("asset2"), Region: otherRegion}).Error; err != nil {
+		t.Fatalf("failed to create client in setup: %v", err)
+	}
+
+	// Empty pool has every usable host free
+	free, err := db.CountFreeIPs(region)
+	if err != nil {
+		t.Fatalf("unexpected error counting free IPs: %v", err)
+	}
+	if free != WGUsableHosts {
+		t.Fatalf("expected %d free IPs in empty pool, got %d", WGUsableHosts, free)
+	}
+
+	// Partially fill the pool with 5 peers
+	for i := range 5 {
+		if err := db.AddWGPeer(
+			[]byte("asset1"),
+			fmt.Sprintf("pubkey%d", i),
+			fmt.Sprintf("10.8.0.%d", i+2),
+		); err != nil {
+			t.Fatalf("failed to add WG peer in setup: %v", err)
+		}
+	}
+	// Peers in another region don't count against this one
+	if err := db.AddWGPeer([]byte("asset2"), "pubkey-other", "10.8.0.100"); err != nil {
+		t.Fatalf("failed to add WG peer in setup: %v", err)
+	}
+
+	free, err = db.CountFreeIPs(region)
+	if err != nil {
+		t.Fatalf("unexpected error counting free IPs: %v", err)
+	}
+	if free != WGUsableHosts-5 {
+		t.Fatalf("expected %d free IPs, got %d", WGUsableHosts-5, free)
+	}
+
+	free, err = db.CountFreeIPs(otherRegion)
+	if err != nil {
+		t.Fatalf("unexpected error counting free IPs: %v", err)
+	}
+	if free != WGUsableHosts-1 {
+		t.Fatalf("expected %d free IPs in other region, got %d", WGUsableHosts-1, free)
+	}
+}
+
+// wgIPPoolFreeMetric returns the current wg_ip_pool_free gauge value for a
+// region
+func wgIPPoolFreeMetric(t *testing.T, region string) int {
+	t.Helper()
+	var m dto.Metric
+	if err := metricWGIPPoolFree.WithLabelValues(region).Write(&m); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	return int(m.GetGauge().GetValue())
+}
+
+func TestIPPoolFreeMetric(t *testing.T) {
+	db := newTestDatabase(t)
+
+	region := "metric-region"
+	if err := db.db.Create(&Client{AssetName: []byte("asset1"), Region: region}).Error; err != nil {
+		t.Fatalf("failed to create client in setup: %v", err)
+	}
+
+	// Start near the top of the range so allocations wrap around to .2
+	if err := db.db.Create(&WGIPPool{Region: region, NextIP: 252}).Error; err != nil {
+		t.Fatalf("failed to create IP pool in setup: %v", err)
+	}
+
+	wantIPs := []string{"10.8.0.252", "10.8.0.253", "10.8.0.254", "10.8.0.2", "10.8.0.3"}
+	for i, wantIP := range wantIPs {
+		ip, err := db.AllocateIP(region)
+		if err != nil {
+			t.Fatalf("unexpected error allocating IP: %v", err)
+		}
+		if ip != wantIP {
+			t.Fatalf("allocation %d: expected IP %s, got %s", i, wantIP, ip)
+		}
+		if got, want := wgIPPoolFreeMetric(t, region), WGUsableHosts-(i+1); got != want {
+			t.Fatalf("allocation %d: expected free gauge %d, got %d", i, want, got)
+		}
+		if err := db.AddWGPeer([]byte("asset1"), fmt.Sprintf("pubkey%d", i), ip); err != nil {
+			t.Fatalf("failed to add WG peer: %v", err)
+		}
+	}
+
+	// Removing a peer and releasing its IP frees it again
+	if err := db.DeleteWGPeer("pubkey3"); err != nil {
+		t.Fatalf("failed to delete WG peer: %v", err)
+	}
+	if err := db.DeallocateIP(region, "10.8.0.2"); err != nil {
+		t.Fatalf("failed to deallocate IP: %v", err)
+	}
+	if got, want := wgIPPoolFreeMetric(t, region), WGUsableHosts-4; got != want {
+		t.Fatalf("after deallocate: expected free gauge %d, got %d", want, got)
+	}
+
+	// Rebuilding the pool recalculates the gauge from stored peers
+	if err := db.RebuildIPPool(region); err != nil {
+		t.Fatalf("failed to rebuild IP pool: %v", err)
+	}
+	if got, want := wgIPPoolFreeMetric(t, region), WGUsableHosts-4; got != want {
+		t.Fatalf("after rebuild: expected free gauge %d, got %d", want, got)
+	}
+}
+
+func TestAllocateIPStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		wantIPs  []string
+	}{
+		{
+			name:     "next continues from hint",
+			strategy: config.WGAllocationNext,
+			wantIPs:  []string{"10.8.0.7", "10.8.0.8", "10.8.0.9"},
+		},
+		{
+			name:     "default behaves like next",
+			strategy: "",
+			wantIPs:  []string{"10.8.0.7", "10.8.0.8", "10.8.0.9"},
+		},
+		{
+			name:     "lowest fills gaps first",
+			strategy: config.WGAllocationLowest,
+			wantIPs:  []string{"10.8.0.3", "10.8.0.5", "10.8.0.7"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			db.config.Vpn.WGAllocationStrategy = tt.strategy
+
+			region := "test-region"
+			if err := db.db.Create(&Client{AssetName: []byte("asset1"), Region: region}).Error; err != nil {
+				t.Fatalf("failed to create client in setup: %v", err)
+			}
+
+			// Sparse pool: .2, .4, and .6 in use, with the hint past them
+			for _, ip := range []string{"10.8.0.2", "10.8.0.4", "10.8.0.6"} {
+				if err := db.AddWGPeer([]byte("asset1"), "pubkey-"+ip, ip); err != nil {
+					t.Fatalf("failed to add WG peer in setup: %v", err)
+				}
+			}
+			if err := db.db.Create(&WGIPPool{Region: region, NextIP: 7}).Error; err != nil {
+				t.Fatalf("failed to create IP pool in setup: %v", err)
+			}
+
+			for i, wantIP := range tt.wantIPs {
+				ip, err := db.AllocateIP(region)
+				if err != nil {
+					t.Fatalf("unexpected error allocating IP: %v", err)
+				}
+				if ip != wantIP {
+					t.Fatalf("allocation %d: expected IP %s, got %s", i, wantIP, ip)
+				}
+				if err := db.AddWGPeer([]byte("asset1"), "pubkey-"+ip, ip); err != nil {
+					t.Fatalf("failed to add WG peer: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateIPLowestConcurrent(t *testing.T) {
+	db := newTestDatabase(t)
+	db.config.Vpn.WGAllocationStrategy = config.WGAllocationLowest
+
+	region := "test-region"
+	if err := db.db.Create(&Client{AssetName: []byte("asset1"), Region: region}).Error; err != nil {
+		t.Fatalf("failed to create client in setup: %v", err)
+	}
+
+	// Allocate concurrently without adding the peers, like registrations
+	// that are still saving their peer files
+	const allocations = 10
+	ips := make([]string, allocations)
+	errs := make([]error, allocations)
+	var wg sync.WaitGroup
+	for i := range allocations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips[i], errs[i] = db.AllocateIP(region)
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, ip := range ips {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error allocating IP: %v", errs[i])
+		}
+		if seen[ip] {
+			t.Fatalf("IP %s allocated more than once", ip)
+		}
+		seen[ip] = true
+	}
+
+	// A deallocated IP is released for the next allocation
+	if err := db.DeallocateIP(region, ips[0]); err != nil {
+		t.Fatalf("unexpected error deallocating IP: %v", err)
+	}
+	ip, err := db.AllocateIP(region)
+	if err != nil {
+		t.Fatalf("unexpected error allocating IP: %v", err)
+	}
+	if ip != ips[0] {
+		t.Fatalf("expected deallocated IP %s to be reused, got %s", ips[0], ip)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	db := newTestDatabase(t)
+	const region = "test"
+	if err := db.AddClient(
+		[]byte("asset1"),
+		time.Now().Add(time.Hour),
+		[]byte("credential"),
+		region,
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	if err := db.AddWGPeer([]byte("asset1"), "pubkey1", "10.8.0.2"); err != nil {
+		t.Fatalf("failed to add peer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := db.GetWGPeersByAssetContext(
+		ctx,
+		[]byte("asset1"),
+	); !errors.Is(err, context.Canceled) {
+		t.Errorf(
+			"GetWGPeersByAssetContext error = %v, want %v",
+			err,
+			context.Canceled,
+		)
+	}
+	if _, err := db.ClientByAssetNameContext(
+		ctx,
+		[]byte("asset1"),
+	); !errors.Is(err, context.Canceled) {
+		t.Errorf(
+			"ClientByAssetNameContext error = %v, want %v",
+			err,
+			context.Canceled,
+		)
+	}
+	if _, err := db.AllocateIPContext(ctx, region); !errors.Is(
+		err,
+		context.Canceled,
+	) {
+		t.Errorf(
+			"AllocateIPContext error = %v, want %v",
+			err,
+			context.Canceled,
+		)
+	}
+
+	// The aborted allocation left no pool behind, and the non-context
+	// wrappers still work
+	regions, err := db.GetWGIPPoolRegions()
+	if err != nil {
+		t.Fatalf("GetWGIPPoolRegions: %v", err)
+	}
+	if len(regions) != 0 {
+		t.Errorf("regions = %v, want none", regions)
+	}
+	ip, err := db.AllocateIP(region)
+	if err != nil {
+		t.Fatalf("AllocateIP: %v", err)
+	}
+	if ip != "10.8.0.3" {
+		t.Errorf("ip = %q, want %q", ip, "10.8.0.3")
+	}
+}
+
+func TestAllocateIPRegionSubnet(t *testing.T) {
+	db := newTestDatabase(t)
+	db.config.Vpn.WGRegions = map[string]config.WGRegionConfig{
+		"custom": {Subnet: "10.9.0"},
+	}
+
+	ip, err := db.AllocateIP("custom")
+	if err != nil {
+		t.Fatalf("unexpected error allocating IP: %v", err)
+	}
+	if ip != "10.9.0.2" {
+		t.Fatalf("expected first IP for custom region to be 10.9.0.2, got %s", ip)
+	}
+
+	// Regions without an override keep the global subnet
+	ip, err = db.AllocateIP("other")
+	if err != nil {
+		t.Fatalf("unexpected error allocating IP: %v", err)
+	}
+	if ip != "10.8.0.2" {
+		t.Fatalf("expected first IP for other region to be 10.8.0.2, got %s", ip)
+	}
+}
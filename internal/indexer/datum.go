@@ -16,6 +16,8 @@ package indexer
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/blinklabs-io/gouroboros/cbor"
 )
@@ -25,6 +27,9 @@ type ClientDatum struct {
 	Credential []byte
 	Region     []byte
 	Expiration uint
+	// DeviceLimit is an optional trailing datum field that overrides the
+	// global WireGuard device limit. It is zero when not present.
+	DeviceLimit uint
 }
 
 func (d *ClientDatum) UnmarshalCBOR(data []byte) error {
@@ -35,12 +40,27 @@ func (d *ClientDatum) UnmarshalCBOR(data []byte) error {
 	if tmpConstr.Tag() != 1 {
 		return errors.New("invalid constructor")
 	}
-	type tClientDatum ClientDatum
-	var tmp tClientDatum
-	if _, err := cbor.Decode(tmpConstr.Fields(), &tmp); err != nil {
+	// Decode fields individually, since the device limit may be omitted
+	var fields []cbor.RawMessage
+	if _, err := cbor.Decode(tmpConstr.Fields(), &fields); err != nil {
 		return err
 	}
-	*d = ClientDatum(tmp)
+	if len(fields) != 3 && len(fields) != 4 {
+		return fmt.Errorf("invalid client datum field count: %d", len(fields))
+	}
+	var tmp ClientDatum
+	dests := []any{
+		&tmp.Credential,
+		&tmp.Region,
+		&tmp.Expiration,
+		&tmp.DeviceLimit,
+	}
+	for idx, field := range fields {
+		if _, err := cbor.Decode(field, dests[idx]); err != nil {
+			return err
+		}
+	}
+	*d = tmp
 	return nil
 }
 
@@ -48,6 +68,10 @@ type ReferenceDatum struct {
 	cbor.StructAsArray
 	Prices  []ReferenceDatumPricing
 	Regions [][]byte
+	// Version and Metadata are optional trailing datum fields describing the
+	// provider. They are zero when not present.
+	Version  uint
+	Metadata map[cbor.ByteString]any
 }
 
 func (d *ReferenceDatum) UnmarshalCBOR(data []byte) error {
@@ -58,15 +82,54 @@ func (d *ReferenceDatum) UnmarshalCBOR(data []byte) error {
 	if tmpConstr.Tag() != 0 {
 		return errors.New("invalid constructor")
 	}
-	type tReferenceDatum ReferenceDatum
-	var tmp tReferenceDatum
-	if _, err := cbor.Decode(tmpConstr.Fields(), &tmp); err != nil {
+	// Decode fields individually, since the version and metadata may be
+	// omitted
+	var fields []cbor.RawMessage
+	if _, err := cbor.Decode(tmpConstr.Fields(), &fields); err != nil {
 		return err
 	}
-	*d = ReferenceDatum(tmp)
+	if len(fields) < 2 || len(fields) > 4 {
+		return fmt.Errorf(
+			"invalid reference datum field count: %d",
+			len(fields),
+		)
+	}
+	var tmp ReferenceDatum
+	dests := []any{
+		&tmp.Prices,
+		&tmp.Regions,
+		&tmp.Version,
+		&tmp.Metadata,
+	}
+	for idx, field := range fields {
+		if _, err := cbor.Decode(field, dests[idx]); err != nil {
+			return err
+		}
+	}
+	*d = tmp
 	return nil
 }
 
+// MetadataStrings returns the datum metadata with keys and values as
+// strings. Entries whose value isn't bytes or an integer are skipped.
+func (d *ReferenceDatum) MetadataStrings() map[string]string {
+	if len(d.Metadata) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(d.Metadata))
+	for key, value := range d.Metadata {
+		switch v := value.(type) {
+		case []byte:
+			ret[string(key.Bytes())] = string(v)
+		case uint64:
+			ret[string(key.Bytes())] = strconv.FormatUint(v, 10)
+		case int64:
+			ret[string(key.Bytes())] = strconv.FormatInt(v, 10)
+		}
+	}
+	return ret
+}
+
 type ReferenceDatumPricing struct {
 	cbor.StructAsArray
 	Duration int
@@ -0,0 +1,196 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+
+	"github.com/blinklabs-io/gouroboros/cbor"
+)
+
+func TestClientDatumDeviceLimit(t *testing.T) {
+	tests := []struct {
+		name            string
+		fields          cbor.IndefLengthList
+		wantDeviceLimit uint
+		shouldError     bool
+	}{
+		{
+			name: "without device limit",
+			fields: cbor.IndefLengthList{
+				[]byte("credential"),
+				[]byte("us-east-1"),
+				uint(1700000000000),
+			},
+			wantDeviceLimit: 0,
+		},
+		{
+			name: "with device limit",
+			fields: cbor.IndefLengthList{
+				[]byte("credential"),
+				[]byte("us-east-1"),
+				uint(1700000000000),
+				uint(10),
+			},
+			wantDeviceLimit: 10,
+		},
+		{
+			name: "missing expiration",
+			fields: cbor.IndefLengthList{
+				[]byte("credential"),
+				[]byte("us-east-1"),
+			},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := cbor.Encode(cbor.NewConstructorEncoder(1, tt.fields))
+			if err != nil {
+				t.Fatalf("failed to encode datum: %v", err)
+			}
+			var datum ClientDatum
+			_, err = cbor.Decode(data, &datum)
+			if tt.shouldError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(datum.Credential, []byte("credential")) {
+				t.Errorf("credential = %q, want %q", datum.Credential, "credential")
+			}
+			if string(datum.Region) != "us-east-1" {
+				t.Errorf("region = %q, want %q", datum.Region, "us-east-1")
+			}
+			if datum.Expiration != 1700000000000 {
+				t.Errorf("expiration = %d, want 1700000000000", datum.Expiration)
+			}
+			if datum.DeviceLimit != tt.wantDeviceLimit {
+				t.Errorf(
+					"device limit = %d, want %d",
+					datum.DeviceLimit,
+					tt.wantDeviceLimit,
+				)
+			}
+		})
+	}
+}
+
+func TestReferenceDatumOptionalFields(t *testing.T) {
+	prices := []any{
+		cbor.NewConstructorEncoder(
+			0,
+			cbor.IndefLengthList{2_592_000_000, 5_000_000},
+		),
+	}
+	regions := []any{[]byte("us-east-1")}
+	metadata := map[cbor.ByteString]any{
+		cbor.NewByteString([]byte("provider")):   []byte("Blink Labs"),
+		cbor.NewByteString([]byte("maxDevices")): 5,
+		cbor.NewByteString([]byte("nested")):     []any{1, 2},
+	}
+	tests := []struct {
+		name         string
+		fields       cbor.IndefLengthList
+		wantVersion  uint
+		wantMetadata map[string]string
+		shouldError  bool
+	}{
+		{
+			name:   "without extra fields",
+			fields: cbor.IndefLengthList{prices, regions},
+		},
+		{
+			name:        "with version",
+			fields:      cbor.IndefLengthList{prices, regions, uint(2)},
+			wantVersion: 2,
+		},
+		{
+			name: "with version and metadata",
+			fields: cbor.IndefLengthList{
+				prices,
+				regions,
+				uint(2),
+				metadata,
+			},
+			wantVersion: 2,
+			wantMetadata: map[string]string{
+				"provider":   "Blink Labs",
+				"maxDevices": "5",
+			},
+		},
+		{
+			name:        "missing regions",
+			fields:      cbor.IndefLengthList{prices},
+			shouldError: true,
+		},
+		{
+			name: "too many fields",
+			fields: cbor.IndefLengthList{
+				prices,
+				regions,
+				uint(2),
+				metadata,
+				uint(0),
+			},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := cbor.Encode(cbor.NewConstructorEncoder(0, tt.fields))
+			if err != nil {
+				t.Fatalf("failed to encode datum: %v", err)
+			}
+			var datum ReferenceDatum
+			_, err = cbor.Decode(data, &datum)
+			if tt.shouldError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(datum.Prices) != 1 ||
+				datum.Prices[0].Duration != 2_592_000_000 ||
+				datum.Prices[0].Price != 5_000_000 {
+				t.Errorf("prices = %+v", datum.Prices)
+			}
+			if len(datum.Regions) != 1 ||
+				string(datum.Regions[0]) != "us-east-1" {
+				t.Errorf("regions = %q, want [us-east-1]", datum.Regions)
+			}
+			if datum.Version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", datum.Version, tt.wantVersion)
+			}
+			if got := datum.MetadataStrings(); !maps.Equal(
+				got,
+				tt.wantMetadata,
+			) {
+				t.Errorf("metadata = %v, want %v", got, tt.wantMetadata)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ package indexer
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -141,11 +142,9 @@ func (i *Indexer) Start(
 	}
 	if len(cursorPoints) > 0 {
 		slog.Info(
-			fmt.Sprintf(
-				"found previous chainsync cursor(s), latest is: %d, %x",
-				cursorPoints[0].Slot,
-				cursorPoints[0].Hash,
-			),
+			"found previous chainsync cursor(s)",
+			"slot", cursorPoints[0].Slot,
+			"hash", hex.EncodeToString(cursorPoints[0].Hash),
 		)
 		inputOpts = append(
 			inputOpts,
@@ -155,7 +154,9 @@ func (i *Indexer) Start(
 		)
 	} else if cfg.Indexer.IntersectHash != "" && cfg.Indexer.IntersectSlot > 0 {
 		slog.Info(
-			fmt.Sprintf("starting new chainsync at configured location: %d, %s", cfg.Indexer.IntersectSlot, cfg.Indexer.IntersectHash),
+			"starting new chainsync at configured location",
+			"slot", cfg.Indexer.IntersectSlot,
+			"hash", cfg.Indexer.IntersectHash,
 		)
 		hashBytes, err := hex.DecodeString(cfg.Indexer.IntersectHash)
 		if err != nil {
@@ -195,18 +196,14 @@ func (i *Indexer) Start(
 	i.pipeline.AddOutput(output)
 	// Start pipeline
 	if err := i.pipeline.Start(); err != nil {
-		slog.Error(
-			fmt.Sprintf("failed to start pipeline: %s\n", err),
-		)
+		slog.Error("failed to start pipeline", "error", err)
 		os.Exit(1)
 	}
 	// Start error handler
 	go func() {
 		err, ok := <-i.pipeline.ErrorChan()
 		if ok {
-			slog.Error(
-				fmt.Sprintf("pipeline failed: %s\n", err),
-			)
+			slog.Error("pipeline failed", "error", err)
 			os.Exit(1)
 		}
 	}()
@@ -279,20 +276,16 @@ func (i *Indexer) handleEventClient(txOutput lcommon.Utxo) error {
 	datum := txOutput.Output.Datum()
 	if datum == nil {
 		i.logger.Warn(
-			fmt.Sprintf(
-				"ignoring missing datum in %s",
-				txOutput.Id.String(),
-			),
+			"ignoring missing datum",
+			"tx_output", txOutput.Id.String(),
 		)
 		return nil
 	}
 	var clientDatum ClientDatum
 	if _, err := cbor.Decode(datum.Cbor(), &clientDatum); err != nil {
 		i.logger.Warn(
-			fmt.Sprintf(
-				"ignoring unknown client datum format in %s",
-				txOutput.Id.String(),
-			),
+			"ignoring unknown client datum format",
+			"tx_output", txOutput.Id.String(),
 		)
 		return nil
 	}
@@ -305,27 +298,27 @@ func (i *Indexer) handleEventClient(txOutput lcommon.Utxo) error {
 	}
 	if len(assetName) == 0 {
 		i.logger.Warn(
-			fmt.Sprintf(
-				"ignoring datum without expected asset in %s",
-				txOutput.Id.String(),
-			),
+			"ignoring datum without expected asset",
+			"tx_output", txOutput.Id.String(),
 		)
 		return nil
 	}
 	// Record client datum in database
+	region := config.NormalizeRegion(string(clientDatum.Region))
 	err := i.db.AddClient(
 		assetName,
 		time.Unix(int64(clientDatum.Expiration/1000), 0),
 		clientDatum.Credential,
-		string(clientDatum.Region),
+		region,
 		txOutput.Id.Id().Bytes(),
 		uint(txOutput.Id.Index()),
+		int(clientDatum.DeviceLimit), // nolint:gosec
 	)
 	if err != nil {
 		return err
 	}
 	// Check region match
-	if i.cfg.Vpn.Region != string(clientDatum.Region) {
+	if config.NormalizeRegion(i.cfg.Vpn.Region) != region {
 		// Region doesn't match, return without error
 		return nil
 	}
@@ -359,21 +352,34 @@ func (i *Indexer) handleWireGuardClient(
 	return nil
 }
 
-// handleOpenVPNClient generates OpenVPN certificates and profiles immediately.
-// This is the existing/legacy flow for OpenVPN clients.
+// handleOpenVPNClient generates OpenVPN certificates and profiles immediately,
+// unless lazy profile generation is enabled. This is the existing/legacy flow
+// for OpenVPN clients.
 func (i *Indexer) handleOpenVPNClient(
 	assetName []byte,
 	clientDatum ClientDatum,
 	txOutput lcommon.Utxo,
 ) error {
+	if i.cfg.Vpn.LazyProfiles {
+		// The profile is generated by the API on the first request for it.
+		// Trigger CRL update to unblock renewals.
+		i.crl.SetNeedsUpdate()
+		i.logger.Info(
+			"indexed client, deferring profile generation",
+			"client",
+			hex.EncodeToString(assetName),
+			"tx_output",
+			txOutput.Id.String(),
+		)
+		return nil
+	}
 	// Generate client
-	tmpClient := client.New(i.cfg, i.ca, assetName)
-	vpnHost := fmt.Sprintf(
-		"%s.%s",
-		string(clientDatum.Region),
-		i.cfg.Vpn.Domain,
+	tmpClient := client.New(i.cfg, i.ca, i.db, assetName)
+	clientId, err := tmpClient.Generate(
+		i.cfg.Vpn.ProfileHost(string(clientDatum.Region)),
+		i.cfg.Vpn.Port,
+		i.cfg.Vpn.DNS,
 	)
-	clientId, err := tmpClient.Generate(vpnHost, i.cfg.Vpn.Port, i.cfg.Vpn.DNS)
 	if err != nil {
 		return err
 	}
@@ -394,20 +400,16 @@ func (i *Indexer) handleEventReference(txOutput lcommon.Utxo) error {
 	datum := txOutput.Output.Datum()
 	if datum == nil {
 		i.logger.Warn(
-			fmt.Sprintf(
-				"ignoring missing datum in %s",
-				txOutput.Id.String(),
-			),
+			"ignoring missing datum",
+			"tx_output", txOutput.Id.String(),
 		)
 		return nil
 	}
 	var referenceDatum ReferenceDatum
 	if _, err := cbor.Decode(datum.Cbor(), &referenceDatum); err != nil {
 		i.logger.Warn(
-			fmt.Sprintf(
-				"ignoring unknown reference datum format in %s",
-				txOutput.Id.String(),
-			),
+			"ignoring unknown reference datum format",
+			"tx_output", txOutput.Id.String(),
 		)
 		return nil
 	}
@@ -426,7 +428,43 @@ func (i *Indexer) handleEventReference(txOutput lcommon.Utxo) error {
 	for _, region := range referenceDatum.Regions {
 		tmpRegions = append(tmpRegions, string(region))
 	}
-	if err := i.db.UpdateReferenceData(txOutput.Id, tmpPrices, tmpRegions); err != nil {
+	metadata := referenceDatum.MetadataStrings()
+	// Skip rewriting the reference data when it hasn't changed, such as when
+	// replaying blocks after a restart
+	stored, err := i.db.ReferenceData()
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		return fmt.Errorf("lookup reference in database: %w", err)
+	}
+	if err == nil {
+		incoming := database.Reference{
+			TxId:      txOutput.Id.Id().Bytes(),
+			OutputIdx: int(txOutput.Id.Index()),
+			Prices:    tmpPrices,
+			Version:   referenceDatum.Version,
+			Metadata:  metadata,
+		}
+		for _, region := range tmpRegions {
+			incoming.Regions = append(
+				incoming.Regions,
+				database.ReferenceRegion{Name: region},
+			)
+		}
+		if database.ReferenceEquals(stored, incoming) {
+			i.logger.Debug(
+				"reference data unchanged",
+				"tx_output",
+				txOutput.Id.String(),
+			)
+			return nil
+		}
+	}
+	if err := i.db.UpdateReferenceData(
+		txOutput.Id,
+		tmpPrices,
+		tmpRegions,
+		referenceDatum.Version,
+		metadata,
+	); err != nil {
 		return fmt.Errorf("update reference in database: %w", err)
 	}
 	i.logger.Info(
@@ -443,12 +481,10 @@ func (i *Indexer) scheduleSyncStatusLog() {
 
 func (i *Indexer) syncStatusLog() {
 	slog.Info(
-		fmt.Sprintf(
-			"catch-up sync in progress: at %d.%s (current tip slot is %d)",
-			i.syncStatus.SlotNumber,
-			i.syncStatus.BlockHash,
-			i.syncStatus.TipSlotNumber,
-		),
+		"catch-up sync in progress",
+		"slot", i.syncStatus.SlotNumber,
+		"hash", i.syncStatus.BlockHash,
+		"tip_slot", i.syncStatus.TipSlotNumber,
 	)
 	i.scheduleSyncStatusLog()
 }
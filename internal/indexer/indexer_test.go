@@ -0,0 +1,446 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	input_chainsync "github.com/blinklabs-io/adder/input/chainsync"
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/ledger/babbage"
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+	"github.com/blinklabs-io/gouroboros/ledger/mary"
+	"github.com/blinklabs-io/gouroboros/ledger/shelley"
+	"github.com/blinklabs-io/vpn-indexer/internal/ca"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/crl"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+func TestSyncStatusLogStructured(t *testing.T) {
+	var buf bytes.Buffer
+	prevLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prevLogger) })
+
+	i := &Indexer{
+		syncStatus: input_chainsync.ChainSyncStatus{
+			SlotNumber:    1234,
+			BlockHash:     "abcd",
+			TipSlotNumber: 5678,
+		},
+	}
+	i.syncStatusLog()
+	i.syncLogTimer.Stop()
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("failed to decode log record %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "catch-up sync in progress" {
+		t.Errorf("msg = %q, want static message", record["msg"])
+	}
+	// JSON numbers decode as float64
+	wantAttrs := map[string]any{
+		"slot":     float64(1234),
+		"hash":     "abcd",
+		"tip_slot": float64(5678),
+	}
+	for key, want := range wantAttrs {
+		if got := record[key]; got != want {
+			t.Errorf("attribute %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+// testClientUtxo returns a UTxO holding a client asset under scriptHash with a
+// client datum for region
+func testClientUtxo(
+	t *testing.T,
+	scriptHash lcommon.Blake2b224,
+	assetName []byte,
+	region string,
+) lcommon.Utxo {
+	t.Helper()
+	datumCbor, err := cbor.Encode(
+		cbor.NewConstructorEncoder(
+			1,
+			cbor.IndefLengthList{
+				[]byte("credential"),
+				[]byte(region),
+				uint(time.Now().Add(time.Hour).UnixMilli()),
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("failed to encode datum: %v", err)
+	}
+	datumOptionCbor, err := cbor.Encode(
+		[]any{1, cbor.WrappedCbor(datumCbor)},
+	)
+	if err != nil {
+		t.Fatalf("failed to encode datum option: %v", err)
+	}
+	var datumOption babbage.BabbageTransactionOutputDatumOption
+	if _, err := cbor.Decode(datumOptionCbor, &datumOption); err != nil {
+		t.Fatalf("failed to decode datum option: %v", err)
+	}
+	assets := lcommon.NewMultiAsset(
+		map[lcommon.Blake2b224]map[cbor.ByteString]lcommon.MultiAssetTypeOutput{
+			scriptHash: {cbor.NewByteString(assetName): big.NewInt(1)},
+		},
+	)
+	return lcommon.Utxo{
+		Id: shelley.ShelleyTransactionInput{
+			TxId:        lcommon.Blake2b256Hash(assetName),
+			OutputIndex: 0,
+		},
+		Output: babbage.BabbageTransactionOutput{
+			OutputAmount: mary.MaryTransactionOutputValue{
+				Amount: 2_000_000,
+				Assets: &assets,
+			},
+			DatumOption: &datumOption,
+		},
+	}
+}
+
+// testReferenceUtxo returns a reference UTxO with a single plan and region
+func testReferenceUtxo(
+	t *testing.T,
+	txId lcommon.Blake2b256,
+	price int,
+) lcommon.Utxo {
+	t.Helper()
+	datumCbor, err := cbor.Encode(
+		cbor.NewConstructorEncoder(
+			0,
+			cbor.IndefLengthList{
+				[]any{
+					cbor.NewConstructorEncoder(
+						0,
+						cbor.IndefLengthList{2_592_000_000, price},
+					),
+				},
+				[]any{[]byte("us-east-1")},
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("failed to encode datum: %v", err)
+	}
+	datumOptionCbor, err := cbor.Encode(
+		[]any{1, cbor.WrappedCbor(datumCbor)},
+	)
+	if err != nil {
+		t.Fatalf("failed to encode datum option: %v", err)
+	}
+	var datumOption babbage.BabbageTransactionOutputDatumOption
+	if _, err := cbor.Decode(datumOptionCbor, &datumOption); err != nil {
+		t.Fatalf("failed to decode datum option: %v", err)
+	}
+	return lcommon.Utxo{
+		Id: shelley.ShelleyTransactionInput{TxId: txId},
+		Output: babbage.BabbageTransactionOutput{
+			OutputAmount: mary.MaryTransactionOutputValue{Amount: 2_000_000},
+			DatumOption:  &datumOption,
+		},
+	}
+}
+
+func TestHandleEventReferenceUnchanged(t *testing.T) {
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	i := &Indexer{
+		cfg:    cfg,
+		db:     db,
+		logger: slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	txId := lcommon.Blake2b256Hash([]byte("reference"))
+	otherTxId := lcommon.Blake2b256Hash([]byte("other reference"))
+
+	steps := []struct {
+		name        string
+		utxo        lcommon.Utxo
+		wantHistory int
+	}{
+		{
+			name:        "first",
+			utxo:        testReferenceUtxo(t, txId, 5_000_000),
+			wantHistory: 1,
+		},
+		{
+			name:        "replayed",
+			utxo:        testReferenceUtxo(t, txId, 5_000_000),
+			wantHistory: 1,
+		},
+		{
+			name:        "moved",
+			utxo:        testReferenceUtxo(t, otherTxId, 5_000_000),
+			wantHistory: 2,
+		},
+		{
+			name:        "price change",
+			utxo:        testReferenceUtxo(t, otherTxId, 6_000_000),
+			wantHistory: 3,
+		},
+	}
+	for _, step := range steps {
+		if err := i.handleEventReference(step.utxo); err != nil {
+			t.Fatalf("%s: handleEventReference: %v", step.name, err)
+		}
+		// Every write records a history entry
+		history, err := db.ReferenceHistory(0)
+		if err != nil {
+			t.Fatalf("%s: ReferenceHistory: %v", step.name, err)
+		}
+		if len(history) != step.wantHistory {
+			t.Errorf(
+				"%s: history has %d entries, want %d",
+				step.name,
+				len(history),
+				step.wantHistory,
+			)
+		}
+	}
+}
+
+func TestHandleEventClientRegionCase(t *testing.T) {
+	scriptHash := lcommon.Blake2b224Hash([]byte("script"))
+
+	tests := []struct {
+		name        string
+		cfgRegion   string
+		datumRegion string
+		wantRegion  string
+		wantIndexed bool
+	}{
+		{
+			name:        "same case",
+			cfgRegion:   "us",
+			datumRegion: "us",
+			wantRegion:  "us",
+			wantIndexed: true,
+		},
+		{
+			name:        "upper case datum",
+			cfgRegion:   "us",
+			datumRegion: "US",
+			wantRegion:  "us",
+			wantIndexed: true,
+		},
+		{
+			name:        "upper case config",
+			cfgRegion:   " US ",
+			datumRegion: "us",
+			wantRegion:  "us",
+			wantIndexed: true,
+		},
+		{
+			name:        "other region",
+			cfgRegion:   "us",
+			datumRegion: "EU",
+			wantRegion:  "eu",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Database: config.DatabaseConfig{Directory: t.TempDir()},
+				Vpn: config.VpnConfig{
+					Region:   tt.cfgRegion,
+					Protocol: "wireguard",
+				},
+			}
+			db, err := database.New(cfg, nil)
+			if err != nil {
+				t.Fatalf("failed to create database: %v", err)
+			}
+			var buf bytes.Buffer
+			i := &Indexer{
+				cfg:        cfg,
+				db:         db,
+				logger:     slog.New(slog.NewJSONHandler(&buf, nil)),
+				scriptHash: scriptHash,
+			}
+
+			assetName := []byte("client")
+			utxo := testClientUtxo(t, scriptHash, assetName, tt.datumRegion)
+			if err := i.handleEventClient(utxo); err != nil {
+				t.Fatalf("handleEventClient: %v", err)
+			}
+
+			client, err := db.ClientByAssetName(assetName)
+			if err != nil {
+				t.Fatalf("client not recorded: %v", err)
+			}
+			if client.Region != tt.wantRegion {
+				t.Errorf("region = %q, want %q", client.Region, tt.wantRegion)
+			}
+			indexed := strings.Contains(
+				buf.String(),
+				"indexed wireguard client",
+			)
+			if indexed != tt.wantIndexed {
+				t.Errorf("indexed = %v, want %v", indexed, tt.wantIndexed)
+			}
+		})
+	}
+}
+
+// newTestCa generates a self-signed CA for issuing client certs
+func newTestCa(t *testing.T, cfg *config.Config) *ca.Ca {
+	t.Helper()
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	certBytes, err := x509.CreateCertificate(
+		rand.Reader,
+		tmpl,
+		tmpl,
+		pubKey,
+		privKey,
+	)
+	if err != nil {
+		t.Fatalf("failed to create CA cert: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatalf("failed to marshal CA key: %v", err)
+	}
+	cfg.Ca.Cert = string(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}),
+	)
+	cfg.Ca.Key = string(
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}),
+	)
+	caObj, err := ca.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	return caObj
+}
+
+func TestHandleEventClientLazyProfile(t *testing.T) {
+	scriptHash := lcommon.Blake2b224Hash([]byte("script"))
+
+	for _, lazy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazy=%t", lazy), func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", "test")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+			t.Setenv("AWS_REGION", "us-east-1")
+			// Fake S3 endpoint recording the profiles uploaded to it
+			var mu sync.Mutex
+			var uploaded []string
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					defer mu.Unlock()
+					switch r.Method {
+					case http.MethodPut:
+						uploaded = append(uploaded, r.URL.Path)
+					case http.MethodHead:
+						w.WriteHeader(http.StatusNotFound)
+					default:
+						w.WriteHeader(http.StatusMethodNotAllowed)
+					}
+				}),
+			)
+			t.Cleanup(server.Close)
+
+			cfg := &config.Config{
+				Database: config.DatabaseConfig{Directory: t.TempDir()},
+				S3: config.S3Config{
+					ClientBucket: "test-bucket",
+					Endpoint:     server.URL,
+				},
+				Vpn: config.VpnConfig{
+					Region:       "us",
+					Domain:       "test.domain",
+					Port:         443,
+					Protocol:     "openvpn",
+					LazyProfiles: lazy,
+				},
+			}
+			db, err := database.New(cfg, nil)
+			if err != nil {
+				t.Fatalf("failed to create database: %v", err)
+			}
+			i := &Indexer{
+				cfg:        cfg,
+				db:         db,
+				ca:         newTestCa(t, cfg),
+				crl:        &crl.Crl{},
+				logger:     slog.New(slog.DiscardHandler),
+				scriptHash: scriptHash,
+			}
+
+			assetName := []byte("client")
+			utxo := testClientUtxo(t, scriptHash, assetName, "us")
+			if err := i.handleEventClient(utxo); err != nil {
+				t.Fatalf("handleEventClient: %v", err)
+			}
+			if _, err := db.ClientByAssetName(assetName); err != nil {
+				t.Fatalf("client not recorded: %v", err)
+			}
+
+			var want []string
+			if !lazy {
+				want = []string{
+					"/test-bucket/" + hex.EncodeToString(assetName) + ".ovpn",
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(uploaded, want) {
+				t.Errorf("uploaded profiles = %v, want %v", uploaded, want)
+			}
+		})
+	}
+}
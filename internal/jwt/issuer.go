@@ -19,6 +19,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"os"
 	"time"
 
@@ -136,13 +137,64 @@ func (i *Issuer) IssueSessionJWT(subject string) (string, time.Time, error) {
 // audience, expiry, and issued-at. Peer tokens (which lack the session
 // audience) are rejected.
 func (i *Issuer) VerifySessionJWT(tokenString string) (string, error) {
+	return i.verifyAudienceJWT(tokenString, sessionAudience)
+}
+
+// ProfileJWTLifetime is the validity period for profile download tokens. It
+// matches the lifetime of the pre-signed S3 URL the download is served from.
+const ProfileJWTLifetime = 5 * time.Minute
+
+// profileAudience identifies profile download tokens. A profile token only
+// grants a single profile download and can never be used as a session token.
+const profileAudience = "profile"
+
+// IssueProfileJWT creates a short-lived token granting a download of the
+// profile for a single client ID. It returns the signed token and its expiry.
+// Claims: sub=<clientID>, aud="profile", iat, exp
+func (i *Issuer) IssueProfileJWT(clientID string) (string, time.Time, error) {
+	if clientID == "" {
+		return "", time.Time{}, errors.New("client ID is required")
+	}
+	now := time.Now()
+	expiresAt := now.Add(ProfileJWTLifetime)
+
+	claims := jwt.MapClaims{
+		"sub": clientID,
+		"aud": profileAudience,
+		"iat": now.Unix(),
+		"exp": expiresAt.Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+
+	signedToken, err := token.SignedString(i.privateKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return signedToken, expiresAt, nil
+}
+
+// VerifyProfileJWT validates a profile download token and returns the client
+// ID it was issued for. Session and peer tokens are rejected.
+func (i *Issuer) VerifyProfileJWT(tokenString string) (string, error) {
+	return i.verifyAudienceJWT(tokenString, profileAudience)
+}
+
+// verifyAudienceJWT validates a token issued for the given audience and
+// returns its subject claim. It enforces the EdDSA signing method, the
+// audience, expiry, and issued-at.
+func (i *Issuer) verifyAudienceJWT(
+	tokenString string,
+	audience string,
+) (string, error) {
 	token, err := jwt.Parse(
 		tokenString,
 		func(_ *jwt.Token) (any, error) {
 			return i.publicKey, nil
 		},
 		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
-		jwt.WithAudience(sessionAudience),
+		jwt.WithAudience(audience),
 		jwt.WithExpirationRequired(),
 		// Allow small amount of clock skew with freshly issued tokens
 		jwt.WithLeeway(2*time.Second),
@@ -162,16 +214,16 @@ func (i *Issuer) VerifySessionJWT(tokenString string) (string, error) {
 		return "", err
 	}
 	if iat == nil {
-		return "", errors.New("session token missing issued-at")
+		return "", fmt.Errorf("%s token missing issued-at", audience)
 	}
 
-	clientID, err := token.Claims.GetSubject()
+	subject, err := token.Claims.GetSubject()
 	if err != nil {
 		return "", err
 	}
-	if clientID == "" {
-		return "", errors.New("session token missing subject")
+	if subject == "" {
+		return "", fmt.Errorf("%s token missing subject", audience)
 	}
 
-	return clientID, nil
+	return subject, nil
 }
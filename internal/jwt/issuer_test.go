@@ -519,3 +519,52 @@ func TestVerifySessionJWTRejectsWrongKey(t *testing.T) {
 		t.Fatal("expected token signed with wrong key to be rejected")
 	}
 }
+
+func TestProfileJWTRoundTrip(t *testing.T) {
+	keyPath, _ := generateTestEd25519Key(t)
+	issuer, err := NewIssuer(keyPath)
+	if err != nil {
+		t.Fatalf("unexpected error creating issuer: %v", err)
+	}
+
+	clientID := "0123456789abcdef"
+	token, expiresAt, err := issuer.IssueProfileJWT(clientID)
+	if err != nil {
+		t.Fatalf("unexpected error issuing profile token: %v", err)
+	}
+	if time.Until(expiresAt) > ProfileJWTLifetime {
+		t.Fatalf("expiry %v exceeds profile token lifetime", expiresAt)
+	}
+
+	got, err := issuer.VerifyProfileJWT(token)
+	if err != nil {
+		t.Fatalf("unexpected error verifying profile token: %v", err)
+	}
+	if got != clientID {
+		t.Fatalf("expected client ID %q, got %q", clientID, got)
+	}
+}
+
+func TestProfileAndSessionJWTNotInterchangeable(t *testing.T) {
+	keyPath, _ := generateTestEd25519Key(t)
+	issuer, err := NewIssuer(keyPath)
+	if err != nil {
+		t.Fatalf("unexpected error creating issuer: %v", err)
+	}
+
+	profileToken, _, err := issuer.IssueProfileJWT("0123456789abcdef")
+	if err != nil {
+		t.Fatalf("unexpected error issuing profile token: %v", err)
+	}
+	if _, err := issuer.VerifySessionJWT(profileToken); err == nil {
+		t.Fatal("expected profile token to be rejected as a session token")
+	}
+
+	sessionToken, _, err := issuer.IssueSessionJWT("0123456789abcdef")
+	if err != nil {
+		t.Fatalf("unexpected error issuing session token: %v", err)
+	}
+	if _, err := issuer.VerifyProfileJWT(sessionToken); err == nil {
+		t.Fatal("expected session token to be rejected as a profile token")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package requestid carries a per-request correlation ID through contexts so
+// that logs from the API, S3 registry, and WireGuard container calls for the
+// same request can be tied together.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// Header is the HTTP header used to pass the request ID between services
+const Header = "X-Request-ID"
+
+// maxLength is the longest client-supplied request ID that will be accepted
+const maxLength = 64
+
+type contextKey struct{}
+
+// New generates a random request ID
+func New() string {
+	buf := make([]byte, 16)
+	// crypto/rand.Read never returns an error
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Valid reports whether a client-supplied request ID is safe to reuse. Only
+// short IDs made of letters, digits, '-', and '_' are accepted so they can't
+// be used to inject content into logs or headers.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z',
+			c >= 'A' && c <= 'Z',
+			c >= '0' && c <= '9',
+			c == '-',
+			c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or "" if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger returns the default logger with the request ID from ctx attached
+func Logger(ctx context.Context) *slog.Logger {
+	id := FromContext(ctx)
+	if id == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("request_id", id)
+}
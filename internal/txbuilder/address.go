@@ -0,0 +1,168 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txbuilder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	serAddress "github.com/Salvionied/apollo/serialization/Address"
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+)
+
+// Reasons an address is rejected, which can be matched with errors.Is on the
+// InputValidationError returned by ParseAddress and ParseOwnerAddress
+var (
+	ErrAddressInvalid       = errors.New("invalid address")
+	ErrAddressWrongNetwork  = errors.New("address is for the wrong network")
+	ErrAddressNoPaymentPart = errors.New("address has no payment credential")
+	ErrAddressScriptPayment = errors.New(
+		"address has a script payment credential",
+	)
+)
+
+// newAddressError returns an InputValidationError for an address rejected
+// for reason
+func newAddressError(reason error, msg string) error {
+	return InputValidationError{
+		msg:    msg,
+		reason: reason,
+	}
+}
+
+// ParseAddress validates and decodes a bech32 Shelley address given as field
+// of a request. Surrounding whitespace is ignored. The address must have a
+// payment credential, which rules out reward addresses, and must be for the
+// network configured for the indexer, since a transaction spending from or
+// paying to another network's address can never be submitted. Pointer and
+// Byron addresses aren't supported.
+func ParseAddress(
+	address string,
+	field string,
+	indexer config.IndexerConfig,
+) (serAddress.Address, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return serAddress.Address{}, newAddressError(
+			ErrAddressInvalid,
+			"empty "+field+" provided",
+		)
+	}
+	// The address is checked with gouroboros first, since apollo doesn't
+	// validate the payload length before slicing it
+	addr, err := lcommon.NewAddress(address)
+	if err != nil {
+		return serAddress.Address{}, newAddressError(
+			ErrAddressInvalid,
+			"failed to decode "+field,
+		)
+	}
+	if id, ok := indexer.AddressNetworkId(); ok &&
+		addr.NetworkId() != uint(id) {
+		return serAddress.Address{}, newAddressError(
+			ErrAddressWrongNetwork,
+			fmt.Sprintf(
+				"%s is a %s address, but the configured network is %s",
+				field,
+				addressNetworkName(addr.NetworkId()),
+				configuredNetworkName(indexer),
+			),
+		)
+	}
+	switch addr.Type() {
+	case lcommon.AddressTypeNoneKey, lcommon.AddressTypeNoneScript:
+		return serAddress.Address{}, newAddressError(
+			ErrAddressNoPaymentPart,
+			field+" has no payment credential",
+		)
+	case lcommon.AddressTypeKeyPointer,
+		lcommon.AddressTypeScriptPointer,
+		lcommon.AddressTypeByron:
+		return serAddress.Address{}, newAddressError(
+			ErrAddressInvalid,
+			field+" must be a base or enterprise address",
+		)
+	}
+	ret, err := serAddress.DecodeAddress(address)
+	if err != nil {
+		return serAddress.Address{}, newAddressError(
+			ErrAddressInvalid,
+			"failed to decode "+field,
+		)
+	}
+	return ret, nil
+}
+
+// addressNetworkName describes the network of an address header
+func addressNetworkName(networkId uint) string {
+	if networkId == lcommon.AddressNetworkMainnet {
+		return "mainnet"
+	}
+	return "testnet"
+}
+
+// configuredNetworkName describes the network the indexer is configured for
+func configuredNetworkName(indexer config.IndexerConfig) string {
+	if indexer.NetworkMagic > 0 {
+		return fmt.Sprintf("network magic %d", indexer.NetworkMagic)
+	}
+	return indexer.Network
+}
+
+// ParseOwnerAddress validates an address given as field of a request with
+// ParseAddress and returns the payment key hash that owns subscriptions
+func ParseOwnerAddress(
+	address string,
+	field string,
+	indexer config.IndexerConfig,
+) ([]byte, error) {
+	addr, err := ParseAddress(address, field, indexer)
+	if err != nil {
+		return nil, err
+	}
+	return ownerPaymentCredential(addr, field)
+}
+
+// ownerPaymentCredential returns the payment key hash of an address used as the
+// owner of a subscription. The contract authorizes owners by requiring their
+// signature, which a script can't provide, and the client datum has no way to
+// mark the credential as a script hash, so script-owned subscriptions are
+// rejected rather than built into a transaction that can never be signed.
+func ownerPaymentCredential(
+	addr serAddress.Address,
+	field string,
+) ([]byte, error) {
+	switch addr.AddressType {
+	case serAddress.KEY_KEY,
+		serAddress.KEY_SCRIPT,
+		serAddress.KEY_NONE:
+		return addr.PaymentPart, nil
+	case serAddress.SCRIPT_KEY,
+		serAddress.SCRIPT_SCRIPT,
+		serAddress.SCRIPT_NONE:
+		return nil, newAddressError(
+			ErrAddressScriptPayment,
+			field+" has a script payment credential, which can't own "+
+				"a subscription",
+		)
+	default:
+		return nil, newAddressError(
+			ErrAddressNoPaymentPart,
+			field+" has no payment credential",
+		)
+	}
+}
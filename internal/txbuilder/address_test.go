@@ -0,0 +1,214 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txbuilder
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	serAddress "github.com/Salvionied/apollo/serialization/Address"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+)
+
+func TestParseAddress(t *testing.T) {
+	// All of the addresses use a payment (and stake) hash of 28 0x64 bytes
+	keyHash := bytes.Repeat([]byte{0x64}, 28)
+	const (
+		baseAddress        = "addr_test1qpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jqz9lwms"
+		enterpriseAddress  = "addr_test1vpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeq5wpcma"
+		scriptBaseAddress  = "addr_test1zpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jqd2x49y"
+		scriptAddress      = "addr_test1wpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeqaxacva"
+		pointerAddress     = "addr_test1gpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeqpqgpssnrpzk"
+		rewardAddress      = "stake_test1upjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeq5xlqvh"
+		mainnetAddress     = "addr1q9jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jqpnzwh0"
+		truncatedAddress   = "addr_test1vpjxgeryv3jxgeryvs4hv3cf"
+		badChecksumAddress = "addr_test1vpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeq5wpcmb"
+	)
+
+	tests := []struct {
+		name    string
+		address string
+		indexer config.IndexerConfig
+		// wantErr is the reason the address is rejected, if it is
+		wantErr error
+		// wantOwnerErr is the reason ParseOwnerAddress rejects an address that
+		// ParseAddress accepts
+		wantOwnerErr error
+		wantMsg      string
+	}{
+		{
+			name:    "base",
+			address: baseAddress,
+			indexer: config.IndexerConfig{Network: "preprod"},
+		},
+		{
+			name:    "enterprise",
+			address: enterpriseAddress,
+			indexer: config.IndexerConfig{Network: "preview"},
+		},
+		{
+			name:    "surrounding whitespace",
+			address: "  " + enterpriseAddress + "\n",
+			indexer: config.IndexerConfig{Network: "preprod"},
+		},
+		{
+			name:    "mainnet",
+			address: mainnetAddress,
+			indexer: config.IndexerConfig{Network: "mainnet"},
+		},
+		{
+			name:    "unknown network isn't checked",
+			address: mainnetAddress,
+			indexer: config.IndexerConfig{},
+		},
+		{
+			name:         "script base",
+			address:      scriptBaseAddress,
+			indexer:      config.IndexerConfig{Network: "preprod"},
+			wantOwnerErr: ErrAddressScriptPayment,
+		},
+		{
+			name:         "script enterprise",
+			address:      scriptAddress,
+			indexer:      config.IndexerConfig{Network: "preprod"},
+			wantOwnerErr: ErrAddressScriptPayment,
+		},
+		{
+			name:    "reward",
+			address: rewardAddress,
+			indexer: config.IndexerConfig{Network: "preprod"},
+			wantErr: ErrAddressNoPaymentPart,
+			wantMsg: "owner address has no payment credential",
+		},
+		{
+			name:    "pointer",
+			address: pointerAddress,
+			indexer: config.IndexerConfig{Network: "preprod"},
+			wantErr: ErrAddressInvalid,
+		},
+		{
+			name:    "wrong network",
+			address: mainnetAddress,
+			indexer: config.IndexerConfig{Network: "preprod"},
+			wantErr: ErrAddressWrongNetwork,
+			wantMsg: "owner address is a mainnet address, but the " +
+				"configured network is preprod",
+		},
+		{
+			name:    "mainnet network magic",
+			address: mainnetAddress,
+			indexer: config.IndexerConfig{NetworkMagic: 764824073},
+		},
+		{
+			name:    "network magic takes precedence",
+			address: baseAddress,
+			indexer: config.IndexerConfig{
+				Network:      "mainnet",
+				NetworkMagic: 1,
+			},
+		},
+		{
+			name:    "custom network magic is a testnet",
+			address: mainnetAddress,
+			indexer: config.IndexerConfig{NetworkMagic: 42},
+			wantErr: ErrAddressWrongNetwork,
+			wantMsg: "owner address is a mainnet address, but the " +
+				"configured network is network magic 42",
+		},
+		{
+			name:    "testnet address on mainnet",
+			address: baseAddress,
+			indexer: config.IndexerConfig{Network: "mainnet"},
+			wantErr: ErrAddressWrongNetwork,
+		},
+		{
+			name:    "empty",
+			address: " ",
+			indexer: config.IndexerConfig{Network: "preprod"},
+			wantErr: ErrAddressInvalid,
+			wantMsg: "empty owner address provided",
+		},
+		{
+			name:    "not bech32",
+			address: "not-an-address",
+			indexer: config.IndexerConfig{Network: "preprod"},
+			wantErr: ErrAddressInvalid,
+			wantMsg: "failed to decode owner address",
+		},
+		{
+			name:    "bad checksum",
+			address: badChecksumAddress,
+			indexer: config.IndexerConfig{Network: "preprod"},
+			wantErr: ErrAddressInvalid,
+		},
+		{
+			name:    "truncated payload",
+			address: truncatedAddress,
+			indexer: config.IndexerConfig{Network: "preprod"},
+			wantErr: ErrAddressInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := ParseAddress(tt.address, "owner address", tt.indexer)
+			credential, ownerErr := ParseOwnerAddress(
+				tt.address,
+				"owner address",
+				tt.indexer,
+			)
+			wantOwnerErr := tt.wantOwnerErr
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				wantOwnerErr = tt.wantErr
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if wantOwnerErr == nil {
+				if ownerErr != nil {
+					t.Fatalf("unexpected owner error: %v", ownerErr)
+				}
+				if addr.AddressType != serAddress.KEY_KEY &&
+					addr.AddressType != serAddress.KEY_NONE {
+					t.Errorf("address type = %d", addr.AddressType)
+				}
+				if !bytes.Equal(credential, keyHash) {
+					t.Errorf("credential = %x, want %x", credential, keyHash)
+				}
+				return
+			}
+			if !errors.Is(ownerErr, wantOwnerErr) {
+				t.Fatalf("owner error = %v, want %v", ownerErr, wantOwnerErr)
+			}
+			var validationErr InputValidationError
+			if !errors.As(ownerErr, &validationErr) {
+				t.Fatalf(
+					"owner error = %v, want an InputValidationError",
+					ownerErr,
+				)
+			}
+			if !strings.Contains(ownerErr.Error(), "owner address") {
+				t.Errorf("owner error = %q, want the field name", ownerErr)
+			}
+			if tt.wantMsg != "" && ownerErr.Error() != tt.wantMsg {
+				t.Errorf("owner error = %q, want %q", ownerErr, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txbuilder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+// ErrBackendUnavailable is returned without contacting Ogmios or Kupo while
+// the circuit breaker is open after repeated backend failures
+var ErrBackendUnavailable = errors.New("chain backends unavailable")
+
+// backendBreaker guards every Ogmios and Kupo call made to build a
+// transaction
+var backendBreaker circuitBreaker
+
+// circuitBreaker fails calls fast after too many consecutive failures, so
+// requests don't each wait for a backend that's down to time out. Once the
+// cooldown has passed, a single call probes the backend: its success closes
+// the breaker and its failure opens it for another cooldown. The zero value
+// is a closed breaker and it's safe for concurrent use.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns ErrBackendUnavailable when the breaker is open. A threshold
+// of zero disables the breaker.
+func (b *circuitBreaker) allow(now time.Time, threshold int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if threshold <= 0 || b.failures < threshold {
+		return nil
+	}
+	if b.probing || now.Before(b.openUntil) {
+		return ErrBackendUnavailable
+	}
+	b.probing = true
+	return nil
+}
+
+// record updates the breaker with the result of an allowed call
+func (b *circuitBreaker) record(
+	err error,
+	now time.Time,
+	threshold int,
+	cooldown time.Duration,
+) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if threshold > 0 && b.failures >= threshold {
+		b.openUntil = now.Add(cooldown)
+	}
+}
+
+// reset closes the breaker
+func (b *circuitBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+// isBackendFailure reports whether an error from an Ogmios or Kupo call means
+// the backend is unhealthy: it couldn't be reached, timed out, or didn't give
+// a valid answer. Errors returned for the request itself, like a failed
+// script evaluation or an unknown UTxO, aren't, so a few bad requests can't
+// open the breaker for everyone.
+func isBackendFailure(err error) bool {
+	var netErr net.Error
+	var closeErr *websocket.CloseError
+	var syntaxErr *json.SyntaxError
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		// Ogmios is behind a proxy returning an error status instead of
+		// upgrading the connection
+		errors.Is(err, websocket.ErrBadHandshake) ||
+		errors.As(err, &netErr) ||
+		errors.As(err, &closeErr) ||
+		// Kupo's client only reports an error status, such as a proxy's
+		// 5xx page, as a response body that isn't JSON
+		errors.As(err, &syntaxErr)
+}
+
+// callBackend makes an Ogmios or Kupo call through the circuit breaker. Only
+// backend failures are recorded as failures. Any other error means the
+// backend answered, so it's recorded as a success.
+func callBackend[T any](call func() (T, error)) (T, error) {
+	cfg := config.GetConfig()
+	if err := backendBreaker.allow(
+		time.Now(),
+		cfg.TxBuilder.BreakerThreshold,
+	); err != nil {
+		var zero T
+		return zero, err
+	}
+	ret, err := call()
+	var backendErr error
+	if isBackendFailure(err) {
+		backendErr = err
+	}
+	backendBreaker.record(
+		backendErr,
+		time.Now(),
+		cfg.TxBuilder.BreakerThreshold,
+		cfg.TxBuilder.BreakerCooldown,
+	)
+	return ret, err
+}
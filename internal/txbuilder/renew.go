@@ -16,7 +16,6 @@ package txbuilder
 
 import (
 	"bytes"
-	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -27,7 +26,6 @@ import (
 	serAddress "github.com/Salvionied/apollo/serialization/Address"
 	"github.com/Salvionied/apollo/serialization/PlutusData"
 	"github.com/Salvionied/apollo/serialization/Redeemer"
-	"github.com/SundaeSwap-finance/ogmigo/v6"
 	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
 	"github.com/blinklabs-io/vpn-indexer/internal/database"
@@ -37,8 +35,13 @@ type RenewDeps struct {
 	DB     *database.Database
 	Ref    *database.Reference
 	Client *database.Client
+	// Chain defaults to the shared Ogmios/Kupo chain context when nil
+	Chain ChainContext
 }
 
+// BuildRenewTransferTx builds a transaction renewing the client with the plan
+// matching planId, or price and duration when no plan ID is provided. Without
+// either the client is only transferred.
 func BuildRenewTransferTx(
 	deps RenewDeps,
 	paymentAddress string,
@@ -46,21 +49,27 @@ func BuildRenewTransferTx(
 	clientId string,
 	price int,
 	duration int,
+	planId string,
 ) ([]byte, error) {
 	// Validate inputs
-	if paymentAddress == "" {
-		return nil, NewInputValidationError("empty payment address provided")
-	}
 	cfg := config.GetConfig()
-	cc, err := apolloBackend()
+	paymentAddr, err := ParseAddress(
+		paymentAddress,
+		"payment address",
+		cfg.Indexer,
+	)
 	if err != nil {
 		return nil, err
 	}
-	// Lookup current client information
 	clientAssetName, err := hex.DecodeString(clientId)
 	if err != nil {
-		return nil, fmt.Errorf("decode client ID: %w", err)
+		return nil, NewInputValidationError("invalid client ID")
+	}
+	cc, err := chainContextOrDefault(deps.Chain)
+	if err != nil {
+		return nil, err
 	}
+	// Lookup current client information
 	var client database.Client
 	switch {
 	case deps.Client != nil:
@@ -68,6 +77,9 @@ func BuildRenewTransferTx(
 	case deps.DB != nil:
 		client, err = deps.DB.ClientByAssetName(clientAssetName)
 		if err != nil {
+			if errors.Is(err, database.ErrRecordNotFound) {
+				return nil, NewInputValidationError("client not found")
+			}
 			return nil, fmt.Errorf("lookup client (db): %w", err)
 		}
 	default:
@@ -75,22 +87,18 @@ func BuildRenewTransferTx(
 			"renew: deps.Client not provided and no fallback (DB) available",
 		)
 	}
-	// Decode payment address
-	paymentAddr, err := serAddress.DecodeAddress(paymentAddress)
-	if err != nil {
-		return nil, NewInputValidationError("failed to decode payment address")
-	}
 	// Determine owner credential
 	// Use existing owner for client by default
 	ownerCredential := client.Credential
 	if ownerAddress != "" && ownerAddress != paymentAddress {
-		ownerAddr, err := serAddress.DecodeAddress(ownerAddress)
+		ownerCredential, err = ParseOwnerAddress(
+			ownerAddress,
+			"owner address",
+			cfg.Indexer,
+		)
 		if err != nil {
-			return nil, NewInputValidationError(
-				"failed to decode owner address",
-			)
+			return nil, err
 		}
-		ownerCredential = ownerAddr.PaymentPart
 	}
 	// Determine if the owner is changing
 	newOwnerCred := []byte{}
@@ -126,6 +134,31 @@ func BuildRenewTransferTx(
 			"renew: deps.Ref not provided and no fallback (DB) available",
 		)
 	}
+	// Use the reference data's spelling of the client's region, since the
+	// stored region is normalized
+	region := client.Region
+	if refRegion, ok := matchRegion(refData, client.Region); ok {
+		region = refRegion
+	}
+	// Determine plan selection
+	// The default of -1 represents transfer without renewal
+	selectionId := -1
+	// Lookup plan by plan ID or price/duration, if provided
+	if planId != "" || (price > 0 && duration > 0) {
+		var plan database.ReferencePrice
+		selectionId, plan, err = determinePlanSelection(
+			refData,
+			planId,
+			price,
+			duration,
+		)
+		if err != nil {
+			return nil, NewInputValidationError(
+				"could not determine plan selection from provided plan ID or price/duration",
+			)
+		}
+		price, duration = plan.Price, plan.Duration
+	}
 	// Parse script ref
 	scriptRef, err := inputRefFromString(cfg.TxBuilder.ScriptRefInput)
 	if err != nil {
@@ -140,8 +173,12 @@ func BuildRenewTransferTx(
 			err,
 		)
 	}
-	// Choose input UTxOs from user's wallet
-	inputUtxos, err := chooseInputUtxos(availableUtxos, price+5_000_000)
+	// Choose input UTxOs and collateral from user's wallet
+	inputUtxos, collateral, err := chooseInputsAndCollateral(
+		availableUtxos,
+		price+int(cfg.TxBuilder.InputBuffer),
+		int(cfg.TxBuilder.ChangeBuffer),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("choose input UTxOs: %w", err)
 	}
@@ -156,18 +193,6 @@ func BuildRenewTransferTx(
 	if err != nil {
 		return nil, fmt.Errorf("lookup client UTxO: %w", err)
 	}
-	// Determine plan selection
-	// The default of -1 represents transfer without renewal
-	selectionId := -1
-	// Lookup plan by price/duration, if provided
-	if price > 0 && duration > 0 {
-		selectionId, err = determinePlanSelection(refData, price, duration)
-		if err != nil {
-			return nil, NewInputValidationError(
-				"could not determine plan selection from provided price/duration",
-			)
-		}
-	}
 	// Get last known slot
 	curSlot, err := cc.LastBlockSlot()
 	if err != nil {
@@ -177,23 +202,10 @@ func BuildRenewTransferTx(
 	var newExpiry time.Time
 	if time.Now().After(client.Expiration) {
 		// Previous client has expired, so we calculate expiration from the last known slot
-		ogmios := OgmiosClient()
-		systemStart, err := ogmiosSystemStart(ogmios)
-		if err != nil {
-			return nil, fmt.Errorf("query system start: %w", err)
-		}
-		eraHistory, err := ogmios.EraSummaries(context.Background())
+		curSlotTime, err := cc.SlotTime(curSlot)
 		if err != nil {
-			return nil, fmt.Errorf("query era summaries: %w", err)
+			return nil, fmt.Errorf("slot time: %w", err)
 		}
-		curSlotTime := systemStart.Add(
-			time.Duration(
-				ogmigo.SlotToElapsedMilliseconds(
-					eraHistory,
-					uint64(curSlot),
-				),
-			) * time.Millisecond,
-		)
 		newExpiry = curSlotTime.
 			Add(time.Duration(duration) * time.Millisecond)
 	} else {
@@ -202,25 +214,31 @@ func BuildRenewTransferTx(
 			Add(time.Duration(duration) * time.Millisecond)
 	}
 	// Configure transaction builder
-	apollob := apollo.New(cc)
+	bcc, err := newBuildChainContext(cc)
+	if err != nil {
+		return nil, err
+	}
+	apollob := apollo.New(bcc)
 	apollob, err = apollob.
 		SetWalletFromBech32(paymentAddress).
 		SetWalletAsChangeAddress()
 	if err != nil {
 		return nil, fmt.Errorf("build transaction: %w", err)
 	}
-	// Build client datum
+	// Build client datum, keeping the client's device limit, which is an
+	// optional trailing field
+	datumFields := cbor.IndefLengthList{
+		ownerCredential,
+		[]byte(region),
+		newExpiry.UnixMilli(),
+	}
+	if client.DeviceLimit > 0 {
+		datumFields = append(datumFields, client.DeviceLimit)
+	}
 	clientDatum := PlutusData.PlutusData{
 		PlutusDataType: PlutusData.PlutusBytes,
 		TagNr:          0,
-		Value: cbor.NewConstructorEncoder(
-			1,
-			cbor.IndefLengthList{
-				ownerCredential,
-				[]byte(client.Region),
-				newExpiry.UnixMilli(),
-			},
-		),
+		Value:          cbor.NewConstructorEncoder(1, datumFields),
 	}
 	// Build spend redeemer
 	redeemer := Redeemer.Redeemer{
@@ -247,6 +265,9 @@ func BuildRenewTransferTx(
 		AddLoadedUTxOs(availableUtxos...).
 		// Explicitly set our chosen inputs
 		AddInput(inputUtxos...).
+		// Reserve our chosen collateral
+		AddCollateral(collateral).
+		SetCollateralAmount(collateralAmount).
 		// Pad out the fee until we figure out why Apollo isn't calculating it correctly
 		SetFeePadding(200_000).
 		// Set transaction not valid before current slot
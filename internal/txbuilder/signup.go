@@ -15,10 +15,10 @@
 package txbuilder
 
 import (
-	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Salvionied/apollo"
@@ -26,7 +26,7 @@ import (
 	serAddress "github.com/Salvionied/apollo/serialization/Address"
 	"github.com/Salvionied/apollo/serialization/PlutusData"
 	"github.com/Salvionied/apollo/serialization/Redeemer"
-	"github.com/SundaeSwap-finance/ogmigo/v6"
+	"github.com/Salvionied/apollo/serialization/Transaction"
 	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
 	"github.com/blinklabs-io/vpn-indexer/internal/database"
@@ -35,6 +35,20 @@ import (
 type SignupDeps struct {
 	DB  *database.Database
 	Ref *database.Reference
+	// Chain defaults to the shared Ogmios/Kupo chain context when nil
+	Chain ChainContext
+}
+
+// SignupEstimate breaks down the lovelace a signup transaction costs the user
+type SignupEstimate struct {
+	// ServicePrice is paid to the provider for the selected plan
+	ServicePrice int64
+	// Fee is the transaction fee
+	Fee int64
+	// MinAda is locked in the contract output alongside the client asset
+	MinAda int64
+	// Total is the sum of the above
+	Total int64
 }
 
 func BuildSignupTx(
@@ -43,50 +57,142 @@ func BuildSignupTx(
 	ownerAddress string,
 	price int,
 	duration int,
+	planId string,
 	region string,
 ) ([]byte, []byte, error) {
-	// Validate inputs
-	if region == "" {
-		return nil, nil, NewInputValidationError("empty region provided")
+	built, err := buildSignupTx(
+		deps,
+		paymentAddress,
+		ownerAddress,
+		price,
+		duration,
+		planId,
+		region,
+	)
+	if err != nil {
+		return nil, nil, err
 	}
-	if paymentAddress == "" {
-		return nil, nil, NewInputValidationError(
-			"empty payment address provided",
-		)
+	cborData, err := cbor.Encode(built.tx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate transaction CBOR: %w", err)
+	}
+	return cborData, built.clientId, nil
+}
+
+// EstimateSignupTx builds a signup transaction the same way as BuildSignupTx
+// and returns the cost breakdown instead of the transaction
+func EstimateSignupTx(
+	deps SignupDeps,
+	paymentAddress string,
+	ownerAddress string,
+	price int,
+	duration int,
+	planId string,
+	region string,
+) (SignupEstimate, error) {
+	built, err := buildSignupTx(
+		deps,
+		paymentAddress,
+		ownerAddress,
+		price,
+		duration,
+		planId,
+		region,
+	)
+	if err != nil {
+		return SignupEstimate{}, err
 	}
 	cfg := config.GetConfig()
-	cc, err := apolloBackend()
+	scriptAddress, err := serAddress.DecodeAddress(cfg.Indexer.ScriptAddress)
 	if err != nil {
-		return nil, nil, err
+		return SignupEstimate{}, fmt.Errorf("script address: %w", err)
 	}
+	return signupEstimateFromTx(built.tx, built.price, scriptAddress), nil
+}
+
+// signupEstimateFromTx computes the cost breakdown of a built signup
+// transaction. The min-ADA is the lovelace in the output to the script.
+func signupEstimateFromTx(
+	tx *Transaction.Transaction,
+	price int,
+	scriptAddress serAddress.Address,
+) SignupEstimate {
+	ret := SignupEstimate{
+		ServicePrice: int64(price),
+		Fee:          tx.TransactionBody.Fee,
+	}
+	for _, output := range tx.TransactionBody.Outputs {
+		outputAddr := output.GetAddress()
+		if outputAddr.Equal(&scriptAddress) {
+			ret.MinAda += output.GetAmount().GetCoin()
+		}
+	}
+	ret.Total = ret.ServicePrice + ret.Fee + ret.MinAda
+	return ret
+}
+
+// signupTx is a built signup transaction
+type signupTx struct {
+	tx       *Transaction.Transaction
+	clientId []byte
+	// price is the lovelace paid to the provider for the selected plan
+	price int
+}
+
+// buildSignupTx builds a signup transaction for the plan matching planId, or
+// price and duration when no plan ID is provided
+func buildSignupTx(
+	deps SignupDeps,
+	paymentAddress string,
+	ownerAddress string,
+	price int,
+	duration int,
+	planId string,
+	region string,
+) (signupTx, error) {
+	// Validate inputs
+	if strings.TrimSpace(region) == "" {
+		return signupTx{}, NewInputValidationError("empty region provided")
+	}
+	cfg := config.GetConfig()
 	// Decode payment address
-	paymentAddr, err := serAddress.DecodeAddress(paymentAddress)
+	paymentAddr, err := ParseAddress(
+		paymentAddress,
+		"payment address",
+		cfg.Indexer,
+	)
 	if err != nil {
-		return nil, nil, NewInputValidationError(
-			"failed to decode payment address",
-		)
+		return signupTx{}, err
 	}
 	// Determine owner credential
-	ownerCredential := paymentAddr.PaymentPart
+	ownerCredential, err := ownerPaymentCredential(
+		paymentAddr,
+		"payment address",
+	)
 	if ownerAddress != "" && ownerAddress != paymentAddress {
-		ownerAddr, err := serAddress.DecodeAddress(ownerAddress)
-		if err != nil {
-			return nil, nil, NewInputValidationError(
-				"failed to decode owner address",
-			)
-		}
-		ownerCredential = ownerAddr.PaymentPart
+		ownerCredential, err = ParseOwnerAddress(
+			ownerAddress,
+			"owner address",
+			cfg.Indexer,
+		)
+	}
+	if err != nil {
+		return signupTx{}, err
+	}
+	cc, err := chainContextOrDefault(deps.Chain)
+	if err != nil {
+		return signupTx{}, err
 	}
 	scriptAddress, err := serAddress.DecodeAddress(cfg.Indexer.ScriptAddress)
 	if err != nil {
-		return nil, nil, fmt.Errorf("script address: %w", err)
+		return signupTx{}, fmt.Errorf("script address: %w", err)
 	}
 	scriptHash := scriptAddress.PaymentPart
 	providerAddress, err := serAddress.DecodeAddress(
 		cfg.TxBuilder.ProviderAddress,
 	)
 	if err != nil {
-		return nil, nil, fmt.Errorf("provider address: %w", err)
+		return signupTx{}, fmt.Errorf("provider address: %w", err)
 	}
 	var refData database.Reference
 	switch {
@@ -95,87 +201,83 @@ func BuildSignupTx(
 	case deps.DB != nil:
 		refData, err = deps.DB.ReferenceData()
 		if err != nil {
-			return nil, nil, fmt.Errorf("reference data: %w", err)
+			return signupTx{}, fmt.Errorf("reference data: %w", err)
 		}
 	default:
-		return nil, nil, errors.New(
+		return signupTx{}, errors.New(
 			"reference data not provided (missing deps.Ref and deps.DB)",
 		)
 	}
 	// Validate region
-	foundRegion := false
-	for _, refDataRegion := range refData.Regions {
-		if region == refDataRegion.Name {
-			foundRegion = true
-		}
-	}
+	region, foundRegion := matchRegion(refData, region)
 	if !foundRegion {
-		return nil, nil, NewInputValidationError("provided region not valid")
+		return signupTx{}, NewInputValidationError("provided region not valid")
+	}
+	// Determine plan selection ID from plan ID or price/duration
+	selectionId, plan, err := determinePlanSelection(
+		refData,
+		planId,
+		price,
+		duration,
+	)
+	if err != nil {
+		return signupTx{}, NewInputValidationError(
+			"could not determine plan selection from provided plan ID or price/duration",
+		)
 	}
+	price, duration = plan.Price, plan.Duration
 	// Parse script ref
 	scriptRef, err := inputRefFromString(cfg.TxBuilder.ScriptRefInput)
 	if err != nil {
-		return nil, nil, err
+		return signupTx{}, err
 	}
 	// Get available UTxOs from user's wallet
 	availableUtxos, err := cc.Utxos(paymentAddr)
 	if err != nil {
-		return nil, nil, fmt.Errorf(
+		return signupTx{}, fmt.Errorf(
 			"lookup UTxOs for address: %s: %w",
 			paymentAddr.String(),
 			err,
 		)
 	}
-	// Choose input UTxOs from user's wallet
-	inputUtxos, err := chooseInputUtxos(availableUtxos, price+5_000_000)
+	// Choose input UTxOs and collateral from user's wallet
+	inputUtxos, collateral, err := chooseInputsAndCollateral(
+		availableUtxos,
+		price+int(cfg.TxBuilder.InputBuffer),
+		int(cfg.TxBuilder.ChangeBuffer),
+	)
 	if err != nil {
-		return nil, nil, fmt.Errorf("choose input UTxOs: %w", err)
+		return signupTx{}, fmt.Errorf("choose input UTxOs: %w", err)
 	}
 	if len(inputUtxos) == 0 {
-		return nil, nil, NewInputValidationError("no input UTxOs found")
+		return signupTx{}, NewInputValidationError("no input UTxOs found")
 	}
 	// Determine client ID from first selected input UTxO
 	clientId, err := clientIdFromInput(inputUtxos[0].Input)
 	if err != nil {
-		return nil, nil, fmt.Errorf("client ID from input: %w", err)
-	}
-	// Determine plan selection ID from price/duration
-	selectionId, err := determinePlanSelection(refData, price, duration)
-	if err != nil {
-		return nil, nil, NewInputValidationError(
-			"could not determine plan selection from provided price/duration",
-		)
+		return signupTx{}, fmt.Errorf("client ID from input: %w", err)
 	}
 	// Get last known slot
 	curSlot, err := cc.LastBlockSlot()
 	if err != nil {
-		return nil, nil, fmt.Errorf("query latest block slot: %w", err)
+		return signupTx{}, fmt.Errorf("query latest block slot: %w", err)
 	}
 	// Calculate time for last known slot
-	ogmios := OgmiosClient()
-	systemStart, err := ogmiosSystemStart(ogmios)
+	curSlotTime, err := cc.SlotTime(curSlot)
 	if err != nil {
-		return nil, nil, fmt.Errorf("query system start: %w", err)
+		return signupTx{}, fmt.Errorf("slot time: %w", err)
 	}
-	eraHistory, err := ogmios.EraSummaries(context.Background())
+	// Configure transaction builder
+	bcc, err := newBuildChainContext(cc)
 	if err != nil {
-		return nil, nil, fmt.Errorf("query era summaries: %w", err)
+		return signupTx{}, err
 	}
-	curSlotTime := systemStart.Add(
-		time.Duration(
-			ogmigo.SlotToElapsedMilliseconds(
-				eraHistory,
-				uint64(curSlot),
-			),
-		) * time.Millisecond,
-	)
-	// Configure transaction builder
-	apollob := apollo.New(cc)
+	apollob := apollo.New(bcc)
 	apollob, err = apollob.
 		SetWalletFromBech32(paymentAddress).
 		SetWalletAsChangeAddress()
 	if err != nil {
-		return nil, nil, fmt.Errorf("build transaction: %w", err)
+		return signupTx{}, fmt.Errorf("build transaction: %w", err)
 	}
 	// Build client datum
 	clientDatum := PlutusData.PlutusData{
@@ -225,6 +327,9 @@ func BuildSignupTx(
 		AddLoadedUTxOs(availableUtxos...).
 		// Explicitly set our chosen inputs
 		AddInput(inputUtxos...).
+		// Reserve our chosen collateral
+		AddCollateral(collateral).
+		SetCollateralAmount(collateralAmount).
 		// Pad out the fee until we figure out why Apollo isn't calculating it correctly
 		SetFeePadding(100_000).
 		// Set transaction not valid before current slot
@@ -271,12 +376,11 @@ func BuildSignupTx(
 		).
 		Complete()
 	if err != nil {
-		return nil, nil, fmt.Errorf("build transaction: %w", err)
-	}
-	tx := apollob.GetTx()
-	cborData, err := cbor.Encode(tx)
-	if err != nil {
-		return nil, nil, fmt.Errorf("generate transaction CBOR: %w", err)
+		return signupTx{}, fmt.Errorf("build transaction: %w", err)
 	}
-	return cborData, clientId, nil
+	return signupTx{
+		tx:       apollob.GetTx(),
+		clientId: clientId,
+		price:    price,
+	}, nil
 }
@@ -16,14 +16,121 @@ package txbuilder
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/blinklabs-io/gouroboros/ledger"
 	"github.com/blinklabs-io/vpn-indexer/internal/config"
 )
 
+// TxRejectedError is returned by SubmitTx when the submit API rejects the
+// transaction, as opposed to failing to process it
+type TxRejectedError struct {
+	StatusCode int
+	Reason     string
+}
+
+func (e TxRejectedError) Error() string {
+	return fmt.Sprintf(
+		"transaction rejected with status %d: %s",
+		e.StatusCode,
+		e.Reason,
+	)
+}
+
+// DecodeTx decodes a signed transaction of any era. An InputValidationError
+// describes why the data isn't a well-formed transaction.
+func DecodeTx(txRawBytes []byte) (ledger.Transaction, error) {
+	txType, err := ledger.DetermineTransactionType(txRawBytes)
+	if err != nil {
+		return nil, NewInputValidationError(
+			"not a transaction: " + err.Error(),
+		)
+	}
+	tx, err := ledger.NewTransactionFromCbor(txType, txRawBytes)
+	if err != nil {
+		return nil, NewInputValidationError(
+			"malformed transaction: " + err.Error(),
+		)
+	}
+	if len(tx.Cbor()) != len(txRawBytes) {
+		return nil, NewInputValidationError(
+			"malformed transaction: trailing data",
+		)
+	}
+	return tx, nil
+}
+
+// TxHash returns the hash of a signed transaction, which is the hash of its
+// body as encoded in the transaction
+func TxHash(txRawBytes []byte) (string, error) {
+	tx, err := DecodeTx(txRawBytes)
+	if err != nil {
+		return "", err
+	}
+	return tx.Hash().String(), nil
+}
+
+// minUtxoOverheadBytes is added to an output's size when computing its minimum
+// ADA, for the UTxO entry's overhead
+const minUtxoOverheadBytes = 160
+
+// CheckTxSanity rejects a transaction whose fee is below the protocol minimum
+// for its size, or with an output holding less than the minimum ADA, with an
+// InputValidationError. It catches client build errors before submission. The
+// fees of scripts aren't included, so the minimum fee is a lower bound.
+func CheckTxSanity(tx ledger.Transaction) error {
+	cc, err := apolloBackend()
+	if err != nil {
+		return err
+	}
+	pparams, err := cc.GetProtocolParams()
+	if err != nil {
+		return fmt.Errorf("get protocol params: %w", err)
+	}
+
+	minFee := big.NewInt(
+		int64(len(tx.Cbor()))*pparams.MinFeeCoefficient +
+			pparams.MinFeeConstant,
+	)
+	if fee := tx.Fee(); fee == nil || fee.Cmp(minFee) < 0 {
+		return NewInputValidationError(
+			fmt.Sprintf("fee %s is below the minimum fee %s", fee, minFee),
+		)
+	}
+
+	coinsPerUtxoByte, err := strconv.ParseInt(pparams.CoinsPerUtxoByte, 10, 64)
+	if err != nil {
+		coinsPerUtxoByte = int64(pparams.GetCoinsPerUtxoByte())
+	}
+	for i, output := range tx.Outputs() {
+		minAda := big.NewInt(
+			(int64(len(output.Cbor())) + minUtxoOverheadBytes) *
+				coinsPerUtxoByte,
+		)
+		if amount := output.Amount(); amount == nil || amount.Cmp(minAda) < 0 {
+			return NewInputValidationError(
+				fmt.Sprintf(
+					"output %d holds %s lovelace, below the minimum %s",
+					i,
+					amount,
+					minAda,
+				),
+			)
+		}
+	}
+	return nil
+}
+
+// SubmitTx submits a signed transaction to the submit API and returns its
+// hash. A TxRejectedError is returned when the transaction is rejected, and
+// any other error means the submit API couldn't be reached or failed.
 func SubmitTx(txRawBytes []byte) (string, error) {
 	cfg := config.GetConfig()
 	client := createHTTPClient()
@@ -47,7 +154,18 @@ func SubmitTx(txRawBytes []byte) (string, error) {
 		return "", err
 	}
 	if resp.StatusCode == http.StatusAccepted {
-		return string(respBody), nil
+		// The hash is returned as a JSON string
+		var txHash string
+		if err := json.Unmarshal(respBody, &txHash); err != nil {
+			txHash = strings.TrimSpace(string(respBody))
+		}
+		return txHash, nil
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return "", TxRejectedError{
+			StatusCode: resp.StatusCode,
+			Reason:     string(respBody),
+		}
 	}
 	return "", fmt.Errorf(
 		"submit failed with status %d: %s",
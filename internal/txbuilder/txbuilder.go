@@ -19,12 +19,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
+	serAddress "github.com/Salvionied/apollo/serialization/Address"
 	"github.com/Salvionied/apollo/serialization/Amount"
+	"github.com/Salvionied/apollo/serialization/PlutusData"
+	"github.com/Salvionied/apollo/serialization/Redeemer"
 	"github.com/Salvionied/apollo/serialization/TransactionInput"
 	"github.com/Salvionied/apollo/serialization/UTxO"
 	"github.com/Salvionied/apollo/serialization/Value"
+	"github.com/Salvionied/apollo/txBuilding/Backend/Base"
 	"github.com/Salvionied/apollo/txBuilding/Backend/OgmiosChainContext"
 	"github.com/SundaeSwap-finance/kugo"
 	"github.com/SundaeSwap-finance/ogmigo/v6"
@@ -36,21 +43,291 @@ import (
 )
 
 const (
-	defaultKupoTimeout = 1 * time.Second
+	// protocolParamsTTL is how long the chain context and protocol
+	// parameters are reused before being fetched again
+	protocolParamsTTL = 5 * time.Minute
+
+	// collateralAmount is the lovelace put up as collateral for the Plutus
+	// scripts, matching Apollo's default
+	collateralAmount = 5_000_000
+	// minCollateralUtxo is the smallest UTxO reserved as collateral. Apollo
+	// needs the extra ADA to build the collateral return output.
+	minCollateralUtxo = collateralAmount + 1_000_000
 )
 
 var systemStart *time.Time
 
-func apolloBackend() (*OgmiosChainContext.OgmiosChainContext, error) {
-	cfg := config.GetConfig()
-	ogmiosClient := OgmiosClient()
+// ErrKupoUnavailable is returned when a wallet's UTxOs can't be looked up
+// because Kupo is unreachable or didn't return a usable response
+var ErrKupoUnavailable = errors.New("kupo unavailable")
+
+var (
+	chainCacheMutex       sync.Mutex
+	cachedChainContext    *chainContext
+	chainContextExpires   time.Time
+	cachedProtocolParams  *Base.ProtocolParameters
+	protocolParamsExpires time.Time
+	// fetchProtocolParams queries the current protocol parameters. It's
+	// replaced in tests.
+	fetchProtocolParams = func(
+		occ *OgmiosChainContext.OgmiosChainContext,
+	) (Base.ProtocolParameters, error) {
+		return occ.LatestEpochParams()
+	}
+)
+
+// protocolParamsFetchMutex serializes protocol parameter fetches, so concurrent
+// builds with an expired cache make one query between them without holding
+// chainCacheMutex during it
+var protocolParamsFetchMutex sync.Mutex
+
+// ChainContext provides the chain state a transaction build needs. Apollo
+// uses it to balance the transaction, and the builders use it to look up
+// wallet and client UTxOs (Utxos, GetUtxoFromRef) and the current slot
+// (LastBlockSlot, SlotTime). The default is backed by Ogmios and Kupo.
+type ChainContext interface {
+	Base.ChainContext
+	// SlotTime returns the time at the start of a slot
+	SlotTime(slot int) (time.Time, error)
+}
+
+// chainContextOrDefault returns cc, or the shared Ogmios/Kupo chain context
+// when it's nil
+func chainContextOrDefault(cc ChainContext) (ChainContext, error) {
+	if cc != nil {
+		return cc, nil
+	}
+	return apolloBackend()
+}
+
+// chainContext wraps the Ogmios chain context to serve protocol parameters
+// from a TTL cache shared by all builds. The Ogmios context's own cache is
+// keyed on the epoch end time, which Ogmios doesn't report, so it refetches
+// the parameters on every call.
+type chainContext struct {
+	*OgmiosChainContext.OgmiosChainContext
+}
+
+func apolloBackend() (*chainContext, error) {
+	chainCacheMutex.Lock()
+	defer chainCacheMutex.Unlock()
+	if cachedChainContext != nil && time.Now().Before(chainContextExpires) {
+		return cachedChainContext, nil
+	}
+	cachedChainContext = newChainContext(config.GetConfig())
+	chainContextExpires = time.Now().Add(protocolParamsTTL)
+	return cachedChainContext, nil
+}
+
+// newChainContext creates a chain context that queries Ogmios for the chain
+// state and Kupo for wallet UTxOs
+func newChainContext(cfg *config.Config) *chainContext {
 	kupoClient := kugo.New(
 		kugo.WithEndpoint(cfg.TxBuilder.KupoUrl),
-		kugo.WithTimeout(defaultKupoTimeout),
+		kugo.WithTimeout(cfg.TxBuilder.KupoTimeout),
 		kugo.WithLogger(ogmigo.NopLogger),
 	)
-	occ := OgmiosChainContext.NewOgmiosChainContext(ogmiosClient, kupoClient)
-	return &occ, nil
+	occ := OgmiosChainContext.NewOgmiosChainContext(OgmiosClient(), kupoClient)
+	return &chainContext{OgmiosChainContext: &occ}
+}
+
+// Utxos looks up an address's UTxOs in Kupo. Failures are wrapped in
+// ErrKupoUnavailable, so they can be told apart from invalid input.
+func (c *chainContext) Utxos(
+	address serAddress.Address,
+) ([]UTxO.UTxO, error) {
+	return callBackend(func() ([]UTxO.UTxO, error) {
+		utxos, err := c.OgmiosChainContext.Utxos(address)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrKupoUnavailable, err)
+		}
+		return utxos, nil
+	})
+}
+
+// GetUtxoFromRef queries Ogmios for a UTxO through the circuit breaker
+func (c *chainContext) GetUtxoFromRef(
+	txHash string,
+	txIndex int,
+) (*UTxO.UTxO, error) {
+	return callBackend(func() (*UTxO.UTxO, error) {
+		return c.OgmiosChainContext.GetUtxoFromRef(txHash, txIndex)
+	})
+}
+
+// LastBlockSlot queries Ogmios for the tip slot through the circuit breaker
+func (c *chainContext) LastBlockSlot() (int, error) {
+	return callBackend(c.OgmiosChainContext.LastBlockSlot)
+}
+
+// EvaluateTx has Ogmios evaluate a transaction's scripts through the circuit
+// breaker
+func (c *chainContext) EvaluateTx(
+	txCbor []uint8,
+) (map[string]Redeemer.ExecutionUnits, error) {
+	return callBackend(func() (map[string]Redeemer.ExecutionUnits, error) {
+		return c.OgmiosChainContext.EvaluateTx(txCbor)
+	})
+}
+
+// EvaluateTxWithAdditionalUtxos is like EvaluateTx, with UTxOs Ogmios
+// doesn't know about yet
+func (c *chainContext) EvaluateTxWithAdditionalUtxos(
+	txCbor []uint8,
+	additionalUtxos []UTxO.UTxO,
+) (map[string]Redeemer.ExecutionUnits, error) {
+	return callBackend(func() (map[string]Redeemer.ExecutionUnits, error) {
+		return c.OgmiosChainContext.EvaluateTxWithAdditionalUtxos(
+			txCbor,
+			additionalUtxos,
+		)
+	})
+}
+
+// SlotTime calculates the time of a slot from the Shelley genesis start time
+// and the era summaries
+func (c *chainContext) SlotTime(slot int) (time.Time, error) {
+	ogmios := OgmiosClient()
+	systemStart, err := ogmiosSystemStart(ogmios)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query system start: %w", err)
+	}
+	eraHistory, err := callBackend(func() (*ogmigo.EraHistory, error) {
+		return ogmios.EraSummaries(context.Background())
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query era summaries: %w", err)
+	}
+	return systemStart.Add(
+		time.Duration(
+			ogmigo.SlotToElapsedMilliseconds(
+				eraHistory,
+				uint64(slot),
+			),
+		) * time.Millisecond,
+	), nil
+}
+
+// GetProtocolParams returns the cached protocol parameters, fetching them
+// when missing or expired
+func (c *chainContext) GetProtocolParams() (Base.ProtocolParameters, error) {
+	if pparams, ok := cachedParams(); ok {
+		return pparams, nil
+	}
+	protocolParamsFetchMutex.Lock()
+	defer protocolParamsFetchMutex.Unlock()
+	// Another build may have fetched them while this one waited
+	if pparams, ok := cachedParams(); ok {
+		return pparams, nil
+	}
+	pparams, err := callBackend(func() (Base.ProtocolParameters, error) {
+		return fetchProtocolParams(c.OgmiosChainContext)
+	})
+	if err != nil {
+		return Base.ProtocolParameters{}, err
+	}
+	chainCacheMutex.Lock()
+	defer chainCacheMutex.Unlock()
+	cachedProtocolParams = &pparams
+	protocolParamsExpires = time.Now().Add(protocolParamsTTL)
+	return pparams, nil
+}
+
+// cachedParams returns the cached protocol parameters, with ok false when
+// they're missing or expired
+func cachedParams() (Base.ProtocolParameters, bool) {
+	chainCacheMutex.Lock()
+	defer chainCacheMutex.Unlock()
+	if cachedProtocolParams == nil ||
+		!time.Now().Before(protocolParamsExpires) {
+		return Base.ProtocolParameters{}, false
+	}
+	return *cachedProtocolParams, true
+}
+
+// MaxTxFee mirrors the Ogmios context's implementation using the cached
+// protocol parameters
+func (c *chainContext) MaxTxFee() (int, error) {
+	pparams, err := c.GetProtocolParams()
+	if err != nil {
+		return 0, err
+	}
+	maxTxExSteps, err := strconv.Atoi(pparams.MaxTxExSteps)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"invalid MaxTxExSteps %q: %w",
+			pparams.MaxTxExSteps,
+			err,
+		)
+	}
+	maxTxExMem, err := strconv.Atoi(pparams.MaxTxExMem)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"invalid MaxTxExMem %q: %w",
+			pparams.MaxTxExMem,
+			err,
+		)
+	}
+	return Base.Fee(c, pparams.MaxTxSize, maxTxExSteps, maxTxExMem)
+}
+
+// buildChainContext serves a build's protocol parameters, and the cost models
+// in them, from a single fetch when the build starts. Apollo's cost model
+// methods can't return an error, and falls back to a built-in cost model on
+// nil, so fetching up front fails the build instead of letting it hash its
+// scripts with the wrong cost model.
+type buildChainContext struct {
+	ChainContext
+	pparams Base.ProtocolParameters
+}
+
+// newBuildChainContext fetches the protocol parameters for a build
+func newBuildChainContext(cc ChainContext) (*buildChainContext, error) {
+	pparams, err := cc.GetProtocolParams()
+	if err != nil {
+		return nil, fmt.Errorf("get protocol params: %w", err)
+	}
+	return &buildChainContext{ChainContext: cc, pparams: pparams}, nil
+}
+
+func (b *buildChainContext) GetProtocolParams() (
+	Base.ProtocolParameters,
+	error,
+) {
+	return b.pparams, nil
+}
+
+func (b *buildChainContext) CostModelsV1() PlutusData.CostModel {
+	return costModel(b.pparams, Base.CostModelsPlutusV1, "plutus:v1")
+}
+
+func (b *buildChainContext) CostModelsV2() PlutusData.CostModel {
+	return costModel(b.pparams, Base.CostModelsPlutusV2, "plutus:v2")
+}
+
+func (b *buildChainContext) CostModelsV3() PlutusData.CostModel {
+	return costModel(b.pparams, Base.CostModelsPlutusV3, "plutus:v3")
+}
+
+// costModel returns a Plutus cost model from protocol parameters, or nil if
+// they don't have one for the version
+func costModel(
+	pparams Base.ProtocolParameters,
+	version Base.CostModelsPlutusVersion,
+	rawKey string,
+) PlutusData.CostModel {
+	if cm, ok := pparams.CostModels[version]; ok {
+		return cm
+	}
+	raw, ok := pparams.CostModelsRaw[rawKey]
+	if !ok {
+		return nil
+	}
+	cm := make(PlutusData.CostModel, len(raw))
+	for i, v := range raw {
+		cm[i] = int(v)
+	}
+	return cm
 }
 
 func OgmiosClient() *ogmigo.Client {
@@ -61,9 +338,14 @@ func OgmiosClient() *ogmigo.Client {
 	return ogmiosClient
 }
 
-// It clears the cached Shelley genesis start time
+// It clears the cached Shelley genesis start time, along with the cached
+// chain context and protocol parameters
 func ResetCachedSystemStart() {
 	systemStart = nil
+	chainCacheMutex.Lock()
+	defer chainCacheMutex.Unlock()
+	cachedChainContext = nil
+	cachedProtocolParams = nil
 }
 
 func ogmiosSystemStart(ogmios *ogmigo.Client) (time.Time, error) {
@@ -72,42 +354,100 @@ func ogmiosSystemStart(ogmios *ogmigo.Client) (time.Time, error) {
 		return *systemStart, nil
 	}
 	// Get system start from Shelley genesis config
-	genesisConfigRaw, err := ogmios.GenesisConfig(
-		context.Background(),
-		"shelley",
-	)
+	genesisConfigRaw, err := callBackend(func() (json.RawMessage, error) {
+		return ogmios.GenesisConfig(context.Background(), "shelley")
+	})
 	if err != nil {
-		return *systemStart, err
+		return time.Time{}, err
 	}
 	var tmpGenesisConfig struct {
 		StartTime time.Time `json:"startTime"`
 	}
 	if err := json.Unmarshal(genesisConfigRaw, &tmpGenesisConfig); err != nil {
-		return *systemStart, err
+		return time.Time{}, err
 	}
 	systemStart = &(tmpGenesisConfig.StartTime)
 	return *systemStart, nil
 }
 
 func inputRefFromString(ref string) (lcommon.TransactionInput, error) {
-	var refInput shelley.ShelleyTransactionInput
-	var tmpTxId []byte
-	_, err := fmt.Sscanf(
-		ref,
-		"%x#%d",
-		&tmpTxId,
-		&refInput.OutputIndex,
+	txId, outputIndex, err := config.ParseTxOutputRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parse script ref input %q: %w", ref, err)
+	}
+	return shelley.ShelleyTransactionInput{
+		TxId:        lcommon.Blake2b256(txId),
+		OutputIndex: outputIndex,
+	}, nil
+}
+
+// chooseCollateralUtxo returns the smallest pure-ADA UTxO large enough to
+// serve as collateral, keeping larger ones free for inputs
+func chooseCollateralUtxo(availableUtxos []UTxO.UTxO) (UTxO.UTxO, error) {
+	best := -1
+	for idx, utxo := range availableUtxos {
+		value := utxo.Output.GetValue()
+		if value.HasAssets || value.GetCoin() < minCollateralUtxo {
+			continue
+		}
+		if best == -1 ||
+			value.GetCoin() < availableUtxos[best].Output.GetValue().GetCoin() {
+			best = idx
+		}
+	}
+	if best == -1 {
+		return UTxO.UTxO{}, NewInputValidationError(
+			fmt.Sprintf(
+				"no pure-ADA UTxO of at least %d lovelace available for collateral",
+				minCollateralUtxo,
+			),
+		)
+	}
+	return availableUtxos[best], nil
+}
+
+// chooseInputsAndCollateral reserves a collateral UTxO and selects inputs
+// from the rest of the wallet. When the rest can't cover the amount, the
+// collateral is spent as an input as well, which the ledger allows.
+func chooseInputsAndCollateral(
+	availableUtxos []UTxO.UTxO,
+	neededAmount int,
+	changeBuffer int,
+) ([]UTxO.UTxO, UTxO.UTxO, error) {
+	collateral, err := chooseCollateralUtxo(availableUtxos)
+	if err != nil {
+		return nil, UTxO.UTxO{}, err
+	}
+	remainingUtxos := slices.DeleteFunc(
+		slices.Clone(availableUtxos),
+		func(utxo UTxO.UTxO) bool {
+			return utxo.GetKey() == collateral.GetKey()
+		},
+	)
+	inputUtxos, err := chooseInputUtxos(
+		remainingUtxos,
+		neededAmount,
+		changeBuffer,
 	)
-	refInput.TxId = lcommon.Blake2b256(tmpTxId)
 	if err != nil {
-		return nil, fmt.Errorf("parse script ref input: %w", err)
+		inputUtxos, err = chooseInputUtxos(
+			availableUtxos,
+			neededAmount,
+			changeBuffer,
+		)
+		if err != nil {
+			return nil, UTxO.UTxO{}, err
+		}
 	}
-	return refInput, nil
+	return inputUtxos, collateral, nil
 }
 
+// chooseInputUtxos selects pure-ADA inputs until they cover neededAmount
+// plus changeBuffer, which leaves enough for the change output
 func chooseInputUtxos(
 	availableUtxos []UTxO.UTxO,
 	neededAmount int,
+	changeBuffer int,
 ) ([]UTxO.UTxO, error) {
 	var ret []UTxO.UTxO
 	// The below code is adapted from Apollo's own UTxO selection code
@@ -119,7 +459,11 @@ func chooseInputUtxos(
 	}
 	for !selectedAmount.Greater(
 		requestedAmount.Add(
-			Value.Value{Am: Amount.Amount{}, Coin: 1_000_000, HasAssets: false},
+			Value.Value{
+				Am:        Amount.Amount{},
+				Coin:      int64(changeBuffer),
+				HasAssets: false,
+			},
 		),
 	) {
 		if len(availableUtxos) == 0 {
@@ -138,9 +482,26 @@ func chooseInputUtxos(
 	return ret, nil
 }
 
+// clientIdFromInput derives the client ID from the first input of the signup
+// transaction. It's the Blake2b-256 hash of the CBOR of the input as Plutus
+// data, Constr 0 [txid, index], and it's minted on-chain as the client's
+// asset name, so the derivation must not change.
 func clientIdFromInput(
 	input TransactionInput.TransactionInput,
 ) ([]byte, error) {
+	if len(input.TransactionId) != lcommon.Blake2b256Size {
+		return nil, fmt.Errorf(
+			"input transaction ID must be %d bytes, got %d",
+			lcommon.Blake2b256Size,
+			len(input.TransactionId),
+		)
+	}
+	if input.Index < 0 {
+		return nil, fmt.Errorf(
+			"input index must be non-negative, got %d",
+			input.Index,
+		)
+	}
 	tmpData := cbor.NewConstructorEncoder(
 		0,
 		cbor.IndefLengthList{
@@ -156,26 +517,51 @@ func clientIdFromInput(
 	return hash.Bytes(), nil
 }
 
+// matchRegion returns the reference data's spelling of region, compared
+// after normalization. The reference spelling is what goes on-chain.
+func matchRegion(refData database.Reference, region string) (string, bool) {
+	region = config.NormalizeRegion(region)
+	for _, refDataRegion := range refData.Regions {
+		if config.NormalizeRegion(refDataRegion.Name) == region {
+			return refDataRegion.Name, true
+		}
+	}
+	return "", false
+}
+
+// determinePlanSelection returns the index of the selected plan in the
+// reference data along with the plan itself. The plan is matched by its plan
+// ID when one is provided, otherwise by price and duration.
 func determinePlanSelection(
 	refData database.Reference,
+	planId string,
 	price int,
 	duration int,
-) (int, error) {
+) (int, database.ReferencePrice, error) {
 	for idx, tmpPrice := range refData.Prices {
+		if planId != "" {
+			if tmpPrice.PlanId() != planId {
+				continue
+			}
+			return idx, tmpPrice, nil
+		}
 		if tmpPrice.Price != price {
 			continue
 		}
 		if tmpPrice.Duration != duration {
 			continue
 		}
-		return idx, nil
+		return idx, tmpPrice, nil
 	}
-	return 0, errors.New("selection not found")
+	return 0, database.ReferencePrice{}, errors.New("selection not found")
 }
 
 // InputValidationError is a custom error type representing input validation errors
 type InputValidationError struct {
 	msg string
+	// reason is the sentinel error describing why the input was rejected,
+	// when there is one
+	reason error
 }
 
 func NewInputValidationError(msg string) error {
@@ -187,3 +573,7 @@ func NewInputValidationError(msg string) error {
 func (e InputValidationError) Error() string {
 	return e.msg
 }
+
+func (e InputValidationError) Unwrap() error {
+	return e.reason
+}
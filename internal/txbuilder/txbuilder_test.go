@@ -0,0 +1,1664 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package txbuilder
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Salvionied/apollo/serialization"
+	"github.com/Salvionied/apollo/serialization/Address"
+	"github.com/Salvionied/apollo/serialization/Asset"
+	"github.com/Salvionied/apollo/serialization/AssetName"
+	"github.com/Salvionied/apollo/serialization/MultiAsset"
+	"github.com/Salvionied/apollo/serialization/PlutusData"
+	"github.com/Salvionied/apollo/serialization/Policy"
+	"github.com/Salvionied/apollo/serialization/Redeemer"
+	"github.com/Salvionied/apollo/serialization/Transaction"
+	"github.com/Salvionied/apollo/serialization/TransactionBody"
+	"github.com/Salvionied/apollo/serialization/TransactionInput"
+	"github.com/Salvionied/apollo/serialization/TransactionOutput"
+	"github.com/Salvionied/apollo/serialization/UTxO"
+	"github.com/Salvionied/apollo/serialization/Value"
+	"github.com/Salvionied/apollo/txBuilding/Backend/Base"
+	"github.com/Salvionied/apollo/txBuilding/Backend/OgmiosChainContext"
+	"github.com/SundaeSwap-finance/ogmigo/v6"
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/gorilla/websocket"
+)
+
+// stubProtocolParams replaces the protocol parameter query with one that
+// counts its calls, and clears the caches before and after the test
+func stubProtocolParams(t *testing.T) *int {
+	t.Helper()
+	fetches := 0
+	origFetch := fetchProtocolParams
+	fetchProtocolParams = func(
+		*OgmiosChainContext.OgmiosChainContext,
+	) (Base.ProtocolParameters, error) {
+		fetches++
+		return Base.ProtocolParameters{
+			MinFeeConstant:    155381,
+			MinFeeCoefficient: 44,
+			MaxTxSize:         16384,
+			MaxTxExSteps:      "10000000000",
+			MaxTxExMem:        "14000000",
+			CostModelsRaw: map[string][]int64{
+				"plutus:v3": {1, 2, 3},
+			},
+		}, nil
+	}
+	ResetCachedSystemStart()
+	t.Cleanup(func() {
+		fetchProtocolParams = origFetch
+		ResetCachedSystemStart()
+	})
+	return &fetches
+}
+
+// useChainContext makes the protocol parameter queries a transaction build
+// makes
+func useChainContext(t *testing.T) *chainContext {
+	t.Helper()
+	cc, err := apolloBackend()
+	if err != nil {
+		t.Fatalf("failed to create chain context: %v", err)
+	}
+	if _, err := cc.GetProtocolParams(); err != nil {
+		t.Fatalf("failed to get protocol params: %v", err)
+	}
+	if _, err := cc.MaxTxFee(); err != nil {
+		t.Fatalf("failed to get max tx fee: %v", err)
+	}
+	bcc, err := newBuildChainContext(cc)
+	if err != nil {
+		t.Fatalf("failed to create build chain context: %v", err)
+	}
+	if cm := bcc.CostModelsV3(); len(cm) != 3 {
+		t.Fatalf("cost model = %v, want 3 entries", cm)
+	}
+	return cc
+}
+
+func TestProtocolParamsCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		between     func()
+		wantFetches int
+		wantSameCtx bool
+	}{
+		{
+			name:        "within TTL",
+			between:     func() {},
+			wantFetches: 1,
+			wantSameCtx: true,
+		},
+		{
+			name: "expired",
+			between: func() {
+				protocolParamsExpires = time.Now().Add(-time.Second)
+			},
+			wantFetches: 2,
+			wantSameCtx: true,
+		},
+		{
+			name:        "reset",
+			between:     ResetCachedSystemStart,
+			wantFetches: 2,
+			wantSameCtx: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetches := stubProtocolParams(t)
+			first := useChainContext(t)
+			tt.between()
+			second := useChainContext(t)
+
+			if *fetches != tt.wantFetches {
+				t.Errorf(
+					"protocol params fetched %d times, want %d",
+					*fetches,
+					tt.wantFetches,
+				)
+			}
+			if (first == second) != tt.wantSameCtx {
+				t.Errorf(
+					"chain context reused = %t, want %t",
+					first == second,
+					tt.wantSameCtx,
+				)
+			}
+		})
+	}
+}
+
+func TestProtocolParamsConcurrentFetch(t *testing.T) {
+	fetches := stubProtocolParams(t)
+	stubbedFetch := fetchProtocolParams
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	fetchProtocolParams = func(
+		occ *OgmiosChainContext.OgmiosChainContext,
+	) (Base.ProtocolParameters, error) {
+		if *fetches == 0 {
+			close(started)
+		}
+		<-unblock
+		return stubbedFetch(occ)
+	}
+	cc, err := apolloBackend()
+	if err != nil {
+		t.Fatalf("failed to create chain context: %v", err)
+	}
+
+	const builds = 5
+	var wg sync.WaitGroup
+	for range builds {
+		wg.Go(func() {
+			if _, err := cc.GetProtocolParams(); err != nil {
+				t.Errorf("failed to get protocol params: %v", err)
+			}
+		})
+	}
+	<-started
+
+	// The chain context cache isn't locked while the parameters are fetched
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = apolloBackend()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("chain context cache locked during protocol params fetch")
+	}
+
+	// Builds waiting on the fetch share its result
+	close(unblock)
+	wg.Wait()
+	if *fetches != 1 {
+		t.Errorf("protocol params fetched %d times, want 1", *fetches)
+	}
+}
+
+func TestBuildChainContextFetchError(t *testing.T) {
+	stubProtocolParams(t)
+	fetchProtocolParams = func(
+		*OgmiosChainContext.OgmiosChainContext,
+	) (Base.ProtocolParameters, error) {
+		return Base.ProtocolParameters{}, errors.New("query failed")
+	}
+	cc, err := apolloBackend()
+	if err != nil {
+		t.Fatalf("failed to create chain context: %v", err)
+	}
+	// The build fails instead of getting a nil cost model
+	if _, err := newBuildChainContext(cc); err == nil {
+		t.Fatal("expected an error when protocol params can't be fetched")
+	}
+}
+
+// testUtxo returns a UTxO holding the given lovelace, and a native asset when
+// withAsset is set
+func testUtxo(index int, lovelace int64, withAsset bool) UTxO.UTxO {
+	value := Value.PureLovelaceValue(lovelace)
+	if withAsset {
+		value = Value.SimpleValue(lovelace, MultiAsset.MultiAsset[int64]{})
+		value.HasAssets = true
+	}
+	return UTxO.UTxO{
+		Input: TransactionInput.TransactionInput{
+			TransactionId: make([]byte, 32),
+			Index:         index,
+		},
+		Output: TransactionOutput.SimpleTransactionOutput(
+			Address.Address{},
+			value,
+		),
+	}
+}
+
+func TestChooseInputUtxos(t *testing.T) {
+	// Five 3 ADA inputs, after one holding an asset that is never selected
+	available := []UTxO.UTxO{testUtxo(0, 50_000_000, true)}
+	for i := 1; i <= 5; i++ {
+		available = append(available, testUtxo(i, 3_000_000, false))
+	}
+
+	tests := []struct {
+		name         string
+		price        int
+		inputBuffer  int
+		changeBuffer int
+		wantInputs   int
+		shouldError  bool
+	}{
+		{
+			// Needs 7 ADA
+			name:         "default buffers",
+			price:        1_000_000,
+			inputBuffer:  5_000_000,
+			changeBuffer: 1_000_000,
+			wantInputs:   3,
+		},
+		{
+			// Needs 2 ADA
+			name:        "small buffers",
+			price:       1_000_000,
+			inputBuffer: 1_000_000,
+			wantInputs:  1,
+		},
+		{
+			// Needs 4 ADA, one more than a single input holds
+			name:         "change buffer",
+			price:        1_000_000,
+			inputBuffer:  1_000_000,
+			changeBuffer: 2_000_000,
+			wantInputs:   2,
+		},
+		{
+			// Needs 16 ADA, more than the 15 ADA available
+			name:         "not enough funds",
+			price:        10_000_000,
+			inputBuffer:  5_000_000,
+			changeBuffer: 1_000_000,
+			shouldError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs, err := chooseInputUtxos(
+				available,
+				tt.price+tt.inputBuffer,
+				tt.changeBuffer,
+			)
+			if tt.shouldError {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(inputs) != tt.wantInputs {
+				t.Fatalf(
+					"selected %d inputs, want %d",
+					len(inputs),
+					tt.wantInputs,
+				)
+			}
+			for _, input := range inputs {
+				if input.Output.GetValue().HasAssets {
+					t.Errorf(
+						"selected input %d holding assets",
+						input.Input.Index,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestChooseInputsAndCollateral(t *testing.T) {
+	tests := []struct {
+		name           string
+		utxos          []UTxO.UTxO
+		neededAmount   int
+		wantCollateral int
+		wantInputs     []int
+		wantError      bool
+		wantValidation bool
+	}{
+		{
+			// Selecting inputs first would spend the only UTxO big enough
+			// for collateral
+			name: "collateral reserved from inputs",
+			utxos: []UTxO.UTxO{
+				testUtxo(1, 7_000_000, false),
+				testUtxo(2, 3_000_000, false),
+				testUtxo(3, 3_000_000, false),
+			},
+			neededAmount:   5_000_000,
+			wantCollateral: 1,
+			wantInputs:     []int{2, 3},
+		},
+		{
+			name: "smallest eligible collateral",
+			utxos: []UTxO.UTxO{
+				testUtxo(1, 20_000_000, false),
+				testUtxo(2, 8_000_000, false),
+				testUtxo(3, 6_000_000, false),
+				testUtxo(4, 50_000_000, true),
+			},
+			neededAmount:   10_000_000,
+			wantCollateral: 3,
+			wantInputs:     []int{1},
+		},
+		{
+			name: "collateral also spent",
+			utxos: []UTxO.UTxO{
+				testUtxo(1, 10_000_000, false),
+			},
+			neededAmount:   4_000_000,
+			wantCollateral: 1,
+			wantInputs:     []int{1},
+		},
+		{
+			name: "no collateral",
+			utxos: []UTxO.UTxO{
+				testUtxo(1, 3_000_000, false),
+				testUtxo(2, 3_000_000, false),
+				testUtxo(3, 50_000_000, true),
+			},
+			neededAmount:   1_000_000,
+			wantError:      true,
+			wantValidation: true,
+		},
+		{
+			name: "not enough funds",
+			utxos: []UTxO.UTxO{
+				testUtxo(1, 7_000_000, false),
+				testUtxo(2, 3_000_000, false),
+			},
+			neededAmount: 20_000_000,
+			wantError:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs, collateral, err := chooseInputsAndCollateral(
+				tt.utxos,
+				tt.neededAmount,
+				1_000_000,
+			)
+			if tt.wantError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				var validationErr InputValidationError
+				if errors.As(err, &validationErr) != tt.wantValidation {
+					t.Errorf(
+						"validation error = %t, want %t (%v)",
+						!tt.wantValidation,
+						tt.wantValidation,
+						err,
+					)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if collateral.Input.Index != tt.wantCollateral {
+				t.Errorf(
+					"collateral = %d, want %d",
+					collateral.Input.Index,
+					tt.wantCollateral,
+				)
+			}
+			gotInputs := make([]int, 0, len(inputs))
+			for _, input := range inputs {
+				gotInputs = append(gotInputs, input.Input.Index)
+			}
+			if !slices.Equal(gotInputs, tt.wantInputs) {
+				t.Errorf("inputs = %v, want %v", gotInputs, tt.wantInputs)
+			}
+		})
+	}
+}
+
+func TestSignupEstimateFromTx(t *testing.T) {
+	scriptAddress, err := Address.DecodeAddress(
+		"addr_test1zz496ujn6ly5urgwfarftxs2f05s2cs2hjkeed73a8qjcvjjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6suh7mks",
+	)
+	if err != nil {
+		t.Fatalf("failed to decode script address: %v", err)
+	}
+	providerAddress, err := Address.DecodeAddress(
+		"addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5",
+	)
+	if err != nil {
+		t.Fatalf("failed to decode provider address: %v", err)
+	}
+	tx := &Transaction.Transaction{
+		TransactionBody: TransactionBody.TransactionBody{
+			Fee: 250_000,
+			Outputs: []TransactionOutput.TransactionOutput{
+				TransactionOutput.SimpleTransactionOutput(
+					providerAddress,
+					Value.PureLovelaceValue(10_000_000),
+				),
+				TransactionOutput.SimpleTransactionOutput(
+					scriptAddress,
+					Value.PureLovelaceValue(1_400_000),
+				),
+			},
+		},
+	}
+
+	estimate := signupEstimateFromTx(tx, 10_000_000, scriptAddress)
+	want := SignupEstimate{
+		ServicePrice: 10_000_000,
+		Fee:          250_000,
+		MinAda:       1_400_000,
+		Total:        11_650_000,
+	}
+	if estimate != want {
+		t.Errorf("estimate = %+v, want %+v", estimate, want)
+	}
+}
+
+func TestDeterminePlanSelection(t *testing.T) {
+	monthly := database.ReferencePrice{
+		Price:    5_000_000,
+		Duration: 2_592_000_000,
+	}
+	yearly := database.ReferencePrice{
+		Price:    50_000_000,
+		Duration: 31_536_000_000,
+	}
+	refData := database.Reference{
+		Prices: []database.ReferencePrice{monthly, yearly},
+	}
+	// The same plans after the on-chain list is reordered
+	reordered := database.Reference{
+		Prices: []database.ReferencePrice{yearly, monthly},
+	}
+
+	tests := []struct {
+		name     string
+		refData  database.Reference
+		planId   string
+		price    int
+		duration int
+		wantIdx  int
+		wantPlan database.ReferencePrice
+		wantErr  bool
+	}{
+		{
+			name:     "price and duration",
+			refData:  refData,
+			price:    yearly.Price,
+			duration: yearly.Duration,
+			wantIdx:  1,
+			wantPlan: yearly,
+		},
+		{
+			name:     "plan ID",
+			refData:  refData,
+			planId:   yearly.PlanId(),
+			wantIdx:  1,
+			wantPlan: yearly,
+		},
+		{
+			name:     "plan ID after reorder",
+			refData:  reordered,
+			planId:   yearly.PlanId(),
+			wantIdx:  0,
+			wantPlan: yearly,
+		},
+		{
+			name:     "plan ID takes precedence",
+			refData:  reordered,
+			planId:   monthly.PlanId(),
+			price:    yearly.Price,
+			duration: yearly.Duration,
+			wantIdx:  1,
+			wantPlan: monthly,
+		},
+		{
+			name:    "unknown plan ID",
+			refData: refData,
+			planId:  "0000000000000000",
+			wantErr: true,
+		},
+		{
+			name:     "unknown price",
+			refData:  refData,
+			price:    1,
+			duration: monthly.Duration,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, plan, err := determinePlanSelection(
+				tt.refData,
+				tt.planId,
+				tt.price,
+				tt.duration,
+			)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got index %d", idx)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if idx != tt.wantIdx {
+				t.Errorf("index = %d, want %d", idx, tt.wantIdx)
+			}
+			if plan != tt.wantPlan {
+				t.Errorf("plan = %+v, want %+v", plan, tt.wantPlan)
+			}
+		})
+	}
+}
+
+func TestMatchRegion(t *testing.T) {
+	refData := database.Reference{
+		Regions: []database.ReferenceRegion{
+			{Name: "US"},
+			{Name: "eu"},
+		},
+	}
+
+	tests := []struct {
+		region    string
+		want      string
+		wantFound bool
+	}{
+		// The reference data's spelling is returned for any case
+		{region: "US", want: "US", wantFound: true},
+		{region: "us", want: "US", wantFound: true},
+		{region: " Us ", want: "US", wantFound: true},
+		{region: "EU", want: "eu", wantFound: true},
+		{region: "ap", wantFound: false},
+	}
+
+	for _, tt := range tests {
+		got, found := matchRegion(refData, tt.region)
+		if found != tt.wantFound || got != tt.want {
+			t.Errorf(
+				"matchRegion(%q) = %q, %v, want %q, %v",
+				tt.region,
+				got,
+				found,
+				tt.want,
+				tt.wantFound,
+			)
+		}
+	}
+}
+
+func TestScriptOwnerRejected(t *testing.T) {
+	const (
+		keyAddress = "addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5"
+		// Enterprise address with a script payment credential
+		scriptAddress = "addr_test1wpjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeqaxacva"
+		rewardAddress = "stake_test1upjxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeq5xlqvh"
+	)
+	// Each build is rejected before any backend is contacted
+	client := database.Client{
+		AssetName:  []byte("client"),
+		Credential: []byte("credential"),
+	}
+	tests := []struct {
+		name  string
+		build func() error
+	}{
+		{
+			name: "signup from script payment address",
+			build: func() error {
+				_, _, err := BuildSignupTx(
+					SignupDeps{},
+					scriptAddress,
+					"",
+					1,
+					1,
+					"",
+					"us-east-1",
+				)
+				return err
+			},
+		},
+		{
+			name: "signup for script owner",
+			build: func() error {
+				_, _, err := BuildSignupTx(
+					SignupDeps{},
+					keyAddress,
+					scriptAddress,
+					1,
+					1,
+					"",
+					"us-east-1",
+				)
+				return err
+			},
+		},
+		{
+			name: "signup for reward address owner",
+			build: func() error {
+				_, _, err := BuildSignupTx(
+					SignupDeps{},
+					keyAddress,
+					rewardAddress,
+					1,
+					1,
+					"",
+					"us-east-1",
+				)
+				return err
+			},
+		},
+		{
+			name: "transfer to script owner",
+			build: func() error {
+				_, err := BuildRenewTransferTx(
+					RenewDeps{Client: &client},
+					keyAddress,
+					scriptAddress,
+					"636c69656e74",
+					0,
+					0,
+					"",
+				)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.build()
+			var validationErr InputValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("error = %v, want an InputValidationError", err)
+			}
+			if !strings.Contains(err.Error(), "payment credential") {
+				t.Errorf("error = %q, want a payment credential error", err)
+			}
+		})
+	}
+
+	// A key owner's credential is its payment key hash
+	addr, err := Address.DecodeAddress(keyAddress)
+	if err != nil {
+		t.Fatalf("failed to decode address: %v", err)
+	}
+	credential, err := ownerPaymentCredential(addr, "owner address")
+	if err != nil {
+		t.Fatalf("ownerPaymentCredential: %v", err)
+	}
+	if !slices.Equal(credential, addr.PaymentPart) {
+		t.Errorf("credential = %x, want %x", credential, addr.PaymentPart)
+	}
+}
+
+func TestWrongNetworkAddressRejected(t *testing.T) {
+	const (
+		testnetAddress = "addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5"
+		mainnetAddress = "addr1q9jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jxgeryv3jqpnzwh0"
+	)
+	// The default config is for preprod, and each build is rejected before
+	// any backend is contacted
+	client := database.Client{
+		AssetName:  []byte("client"),
+		Credential: []byte("credential"),
+	}
+	tests := []struct {
+		name  string
+		build func() error
+	}{
+		{
+			name: "signup from mainnet payment address",
+			build: func() error {
+				_, _, err := BuildSignupTx(
+					SignupDeps{},
+					mainnetAddress,
+					"",
+					1,
+					1,
+					"",
+					"us-east-1",
+				)
+				return err
+			},
+		},
+		{
+			name: "signup for mainnet owner",
+			build: func() error {
+				_, _, err := BuildSignupTx(
+					SignupDeps{},
+					testnetAddress,
+					mainnetAddress,
+					1,
+					1,
+					"",
+					"us-east-1",
+				)
+				return err
+			},
+		},
+		{
+			name: "renew from mainnet payment address",
+			build: func() error {
+				_, err := BuildRenewTransferTx(
+					RenewDeps{Client: &client},
+					mainnetAddress,
+					"",
+					"636c69656e74",
+					1,
+					1,
+					"",
+				)
+				return err
+			},
+		},
+		{
+			name: "transfer to mainnet owner",
+			build: func() error {
+				_, err := BuildRenewTransferTx(
+					RenewDeps{Client: &client},
+					testnetAddress,
+					mainnetAddress,
+					"636c69656e74",
+					0,
+					0,
+					"",
+				)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.build()
+			var validationErr InputValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("error = %v, want an InputValidationError", err)
+			}
+			if !errors.Is(err, ErrAddressWrongNetwork) {
+				t.Errorf("error = %v, want %v", err, ErrAddressWrongNetwork)
+			}
+		})
+	}
+}
+
+func TestKupoUnavailable(t *testing.T) {
+	addr, err := Address.DecodeAddress(
+		"addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5",
+	)
+	if err != nil {
+		t.Fatalf("failed to decode address: %v", err)
+	}
+	// A server that's been closed refuses connections
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	// A server that doesn't answer until the request is abandoned
+	slow := httptest.NewServer(
+		http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}),
+	)
+	t.Cleanup(slow.Close)
+
+	tests := []struct {
+		name    string
+		kupoUrl string
+	}{
+		{name: "unreachable", kupoUrl: closed.URL},
+		{name: "timeout", kupoUrl: slow.URL},
+	}
+	t.Cleanup(backendBreaker.reset)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := newChainContext(&config.Config{
+				TxBuilder: config.TxBuilderConfig{
+					KupoUrl:     tt.kupoUrl,
+					KupoTimeout: 100 * time.Millisecond,
+				},
+			})
+			_, err := cc.Utxos(addr)
+			if !errors.Is(err, ErrKupoUnavailable) {
+				t.Fatalf("error = %v, want %v", err, ErrKupoUnavailable)
+			}
+			var validationErr InputValidationError
+			if errors.As(err, &validationErr) {
+				t.Errorf("error = %v, want it not to be a validation error", err)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const (
+		threshold = 3
+		cooldown  = time.Minute
+	)
+	var b circuitBreaker
+	now := time.Now()
+	backendErr := errors.New("connection refused")
+	fail := func(t *testing.T) {
+		t.Helper()
+		if err := b.allow(now, threshold); err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		b.record(backendErr, now, threshold, cooldown)
+	}
+
+	// Failures below the threshold don't open the breaker
+	for range threshold - 1 {
+		fail(t)
+	}
+	if err := b.allow(now, threshold); err != nil {
+		t.Fatalf("allow below threshold: %v", err)
+	}
+	b.record(nil, now, threshold, cooldown)
+	for range threshold {
+		fail(t)
+	}
+	if err := b.allow(now, threshold); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("allow when open = %v, want %v", err, ErrBackendUnavailable)
+	}
+	if err := b.allow(now, 0); err != nil {
+		t.Errorf("allow when disabled = %v, want nil", err)
+	}
+
+	// After the cooldown, a single probe is let through
+	now = now.Add(cooldown)
+	if err := b.allow(now, threshold); err != nil {
+		t.Fatalf("allow probe: %v", err)
+	}
+	if err := b.allow(now, threshold); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("allow during probe = %v, want %v", err, ErrBackendUnavailable)
+	}
+	// A failed probe opens the breaker for another cooldown
+	b.record(backendErr, now, threshold, cooldown)
+	if err := b.allow(now, threshold); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf(
+			"allow after failed probe = %v, want %v",
+			err,
+			ErrBackendUnavailable,
+		)
+	}
+
+	// A successful probe closes it
+	now = now.Add(cooldown)
+	if err := b.allow(now, threshold); err != nil {
+		t.Fatalf("allow probe: %v", err)
+	}
+	b.record(nil, now, threshold, cooldown)
+	for range threshold - 1 {
+		fail(t)
+	}
+	if err := b.allow(now, threshold); err != nil {
+		t.Errorf("allow after closing: %v", err)
+	}
+}
+
+func TestBackendBreakerFailsFast(t *testing.T) {
+	fetches := 0
+	origFetch := fetchProtocolParams
+	fetchProtocolParams = func(
+		*OgmiosChainContext.OgmiosChainContext,
+	) (Base.ProtocolParameters, error) {
+		fetches++
+		return Base.ProtocolParameters{}, &net.OpError{
+			Op:  "dial",
+			Net: "tcp",
+			Err: syscall.ECONNREFUSED,
+		}
+	}
+	ResetCachedSystemStart()
+	t.Cleanup(func() {
+		fetchProtocolParams = origFetch
+		ResetCachedSystemStart()
+		backendBreaker.reset()
+	})
+	cc, err := apolloBackend()
+	if err != nil {
+		t.Fatalf("failed to create chain context: %v", err)
+	}
+
+	threshold := config.GetConfig().TxBuilder.BreakerThreshold
+	for range threshold {
+		_, err := cc.GetProtocolParams()
+		if err == nil || errors.Is(err, ErrBackendUnavailable) {
+			t.Fatalf("error = %v, want the backend error", err)
+		}
+	}
+	// Once open, calls fail without reaching the backend
+	_, err = cc.GetProtocolParams()
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("error = %v, want %v", err, ErrBackendUnavailable)
+	}
+	if fetches != threshold {
+		t.Errorf("fetches = %d, want %d", fetches, threshold)
+	}
+}
+
+func TestIsBackendFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "success", err: nil},
+		{
+			name: "connection refused",
+			err: fmt.Errorf("failed to connect to ogmios: %w", &net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: syscall.ECONNREFUSED,
+			}),
+			want: true,
+		},
+		{
+			name: "timeout",
+			err:  fmt.Errorf("query: %w", context.DeadlineExceeded),
+			want: true,
+		},
+		{
+			name: "connection closed",
+			err:  fmt.Errorf("failed to read json response: %w", io.EOF),
+			want: true,
+		},
+		{
+			name: "proxy error status",
+			err: fmt.Errorf(
+				"failed to connect to ogmios: %w",
+				websocket.ErrBadHandshake,
+			),
+			want: true,
+		},
+		{
+			name: "error page",
+			err: fmt.Errorf(
+				"%w: %w",
+				ErrKupoUnavailable,
+				json.Unmarshal([]byte("<html>"), new([]any)),
+			),
+			want: true,
+		},
+		{
+			name: "script evaluation failure",
+			err: ogmigo.Error{
+				Fault: ogmigo.Fault{Code: "3010", String: "script failure"},
+			},
+		},
+		{
+			name: "unknown UTxO",
+			err:  errors.New("UTxO not found"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBackendFailure(tt.err); got != tt.want {
+				t.Errorf(
+					"isBackendFailure(%v) = %t, want %t",
+					tt.err,
+					got,
+					tt.want,
+				)
+			}
+		})
+	}
+
+	// Request errors don't open the breaker
+	t.Cleanup(backendBreaker.reset)
+	requestErr := errors.New("UTxO not found")
+	for range config.GetConfig().TxBuilder.BreakerThreshold + 1 {
+		_, err := callBackend(func() (int, error) { return 0, requestErr })
+		if !errors.Is(err, requestErr) {
+			t.Fatalf("error = %v, want %v", err, requestErr)
+		}
+	}
+}
+
+func TestInputRefFromString(t *testing.T) {
+	const txId = "ea7e4f0147eeba9a17c519e1652ed933262d30fe462bf418ece18dc27a2c13ba"
+	tests := []struct {
+		name      string
+		ref       string
+		wantIndex uint32
+		wantErr   string
+	}{
+		{name: "valid", ref: txId + "#1", wantIndex: 1},
+		{name: "zero index", ref: txId + "#0", wantIndex: 0},
+		{name: "empty", ref: "", wantErr: "missing '#'"},
+		{name: "missing separator", ref: txId + "1", wantErr: "missing '#'"},
+		{name: "non-hex txid", ref: "not-hex#1", wantErr: "hex characters"},
+		{name: "short txid", ref: "ea7e4f01#1", wantErr: "hex characters"},
+		{
+			name:    "non-numeric index",
+			ref:     txId + "#one",
+			wantErr: "non-negative integer",
+		},
+		{
+			name:    "negative index",
+			ref:     txId + "#-1",
+			wantErr: "non-negative integer",
+		},
+		{
+			name:    "trailing data",
+			ref:     txId + "#1x",
+			wantErr: "non-negative integer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := inputRefFromString(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := input.Id().String(); got != txId {
+				t.Errorf("txid = %s, want %s", got, txId)
+			}
+			if got := input.Index(); got != tt.wantIndex {
+				t.Errorf("index = %d, want %d", got, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestClientIdFromInput(t *testing.T) {
+	// The client ID is checked on-chain, so these vectors must never change
+	tests := []struct {
+		name    string
+		txId    string
+		index   int
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "script ref input",
+			txId:  "ea7e4f0147eeba9a17c519e1652ed933262d30fe462bf418ece18dc27a2c13ba",
+			index: 1,
+			want:  "891fa5d74b2292e00fea8eda28abd311cdc033a56297f84ce68c80f11170b051",
+		},
+		{
+			name:  "zero input",
+			txId:  strings.Repeat("00", 32),
+			index: 0,
+			want:  "a2e5e227858e84f1a8f9b0c1246e6cbc9336d707d43ba43d0e1cb7c51c45f4c9",
+		},
+		{
+			name:  "multi-byte index",
+			txId:  "ea7e4f0147eeba9a17c519e1652ed933262d30fe462bf418ece18dc27a2c13ba",
+			index: 300,
+			want:  "d759608531859743d76dc095203d61be010341072bb58660c10f13c3f7f66c67",
+		},
+		{name: "empty transaction ID", txId: "", wantErr: true},
+		{name: "short transaction ID", txId: "ea7e4f01", wantErr: true},
+		{
+			name:    "negative index",
+			txId:    strings.Repeat("00", 32),
+			index:   -1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txId, err := hex.DecodeString(tt.txId)
+			if err != nil {
+				t.Fatalf("failed to decode transaction ID: %v", err)
+			}
+			clientId, err := clientIdFromInput(TransactionInput.TransactionInput{
+				TransactionId: txId,
+				Index:         tt.index,
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("client ID = %x, want an error", clientId)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := hex.EncodeToString(clientId); got != tt.want {
+				t.Errorf("client ID = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTxHash(t *testing.T) {
+	providerAddress, err := Address.DecodeAddress(
+		"addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5",
+	)
+	if err != nil {
+		t.Fatalf("failed to decode provider address: %v", err)
+	}
+	tx := &Transaction.Transaction{
+		TransactionBody: TransactionBody.TransactionBody{
+			Inputs: []TransactionInput.TransactionInput{
+				{TransactionId: make([]byte, 32), Index: 0},
+			},
+			Fee: 250_000,
+			Outputs: []TransactionOutput.TransactionOutput{
+				TransactionOutput.SimpleTransactionOutput(
+					providerAddress,
+					Value.PureLovelaceValue(10_000_000),
+				),
+			},
+		},
+		Valid: true,
+	}
+	txCbor, err := tx.Bytes()
+	if err != nil {
+		t.Fatalf("failed to encode tx: %v", err)
+	}
+	wantHash, err := tx.TransactionBody.Hash()
+	if err != nil {
+		t.Fatalf("failed to hash tx body: %v", err)
+	}
+
+	got, err := TxHash(txCbor)
+	if err != nil {
+		t.Fatalf("TxHash: %v", err)
+	}
+	if want := hex.EncodeToString(wantHash); got != want {
+		t.Errorf("TxHash = %s, want %s", got, want)
+	}
+
+	for _, data := range [][]byte{nil, {0x80}, append(txCbor, 0x00)} {
+		if _, err := TxHash(data); err == nil {
+			t.Errorf("TxHash(%x) succeeded, want error", data)
+		}
+	}
+}
+
+func TestCheckTxSanity(t *testing.T) {
+	stubProtocolParams(t)
+	providerAddress, err := Address.DecodeAddress(
+		"addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5",
+	)
+	if err != nil {
+		t.Fatalf("failed to decode provider address: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		fee     int64
+		amount  int64
+		wantErr string
+	}{
+		{name: "valid", fee: 250_000, amount: 10_000_000},
+		{
+			name:    "under fee",
+			fee:     100_000,
+			amount:  10_000_000,
+			wantErr: "below the minimum fee",
+		},
+		{
+			name:    "under min ADA",
+			fee:     250_000,
+			amount:  500_000,
+			wantErr: "below the minimum",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apolloTx := &Transaction.Transaction{
+				TransactionBody: TransactionBody.TransactionBody{
+					Inputs: []TransactionInput.TransactionInput{
+						{TransactionId: make([]byte, 32), Index: 0},
+					},
+					Fee: tt.fee,
+					Outputs: []TransactionOutput.TransactionOutput{
+						TransactionOutput.SimpleTransactionOutput(
+							providerAddress,
+							Value.PureLovelaceValue(tt.amount),
+						),
+					},
+				},
+				Valid: true,
+			}
+			txCbor, err := apolloTx.Bytes()
+			if err != nil {
+				t.Fatalf("failed to encode tx: %v", err)
+			}
+			tx, err := DecodeTx(txCbor)
+			if err != nil {
+				t.Fatalf("DecodeTx: %v", err)
+			}
+
+			err = CheckTxSanity(tx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckTxSanity: %v", err)
+				}
+				return
+			}
+			var validationErr InputValidationError
+			if !errors.As(err, &validationErr) ||
+				!strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf(
+					"error = %v, want validation error containing %q",
+					err,
+					tt.wantErr,
+				)
+			}
+		})
+	}
+}
+
+// fakeChainContext serves a wallet's UTxOs and the chain state from memory,
+// so transactions can be built without Ogmios or Kupo
+type fakeChainContext struct {
+	utxos    []UTxO.UTxO
+	refUtxos map[string]UTxO.UTxO
+	slot     int
+	slotTime time.Time
+}
+
+func (f *fakeChainContext) GetProtocolParams() (Base.ProtocolParameters, error) {
+	return Base.ProtocolParameters{
+		MinFeeConstant:    155381,
+		MinFeeCoefficient: 44,
+		MaxTxSize:         16384,
+		MaxTxExSteps:      "10000000000",
+		MaxTxExMem:        "14000000",
+		CoinsPerUtxoByte:  "4310",
+		CostModelsRaw: map[string][]int64{
+			"plutus:v3": {1, 2, 3},
+		},
+	}, nil
+}
+
+func (f *fakeChainContext) GetGenesisParams() (Base.GenesisParameters, error) {
+	return Base.GenesisParameters{}, nil
+}
+
+func (f *fakeChainContext) Network() int {
+	return 0
+}
+
+func (f *fakeChainContext) Epoch() (int, error) {
+	return 0, nil
+}
+
+func (f *fakeChainContext) MaxTxFee() (int, error) {
+	return Base.Fee(f, 16384, 10_000_000_000, 14_000_000)
+}
+
+func (f *fakeChainContext) LastBlockSlot() (int, error) {
+	return f.slot, nil
+}
+
+func (f *fakeChainContext) Utxos(Address.Address) ([]UTxO.UTxO, error) {
+	return f.utxos, nil
+}
+
+func (f *fakeChainContext) SubmitTx(
+	Transaction.Transaction,
+) (serialization.TransactionId, error) {
+	return serialization.TransactionId{}, errors.New("not supported")
+}
+
+func (f *fakeChainContext) EvaluateTx(
+	[]uint8,
+) (map[string]Redeemer.ExecutionUnits, error) {
+	// Keep the builders' estimated execution units
+	return map[string]Redeemer.ExecutionUnits{}, nil
+}
+
+func (f *fakeChainContext) EvaluateTxWithAdditionalUtxos(
+	txCbor []uint8,
+	_ []UTxO.UTxO,
+) (map[string]Redeemer.ExecutionUnits, error) {
+	return f.EvaluateTx(txCbor)
+}
+
+func (f *fakeChainContext) GetUtxoFromRef(
+	txHash string,
+	txIndex int,
+) (*UTxO.UTxO, error) {
+	utxo, ok := f.refUtxos[fmt.Sprintf("%s#%d", txHash, txIndex)]
+	if !ok {
+		return nil, errors.New("UTxO not found")
+	}
+	return &utxo, nil
+}
+
+func (f *fakeChainContext) GetContractCbor(string) (string, error) {
+	return "", errors.New("not supported")
+}
+
+func (f *fakeChainContext) CostModelsV1() PlutusData.CostModel {
+	return nil
+}
+
+func (f *fakeChainContext) CostModelsV2() PlutusData.CostModel {
+	return nil
+}
+
+func (f *fakeChainContext) CostModelsV3() PlutusData.CostModel {
+	return PlutusData.CostModel{1, 2, 3}
+}
+
+func (f *fakeChainContext) SlotTime(slot int) (time.Time, error) {
+	return f.slotTime.Add(time.Duration(slot-f.slot) * time.Second), nil
+}
+
+// newFakeChainContext returns a fake chain context with a funded wallet at
+// paymentAddress
+func newFakeChainContext(t *testing.T, paymentAddress string) *fakeChainContext {
+	t.Helper()
+	addr, err := Address.DecodeAddress(paymentAddress)
+	if err != nil {
+		t.Fatalf("failed to decode address: %v", err)
+	}
+	fake := &fakeChainContext{
+		refUtxos: map[string]UTxO.UTxO{},
+		slot:     100_000,
+		slotTime: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for i, lovelace := range []int64{50_000_000, 10_000_000} {
+		txId := make([]byte, 32)
+		txId[0] = byte(i + 1)
+		fake.utxos = append(fake.utxos, UTxO.UTxO{
+			Input: TransactionInput.TransactionInput{
+				TransactionId: txId,
+				Index:         i,
+			},
+			Output: TransactionOutput.SimpleTransactionOutput(
+				addr,
+				Value.PureLovelaceValue(lovelace),
+			),
+		})
+	}
+	return fake
+}
+
+// encodePlutusData returns the CBOR of a datum or redeemer
+func encodePlutusData(t *testing.T, data any) string {
+	t.Helper()
+	ret, err := cbor.Encode(data)
+	if err != nil {
+		t.Fatalf("failed to encode plutus data: %v", err)
+	}
+	return hex.EncodeToString(ret)
+}
+
+func TestBuildSignupTxWithFakeChain(t *testing.T) {
+	const paymentAddress = "addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5"
+	fake := newFakeChainContext(t, paymentAddress)
+	ref := database.Reference{
+		TxId: make([]byte, 32),
+		Prices: []database.ReferencePrice{
+			{Duration: 3_600_000, Price: 1_000_000},
+			{Duration: 2_592_000_000, Price: 5_000_000},
+		},
+		Regions: []database.ReferenceRegion{{Name: "us-east-1"}},
+	}
+
+	built, err := buildSignupTx(
+		SignupDeps{Ref: &ref, Chain: fake},
+		paymentAddress,
+		"",
+		5_000_000,
+		2_592_000_000,
+		"",
+		"US-EAST-1",
+	)
+	if err != nil {
+		t.Fatalf("buildSignupTx: %v", err)
+	}
+
+	addr, err := Address.DecodeAddress(paymentAddress)
+	if err != nil {
+		t.Fatalf("failed to decode address: %v", err)
+	}
+	// The wallet's smaller UTxO is reserved as collateral
+	firstInput := fake.utxos[0].Input
+	wantClientId, err := clientIdFromInput(firstInput)
+	if err != nil {
+		t.Fatalf("clientIdFromInput: %v", err)
+	}
+	if !slices.Equal(built.clientId, wantClientId) {
+		t.Errorf("client ID = %x, want %x", built.clientId, wantClientId)
+	}
+
+	// The client datum holds the owner, the reference data's spelling of
+	// the region and the expiration
+	expiration := fake.slotTime.Add(2_592_000_000 * time.Millisecond)
+	wantDatum := encodePlutusData(t, cbor.NewConstructorEncoder(
+		1,
+		cbor.IndefLengthList{
+			addr.PaymentPart,
+			[]byte("us-east-1"),
+			expiration.UnixMilli(),
+		},
+	))
+	scriptAddress, err := Address.DecodeAddress(
+		config.GetConfig().Indexer.ScriptAddress,
+	)
+	if err != nil {
+		t.Fatalf("failed to decode script address: %v", err)
+	}
+	var datums []string
+	for _, output := range built.tx.TransactionBody.Outputs {
+		outputAddr := output.GetAddress()
+		if outputAddr.Equal(&scriptAddress) {
+			datums = append(datums, encodePlutusData(t, output.GetDatum()))
+		}
+	}
+	if len(datums) != 1 || datums[0] != wantDatum {
+		t.Errorf("script output datums = %v, want [%s]", datums, wantDatum)
+	}
+
+	// The mint redeemer selects the second plan and names the input the
+	// client ID was derived from
+	wantRedeemer := encodePlutusData(t, cbor.NewConstructorEncoder(
+		0,
+		cbor.IndefLengthList{
+			addr.PaymentPart,
+			[]byte("us-east-1"),
+			1,
+			cbor.NewConstructorEncoder(
+				0,
+				cbor.IndefLengthList{
+					firstInput.TransactionId,
+					firstInput.Index,
+				},
+			),
+		},
+	))
+	redeemers := built.tx.TransactionWitnessSet.Redeemer
+	if len(redeemers) != 1 {
+		t.Fatalf("got %d redeemers, want 1", len(redeemers))
+	}
+	if redeemers[0].Tag != Redeemer.MINT {
+		t.Errorf("redeemer tag = %d, want MINT", redeemers[0].Tag)
+	}
+	if got := encodePlutusData(t, redeemers[0].Data); got != wantRedeemer {
+		t.Errorf("redeemer = %s, want %s", got, wantRedeemer)
+	}
+}
+
+func TestBuildRenewTransferTxWithFakeChain(t *testing.T) {
+	const paymentAddress = "addr_test1qpjwevqy6mh5hsnudjgpgrtfjwwxdtl7d73e9u0kxg9453jjduk3c6ecrpkrk8qqlr4ep37cx03ytlcn70n93zyemj6sasxnj5"
+	fake := newFakeChainContext(t, paymentAddress)
+	ref := database.Reference{
+		TxId: make([]byte, 32),
+		Prices: []database.ReferencePrice{
+			{Duration: 3_600_000, Price: 1_000_000},
+		},
+		Regions: []database.ReferenceRegion{{Name: "us-east-1"}},
+	}
+	addr, err := Address.DecodeAddress(paymentAddress)
+	if err != nil {
+		t.Fatalf("failed to decode address: %v", err)
+	}
+	scriptAddress, err := Address.DecodeAddress(
+		config.GetConfig().Indexer.ScriptAddress,
+	)
+	if err != nil {
+		t.Fatalf("failed to decode script address: %v", err)
+	}
+	// The client's UTxO at the script holds its asset
+	client := database.Client{
+		AssetName:     []byte("client"),
+		Credential:    addr.PaymentPart,
+		Region:        "us-east-1",
+		Expiration:    time.Now().Add(24 * time.Hour),
+		TxHash:        slices.Repeat([]byte{0xcc}, 32),
+		TxOutputIndex: 0,
+	}
+	policyId, err := Policy.New(hex.EncodeToString(scriptAddress.PaymentPart))
+	if err != nil {
+		t.Fatalf("failed to create policy ID: %v", err)
+	}
+	clientValue := Value.SimpleValue(
+		2_000_000,
+		MultiAsset.MultiAsset[int64]{
+			*policyId: Asset.Asset[int64]{
+				AssetName.NewAssetNameFromString(string(client.AssetName)): 1,
+			},
+		},
+	)
+	fake.refUtxos[hex.EncodeToString(client.TxHash)+"#0"] = UTxO.UTxO{
+		Input: TransactionInput.TransactionInput{
+			TransactionId: client.TxHash,
+			Index:         0,
+		},
+		Output: TransactionOutput.SimpleTransactionOutput(
+			scriptAddress,
+			clientValue,
+		),
+	}
+
+	txCbor, err := BuildRenewTransferTx(
+		RenewDeps{Ref: &ref, Client: &client, Chain: fake},
+		paymentAddress,
+		"",
+		hex.EncodeToString(client.AssetName),
+		1_000_000,
+		3_600_000,
+		"",
+	)
+	if err != nil {
+		t.Fatalf("BuildRenewTransferTx: %v", err)
+	}
+	var tx Transaction.Transaction
+	if _, err := cbor.Decode(txCbor, &tx); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+
+	// An unexpired client's duration is added to its current expiration
+	wantDatum := encodePlutusData(t, cbor.NewConstructorEncoder(
+		1,
+		cbor.IndefLengthList{
+			addr.PaymentPart,
+			[]byte("us-east-1"),
+			client.Expiration.Add(time.Hour).UnixMilli(),
+		},
+	))
+	var datums []string
+	for _, output := range tx.TransactionBody.Outputs {
+		outputAddr := output.GetAddress()
+		if outputAddr.Equal(&scriptAddress) {
+			datums = append(datums, encodePlutusData(t, output.GetDatum()))
+		}
+	}
+	if len(datums) != 1 || datums[0] != wantDatum {
+		t.Errorf("script output datums = %v, want [%s]", datums, wantDatum)
+	}
+
+	// The spend redeemer keeps the owner and selects the only plan
+	wantRedeemer := encodePlutusData(t, cbor.NewConstructorEncoder(
+		2,
+		cbor.IndefLengthList{[]byte{}, 0},
+	))
+	redeemers := tx.TransactionWitnessSet.Redeemer
+	if len(redeemers) != 1 {
+		t.Fatalf("got %d redeemers, want 1", len(redeemers))
+	}
+	if redeemers[0].Tag != Redeemer.SPEND {
+		t.Errorf("redeemer tag = %d, want SPEND", redeemers[0].Tag)
+	}
+	if got := encodePlutusData(t, redeemers[0].Data); got != wantRedeemer {
+		t.Errorf("redeemer = %s, want %s", got, wantRedeemer)
+	}
+
+	// A client's device limit is carried over to the new datum
+	client.DeviceLimit = 10
+	txCbor, err = BuildRenewTransferTx(
+		RenewDeps{Ref: &ref, Client: &client, Chain: fake},
+		paymentAddress,
+		"",
+		hex.EncodeToString(client.AssetName),
+		1_000_000,
+		3_600_000,
+		"",
+	)
+	if err != nil {
+		t.Fatalf("BuildRenewTransferTx with device limit: %v", err)
+	}
+	tx = Transaction.Transaction{}
+	if _, err := cbor.Decode(txCbor, &tx); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+	wantDatum = encodePlutusData(t, cbor.NewConstructorEncoder(
+		1,
+		cbor.IndefLengthList{
+			addr.PaymentPart,
+			[]byte("us-east-1"),
+			client.Expiration.Add(time.Hour).UnixMilli(),
+			10,
+		},
+	))
+	datums = nil
+	for _, output := range tx.TransactionBody.Outputs {
+		outputAddr := output.GetAddress()
+		if outputAddr.Equal(&scriptAddress) {
+			datums = append(datums, encodePlutusData(t, output.GetDatum()))
+		}
+	}
+	if len(datums) != 1 || datums[0] != wantDatum {
+		t.Errorf(
+			"script output datums with device limit = %v, want [%s]",
+			datums,
+			wantDatum,
+		)
+	}
+}
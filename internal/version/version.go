@@ -22,6 +22,7 @@ import (
 var (
 	Version    string
 	CommitHash string
+	BuildDate  string
 )
 
 func GetVersionString() string {
@@ -16,8 +16,10 @@ package wireguard
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -26,8 +28,26 @@ import (
 
 	"github.com/blinklabs-io/vpn-indexer/internal/database"
 	"github.com/blinklabs-io/vpn-indexer/internal/jwt"
+	"github.com/blinklabs-io/vpn-indexer/internal/requestid"
 )
 
+// DefaultTimeout is the container request timeout used when none is configured
+const DefaultTimeout = 10 * time.Second
+
+// sharedTransport is reused by all container clients so connections to the
+// container are kept alive between requests
+var sharedTransport = newTransport()
+
+// newTransport returns a copy of the default transport with keep-alives
+// explicitly enabled
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = false
+	transport.MaxIdleConnsPerHost = 10
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
 // Client is an HTTP client for the docker-wireguard peer management API
 type Client struct {
 	containerURL string
@@ -47,6 +67,15 @@ type AddPeerResponse struct {
 	ServerPubkey string `json:"server_pubkey"`
 	Endpoint     string `json:"endpoint"`
 	AllowedIPs   string `json:"allowed_ips"`
+	// AlreadyExists is set when the container already had the peer. The
+	// server details are not populated in that case.
+	AlreadyExists bool `json:"-"`
+}
+
+// errorResponse is the error body returned by the container
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
 }
 
 // InfoResponse is the response from the info endpoint
@@ -55,17 +84,33 @@ type InfoResponse struct {
 	Endpoint     string `json:"endpoint"`
 }
 
-// NewClient creates a new WireGuard container client
-func NewClient(containerURL string, jwtIssuer *jwt.Issuer) *Client {
+// NewClient creates a new WireGuard container client. A timeout of 0 uses
+// DefaultTimeout.
+func NewClient(
+	containerURL string,
+	jwtIssuer *jwt.Issuer,
+	timeout time.Duration,
+) *Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
 	return &Client{
 		containerURL: containerURL,
 		jwtIssuer:    jwtIssuer,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   timeout,
+			Transport: sharedTransport,
 		},
 	}
 }
 
+// setRequestID forwards the request ID from ctx, if any, to the container
+func setRequestID(ctx context.Context, req *http.Request) {
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+}
+
 // buildURL constructs a URL by appending the path to the container URL.
 // Handles trailing slashes correctly to avoid double slashes.
 func (c *Client) buildURL(path string) (string, error) {
@@ -79,6 +124,15 @@ func (c *Client) buildURL(path string) (string, error) {
 
 // AddPeer registers a peer with docker-wireguard (POST /peer)
 func (c *Client) AddPeer(pubkey, allowedIP string) (*AddPeerResponse, error) {
+	return c.AddPeerWithContext(context.Background(), pubkey, allowedIP)
+}
+
+// AddPeerWithContext is like AddPeer but accepts a context for cancellation
+// and request ID propagation.
+func (c *Client) AddPeerWithContext(
+	ctx context.Context,
+	pubkey, allowedIP string,
+) (*AddPeerResponse, error) {
 	// Generate JWT for authentication
 	token, err := c.jwtIssuer.IssuePeerJWT(pubkey, allowedIP)
 	if err != nil {
@@ -103,16 +157,33 @@ func (c *Client) AddPeer(pubkey, allowedIP string) (*AddPeerResponse, error) {
 	}
 
 	// Make POST request
-	resp, err := c.httpClient.Post(
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
 		peerURL,
-		"application/json",
 		bytes.NewReader(bodyBytes),
 	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add peer: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// A peer the container already has is the state we wanted
+	if isPeerExistsResponse(resp.StatusCode, respBody) {
+		return &AddPeerResponse{Success: true, AlreadyExists: true}, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf(
 			"add peer request failed with status: %d",
@@ -122,7 +193,7 @@ func (c *Client) AddPeer(pubkey, allowedIP string) (*AddPeerResponse, error) {
 
 	// Parse response
 	var result AddPeerResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(respBody, &result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
@@ -134,10 +205,37 @@ func (c *Client) AddPeer(pubkey, allowedIP string) (*AddPeerResponse, error) {
 	return &result, nil
 }
 
+// isPeerExistsResponse reports whether an add peer response means the peer
+// was already configured: either 409 Conflict or an error body saying so
+func isPeerExistsResponse(statusCode int, body []byte) bool {
+	if statusCode == http.StatusConflict {
+		return true
+	}
+	var errResp errorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil {
+		return false
+	}
+	for _, msg := range []string{errResp.Error, errResp.Message} {
+		if strings.Contains(strings.ToLower(msg), "already exists") {
+			return true
+		}
+	}
+	return false
+}
+
 // RemovePeer removes a peer from docker-wireguard (DELETE /peer)
 // Uses query parameters instead of a JSON body to avoid issues with
 // intermediaries that may reject DELETE requests with bodies.
 func (c *Client) RemovePeer(pubkey, allowedIP string) error {
+	return c.RemovePeerWithContext(context.Background(), pubkey, allowedIP)
+}
+
+// RemovePeerWithContext is like RemovePeer but accepts a context for
+// cancellation and request ID propagation.
+func (c *Client) RemovePeerWithContext(
+	ctx context.Context,
+	pubkey, allowedIP string,
+) error {
 	// Generate JWT for authentication
 	token, err := c.jwtIssuer.IssuePeerJWT(pubkey, allowedIP)
 	if err != nil {
@@ -159,10 +257,16 @@ func (c *Client) RemovePeer(pubkey, allowedIP string) error {
 	u.RawQuery = q.Encode()
 
 	// Create DELETE request without body
-	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodDelete,
+		u.String(),
+		nil,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	setRequestID(ctx, req)
 
 	// Execute request
 	resp, err := c.httpClient.Do(req)
@@ -210,11 +314,25 @@ func (c *Client) GetInfo() (*InfoResponse, error) {
 
 // Health checks container health (GET /health)
 func (c *Client) Health() error {
+	return c.HealthWithContext(context.Background())
+}
+
+// HealthWithContext is like Health but accepts a context for cancellation.
+func (c *Client) HealthWithContext(ctx context.Context) error {
 	healthURL, err := c.buildURL("/health")
 	if err != nil {
 		return err
 	}
-	resp, err := c.httpClient.Get(healthURL)
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		healthURL,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to check health: %w", err)
 	}
@@ -230,26 +348,72 @@ func (c *Client) Health() error {
 	return nil
 }
 
+// WaitHealthy calls Health up to attempts times, waiting interval between
+// failed attempts. It returns nil as soon as the container reports healthy,
+// otherwise the last health check error.
+func (c *Client) WaitHealthy(
+	ctx context.Context,
+	attempts int,
+	interval time.Duration,
+) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = c.HealthWithContext(ctx); err == nil {
+			return nil
+		}
+		slog.Debug(
+			"WG container health check failed",
+			"attempt", attempt,
+			"attempts", attempts,
+			"error", err,
+		)
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	return err
+}
+
+// SyncResult reports the outcome of SyncPeersToContainer
+type SyncResult struct {
+	// Synced is the number of peers the container accepted, including those
+	// it already had
+	Synced int
+	// AlreadyExisted is the number of synced peers the container already had
+	AlreadyExisted int
+	// Failed lists the pubkeys of peers that could not be synced
+	Failed []string
+	// Errors holds the error for each pubkey in Failed, at the same index
+	Errors []error
+}
+
 // SyncPeersToContainer syncs all active peers to the WG container on startup.
 // This is called after rebuilding from S3 to ensure the container has all peers.
-// Returns an error if more than 50% of sync attempts fail (indicating a systemic issue).
+// The result lists any peers that failed so callers can retry them. Returns an
+// error if more than 50% of sync attempts fail (indicating a systemic issue).
 func (c *Client) SyncPeersToContainer(
 	db *database.Database,
 	region string,
-) error {
+) (*SyncResult, error) {
 	// Get all active peers for the region
 	peers, err := db.GetActivePeersForRegion(region)
 	if err != nil {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"failed to get active peers for region %s: %w",
 			region,
 			err,
 		)
 	}
 
+	syncResult := &SyncResult{}
 	if len(peers) == 0 {
 		slog.Info("No peers to sync to WG container", "region", region)
-		return nil
+		return syncResult, nil
 	}
 
 	slog.Info(
@@ -258,14 +422,11 @@ func (c *Client) SyncPeersToContainer(
 		"count", len(peers),
 	)
 
-	successCount := 0
-	failCount := 0
-
 	for _, peer := range peers {
 		// Add each peer to WG container
-		_, err := c.AddPeer(peer.Pubkey, peer.AssignedIP)
+		result, err := c.AddPeer(peer.Pubkey, peer.AssignedIP)
 		if err != nil {
-			// Log but continue - container might already have peer
+			// Log but continue
 			// Safely truncate pubkey for logging
 			shortPubkey := peer.Pubkey
 			if len(shortPubkey) > 8 {
@@ -277,27 +438,33 @@ func (c *Client) SyncPeersToContainer(
 				"assignedIP", peer.AssignedIP,
 				"error", err,
 			)
-			failCount++
+			syncResult.Failed = append(syncResult.Failed, peer.Pubkey)
+			syncResult.Errors = append(syncResult.Errors, err)
 		} else {
-			successCount++
+			syncResult.Synced++
+			if result.AlreadyExists {
+				syncResult.AlreadyExisted++
+			}
 		}
 	}
 
+	failCount := len(syncResult.Failed)
 	slog.Info(
 		"Completed syncing peers to WG container",
 		"region", region,
-		"success", successCount,
+		"success", syncResult.Synced,
+		"already_existed", syncResult.AlreadyExisted,
 		"failed", failCount,
 	)
 
 	// Return error if more than 50% of syncs failed (indicates systemic issue)
 	if failCount > 0 && failCount > len(peers)/2 {
-		return fmt.Errorf(
+		return syncResult, fmt.Errorf(
 			"sync to WG container had high failure rate: %d/%d failed",
 			failCount,
 			len(peers),
 		)
 	}
 
-	return nil
+	return syncResult, nil
 }
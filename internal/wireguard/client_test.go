@@ -0,0 +1,428 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+	"github.com/blinklabs-io/vpn-indexer/internal/jwt"
+)
+
+// newTestIssuer creates a JWT issuer backed by a throwaway Ed25519 key
+func newTestIssuer(t *testing.T) *jwt.Issuer {
+	t.Helper()
+
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	privKeyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "ed25519.key")
+	keyPem := pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: privKeyBytes},
+	)
+	if err := os.WriteFile(keyPath, keyPem, 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	issuer, err := jwt.NewIssuer(keyPath)
+	if err != nil {
+		t.Fatalf("failed to create JWT issuer: %v", err)
+	}
+	return issuer
+}
+
+// newTestContainer starts a mock container whose POST /peer handler reports
+// every pubkey in existing as already present
+func newTestContainer(
+	t *testing.T,
+	existing map[string]bool,
+	status int,
+	body string,
+) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req AddPeerRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if existing[req.Pubkey] {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(body))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(AddPeerResponse{
+				Success:      true,
+				ServerPubkey: "server-pubkey",
+			})
+		}),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAddPeerAlreadyExists(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantExists bool
+		wantErr    bool
+	}{
+		{
+			name:       "conflict status",
+			status:     http.StatusConflict,
+			body:       `{"error":"conflict"}`,
+			wantExists: true,
+		},
+		{
+			name:       "error body",
+			status:     http.StatusBadRequest,
+			body:       `{"success":false,"error":"Peer already exists"}`,
+			wantExists: true,
+		},
+		{
+			name:       "message body",
+			status:     http.StatusOK,
+			body:       `{"success":false,"message":"peer already exists"}`,
+			wantExists: true,
+		},
+		{
+			name:    "other failure",
+			status:  http.StatusInternalServerError,
+			body:    `{"error":"interface down"}`,
+			wantErr: true,
+		},
+		{
+			name:    "non-JSON failure",
+			status:  http.StatusBadGateway,
+			body:    `bad gateway`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestContainer(
+				t,
+				map[string]bool{"existing": true},
+				tc.status,
+				tc.body,
+			)
+			c := NewClient(server.URL, newTestIssuer(t), 0)
+
+			resp, err := c.AddPeer("existing", "10.8.0.2")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.AlreadyExists != tc.wantExists {
+				t.Errorf(
+					"AlreadyExists = %v, want %v",
+					resp.AlreadyExists,
+					tc.wantExists,
+				)
+			}
+
+			// New peers still report the server details
+			resp, err = c.AddPeer("new", "10.8.0.3")
+			if err != nil {
+				t.Fatalf("unexpected error for new peer: %v", err)
+			}
+			if resp.AlreadyExists || resp.ServerPubkey != "server-pubkey" {
+				t.Errorf("unexpected response for new peer: %+v", resp)
+			}
+		})
+	}
+}
+
+// newTestSyncDatabase opens a database holding count active peers named
+// pubkey-0 through pubkey-<count-1> in region "test"
+func newTestSyncDatabase(t *testing.T, count int) *database.Database {
+	t.Helper()
+
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{
+			Directory: t.TempDir(),
+		},
+		Vpn: config.VpnConfig{
+			Region: "test",
+		},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	assetName := []byte("sync-client")
+	if err := db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		[]byte("credential"),
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	for i := range count {
+		if err := db.AddWGPeer(
+			assetName,
+			fmt.Sprintf("pubkey-%d", i),
+			fmt.Sprintf("10.8.0.%d", i+2),
+		); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+	}
+	return db
+}
+
+func TestSyncPeersToContainerAlreadyExists(t *testing.T) {
+	db := newTestSyncDatabase(t, 4)
+
+	// Most peers are already in the container, which is not a failure
+	existing := map[string]bool{
+		"pubkey-1": true,
+		"pubkey-2": true,
+		"pubkey-3": true,
+	}
+	server := newTestContainer(
+		t,
+		existing,
+		http.StatusConflict,
+		`{"error":"peer already exists"}`,
+	)
+	c := NewClient(server.URL, newTestIssuer(t), 0)
+
+	result, err := c.SyncPeersToContainer(db, "test")
+	if err != nil {
+		t.Fatalf("SyncPeersToContainer() error = %v", err)
+	}
+	if result.Synced != 4 || result.AlreadyExisted != 3 {
+		t.Errorf(
+			"Synced = %d, AlreadyExisted = %d, want 4 and 3",
+			result.Synced,
+			result.AlreadyExisted,
+		)
+	}
+}
+
+func TestWaitHealthy(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		attempts     int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "healthy", failures: 0, attempts: 3, wantRequests: 1},
+		{name: "down then healthy", failures: 2, attempts: 3, wantRequests: 3},
+		{name: "never healthy", failures: 5, attempts: 3, wantErr: true, wantRequests: 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if requests.Add(1) <= tc.failures {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					w.WriteHeader(http.StatusOK)
+				}),
+			)
+			defer server.Close()
+			c := NewClient(server.URL, newTestIssuer(t), 0)
+
+			err := c.WaitHealthy(
+				context.Background(),
+				tc.attempts,
+				time.Millisecond,
+			)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("WaitHealthy() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got := requests.Load(); got != tc.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWaitHealthyCanceled(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}),
+	)
+	defer server.Close()
+	c := NewClient(server.URL, newTestIssuer(t), 0)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		50*time.Millisecond,
+	)
+	defer cancel()
+	if err := c.WaitHealthy(ctx, 100, time.Second); err == nil {
+		t.Fatal("expected error from canceled context")
+	}
+}
+
+func TestNewClientTimeout(t *testing.T) {
+	// The mock container answers after 200ms, or when the test finishes
+	done := make(chan struct{})
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-done:
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+	defer server.Close()
+	defer close(done)
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{name: "shorter than response", timeout: 20 * time.Millisecond, wantErr: true},
+		{name: "longer than response", timeout: 2 * time.Second},
+		{name: "default", timeout: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(server.URL, nil, tc.timeout)
+			wantTimeout := tc.timeout
+			if wantTimeout == 0 {
+				wantTimeout = DefaultTimeout
+			}
+			if c.httpClient.Timeout != wantTimeout {
+				t.Errorf(
+					"Timeout = %s, want %s",
+					c.httpClient.Timeout,
+					wantTimeout,
+				)
+			}
+			if c.httpClient.Transport != sharedTransport {
+				t.Error("client does not use the shared transport")
+			}
+
+			start := time.Now()
+			err := c.Health()
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Health() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr && time.Since(start) >= 200*time.Millisecond {
+				t.Errorf("request took %s, timeout not honored", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestSyncPeersToContainerReportsFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		failing    map[string]bool
+		wantFailed []string
+		wantErr    bool
+	}{
+		{
+			name:       "below threshold",
+			failing:    map[string]bool{"pubkey-1": true},
+			wantFailed: []string{"pubkey-1"},
+		},
+		{
+			name: "above threshold",
+			failing: map[string]bool{
+				"pubkey-0": true,
+				"pubkey-2": true,
+				"pubkey-3": true,
+			},
+			wantFailed: []string{"pubkey-0", "pubkey-2", "pubkey-3"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db := newTestSyncDatabase(t, 4)
+			server := newTestContainer(
+				t,
+				tc.failing,
+				http.StatusInternalServerError,
+				`{"error":"interface down"}`,
+			)
+			c := NewClient(server.URL, newTestIssuer(t), 0)
+
+			result, err := c.SyncPeersToContainer(db, "test")
+			if tc.wantErr != (err != nil) {
+				t.Fatalf(
+					"SyncPeersToContainer() error = %v, wantErr %v",
+					err,
+					tc.wantErr,
+				)
+			}
+			if result == nil {
+				t.Fatal("expected result, got nil")
+			}
+			failed := slices.Clone(result.Failed)
+			slices.Sort(failed)
+			if !slices.Equal(failed, tc.wantFailed) {
+				t.Errorf("Failed = %v, want %v", failed, tc.wantFailed)
+			}
+			if len(result.Errors) != len(result.Failed) {
+				t.Errorf(
+					"got %d errors for %d failed peers",
+					len(result.Errors),
+					len(result.Failed),
+				)
+			}
+			if want := 4 - len(tc.wantFailed); result.Synced != want {
+				t.Errorf("Synced = %d, want %d", result.Synced, want)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@
 package wireguard
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -51,11 +52,17 @@ func NewManager(
 		s3Client: s3Client,
 		doneChan: make(chan struct{}),
 	}
+	if cfg.Vpn.WGReassignSubnet {
+		if err := m.reassignPeerSubnet(); err != nil {
+			return nil, fmt.Errorf("reassign peer subnet: %w", err)
+		}
+	}
 	if err := m.cleanupExpiredWGPeers(); err != nil {
 		return nil, fmt.Errorf("expire clients: %w", err)
 	}
 	// Schedule automatic updates to expired peers
 	m.scheduleUpdateExpiredPeers()
+	m.scheduleReconcilePeers()
 	return m, nil
 }
 
@@ -83,10 +90,8 @@ func (m *Manager) scheduleUpdateExpiredPeers() {
 						// Retry on next tick
 						needsUpdate = true
 						m.logger.Error(
-							fmt.Sprintf(
-								"failed to cleanup expired WG peers: %s",
-								err,
-							),
+							"failed to cleanup expired WG peers",
+							"error", err,
 						)
 						break
 					}
@@ -100,6 +105,36 @@ func (m *Manager) scheduleUpdateExpiredPeers() {
 	}()
 }
 
+// scheduleReconcilePeers periodically corrects the database cache of WG peers
+// from S3, so drift from failed cache writes doesn't last until a restart
+func (m *Manager) scheduleReconcilePeers() {
+	if m.config.Vpn.Protocol != "wireguard" || m.s3Client == nil ||
+		m.config.Vpn.WGReconcileInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.config.Vpn.WGReconcileInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.doneChan:
+				return
+			case <-ticker.C:
+				if _, err := m.s3Client.ReconcileWGPeersFromS3(
+					m.db,
+					m.config.Vpn.Region,
+				); err != nil {
+					// Retry on next tick
+					m.logger.Error(
+						"failed to reconcile WG peers from S3",
+						"error", err,
+					)
+				}
+			}
+		}
+	}()
+}
+
 // cleanupExpiredWGPeers removes WireGuard peers for expired subscriptions.
 // Checks doneChan between each peer to support graceful shutdown.
 func (m *Manager) cleanupExpiredWGPeers() error {
@@ -119,10 +154,8 @@ func (m *Manager) cleanupExpiredWGPeers() error {
 	}
 
 	m.logger.Info(
-		fmt.Sprintf(
-			"cleaning up %d expired WireGuard peers",
-			len(expiredPeers),
-		),
+		"cleaning up expired WireGuard peers",
+		"count", len(expiredPeers),
 	)
 
 	for _, peer := range expiredPeers {
@@ -142,10 +175,8 @@ func (m *Manager) cleanupExpiredWGPeers() error {
 		// Skip cleanup if S3 client is not set (S3 is source of truth)
 		if m.s3Client == nil {
 			m.logger.Warn(
-				fmt.Sprintf(
-					"skipping cleanup for peer %s: S3 client not configured",
-					pubkeyPrefix,
-				),
+				"skipping cleanup for peer: S3 client not configured",
+				"pubkey", pubkeyPrefix,
 			)
 			continue
 		}
@@ -157,11 +188,9 @@ func (m *Manager) cleanupExpiredWGPeers() error {
 			peer.Pubkey,
 		); err != nil {
 			m.logger.Warn(
-				fmt.Sprintf(
-					"failed to remove peer %s from S3: %s",
-					pubkeyPrefix,
-					err,
-				),
+				"failed to remove peer from S3",
+				"pubkey", pubkeyPrefix,
+				"error", err,
 			)
 			continue
 		}
@@ -169,11 +198,9 @@ func (m *Manager) cleanupExpiredWGPeers() error {
 		// 2. Remove from DB (cache)
 		if err := m.db.DeleteWGPeer(peer.Pubkey); err != nil {
 			m.logger.Warn(
-				fmt.Sprintf(
-					"failed to remove peer %s from DB: %s",
-					pubkeyPrefix,
-					err,
-				),
+				"failed to remove peer from DB",
+				"pubkey", pubkeyPrefix,
+				"error", err,
 			)
 			// Continue to WG cleanup anyway - S3 is already updated
 		}
@@ -184,11 +211,9 @@ func (m *Manager) cleanupExpiredWGPeers() error {
 			peer.AssignedIP,
 		); err != nil {
 			m.logger.Warn(
-				fmt.Sprintf(
-					"failed to deallocate IP %s: %s",
-					peer.AssignedIP,
-					err,
-				),
+				"failed to deallocate IP",
+				"ip", peer.AssignedIP,
+				"error", err,
 			)
 			// Continue anyway - IP will be reclaimed on next pool wrap-around
 		}
@@ -200,18 +225,121 @@ func (m *Manager) cleanupExpiredWGPeers() error {
 				peer.AssignedIP,
 			); err != nil {
 				m.logger.Warn(
-					fmt.Sprintf(
-						"failed to remove peer %s from WG container: %s",
-						pubkeyPrefix,
-						err,
-					),
+					"failed to remove peer from WG container",
+					"pubkey", pubkeyPrefix,
+					"error", err,
 				)
 				// Continue anyway - container will eventually sync
 			}
 		}
 
+		m.logger.Info("removed expired WG peer", "pubkey", pubkeyPrefix)
+	}
+
+	return nil
+}
+
+// reassignPeerSubnet gives each peer in the region whose assigned IP is
+// outside the configured subnet a new IP within it. The new IP is saved to S3
+// first (source of truth), then the database, then the WG container. Peers
+// that fail to save to S3 keep their IP and are retried on the next startup,
+// and a database update failure stops the pass.
+func (m *Manager) reassignPeerSubnet() error {
+	// Skip if not wireguard protocol
+	if m.config.Vpn.Protocol != "wireguard" {
+		return nil
+	}
+	if m.s3Client == nil {
+		return errors.New("S3 client not configured")
+	}
+
+	peers, err := m.db.WGPeersOutsideSubnet(m.config.Vpn.Region)
+	if err != nil {
+		return fmt.Errorf("failed to get WG peers outside subnet: %w", err)
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+
+	m.logger.Info(
+		"reassigning WireGuard peers outside subnet",
+		"subnet", m.config.Vpn.WGSubnetFor(m.config.Vpn.Region),
+		"count", len(peers),
+	)
+
+	for _, peer := range peers {
+		// Log peer identifier safely (handle short pubkeys)
+		pubkeyPrefix := peer.Pubkey
+		if len(pubkeyPrefix) > 8 {
+			pubkeyPrefix = pubkeyPrefix[:8]
+		}
+
+		newIP, err := m.db.AllocateIP(m.config.Vpn.Region)
+		if err != nil {
+			return fmt.Errorf("failed to allocate IP: %w", err)
+		}
+
+		// 1. Save to S3 first (source of truth)
+		// If this fails, skip this peer and retry next startup
+		if err := m.s3Client.SavePeerToS3(
+			peer.AssetName,
+			peer.Pubkey,
+			newIP,
+		); err != nil {
+			m.logger.Warn(
+				"failed to save reassigned peer IP to S3",
+				"pubkey", pubkeyPrefix,
+				"error", err,
+			)
+			if err := m.db.DeallocateIP(
+				m.config.Vpn.Region,
+				newIP,
+			); err != nil {
+				m.logger.Warn(
+					"failed to release allocated IP",
+					"ip", newIP,
+					"error", err,
+				)
+			}
+			continue
+		}
+
+		// 2. Update DB (cache)
+		// Allocation only sees the IPs in the database, so the pass stops
+		// rather than risk handing the new IP to another peer. The peer
+		// keeps its old IP in the database and is reassigned again on the
+		// next startup.
+		if err := m.db.UpdateWGPeerIP(peer.Pubkey, newIP); err != nil {
+			return fmt.Errorf("failed to update peer IP in DB: %w", err)
+		}
+
+		// 3. Replace in WG container (best effort)
+		if m.wgClient != nil {
+			if err := m.wgClient.RemovePeer(
+				peer.Pubkey,
+				peer.AssignedIP,
+			); err != nil {
+				m.logger.Warn(
+					"failed to remove peer from WG container",
+					"pubkey", pubkeyPrefix,
+					"error", err,
+				)
+			}
+			if _, err := m.wgClient.AddPeer(peer.Pubkey, newIP); err != nil {
+				m.logger.Warn(
+					"failed to add peer to WG container",
+					"pubkey", pubkeyPrefix,
+					"error", err,
+				)
+				// Continue anyway - the startup sync adds it again
+			}
+		}
+
 		m.logger.Info(
-			fmt.Sprintf("removed expired WG peer %s", pubkeyPrefix),
+			"reassigned WG peer IP",
+			"pubkey", pubkeyPrefix,
+			"old_ip", peer.AssignedIP,
+			"new_ip", newIP,
 		)
 	}
 
@@ -0,0 +1,213 @@
+// Copyright 2025 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireguard
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/vpn-indexer/internal/client"
+	"github.com/blinklabs-io/vpn-indexer/internal/config"
+	"github.com/blinklabs-io/vpn-indexer/internal/database"
+)
+
+func TestReassignPeerSubnet(t *testing.T) {
+	// Static credentials keep the AWS SDK from searching the environment
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	// A fake S3 bucket that stores peer files written to it
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	var puts int
+	var beforePut func()
+	s3Server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			key := strings.TrimPrefix(r.URL.Path, "/test-bucket/")
+			if r.Method == http.MethodPut {
+				puts++
+				if beforePut != nil {
+					beforePut()
+				}
+				body, _ := io.ReadAll(r.Body)
+				objects[key] = string(body)
+				w.Header().Set("ETag", `"etag"`)
+				return
+			}
+			body, ok := objects[key]
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+				return
+			}
+			w.Header().Set("ETag", `"etag"`)
+			_, _ = w.Write([]byte(body))
+		}),
+	)
+	t.Cleanup(s3Server.Close)
+
+	// A fake WG container that records the peers added and removed
+	var added, removed []string
+	container := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method == http.MethodDelete {
+				removed = append(removed, r.URL.Query().Get("pubkey"))
+				return
+			}
+			var req AddPeerRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			added = append(added, req.Pubkey)
+			_ = json.NewEncoder(w).Encode(AddPeerResponse{Success: true})
+		}),
+	)
+	t.Cleanup(container.Close)
+
+	// The subnet was changed from the default 10.8.0
+	cfg := &config.Config{
+		Database: config.DatabaseConfig{Directory: t.TempDir()},
+		S3: config.S3Config{
+			ClientBucket: "test-bucket",
+			Endpoint:     s3Server.URL,
+		},
+		Vpn: config.VpnConfig{
+			Protocol: "wireguard",
+			Region:   "test",
+			WGSubnet: "10.9.0",
+		},
+	}
+	db, err := database.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	assetName := []byte("client")
+	if err := db.AddClient(
+		assetName,
+		time.Now().Add(time.Hour),
+		[]byte("credential"),
+		"test",
+		[]byte("txhash"),
+		0,
+		0,
+	); err != nil {
+		t.Fatalf("failed to add client: %v", err)
+	}
+	peers := map[string]string{
+		"pubkey-old-1": "10.8.0.2",
+		"pubkey-old-2": "10.8.0.3",
+		"pubkey-new":   "10.9.0.4",
+	}
+	for pubkey, ip := range peers {
+		if err := db.AddWGPeer(assetName, pubkey, ip); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+	}
+
+	s3Client := client.NewWithConfig(cfg)
+	m := &Manager{
+		config:   cfg,
+		db:       db,
+		logger:   slog.Default(),
+		wgClient: NewClient(container.URL, newTestIssuer(t), 0),
+		s3Client: s3Client,
+	}
+	if err := m.reassignPeerSubnet(); err != nil {
+		t.Fatalf("reassignPeerSubnet: %v", err)
+	}
+
+	// Every peer is in the new subnet, with no IP shared
+	dbPeers, err := db.GetWGPeersByAsset(assetName)
+	if err != nil {
+		t.Fatalf("GetWGPeersByAsset: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, peer := range dbPeers {
+		if !strings.HasPrefix(peer.AssignedIP, "10.9.0.") {
+			t.Errorf(
+				"peer %s IP = %s, want in 10.9.0",
+				peer.Pubkey,
+				peer.AssignedIP,
+			)
+		}
+		if seen[peer.AssignedIP] {
+			t.Errorf("IP %s assigned twice", peer.AssignedIP)
+		}
+		seen[peer.AssignedIP] = true
+		if peer.Pubkey == "pubkey-new" && peer.AssignedIP != "10.9.0.4" {
+			t.Errorf("peer in subnet moved to %s", peer.AssignedIP)
+		}
+	}
+
+	// The new IPs of the moved peers are saved to S3 and the container
+	peerFile, err := s3Client.LoadPeersFromS3(assetName)
+	if err != nil {
+		t.Fatalf("LoadPeersFromS3: %v", err)
+	}
+	if peerFile == nil || len(peerFile.Peers) != 2 {
+		t.Fatalf("S3 peer file = %+v, want the 2 moved peers", peerFile)
+	}
+	for _, p := range peerFile.Peers {
+		if !strings.HasPrefix(p.AssignedIP, "10.9.0.") {
+			t.Errorf(
+				"S3 peer %s IP = %s, want in 10.9.0",
+				p.Pubkey,
+				p.AssignedIP,
+			)
+		}
+	}
+	if len(added) != 2 || len(removed) != 2 {
+		t.Errorf(
+			"container added %v and removed %v, want the 2 moved peers",
+			added,
+			removed,
+		)
+	}
+
+	// A peer whose database update fails stops the pass, so no other peer
+	// is allocated an IP the database doesn't show as taken
+	for _, pubkey := range []string{"pubkey-old-3", "pubkey-old-4"} {
+		if err := db.AddWGPeer(assetName, pubkey, "10.8.0.9"); err != nil {
+			t.Fatalf("failed to add peer: %v", err)
+		}
+	}
+	mu.Lock()
+	puts = 0
+	beforePut = func() {
+		for _, pubkey := range []string{"pubkey-old-3", "pubkey-old-4"} {
+			_ = db.DeleteWGPeer(pubkey)
+		}
+	}
+	mu.Unlock()
+	if err := m.reassignPeerSubnet(); err == nil {
+		t.Fatal("expected an error when the peer IP can't be updated")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if puts != 1 {
+		t.Errorf("S3 peer files written = %d, want 1", puts)
+	}
+}